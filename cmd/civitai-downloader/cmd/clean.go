@@ -23,7 +23,8 @@ func init() {
 var cleanCmd = &cobra.Command{
 	Use:   "clean",
 	Short: "Remove temporary (.tmp) files from the download directory",
-	Long: `Recursively scans the configured SavePath and removes any files ending with the .tmp extension.
+	Long: `Recursively scans the configured SavePath and removes any files ending with the .tmp extension,
+including the .tmp.progress files used to resume interrupted downloads.
 Optionally removes *.torrent and *-magnet.txt files as well.`,
 	Run: runClean,
 }
@@ -88,7 +89,8 @@ func runClean(cmd *cobra.Command, args []string) {
 		fileType := ""
 
 		// Check file types based on flags
-		if strings.HasSuffix(lowerName, ".tmp") {
+		// Partial downloads also leave a .tmp.progress sidecar used for resuming
+		if strings.HasSuffix(lowerName, ".tmp") || strings.HasSuffix(lowerName, ".tmp.progress") {
 			shouldRemove = true
 			fileType = ".tmp"
 		} else if cleanTorrents && strings.HasSuffix(lowerName, ".torrent") {
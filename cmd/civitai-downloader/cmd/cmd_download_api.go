@@ -1,16 +1,18 @@
 package cmd
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"math/rand"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"go-civitai-download/internal/api"
 	"go-civitai-download/internal/database"
 	"go-civitai-download/internal/downloader"
 	"go-civitai-download/internal/helpers"
@@ -21,113 +23,48 @@ import (
 	"github.com/spf13/viper"
 )
 
-// --- Retry Logic Helper --- START ---
-
-// doRequestWithRetry performs an HTTP request with exponential backoff retries.
-// It retries on network errors and specific HTTP status codes (5xx, 408, 429, 504).
-func doRequestWithRetry(client *http.Client, req *http.Request, maxRetries int, initialRetryDelay time.Duration, logPrefix string) (*http.Response, []byte, error) {
-	var resp *http.Response
-	var err error
-	var bodyBytes []byte
-	_ = bodyBytes // Explicitly use bodyBytes to satisfy linter (used indirectly in logging/errors)
-
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		if attempt > 0 {
-			// Calculate backoff: initial * 2^(attempt-1)
-			backoff := initialRetryDelay * time.Duration(1<<(attempt-1))
-			log.Infof("[%s] Retrying request for %s in %v (Attempt %d/%d)...", logPrefix, req.URL.String(), backoff, attempt+1, maxRetries+1)
-			time.Sleep(backoff)
-		}
-
-		// Clone the request for the attempt, especially important if the body is consumed.
-		clonedReq := req.Clone(req.Context())
-		if req.Body != nil && req.GetBody != nil {
-			clonedReq.Body, err = req.GetBody()
-			if err != nil {
-				return nil, nil, fmt.Errorf("[%s] failed to get request body for retry clone (attempt %d): %w", logPrefix, attempt+1, err)
-			}
-		} else if req.Body != nil {
-			// This case should ideally not happen for GET requests used here.
-			// If it were POST/PUT, we'd need GetBody to be set for safe retries.
-			log.Warnf("[%s] Cannot guarantee safe retry for request with non-nil body without GetBody defined (URL: %s)", logPrefix, req.URL.String())
-		}
-
-		log.Debugf("[%s] Attempt %d/%d: Sending request to %s", logPrefix, attempt+1, maxRetries+1, clonedReq.URL.String())
-		resp, err = client.Do(clonedReq)
-
-		if err != nil {
-			// Network-level error
-			log.WithError(err).Warnf("[%s] Attempt %d/%d failed for %s: %v", logPrefix, attempt+1, maxRetries+1, clonedReq.URL.String(), err)
-			if resp != nil {
-				// Check error on Close
-				if closeErr := resp.Body.Close(); closeErr != nil {
-					log.WithError(closeErr).Warnf("[%s] Failed to close response body after network error for %s", logPrefix, clonedReq.URL.String())
-				}
-			}
-			if attempt == maxRetries {
-				return nil, nil, fmt.Errorf("[%s] network error failed after %d attempts for %s: %w", logPrefix, maxRetries+1, clonedReq.URL.String(), err)
-			}
-			continue // Retry
-		}
-
-		// Read the body regardless of status code
-		bodyBytes, readErr := io.ReadAll(resp.Body)
-		// Close body immediately after reading, check error
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			// Log error, but continue processing the body we potentially read successfully
-			log.WithError(closeErr).Warnf("[%s] Failed to close response body after reading for %s", logPrefix, clonedReq.URL.String())
-		}
-
-		if readErr != nil {
-			log.WithError(readErr).Warnf("[%s] Attempt %d/%d failed to read response body for %s: %v", logPrefix, attempt+1, maxRetries+1, clonedReq.URL.String(), readErr)
-			if attempt == maxRetries {
-				return nil, nil, fmt.Errorf("[%s] failed to read body after %d attempts for %s: %w", logPrefix, maxRetries+1, clonedReq.URL.String(), readErr)
-			}
-			continue // Retry
-		}
-
-		// Check status code
-		if resp.StatusCode == http.StatusOK {
-			log.Debugf("[%s] Attempt %d/%d successful for %s", logPrefix, attempt+1, maxRetries+1, clonedReq.URL.String())
-			return resp, bodyBytes, nil // Success!
-		}
+// apiRetryOptions returns the retry settings for API metadata requests, taken from
+// MaxRetries and InitialRetryDelayMs.
+func apiRetryOptions(logPrefix string) api.RetryOptions {
+	return api.RetryOptions{
+		MaxRetries:   viper.GetInt("maxretries"),
+		InitialDelay: time.Duration(viper.GetInt("initialretrydelayms")) * time.Millisecond,
+		LogPrefix:    logPrefix,
+	}
+}
 
-		// Non-OK status code
-		bodySample := string(bodyBytes)
-		if len(bodySample) > 200 { // Limit logged body size
-			bodySample = bodySample[:200] + "..."
+// formatAccepted reports whether a file's metadata format is one of the accepted
+// formats (case-insensitive). An empty list accepts every format, including files
+// without one.
+func formatAccepted(format string, accepted []string) bool {
+	configured := false
+	for _, f := range accepted {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
 		}
-		log.Warnf("[%s] Attempt %d/%d for %s failed with status %s. Body: %s", logPrefix, attempt+1, maxRetries+1, clonedReq.URL.String(), resp.Status, bodySample)
-
-		// Check if status code is retryable
-		isRetryableStatus := resp.StatusCode >= 500 || // Server errors
-			resp.StatusCode == http.StatusRequestTimeout || // 408
-			resp.StatusCode == http.StatusTooManyRequests || // 429
-			resp.StatusCode == http.StatusGatewayTimeout // 504
-
-		if isRetryableStatus && attempt < maxRetries {
-			log.Warnf("[%s] Status %s is retryable.", logPrefix, resp.Status)
-			// Continue loop, backoff delay is handled at the start of the next iteration
-		} else {
-			// Not a retryable status code OR max retries reached
-			errMsg := fmt.Sprintf("[%s] request failed with status %s after %d attempts", logPrefix, resp.Status, attempt+1)
-			if !isRetryableStatus {
-				errMsg = fmt.Sprintf("[%s] request failed with non-retryable status %s on attempt %d", logPrefix, resp.Status, attempt+1)
-			}
-			// Include body sample in final error if it's not success
-			errMsg += fmt.Sprintf(". Body: %s", bodySample)
-			return resp, bodyBytes, fmt.Errorf(errMsg)
+		configured = true
+		if strings.EqualFold(f, format) {
+			return true
 		}
-	} // End of retry loop
-
-	// Should not be reachable
-	return nil, nil, fmt.Errorf("[%s] retry loop completed without success or error return for %s", logPrefix, req.URL.String())
+	}
+	return !configured
 }
 
-// --- Retry Logic Helper --- END ---
+// sizeLimitBytes returns the --min-size or --max-size limit stored under key as a
+// number of bytes, or 0 if it is unset. Invalid values are rejected at startup.
+func sizeLimitBytes(key string) int64 {
+	n, _ := helpers.ParseByteSize(viper.GetString(key))
+	return n
+}
 
 // passesFileFilters checks if a given file passes the configured file-level filters.
-func passesFileFilters(file models.File, modelType string) bool {
+// apiFilteredPrimary reports that the files come from a models query sent with
+// primaryFileOnly=true. The API has then already reduced each version to its primary
+// file, but the returned file does not always carry primary=true, and re-checking it
+// here would drop the version entirely. So the client primary check is skipped in
+// that case unless --recheck-primary is set.
+func passesFileFilters(file models.File, modelType string, apiFilteredPrimary bool) bool {
 	// Check hash presence (essential)
 	if file.Hashes.CRC32 == "" {
 		log.Debugf("Skipping file %s: Missing CRC32 hash.", file.Name)
@@ -136,18 +73,40 @@ func passesFileFilters(file models.File, modelType string) bool {
 
 	// Check primary file filter
 	if viper.GetBool("primaryonly") && !file.Primary {
-		log.Debugf("Skipping non-primary file %s.", file.Name)
+		if apiFilteredPrimary && !viper.GetBool("recheckprimary") {
+			log.Debugf("Keeping file %s: not marked primary, but returned by the API for primaryFileOnly.", file.Name)
+		} else {
+			log.Debugf("Skipping non-primary file %s.", file.Name)
+			return false
+		}
+	}
+
+	// Check format against the accepted formats (empty list accepts all)
+	if !formatAccepted(file.Metadata.Format, viper.GetStringSlice("formats")) {
+		log.Debugf("Skipping file %s: format %q is not in the accepted formats.", file.Name, file.Metadata.Format)
 		return false
 	}
 
-	// Check format (basic check)
-	if file.Metadata.Format == "" {
-		log.Debugf("Skipping file %s: Missing metadata format.", file.Name)
+	// Check scan results; anything but Success (e.g. Pending, Danger) is rejected
+	if viper.GetBool("requiresafescan") {
+		if !strings.EqualFold(file.VirusScanResult, "Success") {
+			log.Debugf("Skipping file %s: virus scan result is %q, not Success.", file.Name, file.VirusScanResult)
+			return false
+		}
+		if !strings.EqualFold(file.PickleScanResult, "Success") {
+			log.Debugf("Skipping file %s: pickle scan result is %q, not Success.", file.Name, file.PickleScanResult)
+			return false
+		}
+	}
+
+	// Check file size limits (0 disables either bound)
+	sizeBytes := int64(file.SizeKB * 1024)
+	if minSize := sizeLimitBytes("minsizebytes"); minSize > 0 && sizeBytes < minSize {
+		log.Debugf("Skipping file %s: size %s is below --min-size.", file.Name, helpers.BytesToSize(uint64(sizeBytes)))
 		return false
 	}
-	// TODO: Make acceptable formats configurable?
-	if strings.ToLower(file.Metadata.Format) != "safetensor" {
-		log.Debugf("Skipping non-safetensor file %s (Format: %s).", file.Name, file.Metadata.Format)
+	if maxSize := sizeLimitBytes("maxsizebytes"); maxSize > 0 && sizeBytes > maxSize {
+		log.Debugf("Skipping file %s: size %s is above --max-size.", file.Name, helpers.BytesToSize(uint64(sizeBytes)))
 		return false
 	}
 
@@ -181,10 +140,54 @@ func passesFileFilters(file models.File, modelType string) bool {
 	return true
 }
 
+// selectVersionFiles returns the files of a version that pass passesFileFilters,
+// narrowed to a single file by --file-select smallest|largest (by SizeKB).
+func selectVersionFiles(files []models.File, modelType string, apiFilteredPrimary bool) []models.File {
+	var passed []models.File
+	for _, file := range files {
+		if passesFileFilters(file, modelType, apiFilteredPrimary) {
+			passed = append(passed, file)
+		}
+	}
+
+	mode := strings.ToLower(viper.GetString("fileselect"))
+	if len(passed) < 2 || (mode != "smallest" && mode != "largest") {
+		return passed
+	}
+	chosen := passed[0]
+	for _, file := range passed[1:] {
+		if (mode == "smallest" && file.SizeKB < chosen.SizeKB) || (mode == "largest" && file.SizeKB > chosen.SizeKB) {
+			chosen = file
+		}
+	}
+	log.Debugf("--file-select %s: keeping %s (%.0f KB) out of %d files.", mode, chosen.Name, chosen.SizeKB, len(passed))
+	return []models.File{chosen}
+}
+
+// selectFileByIDOrName returns the file of a version picked with --file-id and/or
+// --file-name (case-insensitive). It errors, listing the available files, if none matches.
+func selectFileByIDOrName(files []models.File, fileID int, fileName string) (models.File, error) {
+	for _, file := range files {
+		if fileID != 0 && file.ID != fileID {
+			continue
+		}
+		if fileName != "" && !strings.EqualFold(file.Name, fileName) {
+			continue
+		}
+		return file, nil
+	}
+	available := make([]string, 0, len(files))
+	for _, file := range files {
+		available = append(available, fmt.Sprintf("%s (ID %d)", file.Name, file.ID))
+	}
+	return models.File{}, fmt.Errorf("no file matches --file-id %d / --file-name %q, available: %s", fileID, fileName, strings.Join(available, ", "))
+}
+
 // handleSingleVersionDownload Fetches details for a specific model version ID and processes it for download.
-func handleSingleVersionDownload(versionID int, db *database.DB, client *http.Client, cfg *models.Config, _ *cobra.Command) ([]potentialDownload, uint64, error) {
+// A non-zero fileID or non-empty fileName downloads only that file of the version (--file-id / --file-name).
+func handleSingleVersionDownload(versionID int, fileID int, fileName string, db *database.DB, client *http.Client, cfg *models.Config, _ *cobra.Command) ([]potentialDownload, uint64, error) {
 	log.Debugf("Fetching details for model version ID: %d", versionID)
-	apiURL := fmt.Sprintf("https://civitai.com/api/v1/model-versions/%d", versionID)
+	apiURL := fmt.Sprintf("%s/model-versions/%d", api.BaseURL(), versionID)
 	logPrefix := fmt.Sprintf("Version %d", versionID) // For retry logging
 
 	req, err := http.NewRequest("GET", apiURL, nil)
@@ -196,14 +199,12 @@ func handleSingleVersionDownload(versionID int, db *database.DB, client *http.Cl
 	}
 
 	// --- Use Retry Helper ---
-	maxRetries := viper.GetInt("maxretries")
-	initialRetryDelay := time.Duration(viper.GetInt("initialretrydelayms")) * time.Millisecond
 	// Assign the unused resp to the blank identifier `_`
-	_, bodyBytes, err := doRequestWithRetry(client, req, maxRetries, initialRetryDelay, logPrefix)
+	_, bodyBytes, err := api.DoRequestWithRetry(client, req, apiRetryOptions(logPrefix))
 	// --- End Use Retry Helper ---
 
 	if err != nil {
-		// Error already includes context from doRequestWithRetry (status, attempts)
+		// Error already includes context from DoRequestWithRetry (status, attempts)
 		// We might add a bit more context here if needed.
 		// If resp is not nil, the error message likely contains the status code.
 		// If resp is nil, it was likely a network error or read error after all retries.
@@ -232,83 +233,27 @@ func handleSingleVersionDownload(versionID int, db *database.DB, client *http.Cl
 
 	// --- Convert to potentialDownload ---
 	var potentialDownloadsPage []potentialDownload
-	versionWithoutFilesImages := versionResponse // Create a copy for metadata
-	versionWithoutFilesImages.Files = nil
-	versionWithoutFilesImages.Images = nil
-
-	// Use a placeholder creator if not directly available in the response
-	placeholderCreator := models.Creator{Username: "unknown_creator"}
-
-	for _, file := range versionResponse.Files {
-		// Use the new shared filtering function
-		if !passesFileFilters(file, versionResponse.Model.Type) {
-			continue // Skip this file if it doesn't pass filters
-		}
-
-		// --- Path/Filename Construction (Copied/adapted from pagination loop) ---
-		var slug string
-		modelTypeName := helpers.ConvertToSlug(versionResponse.Model.Type)
-		baseModelStr := versionResponse.BaseModel
-		if baseModelStr == "" {
-			baseModelStr = "unknown-base"
-		}
-		baseModelSlug := helpers.ConvertToSlug(baseModelStr)
-		modelNameSlug := helpers.ConvertToSlug(versionResponse.Model.Name)
-		// --- Modify slug construction for type/base/model structure ---
-		slug = filepath.Join(modelTypeName, modelNameSlug, baseModelSlug)
-		// --- End slug construction modification ---
-
-		// --- Create version specific slug based on file name (without extension) ---
-		fileNameWithoutExt := strings.TrimSuffix(file.Name, filepath.Ext(file.Name))
-		versionSlug := fmt.Sprintf("%d-%s", versionResponse.ID, helpers.ConvertToSlug(fileNameWithoutExt))
-		// --- End version specific slug ---
-
-		baseFileName := helpers.ConvertToSlug(file.Name)
-		ext := filepath.Ext(baseFileName)
-		baseFileName = strings.TrimSuffix(baseFileName, ext)
-		if strings.ToLower(file.Metadata.Format) == "safetensor" && !strings.EqualFold(ext, ".safetensors") {
-			ext = ".safetensors"
-		}
-		if ext == "" {
-			ext = ".bin"
-			log.Warnf("File %s in model version %d has no extension, defaulting to '.bin'", file.Name, versionID)
-		}
-		finalBaseFilenameOnly := baseFileName + ext
-		dbKeySimple := strings.ToUpper(file.Hashes.CRC32)
-		metaSuffixParts := []string{dbKeySimple}
-		if strings.EqualFold(versionResponse.Model.Type, "checkpoint") {
-			if fpStr := fmt.Sprintf("%v", file.Metadata.Fp); fpStr != "" {
-				metaSuffixParts = append(metaSuffixParts, helpers.ConvertToSlug(fpStr))
-			}
-			if sizeStr := fmt.Sprintf("%v", file.Metadata.Size); sizeStr != "" {
-				metaSuffixParts = append(metaSuffixParts, helpers.ConvertToSlug(sizeStr))
-			}
+	// Only the nested model summary is available from /model-versions/{id}
+	model := modelFromVersion(versionResponse)
+
+	// Filtering and --file-select are applied by the shared selectVersionFiles,
+	// a file picked explicitly with --file-id or --file-name bypasses them
+	files := selectVersionFiles(versionResponse.Files, versionResponse.Model.Type, false)
+	if fileID != 0 || fileName != "" {
+		file, err := selectFileByIDOrName(versionResponse.Files, fileID, fileName)
+		if err != nil {
+			return nil, 0, fmt.Errorf("version %d: %w", versionID, err)
 		}
-		metaSuffix := "-" + strings.Join(metaSuffixParts, "-")
-		constructedFileNameWithSuffix := baseFileName + metaSuffix + ext
-		// --- Modify directory path to include version ---
-		fullDirPath := filepath.Join(cfg.SavePath, slug, versionSlug)
-		// --- End directory path modification ---
-		fullFilePath := filepath.Join(fullDirPath, constructedFileNameWithSuffix)
-		// --- End Path/Filename Construction ---
-
-		pd := potentialDownload{
-			ModelName:         versionResponse.Model.Name,
-			ModelType:         versionResponse.Model.Type,
-			VersionName:       versionResponse.Name,
-			BaseModel:         versionResponse.BaseModel,
-			Creator:           placeholderCreator,
-			File:              file,
-			ModelVersionID:    versionResponse.ID,
-			TargetFilepath:    fullFilePath,
-			Slug:              slug,
-			FinalBaseFilename: finalBaseFilenameOnly,
-			CleanedVersion:    versionWithoutFilesImages,
-			FullVersion:       versionResponse,
-			OriginalImages:    versionResponse.Images,
+		files = []models.File{file}
+	}
+	for _, file := range files {
+		file, ok := withVersionDownloadUrl(file, versionResponse)
+		if !ok {
+			continue
 		}
+		pd := constructPotentialDownload(model, versionResponse, file, cfg)
 		potentialDownloadsPage = append(potentialDownloadsPage, pd)
-		log.Debugf("Passed filters for single version: %s -> %s", file.Name, fullFilePath)
+		log.Debugf("Passed filters for single version: %s -> %s", file.Name, pd.TargetFilepath)
 
 	} // End file loop for this version
 
@@ -320,7 +265,8 @@ func handleSingleVersionDownload(versionID int, db *database.DB, client *http.Cl
 	// --- Process against DB (Uses processPage moved to cmd_download_processing.go) ---
 	log.Debugf("Checking %d potential downloads from version %d against database...", len(potentialDownloadsPage), versionID)
 	// Assuming processPage is available in this package after refactoring
-	queuedFromPage, sizeFromPage := processPage(db, potentialDownloadsPage, cfg)
+	potentialDownloadsPage = appendVaeDownloads(potentialDownloadsPage, client, cfg)
+	queuedFromPage, sizeFromPage := processPage(db, potentialDownloadsPage, cfg, viper.GetBool("dryrun"))
 	if len(queuedFromPage) > 0 {
 		log.Infof("Queued %d file(s) (Size: %s) from version %d after DB check.", len(queuedFromPage), helpers.BytesToSize(sizeFromPage), versionID)
 	} else {
@@ -334,7 +280,7 @@ func handleSingleVersionDownload(versionID int, db *database.DB, client *http.Cl
 // It now also accepts imageDownloader to handle --model-images.
 func handleSingleModelDownload(modelID int, db *database.DB, client *http.Client, imageDownloader *downloader.Downloader, cfg *models.Config, cmd *cobra.Command) ([]potentialDownload, uint64, error) {
 	log.Debugf("Fetching details for model ID: %d", modelID)
-	apiURL := fmt.Sprintf("https://civitai.com/api/v1/models/%d", modelID)
+	apiURL := fmt.Sprintf("%s/models/%d", api.BaseURL(), modelID)
 	logPrefix := fmt.Sprintf("Model %d", modelID) // For retry logging
 
 	req, err := http.NewRequest("GET", apiURL, nil)
@@ -346,14 +292,12 @@ func handleSingleModelDownload(modelID int, db *database.DB, client *http.Client
 	}
 
 	// --- Use Retry Helper ---
-	maxRetries := viper.GetInt("maxretries")
-	initialRetryDelay := time.Duration(viper.GetInt("initialretrydelayms")) * time.Millisecond
 	// Assign the unused resp to the blank identifier `_`
-	_, bodyBytes, err := doRequestWithRetry(client, req, maxRetries, initialRetryDelay, logPrefix)
+	_, bodyBytes, err := api.DoRequestWithRetry(client, req, apiRetryOptions(logPrefix))
 	// --- End Use Retry Helper ---
 
 	if err != nil {
-		// Error already includes context from doRequestWithRetry
+		// Error already includes context from DoRequestWithRetry
 		finalErrMsg := fmt.Sprintf("failed to fetch model %d: %v", modelID, err)
 		if !strings.Contains(err.Error(), "Body:") && len(bodyBytes) > 0 {
 			bodySample := string(bodyBytes)
@@ -383,10 +327,7 @@ func handleSingleModelDownload(modelID int, db *database.DB, client *http.Client
 		latestInfoTime := time.Time{}
 		if len(modelResponse.ModelVersions) > 0 {
 			for _, v := range modelResponse.ModelVersions {
-				pAt, errP := time.Parse(time.RFC3339Nano, v.PublishedAt)
-				if errP != nil {
-					pAt, _ = time.Parse(time.RFC3339, v.PublishedAt)
-				}
+				pAt, errP := parsePublishedAt(v.PublishedAt)
 				if errP == nil && (latestInfoVersion.ID == 0 || pAt.After(latestInfoTime)) {
 					latestInfoTime = pAt
 					latestInfoVersion = v
@@ -402,7 +343,7 @@ func handleSingleModelDownload(modelID int, db *database.DB, client *http.Client
 		}
 
 		// --- Modify slug construction for type/model structure (removing base model for info/images) ---
-		modelInfoSlug := filepath.Join(helpers.ConvertToSlug(modelResponse.Type), helpers.ConvertToSlug(modelResponse.Name))
+		modelInfoSlug := filepath.Join(typeFolderName(modelResponse.Type), helpers.ConvertToSlug(modelResponse.Name))
 		// --- End slug construction modification ---
 		modelBaseDir := filepath.Join(cfg.SavePath, modelInfoSlug) // Path for model info/images
 
@@ -424,11 +365,7 @@ func handleSingleModelDownload(modelID int, db *database.DB, client *http.Client
 				// --- End image path adjustment ---
 				var totalImgSuccess, totalImgFail int = 0, 0
 
-				// Get concurrency level from command flags
-				concurrency, _ := cmd.Flags().GetInt("concurrency")
-				if concurrency <= 0 {
-					concurrency = 4
-				} // Default concurrency
+				concurrency := imageConcurrencyLevel()
 
 				for _, version := range modelResponse.ModelVersions {
 					versionLogPrefix := fmt.Sprintf("%s v%d", logPrefix, version.ID)
@@ -439,7 +376,7 @@ func handleSingleModelDownload(modelID int, db *database.DB, client *http.Client
 					if len(version.Images) > 0 {
 						log.Debugf("[%s] Calling downloadImages for %d images...", versionLogPrefix, len(version.Images))
 						// Use the existing downloadImages helper
-						imgSuccess, imgFail := downloadImages(versionLogPrefix, version.Images, versionImagesDir, imageDownloader, concurrency)
+						imgSuccess, imgFail := downloadImages(commandContext(cmd), versionLogPrefix, version.Images, versionImagesDir, imageDownloader, concurrency, nil)
 						totalImgSuccess += imgSuccess
 						totalImgFail += imgFail
 					}
@@ -450,39 +387,44 @@ func handleSingleModelDownload(modelID int, db *database.DB, client *http.Client
 		}
 	} // --- End Handle --model-info and --model-images ---
 
+	if viper.GetBool("saveversionlist") {
+		modelBaseDir := filepath.Join(cfg.SavePath, typeFolderName(modelResponse.Type), helpers.ConvertToSlug(modelResponse.Name))
+		if err := saveVersionList(modelResponse, modelBaseDir, db); err != nil {
+			log.WithError(err).Warnf("Failed to save version list for model %d (%s)", modelResponse.ID, modelResponse.Name)
+		}
+	}
+
 	// --- Process versions and files from the model response ---
 	var potentialDownloadsFromModel []potentialDownload
 	versionsToProcess := []models.ModelVersion{}
 
 	// Use Viper to get all-versions flag
 	downloadAll := viper.GetBool("downloadallversions") // Viper key from download.go init
+	candidateVersions := availableVersions(modelResponse.ModelVersions, modelResponse.Name, modelID)
 	if downloadAll {
-		log.Debugf("Processing all %d versions for model %s (%d) due to --all-versions flag.", len(modelResponse.ModelVersions), modelResponse.Name, modelID)
-		if len(modelResponse.ModelVersions) == 0 {
+		log.Debugf("Processing all %d versions for model %s (%d) due to --all-versions flag.", len(candidateVersions), modelResponse.Name, modelID)
+		if len(candidateVersions) == 0 {
 			log.Warnf("Model %s (%d) has no versions listed to process.", modelResponse.Name, modelID)
 			return nil, 0, nil // No versions, no error
 		}
-		versionsToProcess = modelResponse.ModelVersions
+		versionsToProcess = candidateVersions
 	} else {
 		// Find the latest version if not downloading all
 		latestVersion := models.ModelVersion{}
 		latestTime := time.Time{}
-		if len(modelResponse.ModelVersions) == 0 {
+		if len(candidateVersions) == 0 {
 			log.Warnf("Model %s (%d) has no versions listed to process.", modelResponse.Name, modelID)
 			return nil, 0, nil // No versions, no error
 		}
-		for _, version := range modelResponse.ModelVersions {
+		for _, version := range candidateVersions {
 			if version.PublishedAt == "" {
 				log.Warnf("Skipping version %s in model %s (%d): PublishedAt timestamp is empty.", version.Name, modelResponse.Name, modelID)
 				continue
 			}
-			publishedAt, errParse := time.Parse(time.RFC3339Nano, version.PublishedAt)
+			publishedAt, errParse := parsePublishedAt(version.PublishedAt)
 			if errParse != nil {
-				publishedAt, errParse = time.Parse(time.RFC3339, version.PublishedAt)
-				if errParse != nil {
-					log.WithError(errParse).Warnf("Skipping version %s in model %s (%d): Error parsing time '%s'", version.Name, modelResponse.Name, modelID, version.PublishedAt)
-					continue
-				}
+				log.WithError(errParse).Warnf("Skipping version %s in model %s (%d): Error parsing time '%s'", version.Name, modelResponse.Name, modelID, version.PublishedAt)
+				continue
 			}
 			if latestVersion.ID == 0 || publishedAt.After(latestTime) {
 				latestTime = publishedAt
@@ -512,73 +454,15 @@ func handleSingleModelDownload(modelID int, db *database.DB, client *http.Client
 			}
 		}
 
-		// Prepare cleaned version for metadata/DB
-		versionWithoutFilesImages := currentVersion
-		versionWithoutFilesImages.Files = nil
-		versionWithoutFilesImages.Images = nil
-
-	fileLoop: // Label for continue
-		for _, file := range currentVersion.Files { // Use files from currentVersion
-			// Use the shared filtering function
-			if !passesFileFilters(file, modelResponse.Type) {
-				continue fileLoop // Skip this file if it doesn't pass filters
-			}
-
-			// --- Path/Filename Construction (using currentVersion) ---
-			var slug string // Now only used for file path
-			modelTypeName := helpers.ConvertToSlug(modelResponse.Type)
-			baseModelStr := currentVersion.BaseModel // Use currentVersion
-			if baseModelStr == "" {
-				baseModelStr = "unknown-base"
-			}
-			baseModelSlug := helpers.ConvertToSlug(baseModelStr)
-			modelNameSlug := helpers.ConvertToSlug(modelResponse.Name)
-			// --- Modify slug construction for type/model/base structure ---
-			slug = filepath.Join(modelTypeName, modelNameSlug, baseModelSlug) // Changed order for file path
-			// --- End slug construction modification ---
-
-			// --- Create version specific slug based on file name (without extension) ---
-			fileNameWithoutExt := strings.TrimSuffix(file.Name, filepath.Ext(file.Name))
-			versionSlug := fmt.Sprintf("%d-%s", currentVersion.ID, helpers.ConvertToSlug(fileNameWithoutExt))
-			// --- End version specific slug ---
-
-			baseFileName := helpers.ConvertToSlug(file.Name)
-			ext := filepath.Ext(baseFileName)
-			baseFileName = strings.TrimSuffix(baseFileName, ext)
-			if strings.ToLower(file.Metadata.Format) == "safetensor" && !strings.EqualFold(ext, ".safetensors") {
-				ext = ".safetensors"
-			}
-			if ext == "" {
-				ext = ".bin"
-				log.Warnf("File %s in version %s (%d) has no extension, defaulting to '.bin'", file.Name, currentVersion.Name, currentVersion.ID)
-			}
-			finalBaseFilenameOnly := baseFileName + ext
-			constructedFileNameOnly := baseFileName + ext // Just base + extension
-			// --- Modify directory path to include version ---
-			fullDirPath := filepath.Join(cfg.SavePath, slug, versionSlug)
-			// --- End directory path modification ---
-			fullFilePath := filepath.Join(fullDirPath, constructedFileNameOnly) // Use filename without suffix
-			// --- End Path/Filename Construction ---
-
-			// Create potentialDownload using currentVersion data
-			pd := potentialDownload{
-				ModelName:         modelResponse.Name,
-				ModelType:         modelResponse.Type,
-				VersionName:       currentVersion.Name,      // Use currentVersion
-				BaseModel:         currentVersion.BaseModel, // Use currentVersion
-				Creator:           modelResponse.Creator,
-				File:              file,
-				ModelVersionID:    currentVersion.ID, // Use currentVersion
-				TargetFilepath:    fullFilePath,      // Path without suffix
-				Slug:              slug,
-				FinalBaseFilename: finalBaseFilenameOnly,     // Keep original base+ext for reference
-				CleanedVersion:    versionWithoutFilesImages, // Use cleaned currentVersion
-				FullVersion:       currentVersion,            // Store the full original version data
-				OriginalImages:    currentVersion.Images,     // Use currentVersion images
+		for _, file := range selectVersionFiles(currentVersion.Files, modelResponse.Type, false) { // Filtered files from currentVersion
+			file, ok := withVersionDownloadUrl(file, currentVersion)
+			if !ok {
+				continue
 			}
+			pd := constructPotentialDownload(modelResponse, currentVersion, file, cfg)
 			potentialDownloadsFromModel = append(potentialDownloadsFromModel, pd)
 			// Log the intended path *without* suffix for clarity in this phase
-			log.Debugf("Passed filters: %s (Model: %s (%d), Version: %s (%d)) -> %s", file.Name, modelResponse.Name, modelID, currentVersion.Name, currentVersion.ID, fullFilePath)
+			log.Debugf("Passed filters: %s (Model: %s (%d), Version: %s (%d)) -> %s", file.Name, modelResponse.Name, modelID, currentVersion.Name, currentVersion.ID, pd.TargetFilepath)
 		} // End fileLoop
 	} // --- End version loop ---
 
@@ -589,7 +473,8 @@ func handleSingleModelDownload(modelID int, db *database.DB, client *http.Client
 
 	// --- Process against DB (Uses processPage) ---
 	log.Debugf("Checking %d potential downloads from model %d against database...", len(potentialDownloadsFromModel), modelID)
-	queuedFromModel, sizeFromModel := processPage(db, potentialDownloadsFromModel, cfg)
+	potentialDownloadsFromModel = appendVaeDownloads(potentialDownloadsFromModel, client, cfg)
+	queuedFromModel, sizeFromModel := processPage(db, potentialDownloadsFromModel, cfg, viper.GetBool("dryrun"))
 	if len(queuedFromModel) > 0 {
 		log.Infof("Queued %d file(s) (Size: %s) from model %d after DB check.", len(queuedFromModel), helpers.BytesToSize(sizeFromModel), modelID)
 	} else {
@@ -599,147 +484,252 @@ func handleSingleModelDownload(modelID int, db *database.DB, client *http.Client
 	return queuedFromModel, sizeFromModel, nil
 }
 
-// fetchModelsPaginated handles the process of fetching models using API pagination.
-func fetchModelsPaginated(db *database.DB, client *http.Client, imageDownloader *downloader.Downloader, queryParams models.QueryParameters, cfg *models.Config, cmd *cobra.Command) ([]potentialDownload, uint64, error) {
-	var allPotentialDownloads []potentialDownload
-	var totalQueuedSizeBytes uint64
-	pageCount := 0
-	nextCursor := ""         // Start with no cursor
-	totalModelsReceived := 0 // Counter for total models *received* across pages for limit check
+// emptyVersionsSkipped collects the IDs of versions skipped for having no files,
+// reported once after the scan. The scan phase runs on a single goroutine.
+var emptyVersionsSkipped []int
 
-	// Get max pages and retry config from Viper
-	maxPages := viper.GetInt("maxpages")    // Viper key from download.go init
-	userTotalLimit := viper.GetInt("limit") // User's intended total limit (0 = unlimited)
-	maxRetries := viper.GetInt("maxretries")
-	initialRetryDelay := time.Duration(viper.GetInt("initialretrydelayms")) * time.Millisecond
-	apiDelayMs := viper.GetInt("apidelayms") // Viper key from root.go init
+// minPublishedAge is the parsed --min-published-age; versions published more recently
+// are left out of version selection. Set by runDownload.
+var minPublishedAge time.Duration
 
-	for {
-		pageCount++
-		if maxPages > 0 && pageCount > maxPages {
-			log.Infof("Reached max page limit (%d). Stopping pagination.", maxPages)
-			break
-		}
+// tooNewVersionsSkipped collects the IDs of versions skipped by --min-published-age.
+var tooNewVersionsSkipped []int
+
+// publishedSince is the parsed --since cutoff; versions published before it are left
+// out of version selection. Zero disables. Set by runDownload.
+var publishedSince time.Time
+
+// tooOldVersionsSkipped collects the IDs of versions skipped by --since.
+var tooOldVersionsSkipped []int
+
+// parsePublishedAt parses a version's PublishedAt timestamp as returned by the API.
+func parsePublishedAt(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("timestamp is empty")
+	}
+	publishedAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		publishedAt, err = time.Parse(time.RFC3339, value)
+	}
+	return publishedAt, err
+}
 
-		// Construct API URL with query parameters
-		apiURL := "https://civitai.com/api/v1/models"
-		params := url.Values{}
-		// Use API default/max limit per page (e.g., 100) for efficiency.
-		// Do NOT send the user's total limit here.
-		params.Set("limit", "100") // Request max items per page
+// parseSinceDate parses a --since value, either a date (2024-01-01, midnight UTC) or
+// an RFC3339 timestamp. An empty string is the zero time.
+func parseSinceDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if since, err := time.Parse("2006-01-02", value); err == nil {
+		return since, nil
+	}
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: expected e.g. 2024-01-01 or 2024-01-01T15:04:05Z", value)
+	}
+	return since, nil
+}
 
-		// Only set query param if it's not empty
-		if queryParams.Query != "" {
-			params.Set("query", queryParams.Query)
+// availableVersions returns the versions eligible for selection. Versions with an
+// empty Files array (metadata-only or removed uploads) are dropped unless
+// --skip-empty-versions is disabled, versions published less than
+// --min-published-age ago are dropped so that the newest settled version is chosen,
+// and with --since versions published before the cutoff (or without a readable
+// timestamp) are dropped.
+func availableVersions(versions []models.ModelVersion, modelName string, modelID int) []models.ModelVersion {
+	skipEmpty := viper.GetBool("skipemptyversions")
+	if !skipEmpty && minPublishedAge <= 0 && publishedSince.IsZero() {
+		return versions
+	}
+	available := make([]models.ModelVersion, 0, len(versions))
+	for _, version := range versions {
+		if skipEmpty && len(version.Files) == 0 {
+			log.Debugf("Skipping version %s (%d) of model %s (%d): no files available.", version.Name, version.ID, modelName, modelID)
+			emptyVersionsSkipped = append(emptyVersionsSkipped, version.ID)
+			continue
 		}
-		if queryParams.Tag != "" {
-			params.Set("tag", queryParams.Tag)
+		publishedAt, errParse := parsePublishedAt(version.PublishedAt)
+		if !publishedSince.IsZero() && (errParse != nil || publishedAt.Before(publishedSince)) {
+			log.Debugf("Skipping version %s (%d) of model %s (%d): published %q, before --since %s.", version.Name, version.ID, modelName, modelID, version.PublishedAt, publishedSince.Format(time.RFC3339))
+			tooOldVersionsSkipped = append(tooOldVersionsSkipped, version.ID)
+			continue
 		}
-		if queryParams.Username != "" {
-			params.Set("username", queryParams.Username)
+		// Otherwise unparseable timestamps are left to the version loop, which warns about them
+		if minPublishedAge > 0 && errParse == nil && time.Since(publishedAt) < minPublishedAge {
+			log.Debugf("Skipping version %s (%d) of model %s (%d): published %s, less than %v ago.", version.Name, version.ID, modelName, modelID, version.PublishedAt, minPublishedAge)
+			tooNewVersionsSkipped = append(tooNewVersionsSkipped, version.ID)
+			continue
 		}
-		if len(queryParams.Types) > 0 {
-			params.Set("types", strings.Join(queryParams.Types, ","))
+		available = append(available, version)
+	}
+	return available
+}
+
+// reportVersionSkips logs how many versions were skipped for having no files or for
+// being published too recently or before --since.
+func reportVersionSkips() {
+	if len(emptyVersionsSkipped) > 0 {
+		log.Infof("Skipped %d versions with no files (use --skip-empty-versions=false to keep them).", len(emptyVersionsSkipped))
+		if viper.GetBool("verboseskips") {
+			log.Infof("Skipped version IDs: %v", emptyVersionsSkipped)
 		}
-		if queryParams.Sort != "" {
-			params.Set("sort", queryParams.Sort)
+	}
+	if len(tooNewVersionsSkipped) > 0 {
+		log.Infof("Skipped %d versions published less than %v ago (--min-published-age).", len(tooNewVersionsSkipped), minPublishedAge)
+		if viper.GetBool("verboseskips") {
+			log.Infof("Skipped version IDs: %v", tooNewVersionsSkipped)
 		}
-		if queryParams.Period != "" {
-			params.Set("period", queryParams.Period)
+	}
+	if len(tooOldVersionsSkipped) > 0 {
+		log.Infof("Skipped %d versions published before %s (--since).", len(tooOldVersionsSkipped), publishedSince.Format("2006-01-02"))
+		if viper.GetBool("verboseskips") {
+			log.Infof("Skipped version IDs: %v", tooOldVersionsSkipped)
 		}
-		if queryParams.PrimaryFileOnly {
-			params.Set("primaryFileOnly", "true")
+	}
+}
+
+// fetchModelsPaginated handles the process of fetching models using API pagination.
+func fetchModelsPaginated(db *database.DB, client *http.Client, imageDownloader *downloader.Downloader, queryParams models.QueryParameters, cfg *models.Config, cmd *cobra.Command) ([]potentialDownload, uint64, error) {
+	var allPotentialDownloads []potentialDownload
+	var totalQueuedSizeBytes uint64
+	pageCount := 0
+	nextCursor := ""         // Start with no cursor
+	totalModelsReceived := 0 // Counter for total models *received* across pages for limit check
+
+	// Get max pages and limits from Viper (retry config is read by fetchModelsPage)
+	maxPages := viper.GetInt("maxpages")            // Viper key from download.go init
+	userTotalLimit := max(viper.GetInt("limit"), 0) // Total models across all pages (0 = unlimited)
+	pageSize := queryParams.Limit                   // Models per request, see setupQueryParams
+	apiDelayMs := viper.GetInt("apidelayms")        // Viper key from root.go init
+
+	// Models that had nothing to select from are reported once at the end instead
+	// of only as individual warnings buried in the log.
+	var skippedNoVersionIDs []int
+	defer func() {
+		if len(skippedNoVersionIDs) == 0 {
+			return
 		}
-		if !queryParams.AllowNoCredit {
-			params.Set("allowNoCredit", "false")
+		log.Infof("Skipped %d models with no downloadable versions.", len(skippedNoVersionIDs))
+		if viper.GetBool("verboseskips") {
+			log.Infof("Skipped model IDs: %v", skippedNoVersionIDs)
+		} else {
+			log.Info("Use --verbose-skips to list their IDs.")
 		}
-		if !queryParams.AllowDerivatives {
-			params.Set("allowDerivatives", "false")
+	}()
+
+	// --- Multi-tag mode: one query per tag, merged client-side ---
+	// Multi-tag and sample mode fetch all candidates up front; they are then
+	// processed as a single page.
+	var preloadedItems []models.Model
+	tags := splitTags(queryParams.Tag)
+	tagMode := strings.ToLower(viper.GetString("tagfiltermode"))
+	multiTag := len(tags) > 1 && (tagMode == "any" || tagMode == "all")
+	if len(tags) > 1 && !multiTag {
+		log.Warnf("Multiple tags given (%s) but --tag-filter-mode is not 'any' or 'all'; the API only supports one tag, so the value is sent as-is.", queryParams.Tag)
+	}
+	if multiTag {
+		var err error
+		preloadedItems, err = fetchModelsForTags(client, cfg, queryParams, tags, tagMode, maxPages, apiDelayMs, cmd)
+		if err != nil {
+			return allPotentialDownloads, totalQueuedSizeBytes, err
 		}
-		if !queryParams.AllowDifferentLicenses {
-			params.Set("allowDifferentLicenses", "false")
+		if len(preloadedItems) == 0 {
+			log.Info("No models matched the requested tag combination.")
+			return allPotentialDownloads, totalQueuedSizeBytes, nil
 		}
-		if queryParams.AllowCommercialUse != "Any" {
-			params.Set("allowCommercialUse", queryParams.AllowCommercialUse)
+	}
+
+	// --- Sample mode: keep a random sample of N from all matching models ---
+	preloaded := multiTag
+	if sampleSize := viper.GetInt("sample"); sampleSize > 0 {
+		seed := viper.GetInt64("sampleseed")
+		if seed == 0 {
+			seed = time.Now().UnixNano()
 		}
-		// Always set nsfw parameter to true or false
-		if queryParams.Nsfw {
-			params.Set("nsfw", "true")
+		reservoir := newModelReservoir(sampleSize, seed)
+		if multiTag {
+			for _, model := range preloadedItems {
+				reservoir.add(model)
+			}
 		} else {
-			params.Set("nsfw", "false")
+			// --limit applies to the sample, so candidates are fetched in full pages
+			sampleParams := queryParams
+			sampleParams.Limit = configuredPageSize()
+			if err := fetchModelsIntoReservoir(client, cfg, sampleParams, reservoir, maxPages, apiDelayMs, cmd); err != nil {
+				return allPotentialDownloads, totalQueuedSizeBytes, err
+			}
 		}
-		if len(queryParams.BaseModels) > 0 {
-			params.Set("baseModels", strings.Join(queryParams.BaseModels, ","))
+		preloadedItems = reservoir.items
+		preloaded = true
+		log.Infof("Sample mode: picked %d of %d matching models (seed %d, use --sample-seed %d to repeat this sample).", len(reservoir.items), reservoir.seen, seed, seed)
+		if len(preloadedItems) == 0 {
+			log.Info("No models matched the query.")
+			return allPotentialDownloads, totalQueuedSizeBytes, nil
 		}
+	}
 
-		if nextCursor != "" {
-			params.Set("cursor", nextCursor)
-			log.Infof("Requesting next page %d with cursor: %s...", pageCount, nextCursor)
+	// --- Resume State ---
+	// The cursor is keyed by the query (without cursor), so only a run with identical
+	// filters picks up a saved position. Preloaded modes fetch everything up front and
+	// dry runs download nothing, so neither saves a cursor.
+	queryHash := modelsQueryHash(queryParams)
+	persistCursor := db != nil && !preloaded && !viper.GetBool("dryrun")
+	if viper.GetBool("resume") {
+		if !persistCursor {
+			log.Warn("--resume has no effect with --dry-run, --sample or multiple tags. Starting from the first page.")
+		} else if savedCursor, err := db.GetModelsCursor(queryHash); err != nil {
+			log.WithError(err).Warn("Failed to read saved models cursor. Starting from the first page.")
+		} else if savedCursor != "" {
+			log.Infof("Resuming pagination from saved cursor: %s", savedCursor)
+			nextCursor = savedCursor
 		} else {
-			log.Infof("Requesting API page %d...", pageCount)
+			log.Info("No saved cursor found for this query. Starting from the first page.")
 		}
+	}
 
-		fullURL := fmt.Sprintf("%s?%s", apiURL, params.Encode())
-		log.Debugf("API Request URL: %s", fullURL)
-		logPrefix := fmt.Sprintf("Page %d", pageCount) // For retry logging
-
-		// --- Check for debug flag --- NEW
-		if printUrl, _ := cmd.Flags().GetBool("debug-print-api-url"); printUrl {
-			fmt.Print(fullURL) // Print only the URL to stdout (No newline)
-			os.Exit(0)         // Exit immediately
-		}
-		// --- End check for debug flag --- NEW
-
-		req, err := http.NewRequest("GET", fullURL, nil)
-		if err != nil {
-			// This error is unlikely recoverable by retry, return directly.
-			return allPotentialDownloads, totalQueuedSizeBytes, fmt.Errorf("failed to create request for page %d: %w", pageCount, err)
-		}
-		if cfg.ApiKey != "" { // Still need ApiKey from config
-			req.Header.Add("Authorization", "Bearer "+cfg.ApiKey)
+	for {
+		pageCount++
+		if maxPages > 0 && pageCount > maxPages {
+			log.Infof("Reached max page limit (%d). Stopping pagination.", maxPages)
+			break
 		}
 
-		// --- Use Retry Helper ---
-		// Assign the unused resp to the blank identifier `_`
-		_, bodyBytes, err := doRequestWithRetry(client, req, maxRetries, initialRetryDelay, logPrefix)
-		// --- End Use Retry Helper ---
-
-		if err != nil {
-			// Error already includes context from doRequestWithRetry
-			// If resp is nil, it's likely a network/read error after retries.
-			// If resp is not nil, it's a non-200 status after retries.
-			// The error message from the helper should be descriptive enough.
-			finalErrMsg := fmt.Sprintf("failed to fetch page %d: %v", pageCount, err)
-			// Check if the error message already contains the body snippet
-			if !strings.Contains(err.Error(), "Body:") && len(bodyBytes) > 0 {
-				bodySample := string(bodyBytes)
-				if len(bodySample) > 200 {
-					bodySample = bodySample[:200] + "..."
-				}
-				finalErrMsg += fmt.Sprintf(". Last Body: %s", bodySample)
+		var response models.ApiResponse
+		if preloaded {
+			// All candidates were already fetched (multi-tag or sample mode); process them as a single page
+			response.Items = preloadedItems
+			preloadedItems = nil
+		} else {
+			if nextCursor != "" {
+				log.Infof("Requesting next page %d with cursor: %s...", pageCount, nextCursor)
+			} else {
+				log.Infof("Requesting API page %d...", pageCount)
 			}
-			// Stop pagination on persistent error for a page
-			return allPotentialDownloads, totalQueuedSizeBytes, fmt.Errorf(finalErrMsg)
-		}
-		// Success case: resp.StatusCode == http.StatusOK and bodyBytes is valid
-
-		var response models.ApiResponse // Use the correct struct name
-		if err := json.Unmarshal(bodyBytes, &response); err != nil {
-			// Use the bodyBytes we already have for context
-			bodySample := string(bodyBytes)
-			if len(bodySample) > 500 { // Allow slightly more for JSON errors
-				bodySample = bodySample[:500] + "..."
+			// Request no more than the total limit still allows
+			pageParams := queryParams
+			pageParams.Limit = pageRequestSize(pageSize, userTotalLimit, totalModelsReceived)
+			var err error
+			response, err = fetchModelsPage(client, cfg, pageParams, nextCursor, fmt.Sprintf("page %d", pageCount), cmd)
+			if err != nil {
+				// Stop pagination on persistent error for a page
+				return allPotentialDownloads, totalQueuedSizeBytes, err
 			}
-			return allPotentialDownloads, totalQueuedSizeBytes, fmt.Errorf("failed to decode API response for page %d: %w. Body: %s", pageCount, err, bodySample)
 		}
 
 		if len(response.Items) == 0 {
 			log.Info("Received empty item list from API, assuming end of results.")
+			if persistCursor {
+				saveModelsCursorState(db, queryHash, "")
+			}
 			break
 		}
 
 		// --- Add to total received models count --- START ---
-		// This counts models *received* from the API page for the limit check
+		// This counts models *received* from the API page for the limit check.
+		// Pages are requested no larger than the remaining limit, but a merged
+		// multi-tag result can still exceed it.
+		response.Items = truncateToLimit(response.Items, userTotalLimit, totalModelsReceived)
 		totalModelsReceived += len(response.Items)
 		log.Debugf("Received %d models so far across all pages.", totalModelsReceived)
 		// --- Add to total received models count --- END ---
@@ -755,123 +745,50 @@ func fetchModelsPaginated(db *database.DB, client *http.Client, imageDownloader
 		}
 
 		// --- Process Models from this Page ---
+		response.Items = dropExcludedTagModels(response.Items, viper.GetStringSlice("excludetags"))
 		var potentialDownloadsThisPage []potentialDownload
 		log.Debugf("Processing %d models from request %d for potential downloads...", len(response.Items), pageCount)
 
-		for _, model := range response.Items {
-			// --- Save Full Model Info / Images if Flag is Set ---
-			// This logic runs regardless of which versions are downloaded later
-			// Use Viper to get these boolean flags
-			saveFullInfo := viper.GetBool("savemodelinfo") // Viper key from download.go init
-			if saveFullInfo {
-				// Determine baseModelSlug based on the latest version for consistent pathing
-				latestInfoVersion := models.ModelVersion{}
-				latestInfoTime := time.Time{}
-				if len(model.ModelVersions) > 0 {
-					for _, v := range model.ModelVersions {
-						pAt, errP := time.Parse(time.RFC3339Nano, v.PublishedAt)
-						if errP != nil {
-							pAt, _ = time.Parse(time.RFC3339, v.PublishedAt)
-						}
-						if errP == nil && (latestInfoVersion.ID == 0 || pAt.After(latestInfoTime)) {
-							latestInfoTime = pAt
-							latestInfoVersion = v
-						}
-					}
-				}
-
-				modelNameSlug := helpers.ConvertToSlug(model.Name)
-				if modelNameSlug == "" {
-					modelNameSlug = "unknown_model"
-				}
-				baseModelSlug := "unknown_base_model"
-				if latestInfoVersion.ID != 0 {
-					baseModelSlug = helpers.ConvertToSlug(latestInfoVersion.BaseModel)
-					if baseModelSlug == "" {
-						baseModelSlug = "unknown_base_model"
-					}
-				}
-
-				// --- Modify slug construction for type/model structure (removing base model for info/images) ---
-				modelInfoSlug := filepath.Join(helpers.ConvertToSlug(model.Type), modelNameSlug)
-				// --- End slug construction modification ---
-				modelBaseDir := filepath.Join(cfg.SavePath, modelInfoSlug) // Path for model info/images
-
-				// Pass the new modelBaseDir to saveModelInfoFile
-				if err := saveModelInfoFile(model, modelBaseDir); err != nil {
-					log.WithError(err).Warnf("Failed to save full model info for model %d (%s)", model.ID, model.Name)
-				}
+		// Model info and images do not affect version selection, so the whole page is
+		// saved up front across a worker pool. Version selection below stays serial.
+		if viper.GetBool("savemodelinfo") { // Viper key from download.go init
+			savePageModelExtras(commandContext(cmd), response.Items, cfg, imageDownloader)
+		}
 
-				saveModelImages := viper.GetBool("savemodelimages") // Viper key from download.go init
-				if saveModelImages {
-					if !saveFullInfo {
-						log.Error("--model-images requires --model-info to be set as well. Aborting image download.")
-					} else {
-						logPrefix := fmt.Sprintf("Model %d Img", model.ID)
-						log.Infof("[%s] Processing all model images for %s (%d)...", logPrefix, model.Name, model.ID)
-						// --- Adjust image paths to new structure ---
-						modelImagesBaseDir := filepath.Join(modelBaseDir, "images")
-						// --- End image path adjustment ---
-						var totalImgSuccess, totalImgFail int = 0, 0
-
-						// Get concurrency level from Viper
-						concurrency := viper.GetInt("concurrency") // Viper key from download.go init
-						if concurrency <= 0 {
-							concurrency = 4
-						} // Simple default if flag missing/invalid
-
-						for _, version := range model.ModelVersions {
-							versionLogPrefix := fmt.Sprintf("%s v%d", logPrefix, version.ID)
-							// --- Adjust version image path ---
-							versionImagesDir := filepath.Join(modelImagesBaseDir, fmt.Sprintf("%d", version.ID))
-							// --- End version image path adjustment ---
-							log.Debugf("[%s] Checking %d images for version %s (%d)", versionLogPrefix, len(version.Images), version.Name, version.ID)
-							if len(version.Images) > 0 {
-								log.Debugf("[%s] Calling downloadImages for %d images...", versionLogPrefix, len(version.Images))
-								// Correct line using := and retrieved concurrency, remove nil writer argument
-								imgSuccess, imgFail := downloadImages(versionLogPrefix, version.Images, versionImagesDir, imageDownloader, concurrency)
-								totalImgSuccess += imgSuccess
-								totalImgFail += imgFail
-							}
-						}
-						log.Infof("[%s] Finished processing images for model %s (%d). Total Success: %d, Total Failed: %d",
-							logPrefix, model.Name, model.ID, totalImgSuccess, totalImgFail)
-					}
-				}
-			} // --- End Save Full Model Info / Images ---
+		for _, model := range response.Items {
 
 			// --- Version Selection / Processing ---
 			// Get value using Viper
 			downloadAll := viper.GetBool("downloadallversions") // Viper key from download.go init
 			versionsToProcess := []models.ModelVersion{}
+			candidateVersions := availableVersions(model.ModelVersions, model.Name, model.ID)
 
 			if downloadAll {
 				log.Debugf("Processing all versions for model %s (%d) due to --all-versions flag.", model.Name, model.ID)
-				if len(model.ModelVersions) == 0 {
+				if len(candidateVersions) == 0 {
 					log.Warnf("Model %s (%d) has no versions listed to process.", model.Name, model.ID)
+					skippedNoVersionIDs = append(skippedNoVersionIDs, model.ID)
 					continue // Skip this model
 				}
-				versionsToProcess = model.ModelVersions
+				versionsToProcess = candidateVersions
 			} else {
 				// Find the latest version if not downloading all
 				latestVersion := models.ModelVersion{}
 				latestTime := time.Time{}
-				if len(model.ModelVersions) == 0 {
+				if len(candidateVersions) == 0 {
 					log.Warnf("Model %s (%d) has no versions listed to process.", model.Name, model.ID)
+					skippedNoVersionIDs = append(skippedNoVersionIDs, model.ID)
 					continue // Skip this model
 				}
-				for _, version := range model.ModelVersions {
+				for _, version := range candidateVersions {
 					if version.PublishedAt == "" {
 						log.Warnf("Skipping version %s in model %s (%d): PublishedAt timestamp is empty.", version.Name, model.Name, model.ID)
 						continue
 					}
-					publishedAt, errParse := time.Parse(time.RFC3339Nano, version.PublishedAt)
+					publishedAt, errParse := parsePublishedAt(version.PublishedAt)
 					if errParse != nil {
-						publishedAt, errParse = time.Parse(time.RFC3339, version.PublishedAt)
-						if errParse != nil {
-							log.WithError(errParse).Warnf("Skipping version %s in model %s (%d): Error parsing time '%s'", version.Name, model.Name, model.ID, version.PublishedAt)
-							continue
-						}
+						log.WithError(errParse).Warnf("Skipping version %s in model %s (%d): Error parsing time '%s'", version.Name, model.Name, model.ID, version.PublishedAt)
+						continue
 					}
 					if latestVersion.ID == 0 || publishedAt.After(latestTime) {
 						latestTime = publishedAt
@@ -880,6 +797,7 @@ func fetchModelsPaginated(db *database.DB, client *http.Client, imageDownloader
 				}
 				if latestVersion.ID == 0 {
 					log.Warnf("No valid latest version found for model %s (%d). Skipping.", model.Name, model.ID)
+					skippedNoVersionIDs = append(skippedNoVersionIDs, model.ID)
 					continue // Skip this model
 				}
 				log.Debugf("Processing latest version %s (%d) for model %s (%d).", latestVersion.Name, latestVersion.ID, model.Name, model.ID)
@@ -901,101 +819,35 @@ func fetchModelsPaginated(db *database.DB, client *http.Client, imageDownloader
 					}
 				}
 
-				// Prepare cleaned version for metadata/DB
-				versionWithoutFilesImages := currentVersion
-				versionWithoutFilesImages.Files = nil
-				versionWithoutFilesImages.Images = nil
-
-			fileLoop: // Label for continue
-				for _, file := range currentVersion.Files { // Use files from currentVersion
-					// Use the shared filtering function
-					if !passesFileFilters(file, model.Type) {
-						continue fileLoop // Skip this file if it doesn't pass filters
-					}
-
-					// --- Path/Filename Construction (using currentVersion) ---
-					var slug string // Now only used for file path
-					modelTypeName := helpers.ConvertToSlug(model.Type)
-					baseModelStr := currentVersion.BaseModel // Use currentVersion
-					if baseModelStr == "" {
-						baseModelStr = "unknown-base"
-					}
-					baseModelSlug := helpers.ConvertToSlug(baseModelStr)
-					modelNameSlug := helpers.ConvertToSlug(model.Name)
-					// --- Modify slug construction for type/model/base structure ---
-					slug = filepath.Join(modelTypeName, modelNameSlug, baseModelSlug) // Changed order for file path
-					// --- End slug construction modification ---
-
-					// --- Create version specific slug based on file name (without extension) ---
-					fileNameWithoutExt := strings.TrimSuffix(file.Name, filepath.Ext(file.Name))
-					versionSlug := fmt.Sprintf("%d-%s", currentVersion.ID, helpers.ConvertToSlug(fileNameWithoutExt))
-					// --- End version specific slug ---
-
-					baseFileName := helpers.ConvertToSlug(file.Name)
-					ext := filepath.Ext(baseFileName)
-					baseFileName = strings.TrimSuffix(baseFileName, ext)
-					if strings.ToLower(file.Metadata.Format) == "safetensor" && !strings.EqualFold(ext, ".safetensors") {
-						ext = ".safetensors"
-					}
-					if ext == "" {
-						ext = ".bin"
-						log.Warnf("File %s in version %s (%d) has no extension, defaulting to '.bin'", file.Name, currentVersion.Name, currentVersion.ID)
-					}
-					finalBaseFilenameOnly := baseFileName + ext
-					constructedFileNameOnly := baseFileName + ext // Just base + extension
-					// --- Modify directory path to include version ---
-					fullDirPath := filepath.Join(cfg.SavePath, slug, versionSlug)
-					// --- End directory path modification ---
-					fullFilePath := filepath.Join(fullDirPath, constructedFileNameOnly) // Use filename without suffix
-					// --- End Path/Filename Construction ---
-
-					// Create potentialDownload using currentVersion data
-					pd := potentialDownload{
-						ModelName:         model.Name,
-						ModelType:         model.Type,
-						VersionName:       currentVersion.Name,      // Use currentVersion
-						BaseModel:         currentVersion.BaseModel, // Use currentVersion
-						Creator:           model.Creator,
-						File:              file,
-						ModelVersionID:    currentVersion.ID, // Use currentVersion
-						TargetFilepath:    fullFilePath,      // Path without suffix
-						Slug:              slug,
-						FinalBaseFilename: finalBaseFilenameOnly,     // Keep original base+ext for reference
-						CleanedVersion:    versionWithoutFilesImages, // Use cleaned currentVersion
-						FullVersion:       currentVersion,            // Store the full original version data
-						OriginalImages:    currentVersion.Images,     // Use currentVersion images
+				for _, file := range selectVersionFiles(currentVersion.Files, model.Type, queryParams.PrimaryFileOnly) { // Filtered files from currentVersion
+					file, ok := withVersionDownloadUrl(file, currentVersion)
+					if !ok {
+						continue
 					}
+					pd := constructPotentialDownload(model, currentVersion, file, cfg)
 					potentialDownloadsThisPage = append(potentialDownloadsThisPage, pd)
 					// Log the intended path *without* suffix for clarity in this phase
-					log.Debugf("Passed filters: %s (Model: %s (%d), Version: %s (%d)) -> %s", file.Name, model.Name, model.ID, currentVersion.Name, currentVersion.ID, fullFilePath)
+					log.Debugf("Passed filters: %s (Model: %s (%d), Version: %s (%d)) -> %s", file.Name, model.Name, model.ID, currentVersion.Name, currentVersion.ID, pd.TargetFilepath)
 				} // End fileLoop
 			} // --- End version loop ---
-
-			// --- Process this page's potential downloads against the DB ---
-			log.Debugf("Checking %d potential downloads from page %d against database...", len(potentialDownloadsThisPage), pageCount)
-			// Assuming processPage is available after refactoring
-			queuedFromPage, sizeFromPage := processPage(db, potentialDownloadsThisPage, cfg)
-			if len(queuedFromPage) > 0 {
-				allPotentialDownloads = append(allPotentialDownloads, queuedFromPage...)
-				totalQueuedSizeBytes += sizeFromPage
-				log.Infof("Queued %d file(s) (Size: %s) from page %d after DB check.", len(queuedFromPage), helpers.BytesToSize(sizeFromPage), pageCount)
-			} else {
-				log.Debugf("No new files queued from page %d after DB check.", pageCount)
-			}
-
-			// --- Check Total Limit --- START ---
-			if userTotalLimit > 0 && totalModelsReceived >= userTotalLimit {
-				log.Infof("Reached total model limit (%d). Stopping pagination.", userTotalLimit)
-				break // Stop fetching more pages
-			}
-			// --- Check Total Limit --- END ---
-
-			// --- Stop if no cursor --- (Moved after total limit check)
-			if nextCursor == "" {
-				break // No more pages
-			}
 		} // End model loop for this page
 
+		// --- Process this page's potential downloads against the DB ---
+		log.Debugf("Checking %d potential downloads from page %d against database...", len(potentialDownloadsThisPage), pageCount)
+		// Assuming processPage is available after refactoring
+		queuedFromPage, sizeFromPage := processPage(db, appendVaeDownloads(potentialDownloadsThisPage, client, cfg), cfg, viper.GetBool("dryrun"))
+		if len(queuedFromPage) > 0 {
+			allPotentialDownloads = append(allPotentialDownloads, queuedFromPage...)
+			totalQueuedSizeBytes += sizeFromPage
+			log.Infof("Queued %d file(s) (Size: %s) from page %d after DB check.", len(queuedFromPage), helpers.BytesToSize(sizeFromPage), pageCount)
+		} else {
+			log.Debugf("No new files queued from page %d after DB check.", pageCount)
+		}
+		// The page is in the DB now, so a --resume run can continue after it
+		if persistCursor {
+			saveModelsCursorState(db, queryHash, nextCursor)
+		}
+
 		// --- Check Total Limit --- START ---
 		if userTotalLimit > 0 && totalModelsReceived >= userTotalLimit {
 			log.Infof("Reached total model limit (%d). Stopping pagination.", userTotalLimit)
@@ -1019,6 +871,231 @@ func fetchModelsPaginated(db *database.DB, client *http.Client, imageDownloader
 	return allPotentialDownloads, totalQueuedSizeBytes, nil
 }
 
+// modelsQueryHash identifies a models query for the --resume cursor, ignoring the cursor
+// itself. It is cut to 128 bits to keep the DB key within bitcask's 64 byte key limit.
+func modelsQueryHash(queryParams models.QueryParameters) string {
+	sum := sha256.Sum256([]byte(api.ModelsURL(queryParams, "")))
+	return fmt.Sprintf("%x", sum[:16])
+}
+
+// saveModelsCursorState records the cursor of the next page for a subsequent --resume
+// run. An empty cursor means pagination completed and clears the saved one, so the
+// next run starts fresh.
+func saveModelsCursorState(db *database.DB, queryHash string, cursor string) {
+	if cursor == "" {
+		if err := db.DeleteModelsCursor(queryHash); err != nil {
+			log.WithError(err).Warn("Failed to clear saved models cursor.")
+		}
+		return
+	}
+	if err := db.SetModelsCursor(queryHash, cursor); err != nil {
+		log.WithError(err).Warn("Failed to save models cursor for --resume.")
+	}
+}
+
+// splitTags splits a comma-separated --tag value into trimmed, non-empty tags.
+func splitTags(tagValue string) []string {
+	var tags []string
+	for _, t := range strings.Split(tagValue, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// fetchModelsForTags runs the models query once per tag (each limited to maxPages
+// pages) and merges the results client-side: "any" keeps models found for at least
+// one tag, "all" keeps only models found for every tag. Models are deduplicated by
+// ID and returned in the order they were first seen.
+func fetchModelsForTags(client *http.Client, cfg *models.Config, queryParams models.QueryParameters, tags []string, mode string, maxPages int, apiDelayMs int, cmd *cobra.Command) ([]models.Model, error) {
+	log.Infof("Multi-tag mode '%s': running %d separate queries (%s), up to %s each.", mode, len(tags), strings.Join(tags, ", "), pagesLabel(maxPages))
+
+	modelsByID := make(map[int]models.Model)
+	var order []int
+	tagHits := make(map[int]int) // Number of tags each model was found for
+	totalRequests := 0
+
+	for _, tag := range tags {
+		tagParams := queryParams
+		tagParams.Tag = tag
+		seenForTag := make(map[int]struct{})
+		cursor := ""
+		for page := 1; maxPages <= 0 || page <= maxPages; page++ {
+			if totalRequests > 0 && apiDelayMs > 0 {
+				time.Sleep(time.Duration(apiDelayMs) * time.Millisecond)
+			}
+			totalRequests++
+			log.Infof("Requesting tag '%s' page %d...", tag, page)
+			response, err := fetchModelsPage(client, cfg, tagParams, cursor, fmt.Sprintf("tag '%s' page %d", tag, page), cmd)
+			if err != nil {
+				return nil, err
+			}
+			for _, model := range response.Items {
+				if _, dup := seenForTag[model.ID]; dup {
+					continue
+				}
+				seenForTag[model.ID] = struct{}{}
+				tagHits[model.ID]++
+				if _, known := modelsByID[model.ID]; !known {
+					modelsByID[model.ID] = model
+					order = append(order, model.ID)
+				}
+			}
+			cursor = response.Metadata.NextCursor
+			if len(response.Items) == 0 || cursor == "" {
+				break
+			}
+		}
+		log.Infof("Tag '%s': %d models.", tag, len(seenForTag))
+	}
+
+	var merged []models.Model
+	for _, id := range order {
+		if mode == "all" && tagHits[id] < len(tags) {
+			continue
+		}
+		merged = append(merged, modelsByID[id])
+	}
+	log.Infof("Multi-tag mode '%s': %d API requests, %d unique models found, %d kept after merging.", mode, totalRequests, len(order), len(merged))
+	return merged, nil
+}
+
+// modelReservoir keeps a uniform random sample of up to size models from a stream
+// of unknown length (reservoir sampling, algorithm R).
+type modelReservoir struct {
+	size  int
+	seen  int
+	rng   *rand.Rand
+	items []models.Model
+}
+
+func newModelReservoir(size int, seed int64) *modelReservoir {
+	return &modelReservoir{size: size, rng: rand.New(rand.NewSource(seed))}
+}
+
+// add offers model to the sample.
+func (r *modelReservoir) add(model models.Model) {
+	r.seen++
+	if len(r.items) < r.size {
+		r.items = append(r.items, model)
+		return
+	}
+	if j := r.rng.Intn(r.seen); j < r.size {
+		r.items[j] = model
+	}
+}
+
+// fetchModelsIntoReservoir pages through all results of queryParams (up to maxPages)
+// and offers every model to reservoir, so only the sample is kept in memory.
+func fetchModelsIntoReservoir(client *http.Client, cfg *models.Config, queryParams models.QueryParameters, reservoir *modelReservoir, maxPages int, apiDelayMs int, cmd *cobra.Command) error {
+	log.Infof("Sample mode: fetching %s of results to sample %d models from...", pagesLabel(maxPages), reservoir.size)
+	cursor := ""
+	for page := 1; maxPages <= 0 || page <= maxPages; page++ {
+		if page > 1 && apiDelayMs > 0 {
+			time.Sleep(time.Duration(apiDelayMs) * time.Millisecond)
+		}
+		log.Infof("Requesting sample candidates page %d...", page)
+		response, err := fetchModelsPage(client, cfg, queryParams, cursor, fmt.Sprintf("sample page %d", page), cmd)
+		if err != nil {
+			return err
+		}
+		for _, model := range response.Items {
+			reservoir.add(model)
+		}
+		cursor = response.Metadata.NextCursor
+		if len(response.Items) == 0 || cursor == "" {
+			break
+		}
+	}
+	return nil
+}
+
+// pagesLabel describes a --max-pages value for log messages.
+func pagesLabel(maxPages int) string {
+	if maxPages <= 0 {
+		return "all pages"
+	}
+	return fmt.Sprintf("%d page(s)", maxPages)
+}
+
+// fetchModelsPage requests one page of the /models endpoint for queryParams,
+// continuing from cursor if set. pageLabel is used in log and error messages.
+func fetchModelsPage(client *http.Client, cfg *models.Config, queryParams models.QueryParameters, cursor string, pageLabel string, cmd *cobra.Command) (models.ApiResponse, error) {
+	var response models.ApiResponse
+
+	fullURL := api.ModelsURL(queryParams, cursor)
+	log.Debugf("API Request URL: %s", fullURL)
+	logPrefix := pageLabel // For retry logging
+
+	// --- Check for debug flag --- NEW
+	if printUrl, _ := cmd.Flags().GetBool("debug-print-api-url"); printUrl {
+		fmt.Print(fullURL) // Print only the URL to stdout (No newline)
+		os.Exit(0)         // Exit immediately
+	}
+	// --- End check for debug flag --- NEW
+
+	req, err := http.NewRequest("GET", fullURL, nil)
+	if err != nil {
+		// This error is unlikely recoverable by retry, return directly.
+		return response, fmt.Errorf("failed to create request for %s: %w", pageLabel, err)
+	}
+	if cfg.ApiKey != "" { // Still need ApiKey from config
+		req.Header.Add("Authorization", "Bearer "+cfg.ApiKey)
+	}
+
+	// --- Use Retry Helper ---
+	// Assign the unused resp to the blank identifier `_`
+	_, bodyBytes, err := api.DoRequestWithRetry(client, req, apiRetryOptions(logPrefix))
+	// --- End Use Retry Helper ---
+
+	if err != nil {
+		// Error already includes context from DoRequestWithRetry
+		// If resp is nil, it's likely a network/read error after retries.
+		// If resp is not nil, it's a non-200 status after retries.
+		// The error message from the helper should be descriptive enough.
+		finalErrMsg := fmt.Sprintf("failed to fetch %s: %v", pageLabel, err)
+		// Check if the error message already contains the body snippet
+		if !strings.Contains(err.Error(), "Body:") && len(bodyBytes) > 0 {
+			bodySample := string(bodyBytes)
+			if len(bodySample) > 200 {
+				bodySample = bodySample[:200] + "..."
+			}
+			finalErrMsg += fmt.Sprintf(". Last Body: %s", bodySample)
+		}
+		return response, fmt.Errorf("%s", finalErrMsg)
+	}
+	// Success case: resp.StatusCode == http.StatusOK and bodyBytes is valid
+
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		// Use the bodyBytes we already have for context
+		bodySample := string(bodyBytes)
+		if len(bodySample) > 500 { // Allow slightly more for JSON errors
+			bodySample = bodySample[:500] + "..."
+		}
+		return response, fmt.Errorf("failed to decode API response for %s: %w. Body: %s", pageLabel, err, bodySample)
+	}
+	return response, nil
+}
+
+// pageRequestSize returns how many models to request for the next page: pageSize,
+// or fewer if only that many are left before totalLimit (0 = no total limit) is reached.
+func pageRequestSize(pageSize, totalLimit, received int) int {
+	if totalLimit > 0 && totalLimit-received < pageSize {
+		return max(totalLimit-received, 0)
+	}
+	return pageSize
+}
+
+// truncateToLimit drops the items of a page that go beyond totalLimit (0 = no total
+// limit), given the number of models received on earlier pages.
+func truncateToLimit(items []models.Model, totalLimit, received int) []models.Model {
+	if totalLimit > 0 && received+len(items) > totalLimit {
+		return items[:max(totalLimit-received, 0)]
+	}
+	return items
+}
+
 // min is a helper function to find the minimum of two integers.
 func min(a, b int) int {
 	if a < b {
@@ -1026,3 +1103,32 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+// dropExcludedTagModels returns the models that carry none of the excluded tags,
+// compared case-insensitively. The API can only include a tag, not exclude one.
+func dropExcludedTagModels(pageModels []models.Model, excludedTags []string) []models.Model {
+	if len(excludedTags) == 0 {
+		return pageModels
+	}
+	kept := pageModels[:0]
+	for _, model := range pageModels {
+		if tag, excluded := excludedModelTag(model.Tags, excludedTags); excluded {
+			log.Debugf("Skipping model %s (%d): tagged '%s', which is excluded by --exclude-tags.", model.Name, model.ID, tag)
+			continue
+		}
+		kept = append(kept, model)
+	}
+	return kept
+}
+
+// excludedModelTag returns the first of tags that is in excludedTags.
+func excludedModelTag(tags []string, excludedTags []string) (string, bool) {
+	for _, tag := range tags {
+		for _, excluded := range excludedTags {
+			if excluded != "" && strings.EqualFold(strings.TrimSpace(tag), strings.TrimSpace(excluded)) {
+				return tag, true
+			}
+		}
+	}
+	return "", false
+}
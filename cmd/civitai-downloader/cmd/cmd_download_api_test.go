@@ -0,0 +1,1326 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-civitai-download/index"
+	"go-civitai-download/internal/api"
+	"go-civitai-download/internal/database"
+	"go-civitai-download/internal/downloader"
+	"go-civitai-download/internal/models"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// TestSelectVersionFilesPrimaryFileOnly covers a version returned for a models query
+// sent with primaryFileOnly=true whose only file is not flagged primary. The client
+// used to re-apply its own primary check and end up with no files for the version.
+func TestSelectVersionFilesPrimaryFileOnly(t *testing.T) {
+	viper.Set("primaryonly", true)
+	defer viper.Set("primaryonly", false)
+
+	files := []models.File{{
+		ID:       1,
+		Name:     "model.safetensors",
+		Primary:  false, // API returned it as the primary file but did not flag it
+		Hashes:   models.Hashes{CRC32: "4c6b15d9"},
+		Metadata: models.Metadata{Format: "SafeTensor"},
+	}}
+
+	tests := []struct {
+		name               string
+		apiFilteredPrimary bool
+		recheck            bool
+		want               int
+	}{
+		{"API filtered, client trusts API", true, false, 1},
+		{"API filtered, --recheck-primary", true, true, 0},
+		{"Not filtered by API (e.g. --model-id)", false, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("recheckprimary", tt.recheck)
+			defer viper.Set("recheckprimary", false)
+			if got := selectVersionFiles(files, "LORA", tt.apiFilteredPrimary); len(got) != tt.want {
+				t.Errorf("selectVersionFiles() returned %d files, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+// TestPassesFileFiltersFormats checks the --formats list: case-insensitive matching,
+// several accepted formats, and an empty list accepting everything.
+func TestPassesFileFiltersFormats(t *testing.T) {
+	defer viper.Set("formats", []string{"SafeTensor"})
+
+	file := func(format string) models.File {
+		return models.File{Name: "model", Hashes: models.Hashes{CRC32: "4c6b15d9"}, Metadata: models.Metadata{Format: format}}
+	}
+	tests := []struct {
+		name    string
+		formats []string
+		format  string
+		want    bool
+	}{
+		{"SafeTensor only, safetensor", []string{"SafeTensor"}, "SafeTensor", true},
+		{"SafeTensor only, pickle", []string{"SafeTensor"}, "PickleTensor", false},
+		{"SafeTensor only, missing format", []string{"SafeTensor"}, "", false},
+		{"PickleTensor only, pickle (case-insensitive)", []string{"pickletensor"}, "PickleTensor", true},
+		{"PickleTensor only, safetensor", []string{"PickleTensor"}, "SafeTensor", false},
+		{"Multi-format, pickle", []string{"SafeTensor", "PickleTensor"}, "PickleTensor", true},
+		{"Multi-format, gguf", []string{"SafeTensor", "PickleTensor"}, "GGUF", false},
+		{"Empty list accepts all", []string{}, "Other", true},
+		{"Empty list accepts missing format", []string{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("formats", tt.formats)
+			if got := passesFileFilters(file(tt.format), "Checkpoint", false); got != tt.want {
+				t.Errorf("passesFileFilters(format %q) with formats %v = %v, want %v", tt.format, tt.formats, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPassesFileFiltersSize checks --min-size and --max-size against a 1GB file.
+func TestPassesFileFiltersSize(t *testing.T) {
+	defer viper.Set("minsizebytes", "")
+	defer viper.Set("maxsizebytes", "")
+
+	file := models.File{Name: "model", SizeKB: 1024 * 1024, Hashes: models.Hashes{CRC32: "4c6b15d9"}, Metadata: models.Metadata{Format: "SafeTensor"}}
+	tests := []struct {
+		name             string
+		minSize, maxSize string
+		want             bool
+	}{
+		{"no limits", "", "", true},
+		{"max 500MB", "", "500MB", false},
+		{"max 2GB", "", "2GB", true},
+		{"max exactly 1GB", "", "1GB", true},
+		{"min 2GB", "2GB", "", false},
+		{"min 500MB", "500MB", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("minsizebytes", tt.minSize)
+			viper.Set("maxsizebytes", tt.maxSize)
+			if got := passesFileFilters(file, "LORA", false); got != tt.want {
+				t.Errorf("passesFileFilters(1GB) with min %q, max %q = %v, want %v", tt.minSize, tt.maxSize, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPassesFileFiltersSafeScan checks --require-safe-scan against Success, Pending
+// and Danger scan results, and that files pass regardless when it is off.
+func TestPassesFileFiltersSafeScan(t *testing.T) {
+	defer viper.Set("requiresafescan", false)
+
+	file := func(virus, pickle string) models.File {
+		return models.File{Name: "model", VirusScanResult: virus, PickleScanResult: pickle, Hashes: models.Hashes{CRC32: "4c6b15d9"}, Metadata: models.Metadata{Format: "SafeTensor"}}
+	}
+	tests := []struct {
+		name          string
+		require       bool
+		virus, pickle string
+		want          bool
+	}{
+		{"both Success", true, "Success", "Success", true},
+		{"case-insensitive", true, "success", "SUCCESS", true},
+		{"virus Pending", true, "Pending", "Success", false},
+		{"pickle Danger", true, "Success", "Danger", false},
+		{"missing results", true, "", "", false},
+		{"off keeps Danger", false, "Danger", "Danger", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("requiresafescan", tt.require)
+			if got := passesFileFilters(file(tt.virus, tt.pickle), "LORA", false); got != tt.want {
+				t.Errorf("passesFileFilters(virus %q, pickle %q) with require %v = %v, want %v", tt.virus, tt.pickle, tt.require, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBuildTargetPath renders several path templates, including ones where values are
+// missing and ones that are invalid and fall back to the default layout.
+func TestBuildTargetPath(t *testing.T) {
+	defer viper.Set("pathtemplate", defaultPathTemplate)
+
+	full := newPathTemplateData("My Model", "LORA", models.ModelVersion{ID: 42, BaseModel: "SDXL 1.0"}, "Some Creator", "my_model_v2.safetensors")
+	missing := newPathTemplateData("My Model", "LORA", models.ModelVersion{ID: 42}, "", "my_model_v2.safetensors")
+	tests := []struct {
+		name     string
+		template string
+		data     pathTemplateData
+		want     string
+	}{
+		{"default (empty setting)", "", full, filepath.Join("lora", "my_model", "sdxl_1.0", "42-my_model_v2")},
+		{"default template", defaultPathTemplate, full, filepath.Join("lora", "my_model", "sdxl_1.0", "42-my_model_v2")},
+		{"creator first", "{{.Creator}}/{{.ModelType}}/{{.ModelName}}/{{.VersionDir}}", full, filepath.Join("some_creator", "lora", "my_model", "42-my_model_v2")},
+		{"missing base model and creator", "{{.Creator}}/{{.BaseModel}}/{{ .VersionDir }}", missing, filepath.Join("unknown_creator", "unknown-base", "42-my_model_v2")},
+		{"empty component dropped", "{{.ModelType}}//{{.VersionDir}}/", full, filepath.Join("lora", "42-my_model_v2")},
+		{"unknown field falls back", "{{.Nope}}/{{.VersionDir}}", full, filepath.Join("lora", "my_model", "sdxl_1.0", "42-my_model_v2")},
+		{"parent directory falls back", "../{{.VersionDir}}", full, filepath.Join("lora", "my_model", "sdxl_1.0", "42-my_model_v2")},
+		{"flat falls back", "{{.ModelName}}_{{.VersionID}}", full, filepath.Join("lora", "my_model", "sdxl_1.0", "42-my_model_v2")},
+		{"version dir alone falls back", "{{.VersionDir}}", full, filepath.Join("lora", "my_model", "sdxl_1.0", "42-my_model_v2")},
+		{"empty parent falls back", "{{.VersionName}}/{{.VersionDir}}", full, filepath.Join("lora", "my_model", "sdxl_1.0", "42-my_model_v2")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("pathtemplate", tt.template)
+			if got := buildTargetPath(tt.data); got != tt.want {
+				t.Errorf("buildTargetPath() with %q = %q, want %q", tt.template, got, tt.want)
+			}
+		})
+	}
+
+	if err := validatePathTemplate("{{.Nope}}"); err == nil {
+		t.Error("validatePathTemplate accepted an unknown field")
+	}
+	if err := validatePathTemplate("{{.ModelName"); err == nil {
+		t.Error("validatePathTemplate accepted an unparsable template")
+	}
+	if err := validatePathTemplate("{{.ModelType}}/{{.ModelName}}-{{.VersionID}}"); err == nil {
+		t.Error("validatePathTemplate accepted a template not ending in {{.VersionDir}}")
+	}
+}
+
+// TestBuildModelDir checks the model directory torrent and pack work on under
+// several path templates and a TypeFolderMap folder with more than one component.
+func TestBuildModelDir(t *testing.T) {
+	defer viper.Set("pathtemplate", defaultPathTemplate)
+	defer viper.Set("typefoldermap", nil)
+
+	viper.Set("typefoldermap", map[string]string{"Checkpoint": filepath.Join("models", "Stable-diffusion")})
+	data := newPathTemplateData("My Model", "Checkpoint", models.ModelVersion{ID: 42, BaseModel: "SDXL 1.0"}, "Some Creator", "my_model_v2.safetensors")
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{defaultPathTemplate, filepath.Join("models", "Stable-diffusion", "my_model")},
+		{"{{.Creator}}/{{.ModelType}}/{{.ModelName}}/{{.BaseModel}}/{{.VersionDir}}", filepath.Join("some_creator", "models", "Stable-diffusion", "my_model")},
+		{"{{.ModelType}}/{{.ModelName}}-{{.BaseModel}}/{{.VersionDir}}", filepath.Join("models", "Stable-diffusion", "my_model-sdxl_1.0")},
+		{"{{.Creator}}/{{.VersionDir}}", "some_creator"}, // No model name, the directory above the version directory
+	}
+	for _, tt := range tests {
+		viper.Set("pathtemplate", tt.template)
+		if got := buildModelDir(data, buildTargetPath(data)); got != tt.want {
+			t.Errorf("buildModelDir() with %q = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+// TestVersionDirStyle checks the version directory name of each --version-dir-style,
+// and that the model directory above it (used by torrent and pack) stays the same.
+func TestVersionDirStyle(t *testing.T) {
+	defer viper.Set("versiondirstyle", versionDirStyleIDSlug)
+
+	cfg := &models.Config{SavePath: "/downloads"}
+	model := models.Model{ID: 7, Name: "My Model", Type: "LORA"}
+	version := models.ModelVersion{ID: 42, ModelId: 7, Name: "Version Two", BaseModel: "SDXL 1.0", PublishedAt: "2024-05-01T12:30:00.000Z"}
+	file := models.File{Name: "my_model_v2.safetensors", Metadata: models.Metadata{Format: "SafeTensor"}}
+	modelDir := filepath.Join("lora", "my_model", "sdxl_1.0")
+
+	tests := []struct {
+		style   string
+		version models.ModelVersion
+		want    string
+	}{
+		{"", version, "42-my_model_v2"},
+		{versionDirStyleIDSlug, version, "42-my_model_v2"},
+		{versionDirStyleName, version, "version_two-42"},
+		{versionDirStyleDate, version, "2024-05-01-42"},
+		{versionDirStyleID, version, "42"},
+		{versionDirStyleDate, models.ModelVersion{ID: 42, Name: "Version Two", BaseModel: "SDXL 1.0"}, "42"},
+	}
+	for _, tt := range tests {
+		viper.Set("versiondirstyle", tt.style)
+		pd := constructPotentialDownload(model, tt.version, file, cfg)
+		if got := filepath.Base(filepath.Dir(pd.TargetFilepath)); got != tt.want {
+			t.Errorf("style %q: version directory = %q, want %q", tt.style, got, tt.want)
+		}
+		if pd.Slug != modelDir {
+			t.Errorf("style %q: slug = %q, want %q", tt.style, pd.Slug, modelDir)
+		}
+	}
+
+	if !validVersionDirStyle("Date") || validVersionDirStyle("nope") {
+		t.Error("validVersionDirStyle did not accept exactly the documented styles")
+	}
+}
+
+// TestConstructPotentialDownloadSameTarget checks that a file found through
+// --model-version-id (model summary only, no creator) and through the models query or
+// --model-id (full model) gets the same target path. The single-version path used to
+// append a CRC32/fp/size suffix the others did not.
+func TestConstructPotentialDownloadSameTarget(t *testing.T) {
+	cfg := &models.Config{SavePath: "/downloads"}
+	version := models.ModelVersion{ID: 42, ModelId: 7, Name: "v2.0", BaseModel: "SDXL 1.0"}
+	version.Model.Name = "My Model"
+	version.Model.Type = "Checkpoint"
+	fullModel := models.Model{ID: 7, Name: "My Model", Type: "Checkpoint", Creator: models.Creator{Username: "someone"}}
+
+	files := []models.File{
+		{Name: "My Model v2.safetensors", Hashes: models.Hashes{CRC32: "4C6B15D9"}, Metadata: models.Metadata{Format: "SafeTensor", Fp: "fp16", Size: "pruned"}},
+		{Name: "my_model_v2.ckpt", Hashes: models.Hashes{CRC32: "0A1B2C3D"}, Metadata: models.Metadata{Format: "PickleTensor"}},
+		{Name: "model-without-ext", Hashes: models.Hashes{CRC32: "DEADBEEF"}, Metadata: models.Metadata{Format: "SafeTensor"}},
+	}
+	for _, file := range files {
+		single := constructPotentialDownload(modelFromVersion(version), version, file, cfg)
+		paginated := constructPotentialDownload(fullModel, version, file, cfg)
+		if single.TargetFilepath != paginated.TargetFilepath {
+			t.Errorf("%s: single-version target %q differs from paginated target %q", file.Name, single.TargetFilepath, paginated.TargetFilepath)
+		}
+		if single.Slug != paginated.Slug {
+			t.Errorf("%s: single-version slug %q differs from paginated slug %q", file.Name, single.Slug, paginated.Slug)
+		}
+	}
+
+	pd := constructPotentialDownload(fullModel, version, files[0], cfg)
+	want := filepath.Join("/downloads", "checkpoint", "my_model", "sdxl_1.0", "42-my_model_v2", "my_model_v2.safetensors")
+	if pd.TargetFilepath != want {
+		t.Errorf("TargetFilepath = %q, want %q", pd.TargetFilepath, want)
+	}
+	if pd.CleanedVersion.Files != nil || pd.CleanedVersion.Images != nil {
+		t.Error("CleanedVersion should not carry files or images")
+	}
+}
+
+// TestDownloadReport records a mocked set of job results, writes the --report file and
+// checks the parsed counts and per-version entries.
+func TestDownloadReport(t *testing.T) {
+	report := newDownloadReport(5)
+	report.record(1, "/dl/a.safetensors", reportStatusDownloaded, 100, nil)
+	report.record(2, "/dl/b.safetensors", reportStatusSkippedExisting, 50, nil)
+	report.record(2, "/dl/b.vae.safetensors", reportStatusDownloaded, 10, nil)
+	report.record(3, "/dl/c.safetensors", reportStatusFailed, 0, errors.New("hash mismatch"))
+	// The fifth job was never attempted
+
+	path := filepath.Join(t.TempDir(), "reports", "run.json")
+	if err := report.write(path); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading report: %v", err)
+	}
+	var parsed struct {
+		Queued          int                             `json:"queued"`
+		Succeeded       int                             `json:"succeeded"`
+		SkippedExisting int                             `json:"skippedExisting"`
+		Failed          int                             `json:"failed"`
+		NotAttempted    int                             `json:"notAttempted"`
+		TotalBytes      int64                           `json:"totalBytes"`
+		Errors          []string                        `json:"errors"`
+		Files           map[string][]downloadReportFile `json:"files"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("parsing report: %v", err)
+	}
+
+	if parsed.Queued != 5 || parsed.Succeeded != 2 || parsed.SkippedExisting != 1 || parsed.Failed != 1 || parsed.NotAttempted != 1 {
+		t.Errorf("counts = queued %d, succeeded %d, skipped %d, failed %d, not attempted %d; want 5, 2, 1, 1, 1",
+			parsed.Queued, parsed.Succeeded, parsed.SkippedExisting, parsed.Failed, parsed.NotAttempted)
+	}
+	if parsed.TotalBytes != 160 {
+		t.Errorf("TotalBytes = %d, want 160", parsed.TotalBytes)
+	}
+	if len(parsed.Errors) != 1 || !strings.Contains(parsed.Errors[0], "hash mismatch") {
+		t.Errorf("Errors = %v, want one entry mentioning the hash mismatch", parsed.Errors)
+	}
+	if len(parsed.Files["2"]) != 2 {
+		t.Errorf("Files[\"2\"] has %d entries, want 2", len(parsed.Files["2"]))
+	}
+	if files := parsed.Files["3"]; len(files) != 1 || files[0].Status != reportStatusFailed || files[0].Error != "hash mismatch" {
+		t.Errorf("Files[\"3\"] = %+v, want one failed entry with its error", files)
+	}
+}
+
+// TestModelsPageURLLimit checks that the per-request page size, not a fixed 100,
+// is sent as the limit parameter.
+func TestModelsPageURLLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		want  string
+	}{
+		{"Page size", 25, "25"},
+		{"Unset uses API default", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiURL := api.ModelsURL(models.QueryParameters{Limit: tt.limit, AllowCommercialUse: "Any"}, "")
+			parsed, err := url.Parse(apiURL)
+			if err != nil {
+				t.Fatalf("ModelsURL() returned unparsable URL %q: %v", apiURL, err)
+			}
+			if got := parsed.Query().Get("limit"); got != tt.want {
+				t.Errorf("limit = %q, want %q (URL %s)", got, tt.want, apiURL)
+			}
+		})
+	}
+}
+
+// TestTotalLimitAcrossPages checks that --limit caps the total across pages while
+// --page-size only sets how many models each request asks for.
+func TestTotalLimitAcrossPages(t *testing.T) {
+	tests := []struct {
+		name       string
+		pageSize   int
+		totalLimit int
+		received   int
+		wantSize   int
+	}{
+		{"No total limit", 100, 0, 500, 100},
+		{"Limit smaller than a page", 100, 10, 0, 10},
+		{"Remainder on a later page", 20, 50, 40, 10},
+		{"Limit larger than a page", 20, 50, 0, 20},
+		{"Limit already reached", 20, 50, 50, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pageRequestSize(tt.pageSize, tt.totalLimit, tt.received); got != tt.wantSize {
+				t.Errorf("pageRequestSize() = %d, want %d", got, tt.wantSize)
+			}
+			// A full page from the API is cut down to what the limit still allows
+			items := make([]models.Model, tt.pageSize)
+			if got := truncateToLimit(items, tt.totalLimit, tt.received); len(got) != tt.wantSize {
+				t.Errorf("truncateToLimit() kept %d models, want %d", len(got), tt.wantSize)
+			}
+		})
+	}
+}
+
+// TestModelReservoir checks that --sample keeps N distinct models spread over the
+// whole stream, and that the same seed reproduces the same sample.
+func TestModelReservoir(t *testing.T) {
+	sample := func(seed int64, size, total int) []int {
+		r := newModelReservoir(size, seed)
+		for id := 1; id <= total; id++ {
+			r.add(models.Model{ID: id})
+		}
+		ids := make([]int, len(r.items))
+		for i, m := range r.items {
+			ids[i] = m.ID
+		}
+		return ids
+	}
+
+	if got := sample(1, 10, 3); len(got) != 3 {
+		t.Errorf("sample of 10 from 3 models kept %d, want 3", len(got))
+	}
+
+	got := sample(42, 10, 1000)
+	if len(got) != 10 {
+		t.Fatalf("sample kept %d models, want 10", len(got))
+	}
+	seen := make(map[int]bool)
+	beyondFirst := false
+	for _, id := range got {
+		if seen[id] {
+			t.Errorf("model %d sampled twice", id)
+		}
+		seen[id] = true
+		if id > 10 {
+			beyondFirst = true
+		}
+	}
+	if !beyondFirst {
+		t.Errorf("sample %v only contains the first 10 models", got)
+	}
+
+	again := sample(42, 10, 1000)
+	for i := range got {
+		if got[i] != again[i] {
+			t.Fatalf("same seed gave different samples: %v and %v", got, again)
+		}
+	}
+}
+
+// TestModelsURLEncoding pins the exact encoding of values with spaces, plus signs and
+// other reserved characters, and checks that the pagination requests and
+// --debug-print-api-url (both api.ModelsURL) agree with the API client's values.
+func TestModelsURLEncoding(t *testing.T) {
+	params := models.QueryParameters{
+		Limit:                  100,
+		Query:                  "a b+c&d=e",
+		Tag:                    "c++ / ÿ",
+		Types:                  []string{"Checkpoint", "LORA"},
+		Sort:                   "Most Downloaded",
+		Period:                 "AllTime",
+		BaseModels:             []string{"SD 1.5", "SDXL 1.0"},
+		AllowNoCredit:          true,
+		AllowDerivatives:       true,
+		AllowDifferentLicenses: true,
+		AllowCommercialUse:     "Any",
+	}
+
+	want := "https://civitai.com/api/v1/models?" +
+		"baseModels=SD+1.5&baseModels=SDXL+1.0&cursor=abc%2B1&limit=100&nsfw=false&period=AllTime" +
+		"&query=a+b%2Bc%26d%3De&sort=Most+Downloaded&tag=c%2B%2B+%2F+%C3%BF&types=Checkpoint&types=LORA"
+	got := api.ModelsURL(params, "abc+1")
+	if got != want {
+		t.Errorf("ModelsURL() =\n  %s\nwant\n  %s", got, want)
+	}
+
+	parsed, err := url.Parse(got)
+	if err != nil {
+		t.Fatalf("ModelsURL() returned unparsable URL %q: %v", got, err)
+	}
+	values := api.ConvertQueryParamsToURLValues(params)
+	values.Set("cursor", "abc+1")
+	if parsed.RawQuery != values.Encode() {
+		t.Errorf("request query %q differs from ConvertQueryParamsToURLValues %q", parsed.RawQuery, values.Encode())
+	}
+	if q := parsed.Query().Get("query"); q != params.Query {
+		t.Errorf("query round-tripped to %q, want %q", q, params.Query)
+	}
+}
+
+func TestParseStdinIDs(t *testing.T) {
+	input := "12345\n# comment\n\nv:678, V:910 12345\nabc v:x -3\n"
+	got, err := parseStdinIDs(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseStdinIDs() error = %v", err)
+	}
+	want := []stdinID{{ID: 12345}, {ID: 678, IsVersion: true}, {ID: 910, IsVersion: true}}
+	if len(got) != len(want) {
+		t.Fatalf("parseStdinIDs() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parseStdinIDs()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// TestAvailableVersionsSince checks --since keeps versions published at or after the
+// cutoff and drops older ones as well as those without a readable timestamp.
+func TestAvailableVersionsSince(t *testing.T) {
+	since, err := parseSinceDate("2024-01-01")
+	if err != nil {
+		t.Fatalf("parseSinceDate() error = %v", err)
+	}
+	publishedSince = since
+	defer func() { publishedSince = time.Time{}; tooOldVersionsSkipped = nil }()
+
+	versions := []models.ModelVersion{
+		{ID: 1, PublishedAt: "2023-12-31T23:59:59.999Z", Files: []models.File{{}}},
+		{ID: 2, PublishedAt: "2024-01-01T00:00:00.000Z", Files: []models.File{{}}},
+		{ID: 3, PublishedAt: "2024-01-01T00:30:00+01:00", Files: []models.File{{}}}, // 2023-12-31T23:30Z
+		{ID: 4, PublishedAt: "2024-06-15T08:00:00Z", Files: []models.File{{}}},
+		{ID: 5, PublishedAt: "not a date", Files: []models.File{{}}},
+		{ID: 6, PublishedAt: "", Files: []models.File{{}}},
+	}
+	var got []int
+	for _, v := range availableVersions(versions, "model", 1) {
+		got = append(got, v.ID)
+	}
+	if fmt.Sprint(got) != "[2 4]" {
+		t.Errorf("availableVersions() kept %v, want [2 4]", got)
+	}
+	if fmt.Sprint(tooOldVersionsSkipped) != "[1 3 5 6]" {
+		t.Errorf("skipped %v, want [1 3 5 6]", tooOldVersionsSkipped)
+	}
+
+	if _, err := parseSinceDate("01/02/2024"); err == nil {
+		t.Error("parseSinceDate accepted an invalid date")
+	}
+	if ts, err := parseSinceDate("2024-01-01T12:00:00Z"); err != nil || !ts.Equal(since.Add(12*time.Hour)) {
+		t.Errorf("parseSinceDate(RFC3339) = %v, %v", ts, err)
+	}
+}
+
+// TestSavePageModelExtrasConcurrent saves the info and images of a page of models and
+// checks the image server saw several requests in flight at once, i.e. the models
+// were not processed one after another.
+func TestSavePageModelExtrasConcurrent(t *testing.T) {
+	oldConcurrency := viper.Get("concurrency")
+	viper.Set("savemodelimages", true)
+	viper.Set("concurrency", 4)
+	defer viper.Set("savemodelimages", false)
+	defer viper.Set("concurrency", oldConcurrency)
+
+	server, maxInFlight := newInFlightServer(t)
+
+	const pageSize = 4
+	cfg := &models.Config{SavePath: t.TempDir()}
+	var page []models.Model
+	for i := 1; i <= pageSize; i++ {
+		version := models.ModelVersion{ID: 100 + i, Images: []models.ModelImage{{ID: i, URL: fmt.Sprintf("%s/%d.jpeg", server.URL, i)}}}
+		page = append(page, models.Model{ID: i, Name: fmt.Sprintf("Model %d", i), Type: "LORA", ModelVersions: []models.ModelVersion{version}})
+	}
+	savePageModelExtras(context.Background(), page, cfg, downloader.NewDownloader(server.Client(), ""))
+
+	if got := atomic.LoadInt32(maxInFlight); got < 2 {
+		t.Errorf("at most %d image request(s) were in flight, want the models saved concurrently", got)
+	}
+	for i := 1; i <= pageSize; i++ {
+		modelDir := filepath.Join(cfg.SavePath, "lora", fmt.Sprintf("model_%d", i))
+		if _, err := os.Stat(filepath.Join(modelDir, fmt.Sprintf("%d-model_%d.json", i, i))); err != nil {
+			t.Errorf("model info for model %d missing: %v", i, err)
+		}
+		if _, err := os.Stat(filepath.Join(modelDir, "images", strconv.Itoa(100+i), fmt.Sprintf("%d.jpeg", i))); err != nil {
+			t.Errorf("image of model %d missing: %v", i, err)
+		}
+	}
+}
+
+// newInFlightServer serves slow image requests and tracks how many were in flight at
+// most. The server is closed when the test ends.
+func newInFlightServer(t *testing.T) (*httptest.Server, *int32) {
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("img"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &maxInFlight
+}
+
+// TestImageConcurrencyIndependent saves the images of one or several models and checks
+// the number of parallel image requests follows --image-concurrency, not --concurrency.
+func TestImageConcurrencyIndependent(t *testing.T) {
+	oldConcurrency, oldImageConcurrency := viper.Get("concurrency"), viper.Get("imageconcurrency")
+	viper.Set("savemodelimages", true)
+	defer viper.Set("savemodelimages", false)
+	defer viper.Set("concurrency", oldConcurrency)
+	defer viper.Set("imageconcurrency", oldImageConcurrency)
+
+	tests := []struct {
+		name                          string
+		concurrency, imageConcurrency int
+		numModels                     int
+		wantMaxInFlight               int32
+	}{
+		{"many image workers, one file worker", 1, 4, 1, 4},
+		{"one image worker, many file workers", 4, 1, 1, 1},
+		{"unset follows --concurrency", 2, 0, 1, 2},
+		{"shared by several models", 4, 2, 4, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("concurrency", tt.concurrency)
+			viper.Set("imageconcurrency", tt.imageConcurrency)
+			server, maxInFlight := newInFlightServer(t)
+
+			var page []models.Model
+			for m := 1; m <= tt.numModels; m++ {
+				var images []models.ModelImage
+				for i := 1; i <= 4; i++ {
+					images = append(images, models.ModelImage{ID: m*10 + i, URL: fmt.Sprintf("%s/%d.jpeg", server.URL, m*10+i)})
+				}
+				page = append(page, models.Model{ID: m, Name: fmt.Sprintf("Model %d", m), Type: "LORA", ModelVersions: []models.ModelVersion{{ID: 100 + m, Images: images}}})
+			}
+			savePageModelExtras(context.Background(), page, &models.Config{SavePath: t.TempDir()}, downloader.NewDownloader(server.Client(), ""))
+
+			if got := atomic.LoadInt32(maxInFlight); got != tt.wantMaxInFlight {
+				t.Errorf("at most %d image requests were in flight, want %d", got, tt.wantMaxInFlight)
+			}
+		})
+	}
+}
+
+// redirectTransport sends every request to target, keeping path and query, so code
+// that builds URLs from api.CivitaiApiBaseUrl can be pointed at a test server.
+type redirectTransport struct{ target *url.URL }
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = rt.target.Scheme, rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// TestFetchModelsPaginatedResume fails a run on page 2, then checks that --resume
+// starts at the cursor saved after page 1 and that finishing clears it.
+func TestFetchModelsPaginatedResume(t *testing.T) {
+	defer viper.Set("resume", false)
+
+	var failPage2 atomic.Bool
+	failPage2.Store(true)
+	var mu sync.Mutex
+	var cursors []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cursor := r.URL.Query().Get("cursor")
+		mu.Lock()
+		cursors = append(cursors, cursor)
+		mu.Unlock()
+		switch cursor {
+		case "":
+			_, _ = w.Write([]byte(`{"items":[{"id":1}],"metadata":{"nextCursor":"page2"}}`))
+		case "page2":
+			if failPage2.Load() {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"items":[{"id":2}],"metadata":{}}`))
+		}
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: redirectTransport{target: target}}
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	queryParams := models.QueryParameters{Limit: 1, Sort: "Newest"}
+	queryHash := modelsQueryHash(queryParams)
+	cfg := &models.Config{}
+
+	if _, _, err := fetchModelsPaginated(db, client, nil, queryParams, cfg, &cobra.Command{}); err == nil {
+		t.Fatal("first run succeeded, expected the page 2 error")
+	}
+	if saved, _ := db.GetModelsCursor(queryHash); saved != "page2" {
+		t.Fatalf("saved cursor after the interrupted run = %q, want %q", saved, "page2")
+	}
+
+	failPage2.Store(false)
+	mu.Lock()
+	cursors = nil
+	mu.Unlock()
+	viper.Set("resume", true)
+	if _, _, err := fetchModelsPaginated(db, client, nil, queryParams, cfg, &cobra.Command{}); err != nil {
+		t.Fatalf("resumed run failed: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(cursors) != 1 || cursors[0] != "page2" {
+		t.Errorf("resumed run requested cursors %q, want only %q", cursors, "page2")
+	}
+	if saved, _ := db.GetModelsCursor(queryHash); saved != "" {
+		t.Errorf("saved cursor after pagination completed = %q, want it cleared", saved)
+	}
+}
+
+// TestExecuteDownloadsInterrupted cancels the run while the first file is downloading
+// and checks that file still completes, no further job is started and no entry is
+// left inconsistent with the files on disk.
+func TestExecuteDownloadsInterrupted(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		started <- struct{}{}
+		<-release
+		_, _ = w.Write([]byte("model"))
+	}))
+	defer server.Close()
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("database.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	saveDir := t.TempDir()
+	var downloads []potentialDownload
+	for id := 1; id <= 3; id++ {
+		pd := potentialDownload{
+			ModelVersionID: id,
+			File:           models.File{Name: fmt.Sprintf("model%d.bin", id), DownloadUrl: server.URL},
+			TargetFilepath: filepath.Join(saveDir, fmt.Sprintf("model%d.bin", id)),
+			CleanedVersion: models.ModelVersion{ID: id},
+		}
+		entry, _ := json.Marshal(models.DatabaseEntry{Status: models.StatusPending, Filename: pd.File.Name})
+		if err := db.Put([]byte(fmt.Sprintf("v_%d", id)), entry); err != nil {
+			t.Fatal(err)
+		}
+		downloads = append(downloads, pd)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+		close(release)
+	}()
+	executeDownloads(ctx, downloads, db, downloader.NewDownloader(server.Client(), ""), nil, 1, &models.Config{}, nil)
+
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("server saw %d requests, want only the one in flight when cancelled", got)
+	}
+	if tmpFiles, _ := filepath.Glob(filepath.Join(saveDir, "*.tmp")); len(tmpFiles) != 0 {
+		t.Errorf("temporary files left behind: %v", tmpFiles)
+	}
+	for _, pd := range downloads {
+		raw, err := db.Get([]byte(fmt.Sprintf("v_%d", pd.ModelVersionID)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entry models.DatabaseEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			t.Fatal(err)
+		}
+		if pd.ModelVersionID == 1 {
+			if _, statErr := os.Stat(filepath.Join(saveDir, entry.Filename)); entry.Status != models.StatusDownloaded || statErr != nil {
+				t.Errorf("in-flight version 1: status %s, file err %v; want it downloaded", entry.Status, statErr)
+			}
+			continue
+		}
+		leftovers, _ := filepath.Glob(filepath.Join(saveDir, "*"+pd.File.Name+"*"))
+		if entry.Status != models.StatusPending || len(leftovers) != 0 {
+			t.Errorf("version %d: status %s, files %v; want it left pending without files", pd.ModelVersionID, entry.Status, leftovers)
+		}
+	}
+}
+
+// TestExecuteDownloadsStoresComputedSHA256 downloads a file the API gave no SHA256 for
+// and checks the hash computed during the download is saved in its DB entry and kept
+// by a second run that skips the file.
+func TestExecuteDownloadsStoresComputedSHA256(t *testing.T) {
+	content := []byte("model weights")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("database.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	pd := potentialDownload{
+		ModelVersionID: 7,
+		File:           models.File{Name: "model.bin", DownloadUrl: server.URL},
+		TargetFilepath: filepath.Join(t.TempDir(), "model.bin"),
+		CleanedVersion: models.ModelVersion{ID: 7},
+	}
+	entry, _ := json.Marshal(models.DatabaseEntry{Status: models.StatusPending, Filename: pd.File.Name})
+	if err := db.Put([]byte("v_7"), entry); err != nil {
+		t.Fatal(err)
+	}
+	executeDownloads(context.Background(), []potentialDownload{pd}, db, downloader.NewDownloader(server.Client(), ""), nil, 1, &models.Config{}, nil)
+
+	raw, err := db.Get([]byte("v_7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored models.DatabaseEntry
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	want := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if stored.Status != models.StatusDownloaded || stored.File.Hashes.SHA256 != want || stored.File.Hashes.AutoV2 != want[:10] {
+		t.Errorf("stored entry: status %s, SHA256 %q, AutoV2 %q; want Downloaded with SHA256 %s", stored.Status, stored.File.Hashes.SHA256, stored.File.Hashes.AutoV2, want)
+	}
+
+	// A second run finds the file on disk and must keep the hash, though the API
+	// still provides none
+	if queued, _ := processPage(db, []potentialDownload{pd}, &models.Config{}, false); len(queued) != 0 {
+		t.Fatalf("second run queued %d download(s), want the file skipped", len(queued))
+	}
+	raw, err = db.Get([]byte("v_7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.File.Hashes.SHA256 != want || stored.File.Hashes.AutoV2 != want[:10] {
+		t.Errorf("after second run: SHA256 %q, AutoV2 %q; want SHA256 %s", stored.File.Hashes.SHA256, stored.File.Hashes.AutoV2, want)
+	}
+}
+
+// TestDownloadedFileDetails checks that a later run keeps the details recorded for a
+// file converted to fp16 instead of restoring the original's from the API, and keeps
+// hashes computed locally that the API does not provide.
+func TestDownloadedFileDetails(t *testing.T) {
+	api := models.File{SizeKB: 2048, DownloadUrl: "https://civitai.com/api/download/models/7?new",
+		Hashes: models.Hashes{SHA256: "ORIGINAL"}, Metadata: models.Metadata{Fp: "fp32", Format: "SafeTensor"}}
+	converted := models.File{SizeKB: 1024, DownloadUrl: "https://civitai.com/api/download/models/7",
+		Hashes: models.Hashes{SHA256: "CONVERTED"}, Metadata: models.Metadata{Fp: "fp16", Format: "SafeTensor"}}
+
+	got := downloadedFileDetails(converted, api)
+	if got.SizeKB != 1024 || got.Hashes.SHA256 != "CONVERTED" || got.Metadata.Fp != "fp16" || got.DownloadUrl != api.DownloadUrl {
+		t.Errorf("converted file: got %+v, want the converted size, hash and precision with the API's URL", got)
+	}
+	if got := downloadedFileDetails(api, api); got != api {
+		t.Errorf("unconverted file: got %+v, want the API's details %+v", got, api)
+	}
+	computed := api
+	computed.Hashes = models.Hashes{CRC32: "API"}
+	stored := computed
+	stored.Hashes = models.Hashes{SHA256: "LOCAL", AutoV2: "LOCAL", CRC32: "OLD"}
+	if got := downloadedFileDetails(stored, computed); got.Hashes != (models.Hashes{SHA256: "LOCAL", AutoV2: "LOCAL", CRC32: "API"}) {
+		t.Errorf("locally hashed file: got hashes %+v, want the local SHA256 and the API's CRC32", got.Hashes)
+	}
+}
+
+// TestFetchModelsPaginatedExcludeTags serves a page with two models and checks the
+// one carrying an excluded tag is skipped entirely.
+func TestFetchModelsPaginatedExcludeTags(t *testing.T) {
+	viper.Set("excludetags", []string{"meme"})
+	defer viper.Set("excludetags", []string{})
+
+	model := func(id int, tag string) string {
+		return fmt.Sprintf(`{"id":%d,"name":"Model %d","type":"LORA","tags":[%q],"modelVersions":[{"id":%d,"name":"v1","baseModel":"SD 1.5","publishedAt":"2024-01-01T00:00:00.000Z","files":[{"id":%d,"name":"model%d.safetensors","primary":true,"sizeKB":1,"downloadUrl":"https://civitai.com/api/download/models/%d","hashes":{"CRC32":"0000000%d"},"metadata":{"format":"SafeTensor"}}]}]}`,
+			id, id, tag, id*10, id*100, id, id*10, id)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"items":[%s,%s],"metadata":{}}`, model(1, "MEME"), model(2, "style"))
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: redirectTransport{target: target}}
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	downloads, _, err := fetchModelsPaginated(db, client, nil, models.QueryParameters{Limit: 2, Sort: "Newest"}, &models.Config{SavePath: t.TempDir()}, &cobra.Command{})
+	if err != nil {
+		t.Fatalf("fetchModelsPaginated() error = %v", err)
+	}
+	if len(downloads) != 1 || downloads[0].ModelVersionID != 20 {
+		var ids []int
+		for _, pd := range downloads {
+			ids = append(ids, pd.ModelVersionID)
+		}
+		t.Errorf("queued versions %v, want only 20 of the model without the excluded tag", ids)
+	}
+}
+
+// TestSaveTriggerWordsFile checks the trained words are written comma-joined next to
+// the model file and that versions without any get no trigger file.
+func TestSaveTriggerWordsFile(t *testing.T) {
+	modelPath := filepath.Join(t.TempDir(), "123_mychar.safetensors")
+	pd := potentialDownload{FullVersion: models.ModelVersion{TrainedWords: []string{"mychar", " red hair ", ""}}}
+	if err := saveTriggerWordsFile(pd, modelPath); err != nil {
+		t.Fatalf("saveTriggerWordsFile() error = %v", err)
+	}
+	data, err := os.ReadFile(strings.TrimSuffix(modelPath, ".safetensors") + ".trigger.txt")
+	if err != nil {
+		t.Fatalf("trigger file missing: %v", err)
+	}
+	if got := string(data); got != "mychar, red hair\n" {
+		t.Errorf("trigger file = %q, want %q", got, "mychar, red hair\n")
+	}
+
+	otherPath := filepath.Join(filepath.Dir(modelPath), "456_other.safetensors")
+	if err := saveTriggerWordsFile(potentialDownload{}, otherPath); err != nil {
+		t.Fatalf("saveTriggerWordsFile() without trained words error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(otherPath), "456_other.trigger.txt")); !os.IsNotExist(err) {
+		t.Errorf("trigger file written for a version without trained words (stat error %v)", err)
+	}
+}
+
+// TestPendingDownloadsFromDB seeds a Pending and a Downloaded entry and checks
+// --continue downloads only the pending file, to the path a normal run would use,
+// without any API request.
+func TestPendingDownloadsFromDB(t *testing.T) {
+	var apiRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+			atomic.AddInt32(&apiRequests, 1)
+			http.Error(w, "unexpected API request", http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("pending weights"))
+	}))
+	defer server.Close()
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("database.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	cfg := &models.Config{SavePath: t.TempDir()}
+	version := models.ModelVersion{ID: 11, Name: "v1", BaseModel: "SD 1.5"}
+	pending := models.DatabaseEntry{
+		ModelName: "Pending Model",
+		ModelType: "LORA",
+		Version:   version,
+		File:      models.File{Name: "pending.safetensors", DownloadUrl: server.URL + "/download/11", Metadata: models.Metadata{Format: "SafeTensor"}},
+		Creator:   models.Creator{Username: "alice"},
+		Status:    models.StatusPending,
+	}
+	want := constructPotentialDownload(models.Model{Name: pending.ModelName, Type: pending.ModelType, Creator: pending.Creator}, version, pending.File, cfg)
+	pending.Filename = filepath.Base(want.TargetFilepath)
+	pending.Folder = want.Slug
+	done := pending
+	done.Version.ID = 12
+	done.Status = models.StatusDownloaded
+	for key, entry := range map[string]models.DatabaseEntry{"v_11": pending, "v_12": done} {
+		data, _ := json.Marshal(entry)
+		if err := db.Put([]byte(key), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	downloads, err := pendingDownloadsFromDB(db, cfg)
+	if err != nil {
+		t.Fatalf("pendingDownloadsFromDB() error = %v", err)
+	}
+	if len(downloads) != 1 || downloads[0].ModelVersionID != 11 || downloads[0].TargetFilepath != want.TargetFilepath {
+		t.Fatalf("pendingDownloadsFromDB() = %+v, want only version 11 at %s", downloads, want.TargetFilepath)
+	}
+	executeDownloads(context.Background(), downloads, db, downloader.NewDownloader(server.Client(), ""), nil, 1, cfg, nil)
+
+	raw, err := db.Get([]byte("v_11"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored models.DatabaseEntry
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.StatusDownloaded {
+		t.Errorf("entry status = %s, want %s", stored.Status, models.StatusDownloaded)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(want.TargetFilepath), stored.Filename)); err != nil {
+		t.Errorf("pending file was not downloaded: %v", err)
+	}
+	if n := atomic.LoadInt32(&apiRequests); n != 0 {
+		t.Errorf("server saw %d API requests, want none", n)
+	}
+}
+
+// TestTransferStatsUpdate feeds byte counts at known times and checks the speed is
+// the bytes since the previous update over the interval, with the ETA derived from it.
+func TestTransferStatsUpdate(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	const total = 10 << 20
+	steps := []struct {
+		written   uint64
+		at        time.Duration
+		wantSpeed float64
+		wantETA   time.Duration
+	}{
+		{written: 0, at: 0, wantSpeed: 0, wantETA: 0}, // First update has no interval yet
+		{written: 1 << 20, at: time.Second, wantSpeed: 1 << 20, wantETA: 9 * time.Second},
+		{written: 3 << 20, at: 1500 * time.Millisecond, wantSpeed: 4 << 20, wantETA: 2 * time.Second},
+		{written: 3 << 20, at: 2500 * time.Millisecond, wantSpeed: 0, wantETA: 0}, // Stalled
+		{written: total, at: 3500 * time.Millisecond, wantSpeed: 7 << 20, wantETA: 0},
+	}
+	var stats transferStats
+	for i, step := range steps {
+		speed, eta := stats.update(step.written, total, start.Add(step.at))
+		if speed != step.wantSpeed || eta != step.wantETA {
+			t.Errorf("step %d: update() = %.0f B/s, ETA %v; want %.0f B/s, ETA %v", i, speed, eta, step.wantSpeed, step.wantETA)
+		}
+	}
+
+	if got, want := formatTransferProgress(3<<20, total, 4<<20, 2*time.Second), "30.0% (3.00MB/10.00MB) at 4.00MB/s, ETA 2s"; got != want {
+		t.Errorf("formatTransferProgress() = %q, want %q", got, want)
+	}
+	if got, want := formatTransferProgress(3<<20, 0, 0, 0), "3.00MB"; got != want {
+		t.Errorf("formatTransferProgress() without size or speed = %q, want %q", got, want)
+	}
+}
+
+// TestExecuteDownloadsSkipExistingByHash stores a downloaded file and a pending one
+// with the same SHA256 and checks the second is hardlinked to the first instead of
+// being downloaded.
+func TestExecuteDownloadsSkipExistingByHash(t *testing.T) {
+	viper.Set("skipexistingbyhash", true)
+	defer viper.Set("skipexistingbyhash", false)
+	oldSavePath := globalConfig.SavePath
+	globalConfig.SavePath = t.TempDir()
+	defer func() { globalConfig.SavePath = oldSavePath }()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte("vae weights"))
+	}))
+	defer server.Close()
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("database.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	content := []byte("vae weights")
+	sum := sha256.Sum256(content)
+	hashes := models.Hashes{SHA256: strings.ToUpper(hex.EncodeToString(sum[:]))}
+	existingPath := filepath.Join(globalConfig.SavePath, "checkpoint", "model_a", "1_vae.safetensors")
+	if err := os.MkdirAll(filepath.Dir(existingPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(existingPath, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pd := potentialDownload{
+		ModelVersionID: 2,
+		File:           models.File{Name: "vae.safetensors", DownloadUrl: server.URL, Hashes: hashes},
+		TargetFilepath: filepath.Join(globalConfig.SavePath, "checkpoint", "model_b", "vae.safetensors"),
+		CleanedVersion: models.ModelVersion{ID: 2},
+	}
+	entries := map[string]models.DatabaseEntry{
+		"v_1": {Status: models.StatusDownloaded, Version: models.ModelVersion{ID: 1}, File: pd.File, Folder: filepath.Join("checkpoint", "model_a"), Filename: "1_vae.safetensors"},
+		"v_2": {Status: models.StatusPending, Version: models.ModelVersion{ID: 2}, File: pd.File, Filename: "vae.safetensors"},
+	}
+	for key, entry := range entries {
+		data, _ := json.Marshal(entry)
+		if err := db.Put([]byte(key), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(pd.TargetFilepath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	executeDownloads(context.Background(), []potentialDownload{pd}, db, downloader.NewDownloader(server.Client(), ""), nil, 1, &models.Config{}, nil)
+
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("server saw %d requests, want the file linked instead of downloaded", n)
+	}
+	existingInfo, err := os.Stat(existingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkedInfo, err := os.Stat(filepath.Join(filepath.Dir(pd.TargetFilepath), "2_vae.safetensors"))
+	if err != nil {
+		t.Fatalf("linked file missing: %v", err)
+	}
+	if !os.SameFile(existingInfo, linkedInfo) {
+		t.Error("second file is not a link to the existing identical file")
+	}
+	raw, err := db.Get([]byte("v_2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored models.DatabaseEntry
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.StatusDownloaded || stored.Filename != "2_vae.safetensors" {
+		t.Errorf("entry v_2 = %s %q, want Downloaded as 2_vae.safetensors", stored.Status, stored.Filename)
+	}
+}
+
+// TestExecuteDownloadsIndexesDownloadedVersion checks a plain download adds the
+// version to the Bleve index, so search works without running the torrent command.
+func TestExecuteDownloadsIndexesDownloadedVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("lora weights"))
+	}))
+	defer server.Close()
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("database.Open() error = %v", err)
+	}
+	defer db.Close()
+	bleveIndex, err := index.OpenOrCreateIndex(filepath.Join(t.TempDir(), "civitai.bleve"))
+	if err != nil {
+		t.Fatalf("index.OpenOrCreateIndex() error = %v", err)
+	}
+	defer bleveIndex.Close()
+
+	pd := potentialDownload{
+		ModelName:      "Ink Lines",
+		VersionName:    "v2",
+		BaseModel:      "SDXL 1.0",
+		ModelVersionID: 3,
+		File:           models.File{Name: "ink.safetensors", DownloadUrl: server.URL},
+		TargetFilepath: filepath.Join(t.TempDir(), "lora", "ink_lines", "ink.safetensors"),
+		CleanedVersion: models.ModelVersion{ID: 3},
+	}
+	data, _ := json.Marshal(models.DatabaseEntry{Status: models.StatusPending, Version: pd.CleanedVersion, File: pd.File})
+	if err := db.Put([]byte("v_3"), data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(pd.TargetFilepath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	executeDownloads(context.Background(), []potentialDownload{pd}, db, downloader.NewDownloader(server.Client(), ""), nil, 1, &models.Config{}, bleveIndex)
+
+	item, found, err := index.GetItem(bleveIndex, "v_3")
+	if err != nil || !found {
+		t.Fatalf("index.GetItem(v_3) = found %v, error %v, want the downloaded version", found, err)
+	}
+	if item.ModelName != pd.ModelName || item.BaseModel != pd.BaseModel || item.DirectoryPath != filepath.Dir(pd.TargetFilepath) {
+		t.Errorf("indexed item = %+v, want model name, base model and directory of the download", item)
+	}
+}
+
+// TestExecuteDownloadsRetryFailed answers the first request for one file with a 503
+// and always answers another with a 404. --retry-failed must retry only the first,
+// leaving the downloaded file and the non-retryable failure alone.
+func TestExecuteDownloadsRetryFailed(t *testing.T) {
+	oldDelay := viper.Get("retryfaileddelay")
+	viper.Set("retryfailed", 2)
+	viper.Set("retryfaileddelay", time.Millisecond)
+	defer viper.Set("retryfailed", 0)
+	defer viper.Set("retryfaileddelay", oldDelay)
+
+	var flakyRequests, okRequests, goneRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if atomic.AddInt32(&flakyRequests, 1) == 1 {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+		case "/ok":
+			atomic.AddInt32(&okRequests, 1)
+		case "/gone":
+			atomic.AddInt32(&goneRequests, 1)
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("lora weights"))
+	}))
+	defer server.Close()
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("database.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	var downloads []potentialDownload
+	for versionID, name := range map[int]string{4: "flaky", 5: "gone", 6: "ok"} {
+		pd := potentialDownload{
+			ModelVersionID: versionID,
+			File:           models.File{Name: name + ".safetensors", DownloadUrl: server.URL + "/" + name},
+			TargetFilepath: filepath.Join(dir, name+".safetensors"),
+			CleanedVersion: models.ModelVersion{ID: versionID},
+		}
+		data, _ := json.Marshal(models.DatabaseEntry{Status: models.StatusPending, Version: pd.CleanedVersion, File: pd.File})
+		if err := db.Put([]byte(fmt.Sprintf("v_%d", versionID)), data); err != nil {
+			t.Fatal(err)
+		}
+		downloads = append(downloads, pd)
+	}
+	executeDownloads(context.Background(), downloads, db, downloader.NewDownloader(server.Client(), ""), nil, 1, &models.Config{}, nil)
+
+	if n := atomic.LoadInt32(&flakyRequests); n != 2 {
+		t.Errorf("flaky file saw %d requests, want the 503 and one successful retry", n)
+	}
+	if n := atomic.LoadInt32(&okRequests); n != 1 {
+		t.Errorf("downloaded file saw %d requests, want 1", n)
+	}
+	if n := atomic.LoadInt32(&goneRequests); n != 1 {
+		t.Errorf("404 file saw %d requests, want 1 (not_found is not retried)", n)
+	}
+	wantStatus := map[string]string{"v_4": models.StatusDownloaded, "v_5": models.StatusError, "v_6": models.StatusDownloaded}
+	for key, want := range wantStatus {
+		raw, err := db.Get([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stored models.DatabaseEntry
+		if err := json.Unmarshal(raw, &stored); err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != want {
+			t.Errorf("%s status = %s (%s), want %s", key, stored.Status, stored.ErrorDetails, want)
+		}
+	}
+}
+
+// TestSelectFileByIDOrName checks that --file-id and --file-name must both match
+// when given, that names compare case-insensitively and that a miss lists the files.
+func TestSelectFileByIDOrName(t *testing.T) {
+	files := []models.File{
+		{ID: 10, Name: "model_full.safetensors"},
+		{ID: 11, Name: "model_pruned.safetensors"},
+	}
+	tests := []struct {
+		name     string
+		fileID   int
+		fileName string
+		wantID   int
+		wantErr  bool
+	}{
+		{name: "by ID", fileID: 11, wantID: 11},
+		{name: "by name, any case", fileName: "Model_Full.safetensors", wantID: 10},
+		{name: "ID and name must both match", fileID: 10, fileName: "model_pruned.safetensors", wantErr: true},
+		{name: "no match", fileID: 99, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := selectFileByIDOrName(files, tt.fileID, tt.fileName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectFileByIDOrName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && file.ID != tt.wantID {
+				t.Errorf("selectFileByIDOrName() = file %d, want %d", file.ID, tt.wantID)
+			}
+			if err != nil && !strings.Contains(err.Error(), "model_pruned.safetensors (ID 11)") {
+				t.Errorf("error %q does not list the available files", err)
+			}
+		})
+	}
+}
+
+func TestLinkExistingByHash(t *testing.T) {
+	content := []byte("identical model weights")
+	sum := sha256.Sum256(content)
+	file := models.File{SizeKB: float64(len(content)) / 1024, Hashes: models.Hashes{SHA256: strings.ToUpper(hex.EncodeToString(sum[:]))}}
+
+	tests := []struct {
+		name       string
+		onDisk     []byte
+		wantLinked bool
+	}{
+		{"unchanged file is linked", content, true},
+		{"modified file is downloaded", []byte("different model weights"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			existing := filepath.Join(dir, "existing.safetensors")
+			if err := os.WriteFile(existing, tt.onDisk, 0644); err != nil {
+				t.Fatal(err)
+			}
+			index := &hashIndex{paths: make(map[string]string)}
+			index.add(file.Hashes.SHA256, existing)
+			pd := potentialDownload{ModelVersionID: 7, File: file, TargetFilepath: filepath.Join(dir, "other", "model.safetensors")}
+			if err := os.MkdirAll(filepath.Dir(pd.TargetFilepath), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			linkPath, linked := linkExistingByHash(1, index, pd)
+			if linked != tt.wantLinked {
+				t.Fatalf("linkExistingByHash() linked = %v, want %v", linked, tt.wantLinked)
+			}
+			if !linked {
+				return
+			}
+			got, err := os.ReadFile(linkPath)
+			if err != nil || string(got) != string(content) {
+				t.Errorf("linked file content = %q (%v), want %q", got, err, content)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go-civitai-download/internal/helpers"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// convertCheckpointPrecision converts a downloaded full-precision checkpoint to the
+// precision selected with --convert (only fp16 for now) and replaces the original
+// with it once the converted file validates. pd.File's size, hashes and precision are
+// updated so the DB entry describes the converted file. The original is kept next to
+// it with --keep-original. Returns the path of the checkpoint; on any problem the
+// original file is left in place.
+func convertCheckpointPrecision(workerID int, finalPath string, pd *potentialDownload) string {
+	if !strings.EqualFold(viper.GetString("convert"), "fp16") {
+		return finalPath
+	}
+	if !strings.EqualFold(pd.ModelType, "Checkpoint") || !strings.EqualFold(filepath.Ext(finalPath), ".safetensors") {
+		return finalPath
+	}
+
+	tmpPath := finalPath + ".convert.tmp"
+	converted, hashes, err := helpers.ConvertSafetensorsToFP16(finalPath, tmpPath)
+	if err != nil {
+		log.WithError(err).Errorf("Worker %d: Failed to convert %s to fp16, keeping the original.", workerID, filepath.Base(finalPath))
+		os.Remove(tmpPath)
+		return finalPath
+	}
+	if converted == 0 {
+		log.Infof("Worker %d: %s has no fp32 tensors, nothing to convert.", workerID, filepath.Base(finalPath))
+		return finalPath
+	}
+	if err := helpers.ValidateSafetensors(tmpPath); err != nil {
+		log.WithError(err).Errorf("Worker %d: Converted file for %s failed validation, keeping the original.", workerID, filepath.Base(finalPath))
+		os.Remove(tmpPath)
+		return finalPath
+	}
+
+	originalStat, _ := os.Stat(finalPath)
+	if viper.GetBool("keeporiginal") {
+		ext := filepath.Ext(finalPath)
+		originalPath := strings.TrimSuffix(finalPath, ext) + ".original" + ext
+		if err := os.Rename(finalPath, originalPath); err != nil {
+			log.WithError(err).Errorf("Worker %d: Failed to move original %s aside, discarding the converted file.", workerID, filepath.Base(finalPath))
+			os.Remove(tmpPath)
+			return finalPath
+		}
+		log.Infof("Worker %d: Kept original as %s.", workerID, filepath.Base(originalPath))
+	}
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		log.WithError(err).Errorf("Worker %d: Failed to replace %s with the converted file.", workerID, filepath.Base(finalPath))
+		os.Remove(tmpPath)
+		return finalPath
+	}
+
+	convertedStat, err := os.Stat(finalPath)
+	if err == nil {
+		pd.File.SizeKB = float64(convertedStat.Size()) / 1024
+		if originalStat != nil {
+			log.Infof("Worker %d: Converted %d tensor(s) of %s to fp16 (%s -> %s).", workerID, converted, filepath.Base(finalPath),
+				helpers.BytesToSize(uint64(originalStat.Size())), helpers.BytesToSize(uint64(convertedStat.Size())))
+		}
+	}
+	pd.File.Hashes = hashes
+	pd.File.Metadata.Fp = "fp16"
+	return finalPath
+}
+
+// validConvertTarget reports whether value is an accepted --convert setting.
+func validConvertTarget(value string) bool {
+	switch strings.ToLower(value) {
+	case "", "fp16":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go-civitai-download/internal/database"
+	"go-civitai-download/internal/helpers"
+	"go-civitai-download/internal/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// hashIndex maps the SHA256 of downloaded files to their path, so --skip-existing-by-hash
+// can link a file that is already somewhere in the save tree instead of downloading
+// it again. Workers look it up and add their downloads to it concurrently.
+type hashIndex struct {
+	mu    sync.Mutex
+	paths map[string]string // Uppercase SHA256 -> file path
+}
+
+// buildHashIndex folds over the database once and indexes the files of all
+// Downloaded entries with a known SHA256.
+func buildHashIndex(db *database.DB) (*hashIndex, error) {
+	rows, err := collectDbEntries(db, func(entry models.DatabaseEntry) bool {
+		return entry.Status == models.StatusDownloaded && entry.File.Hashes.SHA256 != ""
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build hash index from database: %w", err)
+	}
+	index := &hashIndex{paths: make(map[string]string, len(rows))}
+	for _, row := range rows {
+		index.add(row.Entry.File.Hashes.SHA256, dbEntryFilePath(row.Entry))
+	}
+	log.Infof("Indexed %d downloaded file(s) by SHA256 for --skip-existing-by-hash.", len(index.paths))
+	return index, nil
+}
+
+// add records path as holding the file with the given SHA256. Safe on a nil index.
+func (h *hashIndex) add(sha256 string, path string) {
+	if h == nil || sha256 == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.paths[strings.ToUpper(sha256)] = path
+}
+
+// lookup returns the path of an existing file with the given SHA256.
+func (h *hashIndex) lookup(sha256 string) (string, bool) {
+	if h == nil || sha256 == "" {
+		return "", false
+	}
+	h.mu.Lock()
+	path, ok := h.paths[strings.ToUpper(sha256)]
+	h.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", false // Deleted or moved since it was recorded
+	}
+	return path, true
+}
+
+// verifyExistingFile checks that the file at path still has the size and SHA256 of
+// file, since it may have been modified or replaced since it was indexed.
+func verifyExistingFile(path string, file models.File) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if file.SizeKB > 0 && math.Abs(float64(info.Size())/1024-file.SizeKB) >= 1 {
+		return fmt.Errorf("size %d bytes does not match the expected %.0f KB", info.Size(), file.SizeKB)
+	}
+	sum, err := helpers.FileHash(path, "sha256")
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(sum, file.Hashes.SHA256) {
+		return fmt.Errorf("SHA256 %s does not match the expected %s", sum, file.Hashes.SHA256)
+	}
+	return nil
+}
+
+// linkExistingByHash links pd's file to an identical file already in the index, using
+// the name the downloader would give it. The existing file's size and SHA256 are
+// verified first. A hard link is tried first and a symlink if that fails (e.g. across
+// filesystems). Returns the linked path, or false if there is no identical file or
+// linking failed, in which case the file should be downloaded.
+func linkExistingByHash(workerID int, index *hashIndex, pd potentialDownload) (string, bool) {
+	existing, ok := index.lookup(pd.File.Hashes.SHA256)
+	if !ok {
+		return "", false
+	}
+	if err := verifyExistingFile(existing, pd.File); err != nil {
+		log.WithError(err).Warnf("Worker %d: Indexed file %s no longer matches, downloading %s instead of linking it", workerID, existing, filepath.Base(pd.TargetFilepath))
+		return "", false
+	}
+	linkPath := filepath.Join(filepath.Dir(pd.TargetFilepath), fmt.Sprintf("%d_%s", pd.ModelVersionID, filepath.Base(pd.TargetFilepath)))
+	if existing == linkPath {
+		return linkPath, true
+	}
+	if err := os.Link(existing, linkPath); err != nil {
+		absExisting, absErr := filepath.Abs(existing)
+		if absErr != nil {
+			absExisting = existing
+		}
+		if symErr := os.Symlink(absExisting, linkPath); symErr != nil {
+			log.WithError(symErr).Warnf("Worker %d: Could not hardlink (%v) or symlink %s to identical file %s, downloading it instead", workerID, err, linkPath, existing)
+			return "", false
+		}
+		log.Infof("Worker %d: Symlinked %s to identical file %s instead of downloading it", workerID, linkPath, existing)
+		return linkPath, true
+	}
+	log.Infof("Worker %d: Hardlinked %s to identical file %s instead of downloading it", workerID, linkPath, existing)
+	return linkPath, true
+}
@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go-civitai-download/internal/api"
+	"go-civitai-download/internal/database"
+	"go-civitai-download/internal/downloader"
+	"go-civitai-download/internal/helpers"
+	"go-civitai-download/internal/models"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// civitaiModelLinkRegex matches links to other models in descriptions.
+var civitaiModelLinkRegex = regexp.MustCompile(`civitai\.com/models/(\d+)`)
+
+// negativeEmbeddingListRegex picks the list after "negative embedding(s):" or
+// "negative prompt:" in a description, e.g. "Negative embeddings: EasyNegative, badhandv4".
+var negativeEmbeddingListRegex = regexp.MustCompile(`(?i)negative\s+(?:embeddings?|prompts?)\s*[:：]\s*([^\n<]+)`)
+
+// embeddingNameRegex accepts single-word identifiers that look like an embedding name.
+var embeddingNameRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.\-]{2,60}$`)
+
+// followEmbeddings looks for negative embeddings referenced by the queued LORA
+// versions (links to other models, "negative embeddings:" lists in descriptions and
+// trained words containing "neg") and queues the TextualInversion models it can
+// resolve through handleSingleModelDownload. Resolution is best-effort: every
+// reference is logged as resolved or unresolved.
+func followEmbeddings(pds []potentialDownload, db *database.DB, client *http.Client, imageDownloader *downloader.Downloader, cfg *models.Config, cmd *cobra.Command) []potentialDownload {
+	if !viper.GetBool("followembeddings") {
+		return nil
+	}
+
+	queuedModels := make(map[int]struct{})
+	for _, pd := range pds {
+		queuedModels[pd.Model.ID] = struct{}{}
+	}
+
+	seenVersions := make(map[int]struct{})
+	resolvedIDs := make(map[int]string) // model ID -> reference it was found through
+	var order []int
+	var unresolved []string
+	for _, pd := range pds {
+		if !strings.EqualFold(pd.ModelType, "LORA") && !strings.EqualFold(pd.ModelType, "LoCon") {
+			continue
+		}
+		if _, done := seenVersions[pd.ModelVersionID]; done {
+			continue
+		}
+		seenVersions[pd.ModelVersionID] = struct{}{}
+
+		logPrefix := fmt.Sprintf("%s - %s (%d)", pd.ModelName, pd.VersionName, pd.ModelVersionID)
+		linkedIDs, names := embeddingReferences(pd)
+		for _, id := range linkedIDs {
+			if _, done := resolvedIDs[id]; done {
+				continue
+			}
+			model, err := fetchModelSummary(id, client, cfg)
+			if err != nil {
+				log.WithError(err).Warnf("[Embeddings] %s: could not look up linked model %d.", logPrefix, id)
+				unresolved = append(unresolved, fmt.Sprintf("model %d", id))
+				continue
+			}
+			if !strings.EqualFold(model.Type, "TextualInversion") {
+				log.Debugf("[Embeddings] %s: linked model %d (%s) is a %s, not an embedding.", logPrefix, id, model.Name, model.Type)
+				continue
+			}
+			resolvedIDs[id] = "link"
+			order = append(order, id)
+			log.Infof("[Embeddings] %s: resolved linked embedding %s (%d).", logPrefix, model.Name, id)
+		}
+		for _, name := range names {
+			model, err := searchEmbeddingByName(name, client, cfg)
+			if err != nil {
+				log.WithError(err).Warnf("[Embeddings] %s: search for %q failed.", logPrefix, name)
+			}
+			if model == nil {
+				log.Infof("[Embeddings] %s: could not resolve embedding %q.", logPrefix, name)
+				unresolved = append(unresolved, name)
+				continue
+			}
+			if _, done := resolvedIDs[model.ID]; done {
+				continue
+			}
+			resolvedIDs[model.ID] = name
+			order = append(order, model.ID)
+			log.Infof("[Embeddings] %s: resolved %q to embedding %s (%d).", logPrefix, name, model.Name, model.ID)
+		}
+	}
+
+	var result []potentialDownload
+	for _, id := range order {
+		if _, queued := queuedModels[id]; queued {
+			log.Debugf("[Embeddings] Embedding model %d is already part of this download.", id)
+			continue
+		}
+		queued, _, err := handleSingleModelDownload(id, db, client, imageDownloader, cfg, cmd)
+		if err != nil {
+			log.WithError(err).Warnf("[Embeddings] Failed to queue embedding model %d (found via %s).", id, resolvedIDs[id])
+			continue
+		}
+		queuedModels[id] = struct{}{}
+		result = append(result, queued...)
+	}
+
+	if len(order) > 0 || len(unresolved) > 0 {
+		log.Infof("[Embeddings] Resolved %d embedding model(s), queued %d file(s); %d reference(s) could not be resolved.", len(order), len(result), len(unresolved))
+		if len(unresolved) > 0 {
+			log.Infof("[Embeddings] Unresolved: %s", strings.Join(unresolved, ", "))
+		}
+	}
+	return result
+}
+
+// embeddingReferences extracts linked model IDs and candidate embedding names from a
+// version's description, its model's description and its trained words.
+func embeddingReferences(pd potentialDownload) ([]int, []string) {
+	var ids []int
+	seenIDs := map[int]struct{}{pd.Model.ID: {}} // Ignore links to the model itself
+	var names []string
+	seenNames := make(map[string]struct{})
+	addName := func(name string) {
+		name = strings.Trim(strings.TrimSpace(name), ".,;()[]\"'")
+		if !embeddingNameRegex.MatchString(name) {
+			return
+		}
+		key := strings.ToLower(name)
+		if _, seen := seenNames[key]; seen {
+			return
+		}
+		seenNames[key] = struct{}{}
+		names = append(names, name)
+	}
+
+	for _, text := range []string{pd.FullVersion.Description, pd.Model.Description} {
+		for _, match := range civitaiModelLinkRegex.FindAllStringSubmatch(text, -1) {
+			id, err := strconv.Atoi(match[1])
+			if err != nil {
+				continue
+			}
+			if _, seen := seenIDs[id]; !seen {
+				seenIDs[id] = struct{}{}
+				ids = append(ids, id)
+			}
+		}
+		for _, match := range negativeEmbeddingListRegex.FindAllStringSubmatch(text, -1) {
+			for _, part := range strings.Split(match[1], ",") {
+				addName(part)
+			}
+		}
+	}
+	// Trigger words are mostly for the LORA itself; only "neg" words are likely embeddings
+	for _, word := range pd.FullVersion.TrainedWords {
+		for _, part := range strings.Split(word, ",") {
+			if strings.Contains(strings.ToLower(part), "neg") {
+				addName(part)
+			}
+		}
+	}
+	return ids, names
+}
+
+// searchEmbeddingByName searches TextualInversion models for name and returns the
+// first one whose model name or file name matches it exactly (ignoring case and
+// punctuation), or nil if none does.
+func searchEmbeddingByName(name string, client *http.Client, cfg *models.Config) (*models.Model, error) {
+	params := url.Values{}
+	params.Set("types", "TextualInversion")
+	params.Set("query", name)
+	params.Set("limit", "10")
+	apiURL := api.BaseURL() + "/models?" + params.Encode()
+
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedding search request: %w", err)
+	}
+	if cfg.ApiKey != "" {
+		req.Header.Add("Authorization", "Bearer "+cfg.ApiKey)
+	}
+
+	_, bodyBytes, err := api.DoRequestWithRetry(client, req, apiRetryOptions("Embedding search"))
+	if err != nil {
+		return nil, fmt.Errorf("embedding search for %q failed: %w", name, err)
+	}
+
+	var response models.ApiResponse
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode embedding search response: %w", err)
+	}
+
+	wanted := helpers.ConvertToSlug(name)
+	for i := range response.Items {
+		model := response.Items[i]
+		if helpers.ConvertToSlug(model.Name) == wanted {
+			return &model, nil
+		}
+		for _, version := range model.ModelVersions {
+			for _, file := range version.Files {
+				if helpers.ConvertToSlug(strings.TrimSuffix(file.Name, filepath.Ext(file.Name))) == wanted {
+					return &model, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// fetchModelSummary fetches a model by ID.
+func fetchModelSummary(modelID int, client *http.Client, cfg *models.Config) (models.Model, error) {
+	var model models.Model
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/models/%d", api.BaseURL(), modelID), nil)
+	if err != nil {
+		return model, fmt.Errorf("failed to create request for model %d: %w", modelID, err)
+	}
+	if cfg.ApiKey != "" {
+		req.Header.Add("Authorization", "Bearer "+cfg.ApiKey)
+	}
+
+	_, bodyBytes, err := api.DoRequestWithRetry(client, req, apiRetryOptions(fmt.Sprintf("Model %d", modelID)))
+	if err != nil {
+		return model, fmt.Errorf("failed to fetch model %d: %w", modelID, err)
+	}
+	if err := json.Unmarshal(bodyBytes, &model); err != nil {
+		return model, fmt.Errorf("failed to decode API response for model %d: %w", modelID, err)
+	}
+	return model, nil
+}
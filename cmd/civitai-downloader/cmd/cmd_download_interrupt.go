@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// watchInterrupt returns a context derived from parent that is cancelled on the
+// first SIGINT or SIGTERM, so the download workers can wind down instead of being
+// killed mid-write. The default handling is restored after that signal, so a second
+// one exits immediately. Call stop once the downloads are done.
+func watchInterrupt(parent context.Context) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			log.Warnf("Received %v: finishing the files in progress, no new downloads are started. Interrupt again to exit immediately.", sig)
+			cancel()
+		case <-done:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+}
@@ -0,0 +1,306 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"go-civitai-download/internal/helpers"
+	"go-civitai-download/internal/models"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// typeFolderName returns the top-level folder for files of modelType. The
+// TypeFolderMap config (model type -> folder) is consulted first so files can be
+// placed straight into an Automatic1111/ComfyUI layout (e.g. Checkpoint ->
+// "Stable-diffusion"); unmapped types use the slugified type name.
+func typeFolderName(modelType string) string {
+	// Viper lowercases map keys, so compare case-insensitively
+	for mappedType, folder := range viper.GetStringMapString("typefoldermap") {
+		if !strings.EqualFold(mappedType, modelType) {
+			continue
+		}
+		folder = filepath.Clean(strings.TrimSpace(folder))
+		if folder == "." || filepath.IsAbs(folder) || folder == ".." || strings.HasPrefix(folder, ".."+string(filepath.Separator)) {
+			log.Warnf("Ignoring TypeFolderMap entry for %s: %q must be a relative folder inside SavePath.", modelType, folder)
+			break
+		}
+		return folder
+	}
+	return helpers.ConvertToSlug(modelType)
+}
+
+// targetFileName returns the file name a model file is saved under: the name
+// constructed from the slugified API file name, or with --server-filename the
+// file name exactly as Civitai provides it. The downloader still prefers the
+// Content-Disposition name and prepends the version ID in both cases.
+func targetFileName(file models.File, constructed string) string {
+	if !viper.GetBool("serverfilename") {
+		return constructed
+	}
+	name := filepath.Base(strings.TrimSpace(file.Name))
+	if name == "." || name == string(filepath.Separator) || name == "" {
+		log.Warnf("File %d has no usable file name from the API, using constructed name %s.", file.ID, constructed)
+		return constructed
+	}
+	return name
+}
+
+// modelFromVersion builds the model for a version fetched from /model-versions/{id},
+// which only carries a summary of its model and no creator.
+func modelFromVersion(version models.ModelVersion) models.Model {
+	return models.Model{
+		ID:   version.ModelId,
+		Name: version.Model.Name,
+		Type: version.Model.Type,
+		Nsfw: version.Model.Nsfw,
+		Poi:  version.Model.Poi,
+	}
+}
+
+// constructPotentialDownload builds the download for one file of a model version:
+// the version directory from PathTemplate, the slugified file name (with a
+// .safetensors extension for SafeTensor files and .bin when there is none) and the
+// cleaned version stored in the DB. All download paths go through here so a file
+// gets the same target path however it was found.
+func constructPotentialDownload(model models.Model, version models.ModelVersion, file models.File, cfg *models.Config) potentialDownload {
+	creator := model.Creator
+	if creator.Username == "" {
+		creator.Username = "unknown_creator"
+	}
+
+	// The version directory comes from PathTemplate; the slug is the folder above it
+	templateData := newPathTemplateData(model.Name, model.Type, version, creator.Username, file.Name)
+	versionDir := buildTargetPath(templateData)
+
+	baseFileName := helpers.ConvertToSlug(file.Name)
+	ext := filepath.Ext(baseFileName)
+	baseFileName = strings.TrimSuffix(baseFileName, ext)
+	if strings.EqualFold(file.Metadata.Format, "safetensor") && !strings.EqualFold(ext, ".safetensors") {
+		ext = ".safetensors"
+	}
+	if ext == "" {
+		ext = ".bin"
+		log.Warnf("File %s in version %s (%d) has no extension, defaulting to '.bin'", file.Name, version.Name, version.ID)
+	}
+	finalBaseFilename := baseFileName + ext
+
+	// Cleaned version for metadata/DB
+	cleanedVersion := version
+	cleanedVersion.Files = nil
+	cleanedVersion.Images = nil
+
+	return potentialDownload{
+		ModelName:         model.Name,
+		ModelType:         model.Type,
+		VersionName:       version.Name,
+		BaseModel:         version.BaseModel,
+		Creator:           creator,
+		File:              file,
+		ModelVersionID:    version.ID,
+		TargetFilepath:    filepath.Join(cfg.SavePath, versionDir, targetFileName(file, finalBaseFilename)),
+		Slug:              filepath.Dir(versionDir),
+		ModelDir:          buildModelDir(templateData, versionDir),
+		FinalBaseFilename: finalBaseFilename,
+		CleanedVersion:    cleanedVersion,
+		FullVersion:       version,
+		OriginalImages:    version.Images,
+		Model:             model,
+	}
+}
+
+// defaultPathTemplate is the layout used when PathTemplate is not set:
+// type/model/baseModel/versionDir, with versionDir named by VersionDirStyle.
+const defaultPathTemplate = "{{.ModelType}}/{{.ModelName}}/{{.BaseModel}}/{{.VersionDir}}"
+
+// Supported --version-dir-style values.
+const (
+	versionDirStyleIDSlug = "id-slug" // <versionID>-<fileSlug>, the original layout
+	versionDirStyleName   = "name"    // Slugified version name, then the version ID
+	versionDirStyleDate   = "date"    // Publish date as YYYY-MM-DD, then the version ID
+	versionDirStyleID     = "id"      // Version ID only
+)
+
+// validVersionDirStyle reports whether style is an accepted --version-dir-style.
+func validVersionDirStyle(style string) bool {
+	switch strings.ToLower(style) {
+	case "", versionDirStyleIDSlug, versionDirStyleName, versionDirStyleDate, versionDirStyleID:
+		return true
+	}
+	return false
+}
+
+// versionDirName names a version's directory according to style. The name and date
+// styles append the version ID, since versions of a model often share a name or
+// publish date, and fall back to the version ID alone when there is no name or date.
+func versionDirName(style string, version models.ModelVersion, fileSlug string) string {
+	switch strings.ToLower(style) {
+	case versionDirStyleName:
+		if name := helpers.ConvertToSlug(version.Name); name != "" {
+			return fmt.Sprintf("%s-%d", name, version.ID)
+		}
+	case versionDirStyleDate:
+		if published, err := parsePublishedAt(version.PublishedAt); err == nil {
+			return fmt.Sprintf("%s-%d", published.UTC().Format("2006-01-02"), version.ID)
+		}
+	case versionDirStyleID:
+	default:
+		return fmt.Sprintf("%d-%s", version.ID, fileSlug)
+	}
+	return strconv.Itoa(version.ID)
+}
+
+// pathTemplateData holds the fields available to PathTemplate. The string fields are
+// slugified so every value is safe as a path component; ModelType is the folder from
+// typeFolderName, FileName is the file name without its extension and VersionDir is
+// the version directory named by VersionDirStyle.
+type pathTemplateData struct {
+	ModelName   string
+	ModelType   string
+	BaseModel   string
+	VersionID   int
+	VersionName string
+	VersionDir  string
+	Creator     string
+	FileName    string
+}
+
+// newPathTemplateData fills pathTemplateData for one file of a model version, using
+// placeholders for a missing base model or creator.
+func newPathTemplateData(modelName, modelType string, version models.ModelVersion, creator string, fileName string) pathTemplateData {
+	baseModel := version.BaseModel
+	if baseModel == "" {
+		baseModel = "unknown-base"
+	}
+	if creator == "" {
+		creator = "unknown_creator"
+	}
+	fileSlug := helpers.ConvertToSlug(strings.TrimSuffix(fileName, filepath.Ext(fileName)))
+	return pathTemplateData{
+		ModelName:   helpers.ConvertToSlug(modelName),
+		ModelType:   typeFolderName(modelType),
+		BaseModel:   helpers.ConvertToSlug(baseModel),
+		VersionID:   version.ID,
+		VersionName: helpers.ConvertToSlug(version.Name),
+		VersionDir:  versionDirName(viper.GetString("versiondirstyle"), version, fileSlug),
+		Creator:     helpers.ConvertToSlug(creator),
+		FileName:    fileSlug,
+	}
+}
+
+// renderPathTemplate executes tmpl for data and returns the cleaned relative directory.
+// Empty components (from empty values) are dropped; components that would leave the
+// save path are rejected.
+func renderPathTemplate(tmpl string, data pathTemplateData) (string, error) {
+	t, err := template.New("path").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid path template %q: %w", tmpl, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid path template %q: %w", tmpl, err)
+	}
+	var parts []string
+	for _, part := range strings.FieldsFunc(buf.String(), func(r rune) bool { return r == '/' || r == '\\' }) {
+		part = strings.TrimSpace(part)
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			return "", fmt.Errorf("path template %q must not contain '..'", tmpl)
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("path template %q produced an empty path", tmpl)
+	}
+	return filepath.Join(parts...), nil
+}
+
+// pathTemplateComponents splits tmpl into its non-empty path components.
+func pathTemplateComponents(tmpl string) []string {
+	var parts []string
+	for _, part := range strings.FieldsFunc(tmpl, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part = strings.TrimSpace(part); part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// checkPathTemplateLayout rejects templates whose last component is not
+// {{.VersionDir}} or that have no directory above it. The version directory keeps
+// each version's files apart, and the model directory above it is what db purge,
+// torrent and pack work on.
+func checkPathTemplateLayout(tmpl string) error {
+	parts := pathTemplateComponents(tmpl)
+	if len(parts) < 2 || strings.ReplaceAll(parts[len(parts)-1], " ", "") != "{{.VersionDir}}" {
+		return fmt.Errorf("path template %q must end in a {{.VersionDir}} component below at least one directory", tmpl)
+	}
+	return nil
+}
+
+// validatePathTemplate checks that tmpl parses, only uses known fields and ends in
+// the version directory.
+func validatePathTemplate(tmpl string) error {
+	if tmpl == "" {
+		return nil
+	}
+	if err := checkPathTemplateLayout(tmpl); err != nil {
+		return err
+	}
+	_, err := renderPathTemplate(tmpl, newPathTemplateData("model", "LORA", models.ModelVersion{ID: 1, BaseModel: "SDXL 1.0"}, "creator", "file.safetensors"))
+	return err
+}
+
+// buildTargetPath returns the directory, relative to SavePath, that a model file is
+// saved in, rendered from the PathTemplate setting (or defaultPathTemplate). If the
+// template fails for this file, or renders no directory above the version directory,
+// the default layout is used.
+func buildTargetPath(data pathTemplateData) string {
+	tmpl := viper.GetString("pathtemplate")
+	if tmpl == "" {
+		tmpl = defaultPathTemplate
+	}
+	err := checkPathTemplateLayout(tmpl)
+	var dir string
+	if err == nil {
+		dir, err = renderPathTemplate(tmpl, data)
+	}
+	if err == nil && filepath.Dir(dir) == "." {
+		err = fmt.Errorf("path template %q produced no directory above the version directory", tmpl)
+	}
+	if err != nil {
+		log.WithError(err).Warnf("Using the default layout for version %d.", data.VersionID)
+		dir, _ = renderPathTemplate(defaultPathTemplate, data)
+	}
+	return dir
+}
+
+// buildModelDir returns the model's directory relative to SavePath, which torrent and
+// pack package as a whole: the PathTemplate rendered up to its last component using
+// {{.ModelName}}, or the directory above versionDir when no component does (or
+// versionDir was not rendered from that template).
+func buildModelDir(data pathTemplateData, versionDir string) string {
+	tmpl := viper.GetString("pathtemplate")
+	if tmpl == "" || checkPathTemplateLayout(tmpl) != nil {
+		tmpl = defaultPathTemplate
+	}
+	parts := pathTemplateComponents(tmpl)
+	for i := len(parts) - 2; i >= 0; i-- {
+		if !strings.Contains(parts[i], ".ModelName") {
+			continue
+		}
+		dir, err := renderPathTemplate(strings.Join(parts[:i+1], "/"), data)
+		if err == nil && strings.HasPrefix(versionDir, dir+string(filepath.Separator)) {
+			return dir
+		}
+		break
+	}
+	return filepath.Dir(versionDir)
+}
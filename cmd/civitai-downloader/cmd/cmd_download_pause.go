@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pauseGate lets download workers be paused between files. Workers call wait
+// before starting a job; files already downloading are not interrupted.
+type pauseGate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+}
+
+func newPauseGate() *pauseGate {
+	g := &pauseGate{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// downloadPause is shared by all download workers and toggled by signals, see
+// watchPauseSignals.
+var downloadPause = newPauseGate()
+
+// pause stops workers from starting new files.
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		return
+	}
+	g.paused = true
+	log.Info("Downloads paused: files in progress will finish, no new files are started until resumed.")
+}
+
+// resume lets waiting workers continue.
+func (g *pauseGate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		return
+	}
+	g.paused = false
+	g.cond.Broadcast()
+	log.Info("Downloads resumed.")
+}
+
+// wait blocks while the gate is paused.
+func (g *pauseGate) wait(workerID int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		log.Debugf("Worker %d: waiting for downloads to be resumed", workerID)
+	}
+	for g.paused {
+		g.cond.Wait()
+	}
+}
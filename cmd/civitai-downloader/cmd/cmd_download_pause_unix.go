@@ -0,0 +1,39 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// watchPauseSignals pauses g on SIGUSR1 and resumes it on SIGUSR2 until the
+// returned stop function is called.
+func watchPauseSignals(g *pauseGate) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGUSR1 {
+					g.pause()
+				} else {
+					g.resume()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	log.Debugf("Send SIGUSR1 to pause and SIGUSR2 to resume downloads (kill -USR1 %d).", os.Getpid())
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		g.resume() // Never leave the gate closed for a later run in the same process
+	}
+}
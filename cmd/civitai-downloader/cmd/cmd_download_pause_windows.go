@@ -0,0 +1,8 @@
+//go:build windows
+
+package cmd
+
+// watchPauseSignals is a no-op on Windows, which has no SIGUSR1/SIGUSR2.
+func watchPauseSignals(g *pauseGate) (stop func()) {
+	return func() {}
+}
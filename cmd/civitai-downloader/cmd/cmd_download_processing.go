@@ -1,12 +1,15 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -32,7 +35,7 @@ type imageDownloadJob struct {
 // --- Structs for Concurrent Image Downloads --- END ---
 
 // --- Worker for Concurrent Image Downloads --- START ---
-func imageDownloadWorkerInternal(id int, jobs <-chan imageDownloadJob, imageDownloader *downloader.Downloader, wg *sync.WaitGroup, successCounter *int64, failureCounter *int64, logPrefix string) {
+func imageDownloadWorkerInternal(ctx context.Context, id int, jobs <-chan imageDownloadJob, imageDownloader *downloader.Downloader, slots chan struct{}, wg *sync.WaitGroup, successCounter *int64, failureCounter *int64, logPrefix string) {
 	defer wg.Done()
 	log.Debugf("[%s-Worker-%d] Starting internal image worker", logPrefix, id)
 	for job := range jobs {
@@ -50,7 +53,13 @@ func imageDownloadWorkerInternal(id int, jobs <-chan imageDownloadJob, imageDown
 
 		// Download the image
 		log.Debugf("[%s-Worker-%d] Downloading image %s from %s", logPrefix, id, job.LogFilename, job.SourceURL)
-		_, dlErr := imageDownloader.DownloadFile(job.TargetPath, job.SourceURL, models.Hashes{}, 0)
+		if slots != nil {
+			slots <- struct{}{}
+		}
+		_, dlErr := imageDownloader.DownloadFile(ctx, job.TargetPath, job.SourceURL, models.Hashes{}, 0)
+		if slots != nil {
+			<-slots
+		}
 
 		if dlErr != nil {
 			log.WithError(dlErr).Errorf("[%s-Worker-%d] Failed to download image %s from %s", logPrefix, id, job.LogFilename, job.SourceURL)
@@ -67,7 +76,10 @@ func imageDownloadWorkerInternal(id int, jobs <-chan imageDownloadJob, imageDown
 
 // processPage filters downloads based on config and database status.
 // It returns the list of downloads that should be queued and their total size.
-func processPage(db *database.DB, pageDownloads []potentialDownload, cfg *models.Config) ([]potentialDownload, uint64) {
+// With dryRun the same decisions are made but nothing is written: no Pending
+// entries, no entry updates and no metadata sidecars. db may then be nil (no
+// database exists yet), in which case every file counts as new.
+func processPage(db *database.DB, pageDownloads []potentialDownload, cfg *models.Config, dryRun bool) ([]potentialDownload, uint64) {
 	downloadsToQueue := []potentialDownload{}
 	var queuedSizeBytes uint64 = 0
 
@@ -82,37 +94,45 @@ func processPage(db *database.DB, pageDownloads []potentialDownload, cfg *models
 
 		// Check database
 		// Get retrieves raw bytes, unmarshaling happens later if needed
-		rawValue, err := db.Get([]byte(dbKey)) // Note: db.Get returns raw bytes
+		var rawValue []byte
+		err := database.ErrNotFound
+		if db != nil {
+			rawValue, err = db.Get([]byte(dbKey)) // Note: db.Get returns raw bytes
+		}
 
 		shouldQueue := false
 		// Use errors.Is to check for the specific ErrNotFound error from our database package
 		if errors.Is(err, database.ErrNotFound) {
 			log.Debugf("Model Version %d (Key: %s) not found in DB. Queuing for download.", pd.CleanedVersion.ID, dbKey)
 			shouldQueue = true
-			// Create initial entry using the correct DatabaseEntry fields
-			newEntry := models.DatabaseEntry{
-				ModelName:    pd.ModelName,
-				ModelType:    pd.ModelType,
-				Version:      pd.CleanedVersion,                // Store the cleaned version struct
-				File:         pd.File,                          // Store the file struct
-				Timestamp:    time.Now().Unix(),                // Use Unix timestamp for AddedAt
-				Creator:      pd.Creator,                       // Store the creator struct
-				Filename:     filepath.Base(pd.TargetFilepath), // Use the calculated filename
-				Folder:       pd.Slug,                          // Use the calculated folder slug
-				Status:       models.StatusPending,             // Use constant
-				ErrorDetails: "",                               // Use correct field name
-			}
-			// Marshal the new entry to JSON before putting into DB
-			entryBytes, marshalErr := json.Marshal(newEntry)
-			if marshalErr != nil {
-				log.WithError(marshalErr).Errorf("Failed to marshal new DB entry for key %s", dbKey)
-				continue // Skip queuing if marshalling fails
-			}
-			// Put the marshalled bytes
-			if errPut := db.Put([]byte(dbKey), entryBytes); errPut != nil {
-				log.WithError(errPut).Errorf("Failed to add pending entry to DB for key %s", dbKey)
-				// Decide if we should still attempt download? Maybe not.
-				continue // Skip queuing if DB write fails
+			if !dryRun {
+				// Create initial entry using the correct DatabaseEntry fields
+				newEntry := models.DatabaseEntry{
+					ModelName:    pd.ModelName,
+					ModelType:    pd.ModelType,
+					Version:      pd.CleanedVersion,                // Store the cleaned version struct
+					File:         pd.File,                          // Store the file struct
+					Timestamp:    time.Now().Unix(),                // Use Unix timestamp for AddedAt
+					Creator:      pd.Creator,                       // Store the creator struct
+					Filename:     filepath.Base(pd.TargetFilepath), // Use the calculated filename
+					Folder:       pd.Slug,                          // Use the calculated folder slug
+					VersionDir:   pd.versionDir(),                  // And the version directory below it
+					ModelDir:     pd.ModelDir,
+					Status:       models.StatusPending, // Use constant
+					ErrorDetails: "",                   // Use correct field name
+				}
+				// Marshal the new entry to JSON before putting into DB
+				entryBytes, marshalErr := json.Marshal(newEntry)
+				if marshalErr != nil {
+					log.WithError(marshalErr).Errorf("Failed to marshal new DB entry for key %s", dbKey)
+					continue // Skip queuing if marshalling fails
+				}
+				// Put the marshalled bytes
+				if errPut := db.Put([]byte(dbKey), entryBytes); errPut != nil {
+					log.WithError(errPut).Errorf("Failed to add pending entry to DB for key %s", dbKey)
+					// Decide if we should still attempt download? Maybe not.
+					continue // Skip queuing if DB write fails
+				}
 			}
 		} else if err != nil {
 			// Handle other potential DB errors during Get
@@ -143,8 +163,11 @@ func processPage(db *database.DB, pageDownloads []potentialDownload, cfg *models
 					// Update status back to Pending and clear error
 					entry.Status = models.StatusPending
 					entry.ErrorDetails = ""
+					entry.ErrorCategory = ""
 					// Update other fields that might change
 					entry.Folder = pd.Slug
+					entry.VersionDir = pd.versionDir()
+					entry.ModelDir = pd.ModelDir
 					entry.Version = pd.CleanedVersion
 					entry.File = pd.File
 					// Update DB entry to reflect Pending status
@@ -152,6 +175,8 @@ func processPage(db *database.DB, pageDownloads []potentialDownload, cfg *models
 					if marshalErr != nil {
 						log.WithError(marshalErr).Errorf("Failed to marshal entry for re-queue update (missing file) %s", dbKey)
 						shouldQueue = false // Don't queue if marshalling fails
+					} else if dryRun {
+						log.Debugf("Dry run, not updating DB entry %s.", dbKey)
 					} else if errUpdate := db.Put([]byte(dbKey), entryBytes); errUpdate != nil {
 						log.WithError(errUpdate).Errorf("Failed to update DB entry to Pending (missing file) for key %s", dbKey)
 						shouldQueue = false // Don't queue if update fails
@@ -160,21 +185,27 @@ func processPage(db *database.DB, pageDownloads []potentialDownload, cfg *models
 				} else if statErr == nil {
 					// File *does* exist, proceed with original skip logic + metadata check
 					log.Infof("Skipping %s (VersionID: %d, Key: %s) - File exists and DB status is Downloaded.", pd.TargetFilepath, pd.CleanedVersion.ID, dbKey)
+					// With --no-metadata-for-skipped the entry is only rewritten if something changed
+					readOnlySkip := viper.GetBool("nometadataforskipped")
+					originalBytes, _ := json.Marshal(entry)
 					// Update fields that might change between runs
 					entry.Folder = pd.Slug
-					entry.Version = pd.CleanedVersion // Update associated metadata version
-					entry.File = pd.File              // Update file details (URL might change)
+					entry.VersionDir = pd.versionDir()
+					entry.ModelDir = pd.ModelDir
+					entry.Version = pd.CleanedVersion                       // Update associated metadata version
+					entry.File = downloadedFileDetails(entry.File, pd.File) // Update file details (URL might change)
+					pd.File = entry.File
 
 					// --- START: Save Metadata Check for Existing Download ---
 					// Use Viper to check if metadata saving is enabled
-					if viper.GetBool("savemetadata") {
+					if metadataSidecarEnabled() && !readOnlySkip && !dryRun {
 						// Derive metadata path from the expected path based on the DB entry filename
 						metadataPath := strings.TrimSuffix(expectedPathFromDB, filepath.Ext(expectedPathFromDB)) + ".json"
 
 						if _, metaStatErr := os.Stat(metadataPath); os.IsNotExist(metaStatErr) {
 							log.Infof("Model file exists, but metadata %s is missing. Saving metadata.", filepath.Base(metadataPath))
 							// Marshal the FULL version info from the potential download struct
-							jsonData, jsonErr := json.MarshalIndent(pd.FullVersion, "", "  ")
+							jsonData, jsonErr := json.MarshalIndent(metadataSidecarContent(pd), "", "  ")
 							if jsonErr != nil {
 								log.WithError(jsonErr).Warnf("Failed to marshal full version metadata for existing file %s", pd.TargetFilepath)
 							} else {
@@ -193,6 +224,10 @@ func processPage(db *database.DB, pageDownloads []potentialDownload, cfg *models
 					entryBytes, marshalErr := json.Marshal(entry)
 					if marshalErr != nil {
 						log.WithError(marshalErr).Warnf("Failed to marshal updated downloaded entry %s", dbKey)
+					} else if readOnlySkip && bytes.Equal(entryBytes, originalBytes) {
+						log.Debugf("DB entry %s is unchanged, not rewriting it.", dbKey)
+					} else if dryRun {
+						log.Debugf("Dry run, not updating DB entry %s.", dbKey)
 					} else if errUpdate := db.Put([]byte(dbKey), entryBytes); errUpdate != nil {
 						log.WithError(errUpdate).Warnf("Failed to update metadata for downloaded entry %s", dbKey)
 					}
@@ -209,8 +244,11 @@ func processPage(db *database.DB, pageDownloads []potentialDownload, cfg *models
 				// Update status back to Pending and clear error if any
 				entry.Status = models.StatusPending
 				entry.ErrorDetails = ""
+				entry.ErrorCategory = ""
 				// Update fields that might change
 				entry.Folder = pd.Slug
+				entry.VersionDir = pd.versionDir()
+				entry.ModelDir = pd.ModelDir
 				entry.Version = pd.CleanedVersion
 				entry.File = pd.File
 				// entry.Timestamp = time.Now().Unix() // Optionally update timestamp?
@@ -219,6 +257,8 @@ func processPage(db *database.DB, pageDownloads []potentialDownload, cfg *models
 				if marshalErr != nil {
 					log.WithError(marshalErr).Errorf("Failed to marshal entry for re-queue update %s", dbKey)
 					shouldQueue = false // Don't queue if marshalling fails
+				} else if dryRun {
+					log.Debugf("Dry run, not updating DB entry %s.", dbKey)
 				} else if errUpdate := db.Put([]byte(dbKey), entryBytes); errUpdate != nil {
 					log.WithError(errUpdate).Errorf("Failed to update DB entry to Pending for key %s", dbKey)
 					shouldQueue = false // Don't queue if update fails
@@ -239,6 +279,142 @@ func processPage(db *database.DB, pageDownloads []potentialDownload, cfg *models
 	return downloadsToQueue, queuedSizeBytes
 }
 
+// downloadedFileDetails returns the API's details of a file that is already on disk,
+// keeping the size, hashes and precision recorded for it when it was converted with
+// --convert, since the API still describes the original. Hashes computed locally
+// during the download are kept where the API still has none.
+func downloadedFileDetails(stored, current models.File) models.File {
+	if strings.EqualFold(stored.Metadata.Fp, "fp16") && !strings.EqualFold(current.Metadata.Fp, "fp16") {
+		current.SizeKB = stored.SizeKB
+		current.Hashes = stored.Hashes
+		current.Metadata.Fp = stored.Metadata.Fp
+		return current
+	}
+	for _, hash := range []struct{ current, stored *string }{
+		{&current.Hashes.SHA256, &stored.Hashes.SHA256},
+		{&current.Hashes.AutoV2, &stored.Hashes.AutoV2},
+		{&current.Hashes.BLAKE3, &stored.Hashes.BLAKE3},
+		{&current.Hashes.CRC32, &stored.Hashes.CRC32},
+	} {
+		if *hash.current == "" {
+			*hash.current = *hash.stored
+		}
+	}
+	return current
+}
+
+// pendingDownloadsFromDB rebuilds the downloads of all Pending database entries for
+// download --continue, without querying the API. Target paths are derived from the
+// stored model name, type, creator, version and file the same way a normal run does,
+// so they match as long as PathTemplate and VersionDirStyle are unchanged. Model
+// descriptions, tags and version images are not stored and are therefore missing.
+func pendingDownloadsFromDB(db *database.DB, cfg *models.Config) ([]potentialDownload, error) {
+	if db == nil {
+		return nil, nil // Dry run without an existing database
+	}
+	rows, err := collectDbEntries(db, func(entry models.DatabaseEntry) bool {
+		return entry.Status == models.StatusPending
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pending entries from database: %w", err)
+	}
+
+	downloads := make([]potentialDownload, 0, len(rows))
+	for _, row := range rows {
+		entry := row.Entry
+		if entry.File.DownloadUrl == "" {
+			log.Warnf("Pending entry v_%s (%s) has no download URL, skipping it.", row.VersionID, entry.ModelName)
+			continue
+		}
+		model := models.Model{Name: entry.ModelName, Type: entry.ModelType, Creator: entry.Creator}
+		pd := constructPotentialDownload(model, entry.Version, entry.File, cfg)
+		if entry.Filename != "" && filepath.Base(pd.TargetFilepath) != entry.Filename {
+			log.Warnf("Pending entry v_%s was queued as %s but is now saved as %s (naming settings changed).", row.VersionID, entry.Filename, filepath.Base(pd.TargetFilepath))
+		}
+		downloads = append(downloads, pd)
+	}
+	return downloads, nil
+}
+
+// nonRetryableCategories are the failure categories --retry-failed leaves alone,
+// since trying again within the same run does not change their outcome.
+var nonRetryableCategories = map[string]bool{
+	downloader.CategoryAuth:       true,
+	downloader.CategoryNotFound:   true,
+	downloader.CategoryDiskFull:   true,
+	downloader.CategoryFileSystem: true,
+	downloader.CategoryCanceled:   true,
+}
+
+// failedDownloadsToRetry returns the downloads among downloads that failed in this
+// run with a retryable category, for --retry-failed. failed maps the target path of
+// each failed file to its error category. The entries of their versions are set back
+// to Pending so they can be queued again.
+func failedDownloadsToRetry(db *database.DB, downloads []potentialDownload, failed map[string]string) []potentialDownload {
+	var retries []potentialDownload
+	pending := make(map[int]bool)
+	for _, pd := range downloads {
+		category, ok := failed[pd.TargetFilepath]
+		if !ok {
+			continue // Succeeded, or failed in an earlier run
+		}
+		if nonRetryableCategories[category] {
+			log.Debugf("Not retrying %s, %s failures are not retried", filepath.Base(pd.TargetFilepath), category)
+			continue
+		}
+		if !pending[pd.CleanedVersion.ID] {
+			dbKey := fmt.Sprintf("v_%d", pd.CleanedVersion.ID)
+			if err := updateDbEntry(db, dbKey, models.StatusPending, nil); err != nil {
+				log.WithError(err).Warnf("Not retrying %s", dbKey)
+				continue
+			}
+			pending[pd.CleanedVersion.ID] = true
+		}
+		forgetDownloadFailure(pd.TargetFilepath)
+		runReport.forgetFailure(pd.ModelVersionID, pd.TargetFilepath)
+		retries = append(retries, pd)
+	}
+	return retries
+}
+
+// withVersionDownloadUrl returns file with a download URL filled in. The API
+// occasionally omits a file's own downloadUrl; the version-level URL is then used
+// with the query parameters Civitai puts on file URLs to select a specific file
+// (fileId, plus type/format/size/fp for older endpoints). ok is false, after logging
+// a warning, if neither URL is available.
+func withVersionDownloadUrl(file models.File, version models.ModelVersion) (models.File, bool) {
+	if file.DownloadUrl != "" {
+		return file, true
+	}
+	if version.DownloadUrl == "" {
+		log.Warnf("Skipping file %s (ID: %d) of version %d: neither the file nor the version has a download URL.", file.Name, file.ID, version.ID)
+		return file, false
+	}
+	u, err := url.Parse(version.DownloadUrl)
+	if err != nil {
+		log.WithError(err).Warnf("Skipping file %s (ID: %d): version %d has an invalid download URL %q.", file.Name, file.ID, version.ID, version.DownloadUrl)
+		return file, false
+	}
+	query := u.Query()
+	query.Set("fileId", strconv.Itoa(file.ID))
+	if file.Type != "" {
+		query.Set("type", file.Type)
+	}
+	if file.Metadata.Format != "" {
+		query.Set("format", file.Metadata.Format)
+	}
+	if file.Metadata.Size != "" {
+		query.Set("size", file.Metadata.Size)
+	}
+	if file.Metadata.Fp != "" {
+		query.Set("fp", file.Metadata.Fp)
+	}
+	u.RawQuery = query.Encode()
+	file.DownloadUrl = u.String()
+	log.Infof("File %s (ID: %d) has no download URL, using the version URL %s", file.Name, file.ID, file.DownloadUrl)
+	return file, true
+}
+
 // saveModelInfoFile saves the full model metadata to a .json file.
 // It saves the file to {modelBaseDir}/{model.ID}.json.
 func saveModelInfoFile(model models.Model, modelBaseDir string) error {
@@ -277,8 +453,171 @@ func saveModelInfoFile(model models.Model, modelBaseDir string) error {
 	return nil
 }
 
+// versionListEntry is one version in a model's versions.json.
+type versionListEntry struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	PublishedAt string `json:"publishedAt"`
+	BaseModel   string `json:"baseModel"`
+	Downloaded  bool   `json:"downloaded"`       // Recorded as Downloaded in the database
+	Status      string `json:"status,omitempty"` // Database status, empty if the version was never queued
+}
+
+// saveVersionList writes versions.json to modelBaseDir, listing every version the API
+// reports for the model (newest first, as returned) and whether it is downloaded locally.
+func saveVersionList(model models.Model, modelBaseDir string, db *database.DB) error {
+	if err := os.MkdirAll(modelBaseDir, 0750); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", modelBaseDir, err)
+	}
+
+	entries := make([]versionListEntry, 0, len(model.ModelVersions))
+	for _, version := range model.ModelVersions {
+		item := versionListEntry{
+			ID:          version.ID,
+			Name:        version.Name,
+			PublishedAt: version.PublishedAt,
+			BaseModel:   version.BaseModel,
+		}
+		if rawValue, err := db.Get([]byte(fmt.Sprintf("v_%d", version.ID))); err == nil {
+			var entry models.DatabaseEntry
+			if json.Unmarshal(rawValue, &entry) == nil {
+				item.Status = entry.Status
+				item.Downloaded = entry.Status == models.StatusDownloaded
+			}
+		}
+		entries = append(entries, item)
+	}
+
+	jsonData, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal version list for model %d: %w", model.ID, err)
+	}
+	filePath := filepath.Join(modelBaseDir, "versions.json")
+	if err := os.WriteFile(filePath, jsonData, 0600); err != nil {
+		return fmt.Errorf("failed to write version list %s: %w", filePath, err)
+	}
+	log.Debugf("Saved list of %d versions to %s", len(entries), filePath)
+	return nil
+}
+
+// imagesCompleteMarker is written into an image directory once all of its images
+// downloaded successfully, letting --skip-complete-images skip it on later runs.
+const imagesCompleteMarker = ".images-complete"
+
+// imagesMarker is the content of an imagesCompleteMarker file.
+type imagesMarker struct {
+	Count       int       `json:"count"` // Number of images the directory was completed with
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// imagesComplete reports whether baseDir holds a marker for exactly count images.
+// A version that gained images since the marker was written is not considered complete.
+func imagesComplete(baseDir string, count int) bool {
+	data, err := os.ReadFile(filepath.Join(baseDir, imagesCompleteMarker))
+	if err != nil {
+		return false
+	}
+	var marker imagesMarker
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return false
+	}
+	return marker.Count == count
+}
+
+// writeImagesMarker records that all count images in baseDir are present.
+func writeImagesMarker(baseDir string, count int) error {
+	data, err := json.Marshal(imagesMarker{Count: count, CompletedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(baseDir, imagesCompleteMarker), data, 0600)
+}
+
+// imageConcurrencyLevel returns how many images the download command fetches at once
+// for --model-images and --version-images: --image-concurrency if set, otherwise
+// --concurrency.
+func imageConcurrencyLevel() int {
+	if imageConcurrency := viper.GetInt("imageconcurrency"); imageConcurrency > 0 {
+		return imageConcurrency
+	}
+	if concurrency := viper.GetInt("concurrency"); concurrency > 0 {
+		return concurrency
+	}
+	return 4 // Simple default if flag missing/invalid
+}
+
+// savePageModelExtras saves the full info (and with --model-images the images) of a
+// page of models across a pool of --concurrency workers, as the models are independent
+// of each other. The models being processed share one set of slots, so no more than the
+// image concurrency level of images download at once in total.
+func savePageModelExtras(ctx context.Context, pageModels []models.Model, cfg *models.Config, imageDownloader *downloader.Downloader) {
+	concurrency := viper.GetInt("concurrency") // Viper key from download.go init
+	if concurrency <= 0 {
+		concurrency = 4
+	} // Simple default if flag missing/invalid
+	numWorkers := min(concurrency, len(pageModels))
+	if numWorkers == 0 {
+		return
+	}
+	imageConcurrency := imageConcurrencyLevel()
+	slots := make(chan struct{}, imageConcurrency)
+
+	jobs := make(chan models.Model)
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for model := range jobs {
+				saveModelExtras(ctx, model, cfg, imageDownloader, imageConcurrency, slots)
+			}
+		}()
+	}
+	for _, model := range pageModels {
+		jobs <- model
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// saveModelExtras saves the full info of a model into its type/model folder and, with
+// --model-images, the images of all its versions below it, taking a slot from slots
+// for each image download.
+func saveModelExtras(ctx context.Context, model models.Model, cfg *models.Config, imageDownloader *downloader.Downloader, imageConcurrency int, slots chan struct{}) {
+	modelNameSlug := helpers.ConvertToSlug(model.Name)
+	if modelNameSlug == "" {
+		modelNameSlug = "unknown_model"
+	}
+	modelBaseDir := filepath.Join(cfg.SavePath, typeFolderName(model.Type), modelNameSlug) // Path for model info/images
+
+	if err := saveModelInfoFile(model, modelBaseDir); err != nil {
+		log.WithError(err).Warnf("Failed to save full model info for model %d (%s)", model.ID, model.Name)
+	}
+
+	if !viper.GetBool("savemodelimages") { // Viper key from download.go init
+		return
+	}
+	logPrefix := fmt.Sprintf("Model %d Img", model.ID)
+	log.Infof("[%s] Processing all model images for %s (%d)...", logPrefix, model.Name, model.ID)
+	modelImagesBaseDir := filepath.Join(modelBaseDir, "images")
+	var totalImgSuccess, totalImgFail int
+	for _, version := range model.ModelVersions {
+		versionLogPrefix := fmt.Sprintf("%s v%d", logPrefix, version.ID)
+		versionImagesDir := filepath.Join(modelImagesBaseDir, fmt.Sprintf("%d", version.ID))
+		log.Debugf("[%s] Checking %d images for version %s (%d)", versionLogPrefix, len(version.Images), version.Name, version.ID)
+		if len(version.Images) > 0 {
+			imgSuccess, imgFail := downloadImages(ctx, versionLogPrefix, version.Images, versionImagesDir, imageDownloader, imageConcurrency, slots)
+			totalImgSuccess += imgSuccess
+			totalImgFail += imgFail
+		}
+	}
+	log.Infof("[%s] Finished processing images for model %s (%d). Total Success: %d, Total Failed: %d",
+		logPrefix, model.Name, model.ID, totalImgSuccess, totalImgFail)
+}
+
 // downloadImages handles downloading a list of images concurrently to a specified directory.
-func downloadImages(logPrefix string, images []models.ModelImage, baseDir string, imageDownloader *downloader.Downloader, numWorkers int) (finalSuccessCount, finalFailCount int) {
+// slots, if not nil, bounds the image downloads in flight across several calls sharing it.
+func downloadImages(ctx context.Context, logPrefix string, images []models.ModelImage, baseDir string, imageDownloader *downloader.Downloader, numWorkers int, slots chan struct{}) (finalSuccessCount, finalFailCount int) {
 	if imageDownloader == nil {
 		log.Warnf("[%s] Image downloader is nil, cannot download images.", logPrefix)
 		return 0, len(images) // Count all as failed if downloader doesn't exist
@@ -292,6 +631,11 @@ func downloadImages(logPrefix string, images []models.ModelImage, baseDir string
 		numWorkers = 1
 	}
 
+	if viper.GetBool("skipcompleteimages") && !viper.GetBool("forceimages") && imagesComplete(baseDir, len(images)) {
+		log.Infof("[%s] All %d images already present in %s (marker found), skipping.", logPrefix, len(images), baseDir)
+		return 0, 0
+	}
+
 	log.Infof("[%s] Attempting concurrent download for %d images to %s (Concurrency: %d)", logPrefix, len(images), baseDir, numWorkers)
 
 	if err := os.MkdirAll(baseDir, 0750); err != nil {
@@ -309,7 +653,7 @@ func downloadImages(logPrefix string, images []models.ModelImage, baseDir string
 	log.Debugf("[%s] Starting %d internal image download workers...", logPrefix, numWorkers)
 	for w := 1; w <= numWorkers; w++ {
 		wg.Add(1)
-		go imageDownloadWorkerInternal(w, jobs, imageDownloader, &wg, &successCounter, &failureCounter, logPrefix)
+		go imageDownloadWorkerInternal(ctx, w, jobs, imageDownloader, slots, &wg, &successCounter, &failureCounter, logPrefix)
 	}
 
 	// --- Queue Jobs --- Loop through images and send jobs
@@ -368,5 +712,11 @@ func downloadImages(logPrefix string, images []models.ModelImage, baseDir string
 	wg.Wait()
 	log.Infof("[%s] Image download complete. Success: %d, Failed: %d", logPrefix, atomic.LoadInt64(&successCounter), atomic.LoadInt64(&failureCounter))
 
+	if atomic.LoadInt64(&failureCounter) == 0 {
+		if err := writeImagesMarker(baseDir, len(images)); err != nil {
+			log.WithError(err).Warnf("[%s] Failed to write %s in %s", logPrefix, imagesCompleteMarker, baseDir)
+		}
+	}
+
 	return int(atomic.LoadInt64(&successCounter)), int(atomic.LoadInt64(&failureCounter))
 }
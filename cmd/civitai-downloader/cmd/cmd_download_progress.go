@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"go-civitai-download/internal/downloader"
+	"go-civitai-download/internal/helpers"
+
+	"github.com/gosuri/uilive"
+)
+
+// progressInterval is how often a worker updates the live display of the file it is
+// downloading.
+const progressInterval = 500 * time.Millisecond
+
+// transferStats derives the current speed and ETA of one download from the byte
+// counts it reports over time.
+type transferStats struct {
+	lastWritten uint64
+	lastTime    time.Time
+}
+
+// update records that written bytes were reached at now. It returns the speed in
+// bytes per second since the previous update and the time left to reach total at
+// that speed. Both are 0 on the first update; the ETA is also 0 if total is unknown.
+func (s *transferStats) update(written, total uint64, now time.Time) (speed float64, eta time.Duration) {
+	if !s.lastTime.IsZero() {
+		if elapsed := now.Sub(s.lastTime); elapsed > 0 && written >= s.lastWritten {
+			speed = float64(written-s.lastWritten) / elapsed.Seconds()
+		}
+	}
+	s.lastWritten, s.lastTime = written, now
+	if speed > 0 && total > written {
+		eta = time.Duration(float64(total-written) / speed * float64(time.Second)).Round(time.Second)
+	}
+	return speed, eta
+}
+
+// formatTransferProgress renders a progress line such as
+// "45.0% (1.17GB/2.60GB) at 12.30MB/s, ETA 1m52s". Parts that are unknown are left out.
+func formatTransferProgress(written, total uint64, speed float64, eta time.Duration) string {
+	line := helpers.BytesToSize(written)
+	if total > 0 {
+		line = fmt.Sprintf("%.1f%% (%s/%s)", float64(written)/float64(total)*100, line, helpers.BytesToSize(total))
+	}
+	if speed > 0 {
+		line += fmt.Sprintf(" at %s/s", helpers.BytesToSize(uint64(speed)))
+	}
+	if eta > 0 {
+		line += fmt.Sprintf(", ETA %v", eta)
+	}
+	return line
+}
+
+// newProgressReporter returns a ProgressFunc that shows worker id's download of name
+// on the shared live writer, at most once per progressInterval.
+func newProgressReporter(id int, name string, writer *uilive.Writer) downloader.ProgressFunc {
+	var stats transferStats
+	var lastShown time.Time
+	return func(written, total uint64) {
+		now := time.Now()
+		if now.Sub(lastShown) < progressInterval && (total == 0 || written < total) {
+			return
+		}
+		lastShown = now
+		speed, eta := stats.update(written, total, now)
+		fmt.Fprintf(writer.Newline(), "Worker %d: Downloading %s %s\n", id, name, formatTransferProgress(written, total, speed, eta))
+	}
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Per-file statuses in the --report file.
+const (
+	reportStatusDownloaded      = "downloaded"
+	reportStatusSkippedExisting = "skipped_existing"
+	reportStatusFailed          = "failed"
+)
+
+// downloadReportFile is the outcome of one queued file.
+type downloadReportFile struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Bytes  int64  `json:"bytes,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// downloadReport collects the worker results of a run for --report. Workers record
+// into it concurrently; it is written as JSON once all downloads finished.
+type downloadReport struct {
+	mu sync.Mutex
+
+	StartedAt       time.Time `json:"startedAt"`
+	FinishedAt      time.Time `json:"finishedAt"`
+	Queued          int       `json:"queued"`
+	Succeeded       int       `json:"succeeded"`
+	SkippedExisting int       `json:"skippedExisting"`
+	Failed          int       `json:"failed"`
+	NotAttempted    int       `json:"notAttempted"`        // Left pending, e.g. after --max-errors or low disk space
+	Cancelled       bool      `json:"cancelled,omitempty"` // A confirmation prompt was declined
+	TotalBytes      int64     `json:"totalBytes"`          // Bytes of downloaded and existing files
+	Errors          []string  `json:"errors"`
+	// Files holds each file's outcome keyed by model version ID
+	Files map[string][]downloadReportFile `json:"files"`
+}
+
+// runReport is the report of the current download run, nil unless --report is set.
+var runReport *downloadReport
+
+func newDownloadReport(queued int) *downloadReport {
+	return &downloadReport{
+		StartedAt: time.Now().UTC(),
+		Queued:    queued,
+		Errors:    []string{},
+		Files:     make(map[string][]downloadReportFile),
+	}
+}
+
+// record adds the outcome of one file. err is only used for reportStatusFailed.
+// Safe to call on a nil report.
+func (r *downloadReport) record(versionID int, path string, status string, bytes int64, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	file := downloadReportFile{Path: path, Status: status}
+	switch status {
+	case reportStatusDownloaded:
+		r.Succeeded++
+		r.TotalBytes += bytes
+		file.Bytes = bytes
+	case reportStatusSkippedExisting:
+		r.SkippedExisting++
+		r.TotalBytes += bytes
+		file.Bytes = bytes
+	case reportStatusFailed:
+		r.Failed++
+		if err != nil {
+			file.Error = err.Error()
+			r.Errors = append(r.Errors, fmt.Sprintf("version %d (%s): %v", versionID, filepath.Base(path), err))
+		}
+	}
+	key := strconv.Itoa(versionID)
+	r.Files[key] = append(r.Files[key], file)
+}
+
+// forgetFailure drops the failed result of a file that is about to be retried, so
+// the report only holds the outcome of its last attempt. Safe to call on a nil report.
+func (r *downloadReport) forgetFailure(versionID int, path string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := strconv.Itoa(versionID)
+	files := r.Files[key][:0]
+	for _, file := range r.Files[key] {
+		if file.Status == reportStatusFailed && file.Path == path {
+			r.Failed--
+			continue
+		}
+		files = append(files, file)
+	}
+	r.Files[key] = files
+	errorPrefix := fmt.Sprintf("version %d (%s): ", versionID, filepath.Base(path))
+	errs := r.Errors[:0]
+	for _, msg := range r.Errors {
+		if !strings.HasPrefix(msg, errorPrefix) {
+			errs = append(errs, msg)
+		}
+	}
+	r.Errors = errs
+}
+
+// finish records the end time and the number of files that were never attempted.
+func (r *downloadReport) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.FinishedAt = time.Now().UTC()
+	r.NotAttempted = r.Queued - r.Succeeded - r.SkippedExisting - r.Failed
+	if r.NotAttempted < 0 {
+		r.NotAttempted = 0
+	}
+}
+
+// write finalises the counts and saves the report as indented JSON to path.
+func (r *downloadReport) write(path string) error {
+	r.finish()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal download report: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return fmt.Errorf("failed to create directory for report %s: %w", path, err)
+		}
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write download report %s: %w", path, err)
+	}
+	return nil
+}
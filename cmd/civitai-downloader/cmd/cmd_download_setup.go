@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"go-civitai-download/internal/models"
 
@@ -20,6 +22,14 @@ var allowedSortOrders = map[string]bool{
 	"Newest":          true,
 }
 
+var allowedCommercialUse = map[string]bool{
+	"Any":   true,
+	"None":  true,
+	"Image": true,
+	"Rent":  true,
+	"Sell":  true,
+}
+
 var allowedPeriods = map[string]bool{
 	"AllTime": true,
 	"Year":    true,
@@ -28,6 +38,76 @@ var allowedPeriods = map[string]bool{
 	"Day":     true,
 }
 
+// apiValueKey reduces an API parameter value to lowercase letters and digits, so
+// "highest_rated", "Highest Rated" and "HighestRated" compare equal.
+func apiValueKey(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '_', '-':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(value)))
+}
+
+// normalizeAPIValue returns the exact API spelling of value from allowed, matching
+// case-insensitively and ignoring spaces, underscores and hyphens.
+func normalizeAPIValue(value string, allowed map[string]bool) (string, bool) {
+	key := apiValueKey(value)
+	for candidate := range allowed {
+		if apiValueKey(candidate) == key {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// allowedValuesList lists the keys of allowed sorted and quoted, for error messages.
+func allowedValuesList(allowed map[string]bool) string {
+	values := make([]string, 0, len(allowed))
+	for value := range allowed {
+		values = append(values, fmt.Sprintf("%q", value))
+	}
+	sort.Strings(values)
+	return strings.Join(values, ", ")
+}
+
+// normalizeSortOrder maps a --sort value such as "newest" or "highest_rated" to the
+// string the API expects. Empty selects "Most Downloaded".
+func normalizeSortOrder(value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "Most Downloaded", nil
+	}
+	if normalized, ok := normalizeAPIValue(value, allowedSortOrders); ok {
+		return normalized, nil
+	}
+	return "", fmt.Errorf("unknown sort order %q: must be one of %s", value, allowedValuesList(allowedSortOrders))
+}
+
+// normalizePeriod maps a --period value such as "week" or "all_time" to the string
+// the API expects. Empty selects "AllTime".
+func normalizePeriod(value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "AllTime", nil
+	}
+	if normalized, ok := normalizeAPIValue(value, allowedPeriods); ok {
+		return normalized, nil
+	}
+	return "", fmt.Errorf("unknown period %q: must be one of %s", value, allowedValuesList(allowedPeriods))
+}
+
+// normalizeCommercialUse maps a --commercial-use value such as "sell" to the string
+// the API expects. Empty selects "Any".
+func normalizeCommercialUse(value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "Any", nil
+	}
+	if normalized, ok := normalizeAPIValue(value, allowedCommercialUse); ok {
+		return normalized, nil
+	}
+	return "", fmt.Errorf("unknown commercial use %q: must be one of %s", value, allowedValuesList(allowedCommercialUse))
+}
+
 // Variables defined in download.go that are used here
 // var logLevel string // Declared in download.go
 // var logFormat string // Declared in download.go
@@ -57,34 +137,54 @@ func initLogging() {
 	log.Infof("Logging configured: Level=%s, Format=%s", log.GetLevel(), logFormat)
 }
 
+// configuredPageSize returns the --page-size value, or the API maximum of 100 if it
+// is out of range.
+func configuredPageSize() int {
+	pageSize := viper.GetInt("pagesize") // Viper key from download.go init
+	if pageSize <= 0 || pageSize > 100 {
+		return 100
+	}
+	return pageSize
+}
+
 // setupQueryParams initializes the query parameters using Viper for flag/config precedence.
 func setupQueryParams(cfg *models.Config, cmd *cobra.Command) models.QueryParameters {
 	// Viper keys should match the keys used in viper.BindPFlag in init()
 
 	// Use viper.Get* for values that can be set by flags
-	limit := viper.GetInt("limit") // Viper key from download.go init
-	if limit <= 0 || limit > 100 {
-		if limit != 0 { // Don't warn if just using default
-			log.Warnf("Invalid Limit value '%d' from flag/config, using default 100", limit)
-		}
-		limit = 100 // API default/max
+	// Limit is the total number of models; PageSize is how many are requested at a time
+	pageSize := configuredPageSize()
+	if configured := viper.GetInt("pagesize"); configured != pageSize {
+		log.Warnf("Invalid PageSize value '%d' from flag/config, using default 100", configured)
+	}
+	totalLimit := viper.GetInt("limit")
+	if totalLimit < 0 {
+		log.Warnf("Invalid Limit value '%d' from flag/config, using no limit", totalLimit)
+		totalLimit = 0
 	}
+	// The first page never needs to be larger than the total limit
+	limit := pageRequestSize(pageSize, totalLimit, 0)
 
 	// Use global Viper directly now that TOML parsing is fixed
-	sort := viper.GetString("sort")
-	if sort == "" {
-		sort = "Most Downloaded"
-	} else if _, ok := allowedSortOrders[sort]; !ok {
-		log.Warnf("Invalid Sort value '%s' from flag/config, using default 'Most Downloaded'", sort)
-		sort = "Most Downloaded"
+	sortOrder, err := normalizeSortOrder(viper.GetString("sort"))
+	if err != nil {
+		log.Fatalf("Invalid Sort value from flag/config: %v", err)
+	}
+
+	period, err := normalizePeriod(viper.GetString("period"))
+	if err != nil {
+		log.Fatalf("Invalid Period value from flag/config: %v", err)
+	}
+
+	commercialUse, err := normalizeCommercialUse(viper.GetString("allowcommercialuse"))
+	if err != nil {
+		log.Fatalf("Invalid AllowCommercialUse value from flag/config: %v", err)
 	}
 
-	period := viper.GetString("period")
-	if period == "" {
-		period = "AllTime"
-	} else if _, ok := allowedPeriods[period]; !ok {
-		log.Warnf("Invalid Period value '%s' from flag/config, using default 'AllTime'", period)
-		period = "AllTime"
+	rating := viper.GetInt("rating")
+	if rating < 0 || rating > 5 {
+		log.Warnf("Invalid Rating value '%d' from flag/config, must be 1-5, using no rating filter", rating)
+		rating = 0
 	}
 
 	baseModels := viper.GetStringSlice("basemodels") // Viper should handle precedence correctly now
@@ -96,15 +196,18 @@ func setupQueryParams(cfg *models.Config, cmd *cobra.Command) models.QueryParame
 		Tag:                    viper.GetString("tag"),
 		Username:               viper.GetString("username"),
 		Types:                  viper.GetStringSlice("modeltypes"),
-		Sort:                   sort,
+		Sort:                   sortOrder,
 		Period:                 period,
 		PrimaryFileOnly:        viper.GetBool("primaryonly"),
 		AllowNoCredit:          true,
 		AllowDerivatives:       true,
 		AllowDifferentLicenses: true,
-		AllowCommercialUse:     "Any",
+		AllowCommercialUse:     commercialUse,
 		Nsfw:                   viper.GetBool("nsfw"),
 		BaseModels:             baseModels, // Use value directly from Viper
+		Favorites:              viper.GetBool("favorites"),
+		Hidden:                 viper.GetBool("hidden"),
+		Rating:                 rating,
 	}
 
 	log.WithField("params", fmt.Sprintf("%+v", params)).Debug("Final query parameters set")
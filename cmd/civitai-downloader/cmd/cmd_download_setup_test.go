@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"testing"
+)
+
+// TestNormalizeSortOrder checks that --sort values are matched case-insensitively,
+// ignoring spaces and underscores, that empty selects the default and that unknown
+// values are rejected.
+func TestNormalizeSortOrder(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "newest", want: "Newest"},
+		{value: "Highest Rated", want: "Highest Rated"},
+		{value: "highest_rated", want: "Highest Rated"},
+		{value: "", want: "Most Downloaded"},
+		{value: "Newset", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeSortOrder(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalizeSortOrder(%q) = %q, %v, want %q (error %v)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestNormalizePeriod checks that --period values are matched case-insensitively,
+// with or without underscores, that empty selects AllTime and that unknown periods
+// are rejected.
+func TestNormalizePeriod(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "week", want: "Week"},
+		{value: "ALLTIME", want: "AllTime"},
+		{value: "all_time", want: "AllTime"},
+		{value: "", want: "AllTime"},
+		{value: "Fortnight", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizePeriod(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalizePeriod(%q) = %q, %v, want %q (error %v)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go-civitai-download/internal/database"
+	"go-civitai-download/internal/downloader"
+	"go-civitai-download/internal/models"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// stdinID is one ID read by --stdin: a model ID, or a version ID when IsVersion is set.
+type stdinID struct {
+	ID        int
+	IsVersion bool
+}
+
+func (s stdinID) String() string {
+	if s.IsVersion {
+		return fmt.Sprintf("v:%d", s.ID)
+	}
+	return strconv.Itoa(s.ID)
+}
+
+// parseStdinIDs reads model IDs and "v:"-prefixed version IDs from r, one or more per
+// line separated by whitespace or commas. Empty lines and lines starting with # are
+// ignored; invalid entries are logged and skipped, and repeated IDs are only returned once.
+func parseStdinIDs(r io.Reader) ([]stdinID, error) {
+	var ids []stdinID
+	seen := make(map[stdinID]struct{})
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		for _, field := range fields {
+			var id stdinID
+			value := field
+			if lower := strings.ToLower(field); strings.HasPrefix(lower, "v:") {
+				id.IsVersion = true
+				value = field[2:]
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				log.Warnf("--stdin line %d: ignoring %q, expected a model ID or v:<version ID>.", lineNo, field)
+				continue
+			}
+			id.ID = n
+			if _, dup := seen[id]; dup {
+				continue
+			}
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return ids, fmt.Errorf("error reading IDs from stdin: %w", err)
+	}
+	return ids, nil
+}
+
+// handleStdinIDs runs each ID through the single model/version handlers and returns
+// everything they queued. A failing ID is logged and does not stop the others.
+func handleStdinIDs(ids []stdinID, db *database.DB, client *http.Client, imageDownloader *downloader.Downloader, cfg *models.Config, cmd *cobra.Command) []potentialDownload {
+	var result []potentialDownload
+	queuedPaths := make(map[string]struct{})
+	var failed int
+	for i, id := range ids {
+		log.Infof("--- Processing ID %d/%d from stdin: %s ---", i+1, len(ids), id)
+		var queued []potentialDownload
+		var err error
+		if id.IsVersion {
+			queued, _, err = handleSingleVersionDownload(id.ID, 0, "", db, client, cfg, cmd)
+		} else {
+			queued, _, err = handleSingleModelDownload(id.ID, db, client, imageDownloader, cfg, cmd)
+		}
+		if err != nil {
+			log.WithError(err).Errorf("Failed to process %s from stdin, continuing with the next ID.", id)
+			failed++
+			continue
+		}
+		// A version can be reached both through its model and its own ID
+		for _, pd := range queued {
+			if _, dup := queuedPaths[pd.TargetFilepath]; dup {
+				continue
+			}
+			queuedPaths[pd.TargetFilepath] = struct{}{}
+			result = append(result, pd)
+		}
+	}
+	log.Infof("--- Finished processing %d ID(s) from stdin (%d failed), %d file(s) queued ---", len(ids), failed, len(result))
+	return result
+}
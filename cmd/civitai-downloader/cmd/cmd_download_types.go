@@ -1,6 +1,10 @@
 package cmd
 
-import "go-civitai-download/internal/models"
+import (
+	"path/filepath"
+
+	"go-civitai-download/internal/models"
+)
 
 // potentialDownload holds information about a file identified during the metadata scan phase.
 type potentialDownload struct {
@@ -13,11 +17,19 @@ type potentialDownload struct {
 	ModelVersionID    int         // Add Model Version ID
 	TargetFilepath    string      // Full calculated path for download
 	Slug              string      // Folder structure
+	ModelDir          string      // Model directory relative to SavePath, as packaged by torrent and pack
 	FinalBaseFilename string      // Base filename part without ID prefix or metadata suffix (e.g., wan_cowgirl_v1.3.safetensors)
 	// Store cleaned version separately for potential later use in DB entry
 	CleanedVersion models.ModelVersion
 	FullVersion    models.ModelVersion
 	OriginalImages []models.ModelImage // Add original images for potential download
+	Model          models.Model        // Model-level info (description, tags, license) for combined metadata
+}
+
+// versionDir returns the directory below Slug that holds the file. It is stored in
+// the database entry so the file can be found again without searching for it.
+func (pd potentialDownload) versionDir() string {
+	return filepath.Base(filepath.Dir(pd.TargetFilepath))
 }
 
 // Represents a download task to be processed by a worker.
@@ -25,3 +37,26 @@ type downloadJob struct {
 	PotentialDownload potentialDownload // Embed potential download info
 	DatabaseKey       string            // Key for DB updates
 }
+
+// combinedMetadata is the sidecar written by --combined-metadata: the model-level
+// fields and the version details in one file next to the downloaded model.
+type combinedMetadata struct {
+	Model   combinedModelInfo   `json:"model"`
+	Version models.ModelVersion `json:"version"`
+}
+
+// combinedModelInfo holds the model-level fields of a combinedMetadata sidecar.
+type combinedModelInfo struct {
+	ID                    int            `json:"id"`
+	Name                  string         `json:"name"`
+	Description           string         `json:"description"`
+	Type                  string         `json:"type"`
+	Poi                   bool           `json:"poi"`
+	Nsfw                  bool           `json:"nsfw"`
+	Tags                  []string       `json:"tags"`
+	Creator               models.Creator `json:"creator"`
+	AllowNoCredit         bool           `json:"allowNoCredit"`
+	AllowCommercialUse    []string       `json:"allowCommercialUse"`
+	AllowDerivatives      bool           `json:"allowDerivatives"`
+	AllowDifferentLicense bool           `json:"allowDifferentLicense"`
+}
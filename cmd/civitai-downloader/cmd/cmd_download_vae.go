@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go-civitai-download/internal/api"
+	"go-civitai-download/internal/helpers"
+	"go-civitai-download/internal/models"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// recommendedVaeRegex picks a VAE name out of free-text version descriptions,
+// e.g. "Recommended VAE: sdxl_vae" or "VAE: vae-ft-mse-840000".
+var recommendedVaeRegex = regexp.MustCompile(`(?i)\bvae\b[^:\n<]{0,20}:\s*(?:<[^>]*>\s*)*([A-Za-z0-9][A-Za-z0-9_.\- ]{1,80})`)
+
+// vaeLookupCache avoids repeating the same API lookup for every checkpoint that
+// recommends the same VAE. The scan phase runs on a single goroutine.
+var vaeLookupCache = map[string]*models.ModelVersion{}
+
+// appendVaeDownloads adds the recommended VAE for each checkpoint version in pds
+// when --with-vae is set. The VAE is taken, in order of preference, from a VAE file
+// bundled with the version, the VaeMap config (base model -> VAE version ID), or a
+// Civitai search for the VAE name mentioned in the version description.
+func appendVaeDownloads(pds []potentialDownload, client *http.Client, cfg *models.Config) []potentialDownload {
+	if !viper.GetBool("withvae") {
+		return pds
+	}
+
+	queuedFileIDs := make(map[int]struct{}, len(pds))
+	for _, pd := range pds {
+		queuedFileIDs[pd.File.ID] = struct{}{}
+	}
+
+	seenVersions := make(map[int]struct{})
+	result := pds
+	for _, pd := range pds {
+		if !strings.EqualFold(pd.ModelType, "checkpoint") {
+			continue
+		}
+		if _, done := seenVersions[pd.ModelVersionID]; done {
+			continue
+		}
+		seenVersions[pd.ModelVersionID] = struct{}{}
+
+		vaeVersion, vaeFile, source := findVaeForVersion(pd.FullVersion, client, cfg)
+		if vaeFile == nil {
+			log.Infof("No VAE found for checkpoint %s - %s (%d).", pd.ModelName, pd.VersionName, pd.ModelVersionID)
+			continue
+		}
+		if _, queued := queuedFileIDs[vaeFile.ID]; queued {
+			log.Debugf("VAE file %s for version %d is already part of this download.", vaeFile.Name, pd.ModelVersionID)
+			continue
+		}
+		file, ok := withVersionDownloadUrl(*vaeFile, *vaeVersion)
+		if !ok {
+			continue
+		}
+		queuedFileIDs[vaeFile.ID] = struct{}{}
+
+		log.Infof("Adding VAE %s (version %d, via %s) for checkpoint %s - %s.", vaeFile.Name, vaeVersion.ID, source, pd.ModelName, pd.VersionName)
+		result = append(result, buildVaeDownload(pd, *vaeVersion, file))
+	}
+	return result
+}
+
+// findVaeForVersion resolves the VAE for a checkpoint version. It returns the
+// version that owns the VAE file, the file itself and a short description of
+// where it was found, or a nil file if nothing matched.
+func findVaeForVersion(version models.ModelVersion, client *http.Client, cfg *models.Config) (*models.ModelVersion, *models.File, string) {
+	// 1. A VAE file bundled with the checkpoint version itself
+	for i := range version.Files {
+		if strings.EqualFold(version.Files[i].Type, "VAE") {
+			return &version, &version.Files[i], "bundled file"
+		}
+	}
+
+	// 2. Configured mapping from base model to a VAE model version ID
+	if version.BaseModel != "" {
+		// Viper lowercases map keys, so compare case-insensitively
+		for baseModel, idStr := range viper.GetStringMapString("vaemap") {
+			if !strings.EqualFold(baseModel, version.BaseModel) {
+				continue
+			}
+			vaeVersionID, err := strconv.Atoi(idStr)
+			if err != nil {
+				log.Warnf("Invalid VaeMap entry for base model %q: %q is not a model version ID.", baseModel, idStr)
+				break
+			}
+			cacheKey := "id:" + idStr
+			vaeVersion, cached := vaeLookupCache[cacheKey]
+			if !cached {
+				fetched, fetchErr := fetchModelVersionDetails(vaeVersionID, client, cfg)
+				if fetchErr != nil {
+					log.WithError(fetchErr).Warnf("Failed to fetch VAE version %d configured for base model %s.", vaeVersionID, version.BaseModel)
+				} else {
+					vaeVersion = &fetched
+				}
+				vaeLookupCache[cacheKey] = vaeVersion
+			}
+			if vaeVersion != nil {
+				if file := pickVaeFile(*vaeVersion); file != nil {
+					return vaeVersion, file, "VaeMap"
+				}
+			}
+			break
+		}
+	}
+
+	// 3. Search Civitai for the VAE named in the version description
+	vaeName := recommendedVaeName(version.Description)
+	if vaeName == "" {
+		return nil, nil, ""
+	}
+	cacheKey := "name:" + strings.ToLower(vaeName) + "|" + strings.ToLower(version.BaseModel)
+	vaeVersion, cached := vaeLookupCache[cacheKey]
+	if !cached {
+		found, err := searchVaeByName(vaeName, version.BaseModel, client, cfg)
+		if err != nil {
+			log.WithError(err).Warnf("Failed to search for recommended VAE %q.", vaeName)
+		}
+		vaeVersion = found
+		vaeLookupCache[cacheKey] = vaeVersion
+	}
+	if vaeVersion == nil {
+		return nil, nil, ""
+	}
+	if file := pickVaeFile(*vaeVersion); file != nil {
+		return vaeVersion, file, fmt.Sprintf("search for %q", vaeName)
+	}
+	return nil, nil, ""
+}
+
+// recommendedVaeName extracts a VAE name from a version description, or "".
+func recommendedVaeName(description string) string {
+	match := recommendedVaeRegex.FindStringSubmatch(description)
+	if len(match) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(match[1])
+}
+
+// pickVaeFile returns the primary file of a VAE version, falling back to the first file.
+func pickVaeFile(version models.ModelVersion) *models.File {
+	for i := range version.Files {
+		if version.Files[i].Primary {
+			return &version.Files[i]
+		}
+	}
+	if len(version.Files) > 0 {
+		return &version.Files[0]
+	}
+	return nil
+}
+
+// searchVaeByName queries the models endpoint for VAE models matching name and
+// returns the newest version, preferring one for the same base model.
+func searchVaeByName(name string, baseModel string, client *http.Client, cfg *models.Config) (*models.ModelVersion, error) {
+	params := url.Values{}
+	params.Set("types", "VAE")
+	params.Set("query", name)
+	params.Set("limit", "5")
+	apiURL := api.BaseURL() + "/models?" + params.Encode()
+
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VAE search request: %w", err)
+	}
+	if cfg.ApiKey != "" {
+		req.Header.Add("Authorization", "Bearer "+cfg.ApiKey)
+	}
+
+	_, bodyBytes, err := api.DoRequestWithRetry(client, req, apiRetryOptions("VAE search"))
+	if err != nil {
+		return nil, fmt.Errorf("VAE search for %q failed: %w", name, err)
+	}
+
+	var response models.ApiResponse
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode VAE search response: %w", err)
+	}
+
+	var fallback *models.ModelVersion
+	for _, model := range response.Items {
+		// The API lists versions newest first
+		for i := range model.ModelVersions {
+			v := model.ModelVersions[i]
+			if len(v.Files) == 0 {
+				continue
+			}
+			if baseModel == "" || strings.EqualFold(v.BaseModel, baseModel) {
+				return &v, nil
+			}
+			if fallback == nil {
+				fallback = &v
+			}
+		}
+	}
+	return fallback, nil
+}
+
+// fetchModelVersionDetails fetches a single model version by ID.
+func fetchModelVersionDetails(versionID int, client *http.Client, cfg *models.Config) (models.ModelVersion, error) {
+	var version models.ModelVersion
+	apiURL := fmt.Sprintf("%s/model-versions/%d", api.BaseURL(), versionID)
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return version, fmt.Errorf("failed to create request for version %d: %w", versionID, err)
+	}
+	if cfg.ApiKey != "" {
+		req.Header.Add("Authorization", "Bearer "+cfg.ApiKey)
+	}
+
+	_, bodyBytes, err := api.DoRequestWithRetry(client, req, apiRetryOptions(fmt.Sprintf("Version %d", versionID)))
+	if err != nil {
+		return version, fmt.Errorf("failed to fetch version %d: %w", versionID, err)
+	}
+	if err := json.Unmarshal(bodyBytes, &version); err != nil {
+		return version, fmt.Errorf("failed to decode API response for version %d: %w", versionID, err)
+	}
+	return version, nil
+}
+
+// buildVaeDownload creates a potentialDownload that places the VAE file next to
+// the checkpoint it belongs to.
+func buildVaeDownload(checkpoint potentialDownload, vaeVersion models.ModelVersion, vaeFile models.File) potentialDownload {
+	baseFileName := helpers.ConvertToSlug(vaeFile.Name)
+	ext := filepath.Ext(baseFileName)
+	baseFileName = strings.TrimSuffix(baseFileName, ext)
+	if strings.ToLower(vaeFile.Metadata.Format) == "safetensor" && !strings.EqualFold(ext, ".safetensors") {
+		ext = ".safetensors"
+	}
+	if ext == "" {
+		ext = ".bin"
+	}
+	finalBaseFilename := baseFileName + ext
+
+	cleanedVersion := vaeVersion
+	cleanedVersion.Files = nil
+	cleanedVersion.Images = nil
+
+	modelName := vaeVersion.Model.Name
+	if modelName == "" {
+		modelName = checkpoint.ModelName + " VAE"
+	}
+
+	return potentialDownload{
+		ModelName:         modelName,
+		ModelType:         "VAE",
+		VersionName:       vaeVersion.Name,
+		BaseModel:         vaeVersion.BaseModel,
+		Creator:           checkpoint.Creator,
+		File:              vaeFile,
+		ModelVersionID:    vaeVersion.ID,
+		TargetFilepath:    filepath.Join(filepath.Dir(checkpoint.TargetFilepath), targetFileName(vaeFile, finalBaseFilename)),
+		Slug:              checkpoint.Slug, // Same model directory as the checkpoint
+		ModelDir:          checkpoint.ModelDir,
+		FinalBaseFilename: finalBaseFilename,
+		CleanedVersion:    cleanedVersion,
+		FullVersion:       vaeVersion,
+	}
+}
@@ -1,17 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	index "go-civitai-download/index"
 	"go-civitai-download/internal/database"
 	"go-civitai-download/internal/downloader"
+	"go-civitai-download/internal/helpers"
 	"go-civitai-download/internal/models"
 
 	"github.com/blevesearch/bleve/v2"
@@ -63,9 +67,255 @@ func updateDbEntry(db *database.DB, key string, newStatus string, updateFunc fun
 	return nil
 }
 
+// normalizeModelExtension renames a downloaded model file whose extension does not
+// match its detected format (e.g. a safetensors file served as .ckpt). Returns the
+// path the file ends up at; on any problem the original path is kept.
+func normalizeModelExtension(workerID int, finalPath string) string {
+	correctedPath, err := helpers.CorrectPathBasedOnModelFormat(finalPath)
+	if err != nil {
+		log.WithError(err).Warnf("Worker %d: Could not detect format of %s, keeping extension.", workerID, finalPath)
+		return finalPath
+	}
+	if correctedPath == finalPath {
+		return finalPath
+	}
+	if _, statErr := os.Stat(correctedPath); statErr == nil {
+		log.Warnf("Worker %d: Not renaming %s, %s already exists.", workerID, finalPath, correctedPath)
+		return finalPath
+	}
+	if renameErr := os.Rename(finalPath, correctedPath); renameErr != nil {
+		log.WithError(renameErr).Errorf("Worker %d: Failed to rename %s to %s", workerID, finalPath, correctedPath)
+		return finalPath
+	}
+	log.Infof("Worker %d: Renamed %s to %s to match its detected format.", workerID, filepath.Base(finalPath), filepath.Base(correctedPath))
+	return correctedPath
+}
+
+// storeComputedSHA256 keeps the SHA256 computed during the download in pd.File.Hashes
+// if the API provided none. A --convert afterwards replaces the hashes with the
+// converted ones.
+func storeComputedSHA256(workerID int, finalPath string, sum string, pd *potentialDownload) {
+	if sum == "" || pd.File.Hashes.SHA256 != "" {
+		return
+	}
+	pd.File.Hashes.SHA256 = sum
+	if pd.File.Hashes.AutoV2 == "" {
+		pd.File.Hashes.AutoV2 = sum[:10]
+	}
+	log.Debugf("Worker %d: Stored computed SHA256 %s for %s (API provided none).", workerID, sum, filepath.Base(finalPath))
+}
+
+// addStrongHash computes the --hash-algo hash (sha256 or blake3) of a downloaded
+// file when the API provided neither SHA256 nor BLAKE3 (e.g. only CRC32), and stores
+// it in pd.File.Hashes so the DB entry, and with it later db verify runs, use the
+// strong hash. A SHA256 already stored by storeComputedSHA256 is not computed again.
+// With --hash-sidecar the hash is also written to <file>.<algo> in sha256sum/b3sum
+// format.
+func addStrongHash(workerID int, finalPath string, pd *potentialDownload, apiStrongHash bool) {
+	algo := strings.ToLower(viper.GetString("hashalgo"))
+	if algo == "" {
+		return
+	}
+	current := map[string]*string{"sha256": &pd.File.Hashes.SHA256, "blake3": &pd.File.Hashes.BLAKE3}
+	if !apiStrongHash && *current[algo] == "" {
+		sum, err := helpers.FileHash(finalPath, algo)
+		if err != nil {
+			log.WithError(err).Warnf("Worker %d: Could not compute %s of %s.", workerID, algo, filepath.Base(finalPath))
+			return
+		}
+		*current[algo] = sum
+		if algo == "sha256" {
+			pd.File.Hashes.AutoV2 = sum[:10]
+		}
+		log.Debugf("Worker %d: Computed %s %s for %s (API provided no strong hash).", workerID, algo, sum, filepath.Base(finalPath))
+	}
+
+	if viper.GetBool("hashsidecar") && *current[algo] != "" {
+		sidecarPath := finalPath + "." + algo
+		content := fmt.Sprintf("%s  %s\n", strings.ToLower(*current[algo]), filepath.Base(finalPath))
+		if err := os.WriteFile(sidecarPath, []byte(content), 0600); err != nil {
+			log.WithError(err).Warnf("Worker %d: Failed to write hash sidecar %s", workerID, sidecarPath)
+		}
+	}
+}
+
+// validHashAlgo reports whether value is an accepted --hash-algo setting.
+func validHashAlgo(value string) bool {
+	switch strings.ToLower(value) {
+	case "", "sha256", "blake3":
+		return true
+	}
+	return false
+}
+
+// metadataSidecarEnabled reports whether a .json sidecar should be written next to
+// downloaded files, either version-only (--metadata) or combined (--combined-metadata).
+func metadataSidecarEnabled() bool {
+	return viper.GetBool("savemetadata") || viper.GetBool("combinedmetadata")
+}
+
+// metadataSidecarContent returns what goes into a file's .json sidecar: the full
+// version, or the model and version together when --combined-metadata is set.
+func metadataSidecarContent(pd potentialDownload) interface{} {
+	if !viper.GetBool("combinedmetadata") {
+		return pd.FullVersion
+	}
+	model := combinedModelInfo{
+		ID:                    pd.Model.ID,
+		Name:                  pd.Model.Name,
+		Description:           pd.Model.Description,
+		Type:                  pd.Model.Type,
+		Poi:                   pd.Model.Poi,
+		Nsfw:                  pd.Model.Nsfw,
+		Tags:                  pd.Model.Tags,
+		Creator:               pd.Creator,
+		AllowNoCredit:         pd.Model.AllowNoCredit,
+		AllowCommercialUse:    pd.Model.AllowCommercialUse,
+		AllowDerivatives:      pd.Model.AllowDerivatives,
+		AllowDifferentLicense: pd.Model.AllowDifferentLicense,
+	}
+	if model.ID == 0 {
+		model.ID = pd.FullVersion.ModelId
+	}
+	if model.Name == "" {
+		model.Name = pd.ModelName
+	}
+	if model.Type == "" {
+		model.Type = pd.ModelType
+	}
+	return combinedMetadata{Model: model, Version: pd.FullVersion}
+}
+
+// diskSpaceLow is set once any worker finds too little free space; from then on
+// no new downloads are started or queued.
+var diskSpaceLow atomic.Bool
+
+// failedDownloads counts the downloads that failed in this run.
+var failedDownloads atomic.Int64
+
+// maxErrorsReached is set once --max-errors downloads have failed; from then on no
+// new downloads are started or queued and the command exits with an error.
+var maxErrorsReached atomic.Bool
+
+// failureCategories counts the downloads that failed in this run by error category
+// and keeps the category of each failed file for --retry-failed.
+var failureCategories = struct {
+	sync.Mutex
+	counts map[string]int
+	paths  map[string]string // Target path -> error category
+}{counts: make(map[string]int), paths: make(map[string]string)}
+
+// recordDownloadFailure counts a failed download against --max-errors and under
+// its error category for the summary.
+func recordDownloadFailure(path string, category string) {
+	failureCategories.Lock()
+	failureCategories.counts[category]++
+	failureCategories.paths[path] = category
+	failureCategories.Unlock()
+
+	failed := failedDownloads.Add(1)
+	maxErrors := viper.GetInt64("maxerrors")
+	if maxErrors > 0 && failed >= maxErrors && maxErrorsReached.CompareAndSwap(false, true) {
+		log.Errorf("%d downloads have failed, reaching --max-errors %d. This looks like a systemic problem (e.g. an expired API key); no further downloads will be started.", failed, maxErrors)
+	}
+}
+
+// failedDownloadPaths returns the error category of each file that failed in this run,
+// keyed by target path.
+func failedDownloadPaths() map[string]string {
+	failureCategories.Lock()
+	defer failureCategories.Unlock()
+	return maps.Clone(failureCategories.paths)
+}
+
+// forgetDownloadFailure drops a failed file that is about to be retried from the
+// category counts, so the summary only counts the outcome of its last attempt.
+func forgetDownloadFailure(path string) {
+	failureCategories.Lock()
+	defer failureCategories.Unlock()
+	category, ok := failureCategories.paths[path]
+	if !ok {
+		return
+	}
+	delete(failureCategories.paths, path)
+	if failureCategories.counts[category]--; failureCategories.counts[category] <= 0 {
+		delete(failureCategories.counts, category)
+	}
+}
+
+// failureCategorySummary lists the failed downloads of this run per error category,
+// most frequent first, e.g. "auth: 3, hash_mismatch: 1". Empty if none failed.
+func failureCategorySummary() string {
+	failureCategories.Lock()
+	defer failureCategories.Unlock()
+	parts := make([]string, 0, len(failureCategories.counts))
+	for _, category := range sortedCountKeys(failureCategories.counts) {
+		parts = append(parts, fmt.Sprintf("%s: %d", category, failureCategories.counts[category]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// recordReportResult adds a finished download to the --report. A successful result
+// whose file is older than the attempt was already on disk and not downloaded again.
+func recordReportResult(pd potentialDownload, finalPath string, startTime time.Time, downloadErr error) {
+	if runReport == nil {
+		return
+	}
+	if downloadErr != nil {
+		runReport.record(pd.ModelVersionID, pd.TargetFilepath, reportStatusFailed, 0, downloadErr)
+		return
+	}
+	status := reportStatusDownloaded
+	var size int64
+	if info, err := os.Stat(finalPath); err == nil {
+		size = info.Size()
+		if info.ModTime().Before(startTime) {
+			status = reportStatusSkippedExisting
+		}
+	}
+	runReport.record(pd.ModelVersionID, finalPath, status, size, nil)
+}
+
+// hasFreeSpaceFor checks that the filesystem holding the download has room for the
+// file plus the --min-free-space buffer. Returns false (and sets diskSpaceLow) if
+// not, or if space already ran out earlier in the batch.
+func hasFreeSpaceFor(workerID int, pd potentialDownload) bool {
+	minFreeMB := viper.GetInt64("minfreespacemb")
+	if minFreeMB <= 0 {
+		return true
+	}
+	if diskSpaceLow.Load() {
+		return false
+	}
+
+	// The target directory may not exist yet, so check the nearest existing parent
+	checkPath := filepath.Dir(pd.TargetFilepath)
+	for {
+		if _, err := os.Stat(checkPath); err == nil || filepath.Dir(checkPath) == checkPath {
+			break
+		}
+		checkPath = filepath.Dir(checkPath)
+	}
+	free, err := helpers.FreeDiskSpace(checkPath)
+	if err != nil {
+		log.WithError(err).Warnf("Worker %d: Could not check free disk space, continuing.", workerID)
+		return true
+	}
+
+	needed := uint64(pd.File.SizeKB*1024) + uint64(minFreeMB)*1024*1024
+	if free < needed {
+		if diskSpaceLow.CompareAndSwap(false, true) {
+			log.Errorf("Worker %d: Only %s free on %s, need %s for %s plus the %d MB --min-free-space buffer. Stopping new downloads; remaining files stay pending for the next run.",
+				workerID, helpers.BytesToSize(free), checkPath, helpers.BytesToSize(uint64(pd.File.SizeKB*1024)), filepath.Base(pd.TargetFilepath), minFreeMB)
+		}
+		return false
+	}
+	return true
+}
+
 // handleMetadataSaving checks the config and calls saveMetadataFile if needed.
 func handleMetadataSaving(logPrefix string, pd potentialDownload, finalPath string, finalStatus string, writer *uilive.Writer) {
-	if viper.GetBool("savemetadata") {
+	if metadataSidecarEnabled() {
 		if finalStatus == models.StatusDownloaded {
 			log.Debugf("[%s] Saving metadata for successfully downloaded file: %s", logPrefix, finalPath)
 			if metaErr := saveMetadataFile(pd, finalPath); metaErr != nil {
@@ -84,15 +334,29 @@ func handleMetadataSaving(logPrefix string, pd potentialDownload, finalPath stri
 
 // downloadWorker handles the actual download of a file and updates the database.
 // It now also accepts an imageDownloader, bleveIndex, and concurrencyLevel.
-func downloadWorker(id int, jobs <-chan downloadJob, db *database.DB, fileDownloader *downloader.Downloader, imageDownloader *downloader.Downloader, wg *sync.WaitGroup, writer *uilive.Writer, concurrencyLevel int, bleveIndex bleve.Index) {
+func downloadWorker(ctx context.Context, id int, jobs <-chan downloadJob, db *database.DB, fileDownloader *downloader.Downloader, imageDownloader *downloader.Downloader, wg *sync.WaitGroup, writer *uilive.Writer, concurrencyLevel int, bleveIndex bleve.Index, existingByHash *hashIndex) {
 	defer wg.Done()
 	log.Debugf("Worker %d starting", id)
+	// An interrupt only stops new jobs, the file in progress is not cancelled with ctx.
+	// The --deadline still cancels it through the HTTP transport.
+	downloadCtx := context.WithoutCancel(ctx)
 	for job := range jobs {
 		pd := job.PotentialDownload
 		dbKey := job.DatabaseKey // Use the key passed in the job
+		downloadPause.wait(id)
+		// Leave the remaining jobs Pending once the run was interrupted or too many downloads failed
+		if ctx.Err() != nil || maxErrorsReached.Load() {
+			continue
+		}
 		log.Infof("Worker %d: Processing job for %s", id, pd.TargetFilepath)
 		fmt.Fprintf(writer.Newline(), "Worker %d: Preparing %s...\n", id, filepath.Base(pd.TargetFilepath))
 
+		// Leave the job Pending for a later run once disk space ran low
+		if !hasFreeSpaceFor(id, pd) {
+			fmt.Fprintf(writer.Newline(), "Worker %d: Skipping %s, not enough free disk space\n", id, filepath.Base(pd.TargetFilepath))
+			continue
+		}
+
 		// Ensure directory exists
 		dirPath := filepath.Dir(pd.TargetFilepath)
 		if err := os.MkdirAll(dirPath, 0700); err != nil {
@@ -100,7 +364,10 @@ func downloadWorker(id int, jobs <-chan downloadJob, db *database.DB, fileDownlo
 			// Update DB status to Error using the helper
 			updateErr := updateDbEntry(db, dbKey, models.StatusError, func(entry *models.DatabaseEntry) {
 				entry.ErrorDetails = fmt.Sprintf("Failed to create directory: %v", err)
+				entry.ErrorCategory = downloader.CategoryFileSystem
 			})
+			recordDownloadFailure(pd.TargetFilepath, downloader.CategoryFileSystem)
+			runReport.record(pd.ModelVersionID, pd.TargetFilepath, reportStatusFailed, 0, fmt.Errorf("failed to create directory: %w", err))
 			if updateErr != nil {
 				// Log the error from the helper function
 				log.Errorf("Worker %d: Failed to update DB status after mkdir error: %v", id, updateErr)
@@ -113,8 +380,34 @@ func downloadWorker(id int, jobs <-chan downloadJob, db *database.DB, fileDownlo
 		startTime := time.Now()
 		fmt.Fprintf(writer.Newline(), "Worker %d: Checking/Downloading %s...\n", id, filepath.Base(pd.TargetFilepath))
 
-		// Initiate download - it returns the final path and error
-		finalPath, downloadErr := fileDownloader.DownloadFile(pd.TargetFilepath, pd.File.DownloadUrl, pd.File.Hashes, pd.ModelVersionID)
+		apiStrongHash := pd.File.Hashes.SHA256 != "" || pd.File.Hashes.BLAKE3 != ""
+		// Link an identical file from elsewhere in the save tree instead (--skip-existing-by-hash),
+		// otherwise download - it returns the final path, the SHA256 computed while writing and error
+		var finalPath, computedSHA256 string
+		var downloadErr error
+		if linkedPath, linked := linkExistingByHash(id, existingByHash, pd); linked {
+			finalPath = linkedPath
+		} else {
+			finalPath, computedSHA256, downloadErr = fileDownloader.DownloadFileSHA256(downloadCtx, pd.TargetFilepath, pd.File.DownloadUrl, pd.File.Hashes, pd.ModelVersionID, newProgressReporter(id, filepath.Base(pd.TargetFilepath), writer))
+		}
+		if downloadErr == nil {
+			storeComputedSHA256(id, finalPath, computedSHA256, &pd)
+		}
+
+		// --- Normalize Extension (Optional) ---
+		// --server-filename keeps Civitai's name as-is, including its extension
+		if downloadErr == nil && viper.GetBool("normalizeextensions") && !viper.GetBool("serverfilename") {
+			finalPath = normalizeModelExtension(id, finalPath)
+		}
+
+		// --- Convert Precision (Optional) ---
+		if downloadErr == nil {
+			finalPath = convertCheckpointPrecision(id, finalPath, &pd)
+			addStrongHash(id, finalPath, &pd, apiStrongHash)
+			existingByHash.add(pd.File.Hashes.SHA256, finalPath) // Later identical files in this run can link to it
+		}
+
+		recordReportResult(pd, finalPath, startTime, downloadErr)
 
 		// --- Update DB Based on Result ---
 		finalStatus := models.StatusError // Default to error
@@ -131,6 +424,8 @@ func downloadWorker(id int, jobs <-chan downloadJob, db *database.DB, fileDownlo
 			if downloadErr != nil {
 				// Update error details on failure
 				entry.ErrorDetails = errMsg
+				entry.ErrorCategory = downloader.ErrorCategory(downloadErr)
+				recordDownloadFailure(pd.TargetFilepath, entry.ErrorCategory)
 				log.WithError(downloadErr).Errorf("Worker %d: Failed to download %s", id, pd.TargetFilepath)
 				fmt.Fprintf(writer.Newline(), "Worker %d: Error downloading %s: %v\n", id, filepath.Base(pd.TargetFilepath), downloadErr)
 
@@ -143,6 +438,7 @@ func downloadWorker(id int, jobs <-chan downloadJob, db *database.DB, fileDownlo
 				duration := time.Since(startTime)
 				log.Infof("Worker %d: Successfully downloaded %s in %v", id, finalPath, duration)
 				entry.ErrorDetails = ""                   // Clear any previous error
+				entry.ErrorCategory = ""                  // And its category
 				entry.Filename = filepath.Base(finalPath) // Update filename in DB
 				entry.File = pd.File                      // Update File struct
 				entry.Version = pd.CleanedVersion         // Update Version struct
@@ -159,13 +455,10 @@ func downloadWorker(id int, jobs <-chan downloadJob, db *database.DB, fileDownlo
 					publishedAtTime := time.Time{}
 					if pd.FullVersion.PublishedAt != "" {
 						var errParse error
-						publishedAtTime, errParse = time.Parse(time.RFC3339Nano, pd.FullVersion.PublishedAt)
+						publishedAtTime, errParse = parsePublishedAt(pd.FullVersion.PublishedAt)
 						if errParse != nil {
-							publishedAtTime, errParse = time.Parse(time.RFC3339, pd.FullVersion.PublishedAt)
-							if errParse != nil {
-								log.WithError(errParse).Warnf("Worker %d: Failed to parse PublishedAt time '%s' for indexing", id, pd.FullVersion.PublishedAt)
-								// Keep publishedAtTime as zero time
-							}
+							log.WithError(errParse).Warnf("Worker %d: Failed to parse PublishedAt time '%s' for indexing", id, pd.FullVersion.PublishedAt)
+							// Keep publishedAtTime as zero time
 						}
 					}
 
@@ -218,6 +511,11 @@ func downloadWorker(id int, jobs <-chan downloadJob, db *database.DB, fileDownlo
 		// --- Metadata Saving ---
 		logPrefix := fmt.Sprintf("Worker %d", id)
 		handleMetadataSaving(logPrefix, pd, finalPath, finalStatus, writer)
+		if viper.GetBool("savetriggers") && finalStatus == models.StatusDownloaded {
+			if triggerErr := saveTriggerWordsFile(pd, finalPath); triggerErr != nil {
+				fmt.Fprintf(writer.Newline(), "[%s] Error saving trigger words for %s: %v\n", logPrefix, filepath.Base(finalPath), triggerErr)
+			}
+		}
 
 		// --- Download Version Images if Enabled and Successful ---
 		saveVersionImages := viper.GetBool("saveversionimages")
@@ -229,8 +527,7 @@ func downloadWorker(id int, jobs <-chan downloadJob, db *database.DB, fileDownlo
 
 			// Add log before calling downloadImages
 			log.Debugf("[%s] Calling downloadImages for %d images...", logPrefix, len(pd.OriginalImages))
-			// Call the helper function, passing concurrencyLevel, removing writer
-			imgSuccess, imgFail := downloadImages(logPrefix, pd.OriginalImages, versionImagesDir, imageDownloader, concurrencyLevel)
+			imgSuccess, imgFail := downloadImages(downloadCtx, logPrefix, pd.OriginalImages, versionImagesDir, imageDownloader, imageConcurrencyLevel(), nil)
 			log.Infof("[%s] Finished downloading version images for %s (%s). Success: %d, Failed: %d",
 				logPrefix, pd.ModelName, pd.VersionName, imgSuccess, imgFail)
 		}
@@ -240,7 +537,8 @@ func downloadWorker(id int, jobs <-chan downloadJob, db *database.DB, fileDownlo
 	fmt.Fprintf(writer.Newline(), "Worker %d: Finished job processing.\n", id) // Final update for the worker
 }
 
-// saveMetadataFile saves the cleaned model version metadata to a .json file.
+// saveMetadataFile saves the model version metadata (or combined model+version
+// metadata) to a .json file.
 // It derives the metadata filename from the provided modelFilePath.
 func saveMetadataFile(pd potentialDownload, modelFilePath string) error {
 	// Calculate metadata path based on the model file path
@@ -252,8 +550,8 @@ func saveMetadataFile(pd potentialDownload, modelFilePath string) error {
 		return fmt.Errorf("failed to create directory %s: %w", dirPath, err)
 	}
 
-	// Marshal the full version info
-	jsonData, jsonErr := json.MarshalIndent(pd.FullVersion, "", "  ")
+	// Marshal the full version info (combined with the model info if enabled)
+	jsonData, jsonErr := json.MarshalIndent(metadataSidecarContent(pd), "", "  ")
 	if jsonErr != nil {
 		log.WithError(jsonErr).Warnf("Failed to marshal metadata for %s", modelFilePath)
 		return fmt.Errorf("failed to marshal metadata for %s: %w", pd.ModelName, jsonErr)
@@ -268,3 +566,27 @@ func saveMetadataFile(pd potentialDownload, modelFilePath string) error {
 	log.Debugf("Saved metadata to %s", metadataPath)
 	return nil
 }
+
+// saveTriggerWordsFile writes the version's trained words (the activation keywords of
+// LORAs and embeddings) comma-separated to <model file>.trigger.txt. Nothing is
+// written for versions without trained words.
+func saveTriggerWordsFile(pd potentialDownload, modelFilePath string) error {
+	var words []string
+	for _, word := range pd.FullVersion.TrainedWords {
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 {
+		log.Debugf("No trained words for %s, not writing a trigger file", modelFilePath)
+		return nil
+	}
+
+	triggerPath := strings.TrimSuffix(modelFilePath, filepath.Ext(modelFilePath)) + ".trigger.txt"
+	if err := os.WriteFile(triggerPath, []byte(strings.Join(words, ", ")+"\n"), 0600); err != nil {
+		log.WithError(err).Warnf("Failed to write trigger file %s", triggerPath)
+		return fmt.Errorf("failed to write trigger file %s: %w", triggerPath, err)
+	}
+	log.Debugf("Saved trigger words to %s", triggerPath)
+	return nil
+}
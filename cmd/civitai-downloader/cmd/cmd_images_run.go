@@ -2,10 +2,10 @@ package cmd
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -16,16 +16,29 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/blevesearch/bleve/v2"
 	"github.com/gosuri/uilive"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
 	index "go-civitai-download/index"
+	"go-civitai-download/internal/api"
+	"go-civitai-download/internal/database"
 	"go-civitai-download/internal/downloader"
 	"go-civitai-download/internal/models"
 )
 
+// imagesSummary is the final summary of the images command, printed as JSON with --json.
+type imagesSummary struct {
+	TargetDir     string `json:"targetDir"`
+	ImagesFound   int    `json:"imagesFound"`
+	Queued        int    `json:"queued"`
+	Succeeded     int64  `json:"succeeded"`
+	Failed        int64  `json:"failed"`
+	MetadataSaved bool   `json:"metadataSaved"`
+}
+
 // runImages orchestrates the fetching and downloading of images based on command-line flags.
 func runImages(cmd *cobra.Command, args []string) {
 	// Read flags
@@ -41,12 +54,16 @@ func runImages(cmd *cobra.Command, args []string) {
 	numWorkers := viper.GetInt("images.concurrency")
 	maxPages := viper.GetInt("images.max_pages")
 	postID := viper.GetInt("images.postId")
+	resume := viper.GetBool("images.resume")
+	if !validImageGroupBy(viper.GetString("images.groupby")) {
+		log.Fatalf("Invalid --group-by %q: must be username, post or none", viper.GetString("images.groupby"))
+	}
 
 	// --- Early Exit for Debug Print API URL --- START ---
 	if printUrl, _ := cmd.Flags().GetBool("debug-print-api-url"); printUrl {
 		log.Info("--- Debug API URL (--debug-print-api-url) for Images ---")
 		// Construct URL parameters (logic duplicated/extracted from below)
-		baseURL := "https://civitai.com/api/v1/images"
+		baseURL := api.BaseURL() + "/images"
 		params := url.Values{}
 		if modelVersionID != 0 {
 			params.Set("modelVersionId", strconv.Itoa(modelVersionID))
@@ -96,29 +113,32 @@ func runImages(cmd *cobra.Command, args []string) {
 			"Concurrency":         viper.GetInt("images.concurrency"), // Show image-specific concurrency
 		}
 		globalJSON, _ := json.MarshalIndent(globalSettings, "  ", "  ")
-		fmt.Println("  --- Global Settings (Relevant to Images) ---")
-		fmt.Println("  " + strings.ReplaceAll(string(globalJSON), "\n", "\n  "))
+		fmt.Fprintln(humanOutput(), "  --- Global Settings (Relevant to Images) ---")
+		fmt.Fprintln(humanOutput(), "  "+strings.ReplaceAll(string(globalJSON), "\n", "\n  "))
 
 		// 2. Image API Parameters
 		imageAPIParams := map[string]interface{}{
-			"ModelID":        viper.GetInt("images.modelId"),
-			"ModelVersionID": viper.GetInt("images.modelVersionId"),
-			"PostID":         viper.GetInt("images.postId"),
-			"Username":       viper.GetString("images.username"),
-			"Limit":          viper.GetInt("images.limit"),
-			"Period":         viper.GetString("images.period"),
-			"Sort":           viper.GetString("images.sort"),
-			"NSFW":           viper.GetString("images.nsfw"),
-			"MaxPages":       viper.GetInt("images.max_pages"),
-			"SaveMetadata":   viper.GetBool("images.metadata"),
+			"ModelID":         viper.GetInt("images.modelId"),
+			"ModelVersionID":  viper.GetInt("images.modelVersionId"),
+			"PostID":          viper.GetInt("images.postId"),
+			"Username":        viper.GetString("images.username"),
+			"Limit":           viper.GetInt("images.limit"),
+			"Period":          viper.GetString("images.period"),
+			"Sort":            viper.GetString("images.sort"),
+			"NSFW":            viper.GetString("images.nsfw"),
+			"MaxPages":        viper.GetInt("images.max_pages"),
+			"SaveMetadata":    viper.GetBool("images.metadata"),
+			"Resume":          viper.GetBool("images.resume"),
+			"BlurhashPreview": viper.GetBool("images.blurhashpreview"),
+			"GroupBy":         viper.GetString("images.groupby"),
 		}
 		apiParamsJSON, _ := json.MarshalIndent(imageAPIParams, "  ", "  ")
-		fmt.Println("\n  --- Image API Parameters ---")
-		fmt.Println("  " + strings.ReplaceAll(string(apiParamsJSON), "\n", "\n  "))
+		fmt.Fprintln(humanOutput(), "\n  --- Image API Parameters ---")
+		fmt.Fprintln(humanOutput(), "  "+strings.ReplaceAll(string(apiParamsJSON), "\n", "\n  "))
 
 		// Confirmation Prompt
 		reader := bufio.NewReader(os.Stdin)
-		fmt.Print("\nProceed with these settings? (y/N): ")
+		fmt.Fprint(humanOutput(), "\nProceed with these settings? (y/N): ")
 		input, _ := reader.ReadString('\n')
 		input = strings.ToLower(strings.TrimSpace(input))
 
@@ -166,8 +186,7 @@ func runImages(cmd *cobra.Command, args []string) {
 	// --- Fetch Image List ---
 	log.Info("Fetching image list from Civitai API...")
 
-	var allImages []models.ImageApiItem
-	baseURL := "https://civitai.com/api/v1/images"
+	baseURL := api.BaseURL() + "/images"
 	params := url.Values{}
 	userTotalLimit := viper.GetInt("images.limit") // User's intended total limit (0 = unlimited)
 
@@ -196,12 +215,53 @@ func runImages(cmd *cobra.Command, args []string) {
 		params.Set("nsfw", nsfw)
 	}
 
+	// --- Resume State --- START ---
+	// The cursor is keyed by the query params (before any cursor is added),
+	// so only a run with identical filters picks up a saved position. Half of the
+	// digest keeps the database key within bitcask's 64 byte key limit.
+	querySum := sha256.Sum256([]byte(params.Encode()))
+	queryHash := fmt.Sprintf("%x", querySum[:16])
+	stateDB := openImageStateDB()
+	if stateDB != nil {
+		defer stateDB.Close()
+	}
+
 	pageCount := 0
 	var nextCursor string
 	var loopErr error
+	fetchFinished := false
+
+	if resume {
+		if stateDB == nil {
+			log.Warn("--resume requested but the database is unavailable. Starting from the first page.")
+		} else if savedCursor, err := stateDB.GetImageCursor(queryHash); err != nil {
+			log.WithError(err).Warn("Failed to read saved image cursor. Starting from the first page.")
+		} else if savedCursor != "" {
+			log.Infof("Resuming image fetching from saved cursor: %s", savedCursor)
+			nextCursor = savedCursor
+		} else {
+			log.Info("No saved image cursor found for these filters. Starting from the first page.")
+		}
+	}
+	// --- Resume State --- END ---
 
 	log.Info("--- Starting Image Fetching ---")
 
+	// Each page is downloaded before the next one is requested, and the cursor of the
+	// next page is saved only once that is done, so a run that dies part way never
+	// leaves a saved cursor past images it did not download.
+	var (
+		bleveIndex         bleve.Index
+		dl                 *downloader.Downloader
+		writer             *uilive.Writer
+		finalBaseTargetDir = targetDir
+		imagesFound        int
+		queuedCount        int
+		successCount       int64
+		failureCount       int64
+	)
+	ctx := commandContext(cmd)
+
 	for {
 		pageCount++
 		if maxPages > 0 && pageCount > maxPages {
@@ -224,133 +284,150 @@ func runImages(cmd *cobra.Command, args []string) {
 		}
 		// --- End check for debug flag --- NEW
 
-		req, err := http.NewRequest("GET", requestURL, nil)
+		response, err := fetchImagesPage(apiClient, requestURL, pageCount)
 		if err != nil {
-			loopErr = fmt.Errorf("failed to create request for page %d: %w", pageCount, err)
+			loopErr = err
 			break
 		}
-		if globalConfig.ApiKey != "" {
-			req.Header.Add("Authorization", "Bearer "+globalConfig.ApiKey)
-		}
 
-		resp, err := apiClient.Do(req)
-		if err != nil {
-			if urlErr, ok := err.(*url.Error); ok && urlErr.Timeout() {
-				log.WithError(err).Warnf("Timeout fetching image metadata page %d. Retrying after delay...", pageCount)
-				time.Sleep(5 * time.Second)
-				continue
-			}
-			loopErr = fmt.Errorf("failed to fetch image metadata page %d: %w", pageCount, err)
+		if len(response.Items) == 0 {
+			log.Info("Received empty items list from API. Assuming end of results.")
+			fetchFinished = true
 			break
 		}
 
-		bodyBytes, readErr := io.ReadAll(resp.Body)
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			log.WithError(closeErr).Warn("Error closing image API response body")
-		}
+		pageImages := response.Items
+		imagesFound += len(pageImages)
+		log.Infof("Received %d images from API page %d. Total collected: %d", len(pageImages), pageCount, imagesFound)
 
-		if readErr != nil {
-			loopErr = fmt.Errorf("failed to read response body (Page %d): %w", pageCount, readErr)
-			break
+		// --- Check Total Limit --- START ---
+		// nextCursor still points at this page here, so a resumed run re-fetches
+		// it and picks up the images that were cut off by the truncation.
+		limitReached := false
+		if userTotalLimit > 0 && imagesFound >= userTotalLimit {
+			log.Infof("Reached total image limit (%d). Stopping image fetching.", userTotalLimit)
+			pageImages = pageImages[:len(pageImages)-(imagesFound-userTotalLimit)] // Truncate to exact limit
+			imagesFound = userTotalLimit
+			limitReached = true
 		}
+		// --- Check Total Limit --- END ---
 
-		if resp.StatusCode != http.StatusOK {
-			errMsg := fmt.Sprintf("Image API request failed (Page %d inferred) with status %s", pageCount, resp.Status)
-			if len(bodyBytes) > 0 {
-				maxLen := 200
-				bodyStr := string(bodyBytes)
-				if len(bodyStr) > maxLen {
-					bodyStr = bodyStr[:maxLen] + "..."
-				}
-				errMsg += fmt.Sprintf(". Response: %s", bodyStr)
-			}
-			log.Error(errMsg)
-			if resp.StatusCode == http.StatusTooManyRequests {
-				log.Warn("Rate limited. Applying longer delay...")
-				delay := time.Duration(globalConfig.ApiDelayMs)*time.Millisecond*5 + 5*time.Second
-				time.Sleep(delay)
-				continue
+		if dl == nil {
+			bleveIndex, dl, writer = setupImageDownloads(finalBaseTargetDir)
+			if bleveIndex != nil {
+				defer func() {
+					log.Info("Closing Bleve index.")
+					if err := bleveIndex.Close(); err != nil {
+						log.Errorf("Error closing Bleve index: %v", err)
+					}
+				}()
 			}
-			loopErr = errors.New(errMsg)
-			break
-		}
-
-		var response models.ImageApiResponse
-		if err := json.Unmarshal(bodyBytes, &response); err != nil {
-			loopErr = fmt.Errorf("failed to decode image API response (Page %d): %w", pageCount, err)
-			log.WithError(err).Errorf("Response body sample: %s", string(bodyBytes[:min(len(bodyBytes), 200)]))
-			break
 		}
+		queuedCount += downloadImagesPage(ctx, pageImages, numWorkers, dl, writer, &successCount, &failureCount, saveMeta, finalBaseTargetDir, bleveIndex)
 
-		if len(response.Items) == 0 {
-			log.Info("Received empty items list from API. Assuming end of results.")
+		if ctx.Err() != nil {
+			log.Warn("Image downloads interrupted. The cursor is kept at the current page.")
 			break
 		}
-
-		log.Infof("Received %d images from API page %d. Total collected: %d", len(response.Items), pageCount, len(allImages))
-		allImages = append(allImages, response.Items...)
-
-		// --- Check Total Limit --- START ---
-		if userTotalLimit > 0 && len(allImages) >= userTotalLimit {
-			log.Infof("Reached total image limit (%d). Stopping image fetching.", userTotalLimit)
-			allImages = allImages[:userTotalLimit] // Truncate to exact limit
-			break                                  // Stop fetching more pages
+		if limitReached {
+			break // Stop fetching more pages
 		}
-		// --- Check Total Limit --- END ---
 
 		nextCursor = response.Metadata.NextCursor
 		if nextCursor == "" {
 			log.Info("No next cursor found. Finished fetching.")
+			fetchFinished = true
 			break
 		}
 
 		log.Debugf("Next cursor found: %s", nextCursor)
+		saveImageCursorState(stateDB, queryHash, nextCursor, false)
 
 		if globalConfig.ApiDelayMs > 0 {
 			log.Debugf("Applying API delay: %d ms", globalConfig.ApiDelayMs)
 			time.Sleep(time.Duration(globalConfig.ApiDelayMs) * time.Millisecond)
 		}
 	}
+	if writer != nil {
+		writer.Stop()
+	}
 
 	if loopErr != nil {
 		log.WithError(loopErr).Error("Image fetching stopped due to error.")
-		if len(allImages) == 0 {
+		if imagesFound == 0 {
 			log.Fatal("Exiting as no images were fetched before the error.")
 		}
-		log.Warnf("Proceeding with %d images fetched before the error.", len(allImages))
+		log.Warnf("Stopped after %d images fetched before the error.", imagesFound)
 	} else {
 		log.Info("--- Finished Image Fetching ---")
 	}
 
-	if len(allImages) == 0 {
+	saveImageCursorState(stateDB, queryHash, nextCursor, fetchFinished)
+	if stateDB != nil && !fetchFinished && nextCursor != "" {
+		log.Info("Saved image cursor. Re-run with --resume and the same filters to continue from here.")
+	}
+
+	if imagesFound == 0 {
 		log.Info("No images found matching the criteria after fetching.")
 		return
 	}
-	log.Infof("Found %d total images to potentially download.", len(allImages))
 
+	// --- Final Report ---
+	finalSuccessCount := atomic.LoadInt64(&successCount)
+	finalFailureCount := atomic.LoadInt64(&failureCount)
+
+	log.Infof("Image download process completed.")
+	log.Infof("Successfully downloaded: %d images", finalSuccessCount)
+	log.Infof("Failed to download: %d images", finalFailureCount)
+
+	if finalFailureCount > 0 {
+		log.Warn("Some image downloads failed. Check logs for details.")
+	}
+
+	summary := imagesSummary{
+		TargetDir:     finalBaseTargetDir,
+		ImagesFound:   imagesFound,
+		Queued:        queuedCount,
+		Succeeded:     finalSuccessCount,
+		Failed:        finalFailureCount,
+		MetadataSaved: saveMeta,
+	}
+	printSummary(summary, func() {
+		fmt.Println("----- Download Summary -----")
+		fmt.Printf(" Target Base Directory: %s\n", summary.TargetDir)
+		fmt.Printf(" Total Images Found API: %d\n", summary.ImagesFound)
+		fmt.Printf(" Images Queued: %d\n", summary.Queued)
+		fmt.Printf(" Successfully Downloaded: %d\n", summary.Succeeded)
+		fmt.Printf(" Failed Downloads: %d\n", summary.Failed)
+		fmt.Printf(" Metadata Saved: %t\n", summary.MetadataSaved)
+		fmt.Println("--------------------------")
+	})
+}
+
+// setupImageDownloads opens the Bleve index, creates the downloader and target
+// directory and starts the progress writer. It runs once the first page of images
+// has been fetched. The returned index is nil when indexing is disabled or unavailable.
+func setupImageDownloads(targetDir string) (bleve.Index, *downloader.Downloader, *uilive.Writer) {
 	// --- Initialize Bleve Index --- START ---
 	// Use targetDir as base for index path, ensuring it's consistent
 	indexPath := globalConfig.BleveIndexPath
 	if indexPath == "" {
 		indexPath = filepath.Join(targetDir, "civitai_images.bleve") // Default if config is empty
 		log.Warnf("BleveIndexPath not set in config, defaulting index path for image downloads to: %s", indexPath)
-	} else {
-		// If a shared index path is provided, images might go into the same index
-		// Or we could append a sub-directory like "images"? For now, use the path directly.
-		// Example: If BleveIndexPath = /path/to/index, index will be at /path/to/index
 	}
 	log.Infof("Opening/Creating Bleve index at: %s", indexPath)
-	bleveIndex, err := index.OpenOrCreateIndex(indexPath)
-	if err != nil {
-		log.Fatalf("Failed to open or create Bleve index: %v", err)
+	// The index only backs search; downloads continue without it (workers skip
+	// indexing when it is nil) if it is disabled, locked by another process or corrupt.
+	var bleveIndex bleve.Index
+	var err error
+	if viper.GetBool("noindex") {
+		log.Info("--no-index set: not opening the Bleve index.")
+	} else if bleveIndex, err = index.OpenOrCreateIndex(indexPath); err != nil {
+		log.WithError(err).Warnf("Failed to open or create Bleve index at %s, continuing without search indexing.", indexPath)
+		bleveIndex = nil
+	} else {
+		log.Info("Bleve index opened successfully.")
 	}
-	defer func() {
-		log.Info("Closing Bleve index.")
-		if err := bleveIndex.Close(); err != nil {
-			log.Errorf("Error closing Bleve index: %v", err)
-		}
-	}()
-	log.Info("Bleve index opened successfully.")
 	// --- Initialize Bleve Index --- END ---
 
 	// --- Downloader Setup ---
@@ -361,31 +438,32 @@ func runImages(cmd *cobra.Command, args []string) {
 	dl := downloader.NewDownloader(downloadClient, globalConfig.ApiKey)
 
 	// --- Target Directory ---
-	finalBaseTargetDir := targetDir
-	log.Infof("Ensuring base target directory exists: %s", finalBaseTargetDir)
-	if err := os.MkdirAll(finalBaseTargetDir, 0750); err != nil {
-		log.WithError(err).Fatalf("Failed to create base target directory: %s", finalBaseTargetDir)
+	log.Infof("Ensuring base target directory exists: %s", targetDir)
+	if err := os.MkdirAll(targetDir, 0750); err != nil {
+		log.WithError(err).Fatalf("Failed to create base target directory: %s", targetDir)
 	}
 
-	// --- Download Workers ---
-	var wg sync.WaitGroup
-	jobs := make(chan imageJob, len(allImages))
 	writer := uilive.New()
+	writer.Out = humanOutput()
 	writer.Start()
+	return bleveIndex, dl, writer
+}
 
-	var successCount int64
-	var failureCount int64
+// downloadImagesPage downloads one page of images with numWorkers workers and
+// returns once all of them have finished. Returns the number of queued images.
+func downloadImagesPage(ctx context.Context, images []models.ImageApiItem, numWorkers int, dl *downloader.Downloader, writer *uilive.Writer, successCount *int64, failureCount *int64, saveMeta bool, targetDir string, bleveIndex bleve.Index) int {
+	var wg sync.WaitGroup
+	jobs := make(chan imageJob, len(images))
 
-	log.Infof("Starting %d image download workers...", numWorkers)
+	log.Debugf("Starting %d image download workers...", numWorkers)
 	for w := 1; w <= numWorkers; w++ {
 		wg.Add(1)
-		go imageDownloadWorker(w, jobs, dl, &wg, writer, &successCount, &failureCount, saveMeta, finalBaseTargetDir, bleveIndex)
+		go imageDownloadWorker(ctx, w, jobs, dl, &wg, writer, successCount, failureCount, saveMeta, targetDir, bleveIndex)
 	}
 
 	// --- Queue Jobs ---
-	log.Info("Queueing image download jobs...")
 	queuedCount := 0
-	for _, image := range allImages {
+	for _, image := range images {
 		if image.URL == "" {
 			log.Warnf("Image ID %d has no URL, skipping.", image.ID)
 			continue
@@ -403,28 +481,85 @@ func runImages(cmd *cobra.Command, args []string) {
 	log.Infof("Queued %d image jobs.", queuedCount)
 
 	// --- Wait for Completion ---
-	log.Info("Waiting for image download workers to finish...")
 	wg.Wait()
-	writer.Stop()
+	return queuedCount
+}
 
-	// --- Final Report ---
-	finalSuccessCount := atomic.LoadInt64(&successCount)
-	finalFailureCount := atomic.LoadInt64(&failureCount)
+// imageRetryOptions returns the retry settings for images API requests. Unlike the
+// download command, the images command has always retried timeouts and 429s, so it
+// falls back to 3 retries starting at 1s when MaxRetries/InitialRetryDelayMs are
+// not configured.
+func imageRetryOptions(logPrefix string) api.RetryOptions {
+	opts := apiRetryOptions(logPrefix)
+	if !viper.IsSet("maxretries") {
+		opts.MaxRetries = 3
+	}
+	if !viper.IsSet("initialretrydelayms") {
+		opts.InitialDelay = time.Second
+	}
+	return opts
+}
 
-	log.Infof("Image download process completed.")
-	log.Infof("Successfully downloaded: %d images", finalSuccessCount)
-	log.Infof("Failed to download: %d images", finalFailureCount)
+// fetchImagesPage requests one page of the images API. Network errors, 408, 429 and
+// 5xx responses are retried with exponential backoff as configured by MaxRetries and
+// InitialRetryDelayMs (see imageRetryOptions for the defaults).
+func fetchImagesPage(client *http.Client, requestURL string, pageCount int) (models.ImageApiResponse, error) {
+	var response models.ImageApiResponse
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return response, fmt.Errorf("failed to create request for page %d: %w", pageCount, err)
+	}
+	if globalConfig.ApiKey != "" {
+		req.Header.Add("Authorization", "Bearer "+globalConfig.ApiKey)
+	}
 
-	if finalFailureCount > 0 {
-		log.Warn("Some image downloads failed. Check logs for details.")
+	_, bodyBytes, err := api.DoRequestWithRetry(client, req, imageRetryOptions(fmt.Sprintf("Images page %d", pageCount)))
+	if err != nil {
+		return response, fmt.Errorf("failed to fetch image metadata page %d: %w", pageCount, err)
+	}
+
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		log.WithError(err).Errorf("Response body sample: %s", string(bodyBytes[:min(len(bodyBytes), 200)]))
+		return response, fmt.Errorf("failed to decode image API response (Page %d): %w", pageCount, err)
+	}
+	return response, nil
+}
+
+// openImageStateDB opens the download database used to persist the images cursor.
+// Returns nil (with a warning) if the database cannot be opened; resume state is
+// then simply not tracked for this run.
+func openImageStateDB() *database.DB {
+	dbPath := globalConfig.DatabasePath
+	if dbPath == "" {
+		if globalConfig.SavePath == "" {
+			log.Warn("DatabasePath and SavePath are not set, image cursor will not be saved for --resume.")
+			return nil
+		}
+		dbPath = filepath.Join(globalConfig.SavePath, "civitai_download_db")
+	}
+	db, err := database.Open(dbPath)
+	if err != nil {
+		log.WithError(err).Warnf("Failed to open database at %s, image cursor will not be saved for --resume.", dbPath)
+		return nil
 	}
+	return db
+}
 
-	fmt.Println("----- Download Summary -----")
-	fmt.Printf(" Target Base Directory: %s\n", finalBaseTargetDir)
-	fmt.Printf(" Total Images Found API: %d\n", len(allImages))
-	fmt.Printf(" Images Queued: %d\n", queuedCount)
-	fmt.Printf(" Successfully Downloaded: %d\n", finalSuccessCount)
-	fmt.Printf(" Failed Downloads: %d\n", finalFailureCount)
-	fmt.Printf(" Metadata Saved: %t\n", saveMeta)
-	fmt.Println("--------------------------")
+// saveImageCursorState records where a subsequent --resume run should continue from.
+// A finished crawl clears the saved cursor so the next run starts fresh.
+func saveImageCursorState(db *database.DB, queryHash string, cursor string, finished bool) {
+	if db == nil {
+		return
+	}
+	if finished || cursor == "" {
+		if err := db.DeleteImageCursor(queryHash); err != nil {
+			log.WithError(err).Warn("Failed to clear saved image cursor.")
+		}
+		return
+	}
+	if err := db.SetImageCursor(queryHash, cursor); err != nil {
+		log.WithError(err).Warn("Failed to save image cursor for --resume.")
+		return
+	}
+	log.Debugf("Saved image cursor %s.", cursor)
 }
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"go-civitai-download/internal/downloader"
+	"go-civitai-download/internal/helpers"
+	"go-civitai-download/internal/models"
+
+	"github.com/gosuri/uilive"
+	"github.com/spf13/viper"
+)
+
+// TestFetchImagesPageRetries serves two 503s before a page of images and checks the
+// images command retries them instead of giving up on the first failure.
+func TestFetchImagesPageRetries(t *testing.T) {
+	oldRetries, oldDelay := viper.Get("maxretries"), viper.Get("initialretrydelayms")
+	viper.Set("maxretries", 3)
+	viper.Set("initialretrydelayms", 1)
+	defer viper.Set("maxretries", oldRetries)
+	defer viper.Set("initialretrydelayms", oldDelay)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"items":[{"id":1,"url":"https://example.com/1.jpeg"}],"metadata":{"nextCursor":"abc"}}`))
+	}))
+	defer server.Close()
+
+	response, err := fetchImagesPage(server.Client(), server.URL+"/api/v1/images?limit=100", 1)
+	if err != nil {
+		t.Fatalf("fetchImagesPage() error = %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 3 {
+		t.Errorf("server saw %d requests, want 3", got)
+	}
+	if len(response.Items) != 1 || response.Items[0].ID != 1 || response.Metadata.NextCursor != "abc" {
+		t.Errorf("fetchImagesPage() = %+v, want the page served after the retries", response)
+	}
+
+	viper.Set("maxretries", 1)
+	atomic.StoreInt32(&requests, 0)
+	if _, err := fetchImagesPage(server.Client(), server.URL+"/api/v1/images?limit=100", 1); err == nil {
+		t.Error("fetchImagesPage() succeeded although every allowed attempt got a 503")
+	}
+}
+
+// TestFetchImagesPageDefaultRetries checks the images command still retries a 429
+// when MaxRetries is not configured.
+func TestFetchImagesPageDefaultRetries(t *testing.T) {
+	oldRetries, oldDelay := viper.Get("maxretries"), viper.Get("initialretrydelayms")
+	viper.Set("maxretries", nil)
+	viper.Set("initialretrydelayms", 1)
+	defer viper.Set("maxretries", oldRetries)
+	defer viper.Set("initialretrydelayms", oldDelay)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"items":[{"id":1,"url":"https://example.com/1.jpeg"}],"metadata":{}}`))
+	}))
+	defer server.Close()
+
+	response, err := fetchImagesPage(server.Client(), server.URL+"/api/v1/images?limit=100", 1)
+	if err != nil {
+		t.Fatalf("fetchImagesPage() error = %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 || len(response.Items) != 1 {
+		t.Errorf("server saw %d requests and returned %d items, want 2 requests and the page served after the 429", got, len(response.Items))
+	}
+}
+
+// TestImageDownloadWorkerGroupBy downloads images with and without a post ID and
+// checks each --group-by mode puts them in the expected subdirectories.
+func TestImageDownloadWorkerGroupBy(t *testing.T) {
+	defer viper.Set("images.groupby", "username")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("img"))
+	}))
+	defer server.Close()
+
+	postID := 42
+	images := []models.ImageApiItem{
+		{ID: 1, URL: server.URL + "/a.jpeg", PostID: &postID, Username: "alice", BaseModel: "SDXL 1.0"},
+		{ID: 2, URL: server.URL + "/b.jpeg", Username: "bob"},
+	}
+	tests := []struct {
+		groupBy string
+		want    []string // Per image, relative to the output directory
+	}{
+		{"post", []string{"42/1-a.jpeg", "no-post/2-b.jpeg"}},
+		{"none", []string{"1-a.jpeg", "2-b.jpeg"}},
+		{"username", []string{
+			filepath.Join(helpers.ConvertToSlug("alice"), helpers.ConvertToSlug("SDXL 1.0"), "1-a.jpeg"),
+			filepath.Join(helpers.ConvertToSlug("bob"), "unknown_base_model", "2-b.jpeg"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			viper.Set("images.groupby", tt.groupBy)
+			outputDir := t.TempDir()
+			jobs := make(chan imageJob, len(images))
+			for _, image := range images {
+				jobs <- imageJob{SourceURL: image.URL, ImageID: image.ID, Metadata: image}
+			}
+			close(jobs)
+
+			var wg sync.WaitGroup
+			var succeeded, failed int64
+			wg.Add(1)
+			imageDownloadWorker(context.Background(), 1, jobs, downloader.NewDownloader(server.Client(), ""), &wg, uilive.New(), &succeeded, &failed, false, outputDir, nil)
+			if failed != 0 {
+				t.Fatalf("%d image downloads failed", failed)
+			}
+			for _, rel := range tt.want {
+				if _, err := os.Stat(filepath.Join(outputDir, filepath.FromSlash(rel))); err != nil {
+					t.Errorf("expected image at %s: %v", rel, err)
+				}
+			}
+		})
+	}
+}
+
+// TestImageDownloadWorkerMetadataSidecar checks --metadata writes a JSON sidecar next
+// to the image holding the generation parameters, stats and NSFW level.
+func TestImageDownloadWorkerMetadataSidecar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("img"))
+	}))
+	defer server.Close()
+
+	image := models.ImageApiItem{
+		ID:        7,
+		URL:       server.URL + "/photo.png",
+		NsfwLevel: "None",
+		Stats:     models.ImageStats{LikeCount: 3},
+		Meta:      map[string]interface{}{"prompt": "a red fox", "seed": 1234},
+	}
+	jobs := make(chan imageJob, 1)
+	jobs <- imageJob{SourceURL: image.URL, ImageID: image.ID, Metadata: image}
+	close(jobs)
+
+	outputDir := t.TempDir()
+	var wg sync.WaitGroup
+	var succeeded, failed int64
+	wg.Add(1)
+	viper.Set("images.groupby", "none")
+	defer viper.Set("images.groupby", "username")
+	imageDownloadWorker(context.Background(), 1, jobs, downloader.NewDownloader(server.Client(), ""), &wg, uilive.New(), &succeeded, &failed, true, outputDir, nil)
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "7-photo.json"))
+	if err != nil {
+		t.Fatalf("metadata sidecar missing: %v", err)
+	}
+	var sidecar struct {
+		NsfwLevel string                 `json:"nsfwLevel"`
+		Stats     models.ImageStats      `json:"stats"`
+		Meta      map[string]interface{} `json:"meta"`
+	}
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		t.Fatalf("parsing sidecar: %v", err)
+	}
+	if sidecar.Meta["prompt"] != "a red fox" || sidecar.NsfwLevel != "None" || sidecar.Stats.LikeCount != 3 {
+		t.Errorf("sidecar = %+v, want the prompt, NSFW level and stats of the image", sidecar)
+	}
+}
@@ -26,6 +26,9 @@ func init() {
 	imagesCmd.Flags().IntVarP(&imageConcurrency, "concurrency", "c", 4, "Number of concurrent image downloads")
 	// Add the save-metadata flag
 	imagesCmd.Flags().Bool("metadata", false, "Save a .json metadata file alongside each downloaded image.")
+	imagesCmd.Flags().Bool("blurhash-preview", false, "Decode each image's blurhash into a 32x32 placeholder saved as <id>.blur.png next to the image.")
+	imagesCmd.Flags().String("group-by", "username", "Subdirectories images are sorted into: username ({author}/{baseModel}/), post ({postId}/) or none")
+	imagesCmd.Flags().Bool("resume", false, "Resume fetching from the cursor saved by a previous interrupted run with the same filters.")
 
 	// Hidden flag for testing API URL generation
 	imagesCmd.Flags().Bool("debug-print-api-url", false, "Print the constructed API URL for image fetching and exit")
@@ -46,4 +49,7 @@ func init() {
 	viper.BindPFlag("images.concurrency", imagesCmd.Flags().Lookup("concurrency"))
 	// Bind the new flag
 	viper.BindPFlag("images.metadata", imagesCmd.Flags().Lookup("metadata"))
+	viper.BindPFlag("images.resume", imagesCmd.Flags().Lookup("resume"))
+	viper.BindPFlag("images.blurhashpreview", imagesCmd.Flags().Lookup("blurhash-preview"))
+	viper.BindPFlag("images.groupby", imagesCmd.Flags().Lookup("group-by"))
 }
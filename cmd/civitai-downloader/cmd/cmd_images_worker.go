@@ -1,11 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"image/png"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -19,6 +22,7 @@ import (
 	"github.com/blevesearch/bleve/v2"
 	"github.com/gosuri/uilive"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 // Represents an image download task
@@ -32,7 +36,9 @@ type imageJob struct {
 func saveMetadataJSON(id int, job imageJob, targetPath string, writer *uilive.Writer) {
 	baseFilename := filepath.Base(targetPath)
 	metadataPath := strings.TrimSuffix(targetPath, filepath.Ext(targetPath)) + ".json"
-	jsonData, jsonErr := json.MarshalIndent(job.Metadata, "", "  ")
+	metadata := job.Metadata
+	metadata.Meta = models.ImageMeta(metadata.Meta) // Always save meta as an object
+	jsonData, jsonErr := json.MarshalIndent(metadata, "", "  ")
 	if jsonErr != nil {
 		log.WithError(jsonErr).Warnf("Worker %d: Failed to marshal image metadata for %s", id, baseFilename)
 		fmt.Fprintf(writer.Newline(), "Worker %d: Error marshalling metadata for %s\n", id, baseFilename)
@@ -49,25 +55,89 @@ func saveMetadataJSON(id int, job imageJob, targetPath string, writer *uilive.Wr
 
 // --- Helper to save metadata --- END ---
 
+// blurhashPreviewSize is the width and height of the placeholder written for --blurhash-preview.
+const blurhashPreviewSize = 32
+
+// saveBlurhashPreview decodes the image's blurhash into a small placeholder PNG saved
+// as <name>.blur.png next to the image. Existing previews are left alone.
+func saveBlurhashPreview(id int, job imageJob, targetPath string) {
+	if job.Metadata.Hash == "" {
+		return
+	}
+	previewPath := strings.TrimSuffix(targetPath, filepath.Ext(targetPath)) + ".blur.png"
+	if _, err := os.Stat(previewPath); err == nil {
+		return
+	}
+	img, err := helpers.DecodeBlurhash(job.Metadata.Hash, blurhashPreviewSize, blurhashPreviewSize)
+	if err != nil {
+		log.WithError(err).Warnf("Worker %d: Could not decode blurhash for image %d", id, job.ImageID)
+		return
+	}
+	f, err := os.OpenFile(previewPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.WithError(err).Warnf("Worker %d: Failed to create blurhash preview %s", id, previewPath)
+		return
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		log.WithError(err).Warnf("Worker %d: Failed to write blurhash preview %s", id, previewPath)
+		return
+	}
+	if err := f.Close(); err != nil {
+		log.WithError(err).Warnf("Worker %d: Failed to write blurhash preview %s", id, previewPath)
+		return
+	}
+	log.Debugf("Worker %d: Saved blurhash preview to %s", id, previewPath)
+}
+
+// Values of --group-by for the images command.
+const (
+	imageGroupByUsername = "username"
+	imageGroupByPost     = "post"
+	imageGroupByNone     = "none"
+)
+
+func validImageGroupBy(groupBy string) bool {
+	switch strings.ToLower(groupBy) {
+	case "", imageGroupByUsername, imageGroupByPost, imageGroupByNone:
+		return true
+	}
+	return false
+}
+
+// imageTargetSubDir returns the directory below baseOutputDir that an image is saved
+// in for --group-by: {author}/{baseModel} for username (the default), {postId} for
+// post (no-post if the image has none), and baseOutputDir itself for none.
+func imageTargetSubDir(baseOutputDir string, image models.ImageApiItem, groupBy string) string {
+	switch strings.ToLower(groupBy) {
+	case imageGroupByNone:
+		return baseOutputDir
+	case imageGroupByPost:
+		if image.PostID == nil {
+			return filepath.Join(baseOutputDir, "no-post")
+		}
+		return filepath.Join(baseOutputDir, strconv.Itoa(*image.PostID))
+	}
+	authorSlug := helpers.ConvertToSlug(image.Username)
+	if authorSlug == "" {
+		authorSlug = "unknown_author" // Fallback
+	}
+	baseModelSlug := helpers.ConvertToSlug(image.BaseModel)
+	if baseModelSlug == "" {
+		baseModelSlug = "unknown_base_model"
+	}
+	return filepath.Join(baseOutputDir, authorSlug, baseModelSlug)
+}
+
 // imageDownloadWorker handles the download of a single image.
 // Added baseOutputDir and bleveIndex parameters.
-func imageDownloadWorker(id int, jobs <-chan imageJob, downloader *downloader.Downloader, wg *sync.WaitGroup, writer *uilive.Writer, successCounter *int64, failureCounter *int64, saveMeta bool, baseOutputDir string, bleveIndex bleve.Index) {
+func imageDownloadWorker(ctx context.Context, id int, jobs <-chan imageJob, downloader *downloader.Downloader, wg *sync.WaitGroup, writer *uilive.Writer, successCounter *int64, failureCounter *int64, saveMeta bool, baseOutputDir string, bleveIndex bleve.Index) {
 	defer wg.Done()
 	log.Debugf("Image Worker %d starting", id)
 	for job := range jobs {
 
 		// --- Construct Target Path --- START ---
-		// Create subdirectory based on username
-		authorSlug := helpers.ConvertToSlug(job.Metadata.Username)
-		if authorSlug == "" {
-			authorSlug = "unknown_author" // Fallback
-		}
-		// Add BaseModel subdirectory
-		baseModelSlug := helpers.ConvertToSlug(job.Metadata.BaseModel)
-		if baseModelSlug == "" {
-			baseModelSlug = "unknown_base_model"
-		}
-		targetSubDir := filepath.Join(baseOutputDir, authorSlug, baseModelSlug) // Include baseModelSlug
+		targetSubDir := imageTargetSubDir(baseOutputDir, job.Metadata, viper.GetString("images.groupby"))
 
 		// Construct filename: {id}-{url_filename_base}.{ext}
 		var filename string
@@ -122,6 +192,9 @@ func imageDownloadWorker(id int, jobs <-chan imageJob, downloader *downloader.Do
 					log.WithError(metaErr).Warnf("Worker %d: Could not check status of metadata file %s", id, metadataPath)
 				}
 			}
+			if viper.GetBool("images.blurhashpreview") {
+				saveBlurhashPreview(id, job, targetPath)
+			}
 			// Skip the download
 			fmt.Fprintf(writer.Newline(), "Worker %d: Skipping %s (Exists)\n", id, baseFilename)
 			continue // Skip download steps
@@ -132,7 +205,7 @@ func imageDownloadWorker(id int, jobs <-chan imageJob, downloader *downloader.Do
 		startTime := time.Now()
 
 		// Use DownloadFile with the constructed targetPath
-		_, dlErr := downloader.DownloadFile(targetPath, job.SourceURL, models.Hashes{}, 0)
+		_, dlErr := downloader.DownloadFile(ctx, targetPath, job.SourceURL, models.Hashes{}, 0)
 
 		if dlErr != nil {
 			log.WithError(dlErr).Errorf("Worker %d: Failed to download image %s from %s", id, targetPath, job.SourceURL)
@@ -155,6 +228,10 @@ func imageDownloadWorker(id int, jobs <-chan imageJob, downloader *downloader.Do
 			}
 			// --- End Save Metadata ---
 
+			if viper.GetBool("images.blurhashpreview") {
+				saveBlurhashPreview(id, job, targetPath)
+			}
+
 			// --- Index Item with Bleve --- START ---
 			if bleveIndex != nil {
 				// Extract data from meta with type assertions
@@ -162,23 +239,22 @@ func imageDownloadWorker(id int, jobs <-chan imageJob, downloader *downloader.Do
 				var prompt string
 				var modelName string // Field not directly available, might be in meta?
 
-				if metaMap, ok := job.Metadata.Meta.(map[string]interface{}); ok && metaMap != nil {
-					if p, ok := metaMap["prompt"].(string); ok {
-						prompt = p
-					}
-					if t, ok := metaMap["tags"].([]interface{}); ok {
-						for _, tagInterface := range t {
-							if tagStr, ok := tagInterface.(string); ok {
-								tags = append(tags, tagStr)
-							}
+				metaMap := models.ImageMeta(job.Metadata.Meta)
+				if p, ok := metaMap["prompt"].(string); ok {
+					prompt = p
+				}
+				if t, ok := metaMap["tags"].([]interface{}); ok {
+					for _, tagInterface := range t {
+						if tagStr, ok := tagInterface.(string); ok {
+							tags = append(tags, tagStr)
 						}
 					}
-					// Check for model name in meta (unlikely standard field)
-					if mn, ok := metaMap["modelName"].(string); ok {
-						modelName = mn
-					} else if mn, ok := metaMap["model"].(string); ok { // Common alternative key
-						modelName = mn
-					}
+				}
+				// Check for model name in meta (unlikely standard field)
+				if mn, ok := metaMap["modelName"].(string); ok {
+					modelName = mn
+				} else if mn, ok := metaMap["model"].(string); ok { // Common alternative key
+					modelName = mn
 				}
 
 				itemToIndex := index.Item{
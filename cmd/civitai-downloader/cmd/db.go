@@ -2,21 +2,32 @@ package cmd
 
 import (
 	"bufio"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
+	_ "image/gif" // Register the decoders used by --include-images
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
 
+	index "go-civitai-download/index"
 	"go-civitai-download/internal/database"
 	"go-civitai-download/internal/downloader"
 	"go-civitai-download/internal/helpers"
 	"go-civitai-download/internal/models"
 
+	"github.com/blevesearch/bleve/v2"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -57,99 +68,576 @@ in the database entry identified by the provided Model Version ID (used as the d
 	Run:  runDbRedownload,
 }
 
-// dbSearchCmd represents the command to search database entries by model name
+// dbSearchCmd represents the command to search database entries by model name or other fields
 var dbSearchCmd = &cobra.Command{
-	Use:   "search [MODEL_NAME_QUERY]",
-	Short: "Search database entries by model name",
-	Long: `Searches database entries for models whose names contain the provided query text (case-insensitive).
+	Use:   "search [QUERY]",
+	Short: "Search database entries by model name, creator, base model or filename",
+	Long: `Searches database entries whose model name contains the provided query text (case-insensitive).
+Use --field to search the creator, base model or filename instead, or all of them.
 Prints matching entries.`,
 	Args: cobra.ExactArgs(1), // Requires exactly one argument
 	Run:  runDbSearch,
 }
 
+// dbStatsCmd summarizes the database by status, type, base model and error category
+var dbStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Summarize the collection by status, type, base model and error category",
+	Long: `Counts database entries by status, model type and base model, sums the file
+sizes, and breaks failed downloads down by error
+category, marking each category as transient (likely to succeed on a later run) or
+systematic (e.g. auth errors from expired download URLs, files removed upstream or
+hash mismatches), to help decide what needs attention.`,
+	Args: cobra.NoArgs,
+	Run:  runDbStats,
+}
+
+// dbRelocateCmd rewrites stored paths after the download directory has been moved
+var dbRelocateCmd = &cobra.Command{
+	Use:   "relocate --old <path> --new <path>",
+	Short: "Rewrite stored paths after moving the download directory",
+	Long: `Rewrites paths under the old download directory to the new one in both the
+database entries and the Bleve search index items, so that commands like 'db verify'
+and 'torrent' keep working after the collection has been moved. Every rewritten path
+is checked to exist at the new location before anything is changed.`,
+	Args: cobra.NoArgs,
+	Run:  runDbRelocate,
+}
+
+// dbPurgeCmd removes a single downloaded version from disk, the database and the index
+var dbPurgeCmd = &cobra.Command{
+	Use:   "purge [MODEL_VERSION_ID]",
+	Short: "Remove a version's files, database entry and index item",
+	Long: `Deletes everything recorded for one model version: its directory (model file,
+metadata and images), its database entry and its Bleve search index item. Everything
+that will be deleted is listed first and must be confirmed, or pass --yes. This is the
+inverse of downloading a single version.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runDbPurge,
+}
+
+// dbExportCmd writes all version entries to a CSV or JSON file for post-processing
+var dbExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export database entries as CSV or JSON",
+	Long: `Writes every version entry in the database to a file, or to stdout, for use in
+scripts and spreadsheets. JSON exports contain the complete entries (the same format as
+db view --json); CSV exports contain the columns shown by db view, with a header row.`,
+	Args: cobra.NoArgs,
+	Run:  runDbExport,
+}
+
+// dbImportCmd rebuilds database entries from a JSON export
+var dbImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import database entries from a JSON export",
+	Long: `Reads a JSON array of database entries, as written by db export --format json,
+and stores each one under its version ID. Use this to rebuild the database after moving
+the download folder to another machine without querying the API again. Entries whose
+version is already in the database are skipped unless --overwrite is set.`,
+	Args: cobra.NoArgs,
+	Run:  runDbImport,
+}
+
+// dbPruneCmd removes entries of downloaded files that no longer exist on disk
+var dbPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove database entries whose downloaded files are gone",
+	Long: `Checks every downloaded entry in the database and removes those whose model file
+no longer exists, so files you deleted on purpose are downloaded again by a later run
+instead of being treated as present. The entries to remove are listed first and must
+be confirmed, or pass --yes. This is the opposite of db verify, which re-downloads them.`,
+	Args: cobra.NoArgs,
+	Run:  runDbPrune,
+}
+
+// dbOrphansCmd finds model files on disk that the database does not know about
+var dbOrphansCmd = &cobra.Command{
+	Use:   "orphans",
+	Short: "Find model files in SavePath that have no database entry",
+	Long: `Walks SavePath for model files (.safetensors, .ckpt, .pt, .pth, .bin, .gguf) and
+lists those that no database entry refers to, matched by filename or, failing that, by
+SHA256 hash. These are usually left behind by aborted runs or entries removed by hand.
+With --delete the orphans are removed after confirmation. This is the inverse of db prune.`,
+	Args: cobra.NoArgs,
+	Run:  runDbOrphans,
+}
+
 func init() {
 	rootCmd.AddCommand(dbCmd)
 	dbCmd.AddCommand(dbViewCmd)
 	dbCmd.AddCommand(dbVerifyCmd)
 	dbCmd.AddCommand(dbRedownloadCmd) // Add the redownload command
 	dbCmd.AddCommand(dbSearchCmd)     // Add the search command
-
-	// Add flags specific to db view if needed (e.g., filtering)
-	// dbViewCmd.Flags().StringP("filter", "f", "", "Filter results (e.g., by model name)")
+	dbCmd.AddCommand(dbRelocateCmd)
+	dbCmd.AddCommand(dbStatsCmd)
+	dbCmd.AddCommand(dbPurgeCmd)
+	dbCmd.AddCommand(dbExportCmd)
+	dbCmd.AddCommand(dbImportCmd)
+	dbCmd.AddCommand(dbPruneCmd)
+	dbCmd.AddCommand(dbOrphansCmd)
+
+	// Add flags specific to db view
+	dbViewCmd.Flags().StringP("filter", "f", "", "Only show entries whose model name contains this text (case-insensitive)")
+	dbViewCmd.Flags().String("status", "", "Only show entries with this status (e.g. Downloaded, Pending, Error)")
+	dbViewCmd.Flags().String("type", "", "Only show entries of this model type (e.g. Checkpoint, LORA)")
+	dbViewCmd.Flags().String("error-category", "", "Only show entries whose last error has this category (e.g. auth, not_found, network)")
+	dbViewCmd.Flags().String("sort", "", "Sort entries by: name, size, date (default: database order)")
+	dbViewCmd.Flags().Int("limit", 0, "Maximum number of entries to show (0 for no limit)")
+	dbViewCmd.Flags().Int("offset", 0, "Number of matching entries to skip before showing results")
+	_ = viper.BindPFlag("db.view.filter", dbViewCmd.Flags().Lookup("filter"))
+	_ = viper.BindPFlag("db.view.status", dbViewCmd.Flags().Lookup("status"))
+	_ = viper.BindPFlag("db.view.type", dbViewCmd.Flags().Lookup("type"))
+	_ = viper.BindPFlag("db.view.errorcategory", dbViewCmd.Flags().Lookup("error-category"))
+	_ = viper.BindPFlag("db.view.sort", dbViewCmd.Flags().Lookup("sort"))
+	_ = viper.BindPFlag("db.view.limit", dbViewCmd.Flags().Lookup("limit"))
+	_ = viper.BindPFlag("db.view.offset", dbViewCmd.Flags().Lookup("offset"))
+
+	// Add flags specific to db search
+	dbSearchCmd.Flags().String("field", "name", "Field to search: name, creator, basemodel, filename or all")
+	_ = viper.BindPFlag("db.search.field", dbSearchCmd.Flags().Lookup("field"))
 
 	// Add flags specific to db verify
 	dbVerifyCmd.Flags().Bool("check-hash", true, "Perform hash check for existing files")
 	dbVerifyCmd.Flags().BoolP("yes", "y", false, "Automatically attempt to redownload missing/mismatched files without prompting")
+	dbVerifyCmd.Flags().Bool("repair-metadata", false, "Rewrite the .json metadata sidecar next to every existing file from the version stored in the database, overwriting existing ones (sidecars then hold that version only, without --combined-metadata model info)")
+	dbVerifyCmd.Flags().Bool("include-images", false, "Also check the version images next to each entry's file and report empty or undecodable ones")
 	// Bind flags to Viper
 	_ = viper.BindPFlag("db.verify.checkhash", dbVerifyCmd.Flags().Lookup("check-hash"))
 	_ = viper.BindPFlag("db.verify.yes", dbVerifyCmd.Flags().Lookup("yes"))
+	_ = viper.BindPFlag("db.verify.repairmetadata", dbVerifyCmd.Flags().Lookup("repair-metadata"))
+	_ = viper.BindPFlag("db.verify.includeimages", dbVerifyCmd.Flags().Lookup("include-images"))
+
+	// Add flags specific to db relocate
+	dbRelocateCmd.Flags().String("old", "", "Previous download directory (required)")
+	dbRelocateCmd.Flags().String("new", "", "New download directory (required, must exist)")
+	dbRelocateCmd.Flags().String("index-path", "", "Bleve index to update (default: --bleve-index-path, or civitai.bleve inside the new directory)")
+	dbRelocateCmd.Flags().Bool("allow-missing", false, "Rewrite paths even if some files are not found at the new location")
+	_ = viper.BindPFlag("db.relocate.old", dbRelocateCmd.Flags().Lookup("old"))
+	_ = viper.BindPFlag("db.relocate.new", dbRelocateCmd.Flags().Lookup("new"))
+	_ = viper.BindPFlag("db.relocate.indexpath", dbRelocateCmd.Flags().Lookup("index-path"))
+	_ = viper.BindPFlag("db.relocate.allowmissing", dbRelocateCmd.Flags().Lookup("allow-missing"))
+
+	// Flags for purge command
+	dbPurgeCmd.Flags().BoolP("yes", "y", false, "Delete without asking for confirmation")
+	_ = viper.BindPFlag("db.purge.yes", dbPurgeCmd.Flags().Lookup("yes"))
+
+	// Flags for export command
+	dbExportCmd.Flags().String("format", "json", "Export format: csv or json")
+	dbExportCmd.Flags().StringP("output", "o", "", "File to write the export to (default: stdout)")
+	_ = viper.BindPFlag("db.export.format", dbExportCmd.Flags().Lookup("format"))
+	_ = viper.BindPFlag("db.export.output", dbExportCmd.Flags().Lookup("output"))
+
+	// Flags for import command
+	dbImportCmd.Flags().StringP("input", "i", "", "JSON export to import (required, - for stdin)")
+	dbImportCmd.Flags().Bool("overwrite", false, "Replace entries that already exist in the database")
+	_ = viper.BindPFlag("db.import.input", dbImportCmd.Flags().Lookup("input"))
+	_ = viper.BindPFlag("db.import.overwrite", dbImportCmd.Flags().Lookup("overwrite"))
+
+	// Flags for prune command
+	dbPruneCmd.Flags().BoolP("yes", "y", false, "Remove entries without asking for confirmation")
+	_ = viper.BindPFlag("db.prune.yes", dbPruneCmd.Flags().Lookup("yes"))
+
+	// Flags for orphans command
+	dbOrphansCmd.Flags().Bool("delete", false, "Delete the orphaned files after confirmation")
+	dbOrphansCmd.Flags().BoolP("yes", "y", false, "With --delete, delete without asking for confirmation")
+	_ = viper.BindPFlag("db.orphans.delete", dbOrphansCmd.Flags().Lookup("delete"))
+	_ = viper.BindPFlag("db.orphans.yes", dbOrphansCmd.Flags().Lookup("yes"))
 
 	// Add flags specific to db redownload if needed (e.g., force overwrite without hash check?)
 	// dbRedownloadCmd.Flags().Bool("force", false, "Force redownload even if file exists and hash matches")
 }
 
-func runDbView(cmd *cobra.Command, args []string) {
-	log.Info("Viewing database entries...")
-
-	// Use globalConfig loaded by PersistentPreRunE
-	if globalConfig.DatabasePath == "" {
-		log.Fatal("Database path is not set in the configuration. Please check config file or path.")
+// dbEntryFilePath returns where the entry's model file is expected on disk: in the
+// recorded VersionDir below Folder. Entries written before VersionDir was recorded
+// only have the folder above it; for those the version directory named after the
+// version ID (<versionID> or <versionID>-<fileSlug>) is used if it holds the file.
+// Other directories are never searched, since they may hold another version's file
+// of the same name.
+func dbEntryFilePath(entry models.DatabaseEntry) string {
+	folder := entry.Folder
+	if !filepath.IsAbs(folder) {
+		folder = filepath.Join(globalConfig.SavePath, folder)
 	}
-
-	// Open Database using globalConfig
-	db, err := database.Open(globalConfig.DatabasePath)
+	if entry.VersionDir != "" {
+		return filepath.Join(folder, entry.VersionDir, entry.Filename)
+	}
+	direct := filepath.Join(folder, entry.Filename)
+	if _, err := os.Stat(direct); err == nil || entry.Filename == "" {
+		return direct
+	}
+	dirs, err := os.ReadDir(folder)
 	if err != nil {
-		log.WithError(err).Fatalf("Failed to open database at %s", globalConfig.DatabasePath)
+		return direct
 	}
-	defer db.Close()
+	versionID := strconv.Itoa(entry.Version.ID)
+	for _, dir := range dirs {
+		if !dir.IsDir() || (dir.Name() != versionID && !strings.HasPrefix(dir.Name(), versionID+"-")) {
+			continue
+		}
+		candidate := filepath.Join(folder, dir.Name(), entry.Filename)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return direct
+}
 
-	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) // Adjust padding and alignment
-	fmt.Fprintln(tw, "Model Name\tVersion Name\tFilename\tFolder\tType\tBase Model\tCreator\tStatus\tDB Key (VersionID)")
-	fmt.Fprintln(tw, "----------\t------------\t--------\t------\t----\t----------\t-------\t------\t------------------")
+// inferDbSavePath falls back to the database's directory when SavePath is not set,
+// since entry folders are stored relative to it.
+func inferDbSavePath() {
+	if globalConfig.SavePath != "" {
+		return
+	}
+	// Try to infer from DB path if possible (mirroring clean command logic)
+	if globalConfig.DatabasePath != "" {
+		globalConfig.SavePath = filepath.Dir(globalConfig.DatabasePath)
+		log.Warnf("SavePath is empty, inferring base directory from DatabasePath: %s", globalConfig.SavePath)
+	} else {
+		log.Fatal("Save path is not set (and cannot be inferred from DatabasePath). Please check config file or path.")
+	}
+}
 
-	count := 0
-	// Use Fold to iterate over key-value pairs
-	errFold := db.Fold(func(key []byte, value []byte) error {
+// dbEntryRow pairs a database entry with the version ID taken from its key.
+type dbEntryRow struct {
+	VersionID string
+	Entry     models.DatabaseEntry
+}
+
+// entryNameMatches reports whether the entry's model name contains the (already
+// lowercased) search term. Shared by db search and db view --filter.
+func entryNameMatches(entry models.DatabaseEntry, lowerTerm string) bool {
+	return strings.Contains(strings.ToLower(entry.ModelName), lowerTerm)
+}
+
+// entryFieldMatches reports whether the given field of the entry contains the (already
+// lowercased) search term. field is one of name, creator, basemodel, filename or all;
+// unknown fields never match. Used by db search --field.
+func entryFieldMatches(entry models.DatabaseEntry, field string, lowerTerm string) bool {
+	var values []string
+	switch field {
+	case "name":
+		values = []string{entry.ModelName}
+	case "creator":
+		values = []string{entry.Creator.Username}
+	case "basemodel":
+		values = []string{entry.Version.BaseModel}
+	case "filename":
+		values = []string{entry.Filename}
+	case "all":
+		values = []string{entry.ModelName, entry.Creator.Username, entry.Version.BaseModel, entry.Filename}
+	}
+	for _, value := range values {
+		if strings.Contains(strings.ToLower(value), lowerTerm) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectDbEntries folds over all version entries in the database and returns
+// those accepted by match (all entries if match is nil).
+func collectDbEntries(db *database.DB, match func(models.DatabaseEntry) bool) ([]dbEntryRow, error) {
+	var rows []dbEntryRow
+	err := db.Fold(func(key []byte, value []byte) error {
 		keyStr := string(key)
 		// Skip internal keys like page state
 		if !strings.HasPrefix(keyStr, "v_") { // Only process keys starting with "v_"
 			return nil
 		}
 
-		// Value is already provided by Fold, no need for db.Get
 		var entry models.DatabaseEntry
-		err := json.Unmarshal(value, &entry)
-		if err != nil {
+		if err := json.Unmarshal(value, &entry); err != nil {
 			log.WithError(err).Warnf("Failed to unmarshal JSON for key %s: %s", keyStr, string(value))
 			return nil // Continue folding over other keys
 		}
 
-		// Print table row using the added fields, including Status
-		// Extract version ID from key for display
-		versionIDStr := strings.TrimPrefix(keyStr, "v_")
-		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-			entry.ModelName, // Use added ModelName
+		if match == nil || match(entry) {
+			rows = append(rows, dbEntryRow{VersionID: strings.TrimPrefix(keyStr, "v_"), Entry: entry})
+		}
+		return nil
+	})
+	return rows, err
+}
+
+// sortDbEntries orders rows by name, size (largest first) or date (newest first).
+func sortDbEntries(rows []dbEntryRow, sortBy string) error {
+	switch strings.ToLower(sortBy) {
+	case "":
+		// Keep database order
+	case "name":
+		sort.SliceStable(rows, func(i, j int) bool {
+			a, b := strings.ToLower(rows[i].Entry.ModelName), strings.ToLower(rows[j].Entry.ModelName)
+			if a != b {
+				return a < b
+			}
+			return strings.ToLower(rows[i].Entry.Version.Name) < strings.ToLower(rows[j].Entry.Version.Name)
+		})
+	case "size":
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].Entry.File.SizeKB > rows[j].Entry.File.SizeKB })
+	case "date":
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].Entry.Timestamp > rows[j].Entry.Timestamp })
+	default:
+		return fmt.Errorf("invalid sort %q (expected name, size or date)", sortBy)
+	}
+	return nil
+}
+
+// printDbEntriesJSON writes rows to stdout as a JSON array of database entries,
+// each with its version ID key added, for piping into tools like jq.
+func printDbEntriesJSON(rows []dbEntryRow) error {
+	return writeDbEntriesJSON(os.Stdout, rows)
+}
+
+// writeDbEntriesJSON writes rows to w in the printDbEntriesJSON format.
+func writeDbEntriesJSON(w io.Writer, rows []dbEntryRow) error {
+	type dbEntryJSON struct {
+		VersionID string `json:"versionId"`
+		models.DatabaseEntry
+	}
+	out := make([]dbEntryJSON, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, dbEntryJSON{VersionID: row.VersionID, DatabaseEntry: row.Entry})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// writeDbEntriesCSV writes rows to w as CSV with a header row, using the columns
+// of the db view table.
+func writeDbEntriesCSV(w io.Writer, rows []dbEntryRow) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"Model Name", "Version Name", "Filename", "Folder", "Type", "Base Model", "Creator", "Status", "Version ID"}); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		entry := row.Entry
+		record := []string{
+			entry.ModelName,
+			entry.Version.Name,
+			entry.Filename,
+			entry.Folder,
+			entry.ModelType,
+			entry.Version.BaseModel,
+			entry.Creator.Username,
+			entry.Status,
+			row.VersionID,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// printDbEntriesTable writes rows as the table used by db view and db search.
+func printDbEntriesTable(rows []dbEntryRow) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) // Adjust padding and alignment
+	fmt.Fprintln(tw, "Model Name\tVersion Name\tFilename\tFolder\tType\tBase Model\tCreator\tStatus\tError\tDB Key (VersionID)")
+	fmt.Fprintln(tw, "----------\t------------\t--------\t------\t----\t----------\t-------\t------\t-----\t------------------")
+	for _, row := range rows {
+		entry := row.Entry
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.ModelName,
 			entry.Version.Name,
 			entry.Filename,
 			entry.Folder,
-			entry.ModelType, // Use added ModelType
+			entry.ModelType,
 			entry.Version.BaseModel,
 			entry.Creator.Username, // Print the username from the Creator struct
-			entry.Status,           // Added Status field
-			versionIDStr,           // Display the version ID
+			entry.Status,
+			entry.ErrorCategory,
+			row.VersionID, // Display the version ID
 		)
-		count++
-		return nil
-	})
+	}
+	return tw.Flush()
+}
+
+func runDbView(cmd *cobra.Command, args []string) {
+	log.Info("Viewing database entries...")
+
+	filterTerm := strings.ToLower(viper.GetString("db.view.filter"))
+	statusFilter := viper.GetString("db.view.status")
+	typeFilter := viper.GetString("db.view.type")
+	categoryFilter := viper.GetString("db.view.errorcategory")
+	sortBy := viper.GetString("db.view.sort")
+	limit := viper.GetInt("db.view.limit")
+	offset := viper.GetInt("db.view.offset")
+	if limit < 0 || offset < 0 {
+		log.Fatal("--limit and --offset must not be negative.")
+	}
 
+	// Use globalConfig loaded by PersistentPreRunE
+	if globalConfig.DatabasePath == "" {
+		log.Fatal("Database path is not set in the configuration. Please check config file or path.")
+	}
+
+	// Open Database using globalConfig
+	db, err := database.Open(globalConfig.DatabasePath)
+	if err != nil {
+		log.WithError(err).Fatalf("Failed to open database at %s", globalConfig.DatabasePath)
+	}
+	defer db.Close()
+
+	rows, errFold := collectDbEntries(db, func(entry models.DatabaseEntry) bool {
+		if filterTerm != "" && !entryNameMatches(entry, filterTerm) {
+			return false
+		}
+		if statusFilter != "" && !strings.EqualFold(entry.Status, statusFilter) {
+			return false
+		}
+		if typeFilter != "" && !strings.EqualFold(entry.ModelType, typeFilter) {
+			return false
+		}
+		if categoryFilter != "" && !strings.EqualFold(entry.ErrorCategory, categoryFilter) {
+			return false
+		}
+		return true
+	})
 	if errFold != nil {
 		log.WithError(errFold).Error("Error occurred during database scan (Fold)")
 	}
 
-	if err := tw.Flush(); err != nil {
+	if err := sortDbEntries(rows, sortBy); err != nil {
+		log.Fatal(err)
+	}
+
+	totalMatches := len(rows)
+	if offset > len(rows) {
+		offset = len(rows)
+	}
+	rows = rows[offset:]
+	if limit > 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+
+	if viper.GetBool("json") {
+		if err := printDbEntriesJSON(rows); err != nil {
+			log.WithError(err).Error("Error writing JSON output for db view")
+		}
+	} else if err := printDbEntriesTable(rows); err != nil {
 		log.WithError(err).Error("Error flushing table writer for db view")
 	}
-	log.Infof("Displayed %d entries.", count)
+	if len(rows) < totalMatches {
+		log.Infof("Displayed entries %d-%d of %d matching entries.", offset+1, offset+len(rows), totalMatches)
+	} else {
+		log.Infof("Displayed %d entries.", len(rows))
+	}
+}
+
+func runDbStats(cmd *cobra.Command, args []string) {
+	if globalConfig.DatabasePath == "" {
+		log.Fatal("Database path is not set in the configuration. Please check config file or path.")
+	}
+	db, err := database.Open(globalConfig.DatabasePath)
+	if err != nil {
+		log.WithError(err).Fatalf("Failed to open database at %s", globalConfig.DatabasePath)
+	}
+	defer db.Close()
+
+	rows, errFold := collectDbEntries(db, nil)
+	if errFold != nil {
+		log.WithError(errFold).Error("Error occurred during database scan (Fold)")
+	}
+
+	stats := newDbStats(rows)
+	if err := stats.write(os.Stdout); err != nil {
+		log.WithError(err).Error("Error flushing table writer for db stats")
+	}
+	if authFailures := stats.categoryCounts[downloader.CategoryAuth]; authFailures > 0 {
+		log.Infof("%d download(s) failed with 401/403; their download URLs may have expired or need an API key. Re-running the download re-fetches them.", authFailures)
+	}
+}
+
+// dbStats holds the aggregates printed by db stats.
+type dbStats struct {
+	entries        int
+	totalBytes     uint64 // Sum of the files' API sizes
+	statusCounts   map[string]int
+	typeCounts     map[string]int
+	baseCounts     map[string]int
+	categoryCounts map[string]int // Error entries only
+}
+
+func newDbStats(rows []dbEntryRow) dbStats {
+	stats := dbStats{
+		entries:        len(rows),
+		statusCounts:   make(map[string]int),
+		typeCounts:     make(map[string]int),
+		baseCounts:     make(map[string]int),
+		categoryCounts: make(map[string]int),
+	}
+	orUnknown := func(value string) string {
+		if value == "" {
+			return "unknown"
+		}
+		return value
+	}
+	for _, row := range rows {
+		stats.statusCounts[row.Entry.Status]++
+		stats.typeCounts[orUnknown(row.Entry.ModelType)]++
+		stats.baseCounts[orUnknown(row.Entry.Version.BaseModel)]++
+		stats.totalBytes += uint64(row.Entry.File.SizeKB * 1024)
+		if row.Entry.Status == models.StatusError {
+			category := row.Entry.ErrorCategory
+			if category == "" {
+				category = "uncategorized" // Recorded before categories existed
+			}
+			stats.categoryCounts[category]++
+		}
+	}
+	return stats
+}
+
+// write prints the aggregates as tables, one per grouping.
+func (s dbStats) write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	writeCounts := func(title string, counts map[string]int) {
+		fmt.Fprintf(tw, "%s\tEntries\n", title)
+		fmt.Fprintf(tw, "%s\t-------\n", strings.Repeat("-", len(title)))
+		for _, key := range sortedCountKeys(counts) {
+			fmt.Fprintf(tw, "%s\t%d\n", key, counts[key])
+		}
+	}
+	writeCounts("Status", s.statusCounts)
+	fmt.Fprintf(tw, "Total\t%d\n", s.entries)
+	fmt.Fprintln(tw)
+	writeCounts("Model Type", s.typeCounts)
+	fmt.Fprintln(tw)
+	writeCounts("Base Model", s.baseCounts)
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "Total files\t%d\n", s.entries)
+	fmt.Fprintf(tw, "Total size\t%s\n", helpers.BytesToSize(s.totalBytes))
+	if len(s.categoryCounts) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "Error Category\tEntries\tKind")
+		fmt.Fprintln(tw, "--------------\t-------\t----")
+		for _, category := range sortedCountKeys(s.categoryCounts) {
+			kind := "systematic"
+			if downloader.IsTransientCategory(category) {
+				kind = "transient"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", category, s.categoryCounts[category], kind)
+		}
+	}
+	return tw.Flush()
+}
+
+// sortedCountKeys returns the keys of counts ordered by count (highest first), then name.
+func sortedCountKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
 }
 
 type verificationProblem struct {
@@ -163,20 +651,14 @@ func runDbVerify(cmd *cobra.Command, args []string) {
 	// Read flags using Viper
 	checkHashFlag := viper.GetBool("db.verify.checkhash")
 	autoRedownloadFlag := viper.GetBool("db.verify.yes")
+	repairMetadataFlag := viper.GetBool("db.verify.repairmetadata")
+	includeImagesFlag := viper.GetBool("db.verify.includeimages")
 
 	// --- Basic Config Checks ---
 	if globalConfig.DatabasePath == "" {
 		log.Fatal("Database path is not set in the configuration. Please check config file or path.")
 	}
-	if globalConfig.SavePath == "" {
-		// Try to infer from DB path if possible (mirroring clean command logic)
-		if globalConfig.DatabasePath != "" {
-			globalConfig.SavePath = filepath.Dir(globalConfig.DatabasePath)
-			log.Warnf("SavePath is empty, inferring base directory from DatabasePath: %s", globalConfig.SavePath)
-		} else {
-			log.Fatal("Save path is not set (and cannot be inferred from DatabasePath). Please check config file or path.")
-		}
-	}
+	inferDbSavePath()
 
 	// --- Open Database --- (moved up)
 	db, err := database.Open(globalConfig.DatabasePath)
@@ -185,7 +667,7 @@ func runDbVerify(cmd *cobra.Command, args []string) {
 	}
 	defer db.Close()
 
-	var totalEntries, foundOk, foundHashMismatch, missing int
+	var totalEntries, foundOk, foundHashMismatch, missing, metadataRepaired, corruptImages int
 	var problemsToAddress []verificationProblem // List to store entries needing attention
 
 	log.Info("Scanning database entries...")
@@ -207,7 +689,7 @@ func runDbVerify(cmd *cobra.Command, args []string) {
 
 		// Construct the expected full path using globalConfig and entry data
 		// Ensure the path uses the stored Filename and Folder
-		expectedPath := filepath.Join(globalConfig.SavePath, entry.Folder, entry.Filename)
+		expectedPath := dbEntryFilePath(entry)
 
 		// --- Check Main Model File --- (Simplified logic)
 		mainFileFound := false
@@ -255,30 +737,27 @@ func runDbVerify(cmd *cobra.Command, args []string) {
 		}
 
 		// --- Check/Create Metadata File if Enabled --- (moved down, only if main file is OK)
-		if mainFileFound && hashOK && viper.GetBool("savemetadata") {
+		if repairMetadataFlag && mainFileFound {
+			// Rewrite the sidecar of every file on disk, whether or not its hash checked out
+			if metaFilepath, repairErr := repairMetadataSidecar(entry, expectedPath); repairErr != nil {
+				log.WithError(repairErr).Errorf("[METADATA ERROR] Failed to rewrite metadata file %s", metaFilepath)
+			} else {
+				metadataRepaired++
+				log.WithField("path", metaFilepath).Info("[METADATA REPAIRED] Rewrote metadata file.")
+			}
+		} else if mainFileFound && hashOK && viper.GetBool("savemetadata") {
 			// Construct metadata filepath based on expectedPath (which already has the final filename)
-			metaFilename := strings.TrimSuffix(entry.Filename, filepath.Ext(entry.Filename)) + ".json"
-			metaFilepath := filepath.Join(globalConfig.SavePath, entry.Folder, metaFilename)
+			metaFilepath := strings.TrimSuffix(expectedPath, filepath.Ext(expectedPath)) + ".json"
 
 			if _, metaStatErr := os.Stat(metaFilepath); metaStatErr != nil {
 				if os.IsNotExist(metaStatErr) {
 					// Metadata file missing, attempt to create it
 					log.WithField("path", metaFilepath).Warn("[METADATA MISSING] Creating metadata file...")
-					// Marshal the version info stored in the entry
-					versionToSave := entry.Version // Use the version stored in the DB entry
-					jsonData, jsonErr := json.MarshalIndent(versionToSave, "", "  ")
-					if jsonErr != nil {
-						log.WithError(jsonErr).Errorf("Failed to marshal metadata for %s", metaFilename)
+					// Write the version info stored in the entry
+					if writeErr := writeVersionMetadata(metaFilepath, entry.Version); writeErr != nil {
+						log.WithError(writeErr).Errorf("Failed to write metadata file %s", metaFilepath)
 					} else {
-						// Ensure directory exists BEFORE writing
-						metaDir := filepath.Dir(metaFilepath)
-						if mkdirErr := os.MkdirAll(metaDir, 0700); mkdirErr != nil {
-							log.WithError(mkdirErr).Errorf("Failed to create directory for metadata file %s", metaFilepath)
-						} else if writeErr := os.WriteFile(metaFilepath, jsonData, 0600); writeErr != nil {
-							log.WithError(writeErr).Errorf("Failed to write metadata file %s", metaFilepath)
-						} else {
-							log.WithField("path", metaFilepath).Info("[METADATA CREATED] Successfully wrote metadata file.")
-						}
+						log.WithField("path", metaFilepath).Info("[METADATA CREATED] Successfully wrote metadata file.")
 					}
 				} else {
 					// Other error stating the metadata file
@@ -290,12 +769,24 @@ func runDbVerify(cmd *cobra.Command, args []string) {
 			}
 		} else if viper.GetBool("savemetadata") && (!mainFileFound || !hashOK) {
 			// Log skipping metadata check because main file is missing or hash mismatch
-			metaFilename := strings.TrimSuffix(entry.Filename, filepath.Ext(entry.Filename)) + ".json"
-			metaFilepath := filepath.Join(globalConfig.SavePath, entry.Folder, metaFilename)
+			metaFilepath := strings.TrimSuffix(expectedPath, filepath.Ext(expectedPath)) + ".json"
 			log.WithField("path", metaFilepath).Debug("[METADATA SKIP] Skipping metadata check/creation because main file is missing or has hash mismatch.")
 		}
 		// --- End Check/Create Metadata File ---
 
+		// --- Check Version Images if Enabled ---
+		if includeImagesFlag {
+			imagesDir := filepath.Join(filepath.Dir(expectedPath), "images")
+			corrupt, scanErr := findCorruptImages(imagesDir)
+			if scanErr != nil {
+				log.WithError(scanErr).Errorf("[IMAGES ERROR] Could not scan image directory %s", imagesDir)
+			}
+			for _, bad := range corrupt {
+				corruptImages++
+				log.WithFields(log.Fields{"path": bad.Path, "reason": bad.Reason}).Warn("[CORRUPT IMAGE] Image file is damaged.")
+			}
+		}
+
 		return nil // Continue folding
 	})
 
@@ -303,6 +794,13 @@ func runDbVerify(cmd *cobra.Command, args []string) {
 		log.WithError(errFold).Error("Error occurred during database scan (Fold)")
 	}
 
+	summary := dbVerifySummary{TotalEntries: totalEntries, OK: foundOk, Missing: missing, Mismatch: foundHashMismatch, MetadataRepaired: metadataRepaired, CorruptImages: corruptImages}
+	if repairMetadataFlag {
+		log.Infof("Rewrote %d metadata file(s).", metadataRepaired)
+	}
+	if includeImagesFlag {
+		log.Infof("Found %d corrupt image file(s).", corruptImages)
+	}
 	log.Infof("Initial Scan Summary: Total Entries=%d, OK=%d, Missing=%d, Mismatch=%d",
 		totalEntries, foundOk, missing, foundHashMismatch)
 
@@ -359,7 +857,7 @@ func runDbVerify(cmd *cobra.Command, args []string) {
 				}
 
 				// --- Perform Redownload using existing logic ---
-				targetPath := filepath.Join(globalConfig.SavePath, entry.Folder, entry.Filename)
+				targetPath := dbEntryFilePath(entry)
 				downloadUrl := entry.File.DownloadUrl
 				hashes := entry.File.Hashes
 				versionID := entry.Version.ID // Use the version ID from the entry
@@ -371,6 +869,7 @@ func runDbVerify(cmd *cobra.Command, args []string) {
 					log.WithError(err).Errorf("Failed to create directory for redownload: %s", filepath.Dir(targetPath))
 					updateErr := updateDbEntry(db, dbKey, models.StatusError, func(e *models.DatabaseEntry) {
 						e.ErrorDetails = fmt.Sprintf("Mkdir failed: %v", err)
+						e.ErrorCategory = downloader.CategoryFileSystem
 					})
 					if updateErr != nil {
 						// Also log error if updating DB status failed
@@ -380,7 +879,7 @@ func runDbVerify(cmd *cobra.Command, args []string) {
 					continue // Next problem
 				}
 
-				finalPath, downloadErr := fileDownloader.DownloadFile(targetPath, downloadUrl, hashes, versionID)
+				finalPath, downloadErr := fileDownloader.DownloadFile(commandContext(cmd), targetPath, downloadUrl, hashes, versionID)
 
 				// --- Update DB and Handle Metadata ---
 				finalStatus := models.StatusError
@@ -396,8 +895,10 @@ func runDbVerify(cmd *cobra.Command, args []string) {
 				updateErr := updateDbEntry(db, dbKey, finalStatus, func(e *models.DatabaseEntry) {
 					if downloadErr != nil {
 						e.ErrorDetails = downloadErr.Error()
+						e.ErrorCategory = downloader.ErrorCategory(downloadErr)
 					} else {
 						e.ErrorDetails = ""                   // Clear error on success
+						e.ErrorCategory = ""                  // And its category
 						e.Filename = filepath.Base(finalPath) // Update filename if ID was prepended
 						// Update File and Version structs? Maybe not necessary here unless they changed upstream?
 					}
@@ -422,17 +923,110 @@ func runDbVerify(cmd *cobra.Command, args []string) {
 			}
 		} // End loop through problems
 
+		summary.RedownloadAttempts = redownloadAttempts
+		summary.RedownloadSucceeded = redownloadSuccess
+		summary.RedownloadFailed = redownloadFail
+	} else {
+		log.Info("No missing or mismatched files found requiring redownload.")
+	}
+
+	printSummary(summary, func() {
 		// --- Final Redownload Summary ---
-		if redownloadAttempts > 0 {
+		if summary.RedownloadAttempts > 0 {
 			log.Infof("Redownload Phase Summary: Attempts=%d, Success=%d, Failed=%d",
-				redownloadAttempts, redownloadSuccess, redownloadFail)
+				summary.RedownloadAttempts, summary.RedownloadSucceeded, summary.RedownloadFailed)
 		}
+		log.Info("Verification process completed.")
+	})
+}
 
-	} else {
-		log.Info("No missing or mismatched files found requiring redownload.")
+// dbVerifySummary is the final summary of db verify, printed as JSON with --json.
+type dbVerifySummary struct {
+	TotalEntries        int `json:"totalEntries"`
+	OK                  int `json:"ok"`
+	Missing             int `json:"missing"`
+	Mismatch            int `json:"mismatch"`
+	RedownloadAttempts  int `json:"redownloadAttempts"`
+	RedownloadSucceeded int `json:"redownloadSucceeded"`
+	RedownloadFailed    int `json:"redownloadFailed"`
+	MetadataRepaired    int `json:"metadataRepaired,omitempty"` // Sidecars rewritten by --repair-metadata
+	CorruptImages       int `json:"corruptImages,omitempty"`    // Damaged images found by --include-images
+}
+
+// corruptImage is an image file found damaged by db verify --include-images.
+type corruptImage struct {
+	Path   string
+	Reason string
+}
+
+// findCorruptImages checks the image files in dir and returns the damaged ones: empty
+// files, and PNG, JPEG or GIF files whose header cannot be decoded (e.g. truncated
+// downloads). Other formats such as WebP or videos are only checked for being empty.
+// A missing dir is not an error.
+func findCorruptImages(dir string) ([]corruptImage, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var corrupt []corruptImage
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		// Skip completion markers and metadata sidecars
+		if dirEntry.IsDir() || strings.HasPrefix(name, ".") || strings.EqualFold(filepath.Ext(name), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		info, err := dirEntry.Info()
+		if err != nil {
+			return corrupt, err
+		}
+		if info.Size() == 0 {
+			corrupt = append(corrupt, corruptImage{Path: path, Reason: "empty file"})
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".png", ".jpg", ".jpeg", ".gif":
+		default:
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return corrupt, err
+		}
+		_, _, decodeErr := image.DecodeConfig(f)
+		f.Close()
+		if decodeErr != nil {
+			corrupt = append(corrupt, corruptImage{Path: path, Reason: decodeErr.Error()})
+		}
 	}
+	return corrupt, nil
+}
 
-	log.Info("Verification process completed.")
+// writeVersionMetadata writes version as indented JSON to path, creating its directory.
+func writeVersionMetadata(path string, version models.ModelVersion) error {
+	jsonData, err := json.MarshalIndent(version, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return os.WriteFile(path, jsonData, 0600)
+}
+
+// repairMetadataSidecar overwrites the .json sidecar next to the entry's file at
+// modelPath with the version stored in the entry, and returns the sidecar path.
+// The file's directory must already exist; no directories are created.
+func repairMetadataSidecar(entry models.DatabaseEntry, modelPath string) (string, error) {
+	metaFilepath := strings.TrimSuffix(modelPath, filepath.Ext(modelPath)) + ".json"
+	jsonData, err := json.MarshalIndent(entry.Version, "", "  ")
+	if err != nil {
+		return metaFilepath, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	return metaFilepath, os.WriteFile(metaFilepath, jsonData, 0600)
 }
 
 func runDbRedownload(cmd *cobra.Command, args []string) {
@@ -472,7 +1066,7 @@ func runDbRedownload(cmd *cobra.Command, args []string) {
 	}
 
 	// Reconstruct the expected full path using globalConfig
-	expectedPath := filepath.Join(globalConfig.SavePath, entry.Folder, entry.Filename)
+	expectedPath := dbEntryFilePath(entry)
 	log.Infof("Target path for redownload: %s", expectedPath)
 	log.Infof("Download URL from DB: %s", entry.File.DownloadUrl)
 
@@ -491,7 +1085,7 @@ func runDbRedownload(cmd *cobra.Command, args []string) {
 
 	// Perform the download, checking the error
 	// Pass the Model Version ID from the database entry
-	finalPath, err := fileDownloader.DownloadFile(expectedPath, entry.File.DownloadUrl, entry.File.Hashes, entry.Version.ID)
+	finalPath, err := fileDownloader.DownloadFile(commandContext(cmd), expectedPath, entry.File.DownloadUrl, entry.File.Hashes, entry.Version.ID)
 
 	if err == nil {
 		log.Infof("Successfully redownloaded and verified: %s", finalPath)
@@ -519,7 +1113,13 @@ func runDbRedownload(cmd *cobra.Command, args []string) {
 
 func runDbSearch(cmd *cobra.Command, args []string) {
 	searchTerm := strings.ToLower(args[0]) // Case-insensitive search
-	log.Infof("Searching database entries for model name containing: '%s'", searchTerm)
+	field := strings.ToLower(viper.GetString("db.search.field"))
+	switch field {
+	case "name", "creator", "basemodel", "filename", "all":
+	default:
+		log.Fatalf("Invalid --field %q (expected name, creator, basemodel, filename or all)", field)
+	}
+	log.Infof("Searching database entries for %s containing: '%s'", field, searchTerm)
 
 	// Use globalConfig loaded by PersistentPreRunE
 	if globalConfig.DatabasePath == "" {
@@ -532,51 +1132,622 @@ func runDbSearch(cmd *cobra.Command, args []string) {
 	}
 	defer db.Close()
 
-	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(tw, "Model Name\tVersion Name\tFilename\tFolder\tType\tBase Model\tCreator\tStatus\tDB Key (VersionID)")
-	fmt.Fprintln(tw, "----------\t------------\t--------\t------\t----\t----------\t-------\t------\t------------------")
+	rows, errFold := collectDbEntries(db, func(entry models.DatabaseEntry) bool {
+		// Perform case-insensitive substring search
+		return entryFieldMatches(entry, field, searchTerm)
+	})
+	if errFold != nil {
+		log.WithError(errFold).Error("Error occurred during database scan (Fold)")
+	}
+	matchCount := len(rows)
+
+	if viper.GetBool("json") {
+		if err := printDbEntriesJSON(rows); err != nil {
+			log.WithError(err).Error("Error writing JSON output for db search")
+		}
+	} else if err := printDbEntriesTable(rows); err != nil {
+		log.WithError(err).Error("Error flushing table writer for db search")
+	}
+	log.Infof("Found %d matching entries for query '%s'.", matchCount, searchTerm)
+}
+
+func runDbExport(cmd *cobra.Command, args []string) {
+	format := strings.ToLower(viper.GetString("db.export.format"))
+	var writeRows func(io.Writer, []dbEntryRow) error
+	switch format {
+	case "json":
+		writeRows = writeDbEntriesJSON
+	case "csv":
+		writeRows = writeDbEntriesCSV
+	default:
+		log.Fatalf("Invalid --format %q (expected csv or json)", format)
+	}
+
+	if globalConfig.DatabasePath == "" {
+		log.Fatal("Database path is not set in the configuration.")
+	}
+	db, err := database.Open(globalConfig.DatabasePath)
+	if err != nil {
+		log.WithError(err).Fatalf("Failed to open database at %s", globalConfig.DatabasePath)
+	}
+	defer db.Close()
+
+	rows, errFold := collectDbEntries(db, nil)
+	if errFold != nil {
+		log.WithError(errFold).Fatal("Error occurred during database scan (Fold)")
+	}
 
-	matchCount := 0
-	errFold := db.Fold(func(key []byte, value []byte) error {
-		keyStr := string(key)
-		// Skip non-version keys
-		if !strings.HasPrefix(keyStr, "v_") {
-			return nil
+	outputPath := viper.GetString("db.export.output")
+	if outputPath == "" || outputPath == "-" {
+		if err := writeRows(os.Stdout, rows); err != nil {
+			log.WithError(err).Fatal("Error writing export")
 		}
+		log.Infof("Exported %d entries as %s.", len(rows), format)
+		return
+	}
 
-		var entry models.DatabaseEntry
-		err := json.Unmarshal(value, &entry)
+	// Write to a temporary file first so a failed export never leaves a truncated file
+	tmpPath := outputPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		log.WithError(err).Fatalf("Failed to create %s", tmpPath)
+	}
+	writeErr := writeRows(f, rows)
+	if closeErr := f.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr == nil {
+		writeErr = os.Rename(tmpPath, outputPath)
+	}
+	if writeErr != nil {
+		os.Remove(tmpPath)
+		log.WithError(writeErr).Fatalf("Failed to write export to %s", outputPath)
+	}
+	log.Infof("Exported %d entries as %s to %s.", len(rows), format, outputPath)
+}
+
+// importDbEntries reads a JSON array of database entries from r and stores each under
+// its v_<versionID> key. Entries already in the database are skipped unless overwrite is
+// set. All entries are validated before anything is written, so a malformed export
+// leaves the database unchanged.
+func importDbEntries(db *database.DB, r io.Reader, overwrite bool) (imported int, skipped int, err error) {
+	var entries []models.DatabaseEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return 0, 0, fmt.Errorf("error parsing import file: %w", err)
+	}
+	for i, entry := range entries {
+		if entry.Version.ID == 0 {
+			return 0, 0, fmt.Errorf("entry %d (%q) has no version ID", i+1, entry.Filename)
+		}
+	}
+
+	for _, entry := range entries {
+		dbKey := []byte(fmt.Sprintf("v_%d", entry.Version.ID))
+		if !overwrite && db.Has(dbKey) {
+			log.Debugf("Skipping existing entry %s", dbKey)
+			skipped++
+			continue
+		}
+		entryBytes, err := json.Marshal(entry)
 		if err != nil {
-			log.WithError(err).Warnf("Failed to unmarshal JSON for key %s, skipping search check.", keyStr)
-			return nil
+			return imported, skipped, fmt.Errorf("error marshalling entry %s: %w", dbKey, err)
 		}
+		if err := db.Put(dbKey, entryBytes); err != nil {
+			return imported, skipped, fmt.Errorf("error writing entry %s: %w", dbKey, err)
+		}
+		imported++
+	}
+	return imported, skipped, nil
+}
 
-		// Perform case-insensitive substring search
-		if strings.Contains(strings.ToLower(entry.ModelName), searchTerm) {
-			matchCount++
-			// Extract version ID from key for display
-			versionIDStr := strings.TrimPrefix(keyStr, "v_")
-			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-				entry.ModelName,
-				entry.Version.Name,
-				entry.Filename,
-				entry.Folder,
-				entry.ModelType,
-				entry.Version.BaseModel,
-				entry.Creator.Username,
-				entry.Status, // Added Status field
-				versionIDStr, // Display the version ID
-			)
+func runDbImport(cmd *cobra.Command, args []string) {
+	inputPath := viper.GetString("db.import.input")
+	if inputPath == "" {
+		log.Fatal("--input is required.")
+	}
+	var input io.Reader = os.Stdin
+	if inputPath != "-" {
+		f, err := os.Open(inputPath)
+		if err != nil {
+			log.WithError(err).Fatalf("Failed to open %s", inputPath)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	if globalConfig.DatabasePath == "" {
+		log.Fatal("Database path is not set in the configuration.")
+	}
+	db, err := database.Open(globalConfig.DatabasePath)
+	if err != nil {
+		log.WithError(err).Fatalf("Failed to open database at %s", globalConfig.DatabasePath)
+	}
+	defer db.Close()
+
+	imported, skipped, err := importDbEntries(db, input, viper.GetBool("db.import.overwrite"))
+	if err != nil {
+		log.WithError(err).Errorf("Import stopped after %d entries", imported)
+		db.Close()
+		os.Exit(1)
+	}
+	log.Infof("Import complete. Imported: %d, Skipped (already present): %d", imported, skipped)
+}
+
+// findPrunableEntries returns the downloaded entries whose model file is missing.
+// Entries that were never downloaded (pending or failed) have no file to check.
+func findPrunableEntries(db *database.DB) ([]dbEntryRow, error) {
+	return collectDbEntries(db, func(entry models.DatabaseEntry) bool {
+		if entry.Status != models.StatusDownloaded || entry.Filename == "" {
+			return false
+		}
+		_, err := os.Stat(dbEntryFilePath(entry))
+		return os.IsNotExist(err)
+	})
+}
+
+func runDbPrune(cmd *cobra.Command, args []string) {
+	if globalConfig.DatabasePath == "" {
+		log.Fatal("Database path is not set in the configuration. Please check config file or path.")
+	}
+	inferDbSavePath()
+
+	db, err := database.Open(globalConfig.DatabasePath)
+	if err != nil {
+		log.WithError(err).Fatalf("Failed to open database at %s", globalConfig.DatabasePath)
+	}
+	defer db.Close()
+
+	rows, err := findPrunableEntries(db)
+	if err != nil {
+		log.WithError(err).Fatal("Error occurred during database scan (Fold)")
+	}
+	if len(rows) == 0 {
+		log.Info("All downloaded files are present, nothing to prune.")
+		return
+	}
+
+	fmt.Printf("%d database entries refer to files that no longer exist:\n", len(rows))
+	for _, row := range rows {
+		fmt.Printf("  v_%s  %s - %s  (%s)\n", row.VersionID, row.Entry.ModelName, row.Entry.Version.Name, dbEntryFilePath(row.Entry))
+	}
+	if !viper.GetBool("db.prune.yes") {
+		fmt.Print("Remove these entries? (y/N): ")
+		input, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(strings.ToLower(input)) != "y" {
+			log.Info("Prune cancelled.")
+			return
+		}
+	}
+
+	pruned := 0
+	for _, row := range rows {
+		dbKey := "v_" + row.VersionID
+		if err := db.Delete([]byte(dbKey)); err != nil {
+			log.WithError(err).Errorf("Failed to delete database entry %s", dbKey)
+			continue
 		}
+		pruned++
+	}
+	log.Infof("Pruned %d of %d entries with missing files.", pruned, len(rows))
+}
+
+// orphanModelExts are the extensions db orphans treats as model files.
+var orphanModelExts = map[string]bool{
+	".safetensors": true, ".ckpt": true, ".pt": true, ".pth": true, ".bin": true, ".gguf": true,
+}
+
+// findOrphanedFiles returns the model files below savePath that no version entry in
+// db refers to. A file is known if an entry has its filename, or, for files not known
+// by name, its SHA256 hash. Originals kept by --convert --keep-original
+// ("<name>.original<ext>") count as known when "<name><ext>" is.
+func findOrphanedFiles(db *database.DB, savePath string) ([]string, error) {
+	knownNames := make(map[string]bool)
+	knownHashes := make(map[string]bool)
+	rows, err := collectDbEntries(db, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if row.Entry.Filename != "" {
+			knownNames[strings.ToLower(row.Entry.Filename)] = true
+		}
+		if sha := row.Entry.File.Hashes.SHA256; sha != "" {
+			knownHashes[strings.ToUpper(sha)] = true
+		}
+	}
+
+	var orphans []string
+	walkErr := filepath.WalkDir(savePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Warnf("Error accessing path %q during scan: %v", path, err)
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		name := strings.ToLower(d.Name())
+		ext := filepath.Ext(name)
+		if !orphanModelExts[ext] {
+			return nil
+		}
+		if knownNames[name] || knownNames[strings.TrimSuffix(name, ".original"+ext)+ext] {
+			return nil
+		}
+		if len(knownHashes) > 0 {
+			sum, hashErr := helpers.FileHash(path, "sha256")
+			if hashErr != nil {
+				log.WithError(hashErr).Warnf("Could not hash %s, treating it as orphaned", path)
+			} else if knownHashes[sum] {
+				return nil
+			}
+		}
+		orphans = append(orphans, path)
 		return nil
 	})
+	return orphans, walkErr
+}
+
+func runDbOrphans(cmd *cobra.Command, args []string) {
+	if globalConfig.DatabasePath == "" {
+		log.Fatal("Database path is not set in the configuration. Please check config file or path.")
+	}
+	inferDbSavePath()
+
+	db, err := database.Open(globalConfig.DatabasePath)
+	if err != nil {
+		log.WithError(err).Fatalf("Failed to open database at %s", globalConfig.DatabasePath)
+	}
+	defer db.Close()
+
+	log.Infof("Scanning %s for model files without a database entry...", globalConfig.SavePath)
+	orphans, err := findOrphanedFiles(db, globalConfig.SavePath)
+	if err != nil {
+		log.WithError(err).Fatal("Error scanning for orphaned files")
+	}
+	if len(orphans) == 0 {
+		log.Info("No orphaned model files found.")
+		return
+	}
+
+	var totalSize int64
+	for _, path := range orphans {
+		var size int64
+		if info, statErr := os.Stat(path); statErr == nil {
+			size = info.Size()
+		}
+		totalSize += size
+		fmt.Printf("  %s  (%s)\n", path, helpers.BytesToSize(uint64(size)))
+	}
+	fmt.Printf("%d orphaned model file(s), %s in total.\n", len(orphans), helpers.BytesToSize(uint64(totalSize)))
+
+	if !viper.GetBool("db.orphans.delete") {
+		return
+	}
+	if !viper.GetBool("db.orphans.yes") {
+		fmt.Print("Delete these files? (y/N): ")
+		input, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(strings.ToLower(input)) != "y" {
+			log.Info("Deletion cancelled.")
+			return
+		}
+	}
+	deleted := 0
+	for _, path := range orphans {
+		if removeErr := os.Remove(path); removeErr != nil {
+			log.WithError(removeErr).Errorf("Failed to delete %s", path)
+			continue
+		}
+		deleted++
+	}
+	log.Infof("Deleted %d of %d orphaned files.", deleted, len(orphans))
+}
+
+// relocatePath rewrites p if it is oldRoot or lies below it. It reports whether p was changed.
+func relocatePath(p string, oldRoot string, newRoot string) (string, bool) {
+	if p == "" {
+		return p, false
+	}
+	cleaned := filepath.Clean(p)
+	if cleaned == oldRoot {
+		return newRoot, true
+	}
+	if strings.HasPrefix(cleaned, oldRoot+string(filepath.Separator)) {
+		return filepath.Join(newRoot, strings.TrimPrefix(cleaned, oldRoot+string(filepath.Separator))), true
+	}
+	return p, false
+}
 
+func runDbRelocate(cmd *cobra.Command, args []string) {
+	oldFlag := viper.GetString("db.relocate.old")
+	newFlag := viper.GetString("db.relocate.new")
+	allowMissing := viper.GetBool("db.relocate.allowmissing")
+	if oldFlag == "" || newFlag == "" {
+		log.Fatal("Both --old and --new must be provided.")
+	}
+	oldRoot, err := filepath.Abs(oldFlag)
+	if err != nil {
+		log.WithError(err).Fatalf("Invalid --old path %s", oldFlag)
+	}
+	newRoot, err := filepath.Abs(newFlag)
+	if err != nil {
+		log.WithError(err).Fatalf("Invalid --new path %s", newFlag)
+	}
+	if info, statErr := os.Stat(newRoot); statErr != nil || !info.IsDir() {
+		log.Fatalf("New directory %s does not exist or is not a directory.", newRoot)
+	}
+	if oldRoot == newRoot {
+		log.Fatal("--old and --new point to the same directory, nothing to do.")
+	}
+
+	if globalConfig.DatabasePath == "" {
+		log.Fatal("Database path is not set in the configuration. Please check config file or path.")
+	}
+	db, err := database.Open(globalConfig.DatabasePath)
+	if err != nil {
+		log.WithError(err).Fatalf("Failed to open database at %s", globalConfig.DatabasePath)
+	}
+	defer db.Close()
+
+	var missing []string
+	checkExists := func(path string) {
+		if _, statErr := os.Stat(path); statErr != nil {
+			missing = append(missing, path)
+		}
+	}
+
+	// --- Database entries ---
+	// Folder is normally relative to SavePath and only needs rewriting when absolute,
+	// but downloaded files are checked at the new location either way.
+	rows, errFold := collectDbEntries(db, nil)
 	if errFold != nil {
-		log.WithError(errFold).Error("Error occurred during database scan (Fold)")
+		log.WithError(errFold).Fatal("Error scanning database")
+	}
+	var changedRows []dbEntryRow
+	for _, row := range rows {
+		entry := row.Entry
+		var newFilePath string
+		if filepath.IsAbs(entry.Folder) {
+			newFolder, changed := relocatePath(entry.Folder, oldRoot, newRoot)
+			if !changed {
+				continue
+			}
+			entry.Folder = newFolder
+			changedRows = append(changedRows, dbEntryRow{VersionID: row.VersionID, Entry: entry})
+			newFilePath = filepath.Join(newFolder, entry.VersionDir, entry.Filename)
+		} else {
+			newFilePath = filepath.Join(newRoot, entry.Folder, entry.VersionDir, entry.Filename)
+		}
+		if entry.Status == models.StatusDownloaded {
+			checkExists(newFilePath)
+		}
 	}
 
-	if err := tw.Flush(); err != nil {
-		log.WithError(err).Error("Error flushing table writer for db search")
+	// --- Bleve index items ---
+	indexPath := viper.GetString("db.relocate.indexpath")
+	if indexPath == "" {
+		indexPath = viper.GetString("bleveindexpath")
 	}
-	log.Infof("Found %d matching entries for query '%s'.", matchCount, searchTerm)
+	if indexPath == "" {
+		indexPath = filepath.Join(newRoot, "civitai.bleve")
+	}
+	var changedItems []index.Item
+	bleveIndex, err := bleve.Open(indexPath)
+	if err != nil {
+		log.WithError(err).Warnf("Could not open Bleve index at %s, only the database will be updated.", indexPath)
+		bleveIndex = nil
+	} else {
+		defer func() {
+			if closeErr := bleveIndex.Close(); closeErr != nil {
+				log.WithError(closeErr).Error("Error closing Bleve index")
+			}
+		}()
+		items, itemsErr := index.AllItems(bleveIndex)
+		if itemsErr != nil {
+			log.WithError(itemsErr).Fatalf("Failed to read items from Bleve index at %s", indexPath)
+		}
+		for _, item := range items {
+			changed := false
+			for _, field := range []*string{&item.FilePath, &item.DirectoryPath, &item.BaseModelPath, &item.ModelPath, &item.TorrentPath} {
+				if newPath, ok := relocatePath(*field, oldRoot, newRoot); ok {
+					*field = newPath
+					changed = true
+				}
+			}
+			if !changed {
+				continue
+			}
+			if item.FilePath != "" {
+				checkExists(item.FilePath)
+			} else if item.DirectoryPath != "" {
+				checkExists(item.DirectoryPath)
+			}
+			changedItems = append(changedItems, item)
+		}
+	}
+
+	log.Infof("Relocating %s -> %s: %d database entries and %d index items to rewrite.", oldRoot, newRoot, len(changedRows), len(changedItems))
+	if len(missing) > 0 {
+		for i, path := range missing {
+			if i == 10 {
+				log.Warnf("... and %d more", len(missing)-i)
+				break
+			}
+			log.Warnf("Not found at new location: %s", path)
+		}
+		if !allowMissing {
+			log.Fatalf("%d paths do not exist under %s. Nothing was changed; check the paths or use --allow-missing.", len(missing), newRoot)
+		}
+	}
+
+	var dbErrors, indexErrors int
+	for _, row := range changedRows {
+		data, marshalErr := json.Marshal(row.Entry)
+		if marshalErr == nil {
+			marshalErr = db.Put([]byte("v_"+row.VersionID), data)
+		}
+		if marshalErr != nil {
+			log.WithError(marshalErr).Errorf("Failed to update database entry v_%s", row.VersionID)
+			dbErrors++
+		}
+	}
+	for _, item := range changedItems {
+		if indexErr := index.IndexItem(bleveIndex, item); indexErr != nil {
+			log.WithError(indexErr).Errorf("Failed to update index item %s", item.ID)
+			indexErrors++
+		}
+	}
+
+	log.Infof("Relocation complete. Database entries updated: %d (failed: %d), index items updated: %d (failed: %d).",
+		len(changedRows)-dbErrors, dbErrors, len(changedItems)-indexErrors, indexErrors)
+	if _, changed := relocatePath(globalConfig.SavePath, oldRoot, newRoot); changed || globalConfig.SavePath == "" {
+		log.Infof("Remember to set SavePath (and BleveIndexPath/DatabasePath if they moved) to the new location in your config: %s", newRoot)
+	}
+}
+
+// purgePaths returns the files and directories db purge deletes for the entry. The
+// version directory holding the file is removed as a whole unless another entry's
+// file lives in it too (or it is not a proper subdirectory of SavePath); then only
+// this version's file and metadata are removed.
+func purgePaths(db *database.DB, entry models.DatabaseEntry, versionID int) ([]string, error) {
+	if entry.Folder == "" {
+		return nil, nil
+	}
+	filePath := dbEntryFilePath(entry)
+	versionDir := filepath.Dir(filePath)
+	rows, err := collectDbEntries(db, func(other models.DatabaseEntry) bool {
+		return other.Folder != "" && filepath.Dir(dbEntryFilePath(other)) == versionDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	sharedWith := ""
+	for _, row := range rows {
+		if row.VersionID != strconv.Itoa(versionID) {
+			sharedWith = "v_" + row.VersionID
+			break
+		}
+	}
+	rel, relErr := filepath.Rel(globalConfig.SavePath, versionDir)
+	insideSavePath := relErr == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+
+	var paths []string
+	if sharedWith == "" && insideSavePath {
+		if _, statErr := os.Stat(versionDir); statErr == nil {
+			paths = append(paths, versionDir)
+		}
+		return paths, nil
+	}
+	if sharedWith != "" {
+		log.Warnf("Directory %s is shared with %s, only this version's files will be removed.", versionDir, sharedWith)
+	} else {
+		log.Warnf("Directory %s is not inside SavePath, only this version's files will be removed.", versionDir)
+	}
+	if entry.Filename != "" {
+		metaPath := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".json"
+		for _, p := range []string{filePath, metaPath} {
+			if _, statErr := os.Stat(p); statErr == nil {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths, nil
+}
+
+func runDbPurge(cmd *cobra.Command, args []string) {
+	versionID, err := strconv.Atoi(args[0])
+	if err != nil || versionID <= 0 {
+		log.Fatalf("Invalid Model Version ID %q", args[0])
+	}
+	dbKey := fmt.Sprintf("v_%d", versionID)
+
+	if globalConfig.DatabasePath == "" {
+		log.Fatal("Database path is not set in the configuration. Please check config file or path.")
+	}
+	if globalConfig.SavePath == "" {
+		log.Fatal("Save path is not set in the configuration. Please check config file or path.")
+	}
+	db, err := database.Open(globalConfig.DatabasePath)
+	if err != nil {
+		log.WithError(err).Fatalf("Failed to open database at %s", globalConfig.DatabasePath)
+	}
+	defer db.Close()
+
+	value, err := db.Get([]byte(dbKey))
+	if errors.Is(err, database.ErrNotFound) {
+		log.Fatalf("No database entry found for Model Version ID %d (Key: %s)", versionID, dbKey)
+	} else if err != nil {
+		log.WithError(err).Fatalf("Failed to retrieve database entry for key %s", dbKey)
+	}
+	var entry models.DatabaseEntry
+	if err := json.Unmarshal(value, &entry); err != nil {
+		log.WithError(err).Fatalf("Failed to unmarshal database entry for key %s", dbKey)
+	}
+
+	// --- Work out what to delete ---
+	paths, err := purgePaths(db, entry, versionID)
+	if err != nil {
+		log.WithError(err).Fatal("Error scanning database")
+	}
+
+	indexPath := viper.GetString("bleveindexpath")
+	if indexPath == "" {
+		indexPath = filepath.Join(globalConfig.SavePath, "civitai.bleve")
+	}
+	bleveIndex, err := bleve.Open(indexPath)
+	if err != nil {
+		log.WithError(err).Warnf("Could not open Bleve index at %s, the index item will not be removed.", indexPath)
+		bleveIndex = nil
+	} else {
+		defer func() {
+			if closeErr := bleveIndex.Close(); closeErr != nil {
+				log.WithError(closeErr).Error("Error closing Bleve index")
+			}
+		}()
+		if doc, docErr := bleveIndex.Document(dbKey); docErr != nil || doc == nil {
+			bleveIndex = nil // Nothing indexed for this version
+		}
+	}
+
+	// --- Show and confirm ---
+	fmt.Printf("Purging %s - %s (version %d):\n", entry.ModelName, entry.Version.Name, versionID)
+	for _, p := range paths {
+		fmt.Printf("  delete %s\n", p)
+	}
+	if len(paths) == 0 {
+		fmt.Println("  (no files found on disk)")
+	}
+	fmt.Printf("  remove database entry %s\n", dbKey)
+	if bleveIndex != nil {
+		fmt.Printf("  remove index item %s from %s\n", dbKey, indexPath)
+	}
+
+	if !viper.GetBool("db.purge.yes") {
+		fmt.Print("Proceed? (y/N): ")
+		input, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(strings.ToLower(input)) != "y" {
+			log.Info("Purge cancelled.")
+			return
+		}
+	}
+
+	// --- Delete ---
+	failed := false
+	for _, p := range paths {
+		if removeErr := os.RemoveAll(p); removeErr != nil {
+			log.WithError(removeErr).Errorf("Failed to delete %s", p)
+			failed = true
+		}
+	}
+	if failed {
+		log.Fatalf("Not all files of version %d could be deleted; the database entry and index item were kept.", versionID)
+	}
+	if err := db.Delete([]byte(dbKey)); err != nil {
+		log.WithError(err).Fatalf("Failed to delete database entry %s", dbKey)
+	}
+	if bleveIndex != nil {
+		if err := bleveIndex.Delete(dbKey); err != nil {
+			log.WithError(err).Errorf("Failed to delete index item %s", dbKey)
+		}
+	}
+	log.Infof("Purged version %d: %d path(s) deleted, database entry removed.", versionID, len(paths))
 }
@@ -0,0 +1,540 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"go-civitai-download/internal/database"
+	"go-civitai-download/internal/models"
+)
+
+// foldDbValues returns all key/value pairs in db, with values decompressed by Fold.
+func foldDbValues(t *testing.T, db *database.DB) map[string]string {
+	t.Helper()
+	values := make(map[string]string)
+	if err := db.Fold(func(key []byte, value []byte) error {
+		values[string(key)] = string(value)
+		return nil
+	}); err != nil {
+		t.Fatalf("Fold() error = %v", err)
+	}
+	return values
+}
+
+// TestDbExportImportRoundTrip exports a database to JSON, imports it into a fresh
+// database and checks both hold the same entries.
+func TestDbExportImportRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src, err := database.Open(filepath.Join(dir, "src.db"))
+	if err != nil {
+		t.Fatalf("opening source db: %v", err)
+	}
+	defer src.Close()
+
+	for i := 1; i <= 3; i++ {
+		entry := models.DatabaseEntry{
+			ModelName: fmt.Sprintf("Model %d", i),
+			ModelType: "LORA",
+			Filename:  fmt.Sprintf("model_%d.safetensors", i),
+			Folder:    fmt.Sprintf("lora/model-%d/sdxl-1.0", i),
+			Status:    models.StatusDownloaded,
+			Version:   models.ModelVersion{ID: 100 + i, Name: "v1", BaseModel: "SDXL 1.0"},
+			File:      models.File{ID: i, SizeKB: float64(i * 1024)},
+		}
+		entryBytes, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatalf("marshalling entry: %v", err)
+		}
+		if err := src.Put([]byte(fmt.Sprintf("v_%d", entry.Version.ID)), entryBytes); err != nil {
+			t.Fatalf("storing entry: %v", err)
+		}
+	}
+	// Non-version keys are not exported
+	if err := src.SetPageState("query", 3); err != nil {
+		t.Fatalf("SetPageState() error = %v", err)
+	}
+
+	rows, err := collectDbEntries(src, nil)
+	if err != nil {
+		t.Fatalf("collectDbEntries() error = %v", err)
+	}
+	var export bytes.Buffer
+	if err := writeDbEntriesJSON(&export, rows); err != nil {
+		t.Fatalf("writeDbEntriesJSON() error = %v", err)
+	}
+
+	dst, err := database.Open(filepath.Join(dir, "dst.db"))
+	if err != nil {
+		t.Fatalf("opening destination db: %v", err)
+	}
+	defer dst.Close()
+	imported, skipped, err := importDbEntries(dst, bytes.NewReader(export.Bytes()), false)
+	if err != nil {
+		t.Fatalf("importDbEntries() error = %v", err)
+	}
+	if imported != 3 || skipped != 0 {
+		t.Errorf("imported %d, skipped %d; want 3, 0", imported, skipped)
+	}
+
+	want := foldDbValues(t, src)
+	delete(want, "current_page_query")
+	if got := foldDbValues(t, dst); !reflect.DeepEqual(got, want) {
+		t.Errorf("imported database differs from the source:\n got %v\nwant %v", got, want)
+	}
+
+	// A second import skips everything unless --overwrite is set
+	if imported, skipped, err = importDbEntries(dst, bytes.NewReader(export.Bytes()), false); err != nil || imported != 0 || skipped != 3 {
+		t.Errorf("re-import = %d imported, %d skipped, err %v; want 0, 3, nil", imported, skipped, err)
+	}
+	if imported, skipped, err = importDbEntries(dst, bytes.NewReader(export.Bytes()), true); err != nil || imported != 3 || skipped != 0 {
+		t.Errorf("re-import with overwrite = %d imported, %d skipped, err %v; want 3, 0, nil", imported, skipped, err)
+	}
+}
+
+// TestDbImportRejectsMissingVersionID checks that nothing is written when an entry
+// has no version ID.
+func TestDbImportRejectsMissingVersionID(t *testing.T) {
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("opening db: %v", err)
+	}
+	defer db.Close()
+
+	input := `[{"modelName": "Good", "version": {"id": 1}}, {"modelName": "Bad", "version": {"id": 0}}]`
+	if _, _, err := importDbEntries(db, bytes.NewReader([]byte(input)), false); err == nil {
+		t.Fatal("importDbEntries() accepted an entry without a version ID")
+	}
+	if got := foldDbValues(t, db); len(got) != 0 {
+		t.Errorf("database has %d entries after a rejected import, want 0", len(got))
+	}
+}
+
+// TestFindPrunableEntries checks that only downloaded entries whose file is missing
+// are selected for db prune.
+func TestFindPrunableEntries(t *testing.T) {
+	savePath := t.TempDir()
+	oldSavePath := globalConfig.SavePath
+	globalConfig.SavePath = savePath
+	defer func() { globalConfig.SavePath = oldSavePath }()
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("opening db: %v", err)
+	}
+	defer db.Close()
+
+	if err := os.MkdirAll(filepath.Join(savePath, "lora", "kept"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(savePath, "lora", "kept", "kept.safetensors"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Downloads store the model folder, the file lives in a version directory below it
+	if err := os.MkdirAll(filepath.Join(savePath, "lora", "nested", "4-nested"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(savePath, "lora", "nested", "4-nested", "nested.safetensors"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	entries := []models.DatabaseEntry{
+		{Folder: "lora/kept", Filename: "kept.safetensors", Status: models.StatusDownloaded, Version: models.ModelVersion{ID: 1}},
+		{Folder: "lora/gone", Filename: "gone.safetensors", Status: models.StatusDownloaded, Version: models.ModelVersion{ID: 2}},
+		{Folder: "lora/pending", Filename: "pending.safetensors", Status: models.StatusPending, Version: models.ModelVersion{ID: 3}},
+		{Folder: "lora/nested", Filename: "nested.safetensors", Status: models.StatusDownloaded, Version: models.ModelVersion{ID: 4}},
+	}
+	for _, entry := range entries {
+		entryBytes, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put([]byte(fmt.Sprintf("v_%d", entry.Version.ID)), entryBytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := findPrunableEntries(db)
+	if err != nil {
+		t.Fatalf("findPrunableEntries() error = %v", err)
+	}
+	if len(rows) != 1 || rows[0].VersionID != "2" {
+		t.Errorf("findPrunableEntries() = %+v, want only version 2", rows)
+	}
+}
+
+// TestFindOrphanedFiles builds a download tree with one file known to the database
+// and one that is not, and checks only the latter is reported.
+func TestFindOrphanedFiles(t *testing.T) {
+	savePath := t.TempDir()
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("opening db: %v", err)
+	}
+	defer db.Close()
+
+	known := filepath.Join(savePath, "lora", "known", "known.safetensors")
+	orphan := filepath.Join(savePath, "lora", "aborted", "orphan.safetensors")
+	metadata := filepath.Join(savePath, "lora", "aborted", "orphan.json")
+	for _, path := range []string{known, orphan, metadata} {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(path), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry := models.DatabaseEntry{Folder: "lora/known", Filename: "known.safetensors", Status: models.StatusDownloaded, Version: models.ModelVersion{ID: 1}}
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("v_1"), entryBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	orphans, err := findOrphanedFiles(db, savePath)
+	if err != nil {
+		t.Fatalf("findOrphanedFiles() error = %v", err)
+	}
+	if len(orphans) != 1 || orphans[0] != orphan {
+		t.Errorf("findOrphanedFiles() = %v, want [%s]", orphans, orphan)
+	}
+}
+
+// TestPurgePaths keeps two versions of one model in the same model folder: purging
+// one must remove only its own version directory, not the folder holding both.
+func TestPurgePaths(t *testing.T) {
+	savePath := t.TempDir()
+	oldSavePath := globalConfig.SavePath
+	globalConfig.SavePath = savePath
+	defer func() { globalConfig.SavePath = oldSavePath }()
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("opening db: %v", err)
+	}
+	defer db.Close()
+
+	folder := filepath.Join("lora", "model", "sdxl_1.0")
+	entries := []models.DatabaseEntry{
+		{Folder: folder, VersionDir: "1-model", Filename: "model.safetensors", Version: models.ModelVersion{ID: 1}},
+		{Folder: folder, VersionDir: "2-model", Filename: "model.safetensors", Version: models.ModelVersion{ID: 2}},
+		// Two older entries without a version directory share the model folder
+		{Folder: filepath.Join("lora", "old"), Filename: "a.safetensors", Version: models.ModelVersion{ID: 3}},
+		{Folder: filepath.Join("lora", "old"), Filename: "b.safetensors", Version: models.ModelVersion{ID: 4}},
+	}
+	for _, entry := range entries {
+		path := filepath.Join(savePath, entry.Folder, entry.VersionDir, entry.Filename)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		entryBytes, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put([]byte(fmt.Sprintf("v_%d", entry.Version.ID)), entryBytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		entry models.DatabaseEntry
+		want  []string
+	}{
+		{entries[0], []string{filepath.Join(savePath, folder, "1-model")}},
+		{entries[1], []string{filepath.Join(savePath, folder, "2-model")}},
+		{entries[2], []string{filepath.Join(savePath, "lora", "old", "a.safetensors")}},
+	}
+	for _, tt := range tests {
+		got, err := purgePaths(db, tt.entry, tt.entry.Version.ID)
+		if err != nil {
+			t.Fatalf("purgePaths(v_%d): %v", tt.entry.Version.ID, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("purgePaths(v_%d) = %v, want %v", tt.entry.Version.ID, got, tt.want)
+		}
+	}
+}
+
+// TestDbEntryFilePath lays out two versions of a model that both ship model.safetensors
+// and checks each entry resolves to its own file, never to the other version's.
+func TestDbEntryFilePath(t *testing.T) {
+	oldSavePath := globalConfig.SavePath
+	globalConfig.SavePath = t.TempDir()
+	defer func() { globalConfig.SavePath = oldSavePath }()
+
+	folder := filepath.Join("lora", "model", "sdxl_1.0")
+	for _, dir := range []string{"10-model", "11-model"} {
+		path := filepath.Join(globalConfig.SavePath, folder, dir, "model.safetensors")
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(dir), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name  string
+		entry models.DatabaseEntry
+		want  string // Relative to the folder
+	}{
+		{"recorded version dir", models.DatabaseEntry{Folder: folder, VersionDir: "11-model", Filename: "model.safetensors", Version: models.ModelVersion{ID: 11}}, filepath.Join("11-model", "model.safetensors")},
+		{"older entry, dir named after the version", models.DatabaseEntry{Folder: folder, Filename: "model.safetensors", Version: models.ModelVersion{ID: 10}}, filepath.Join("10-model", "model.safetensors")},
+		{"older entry without its own dir", models.DatabaseEntry{Folder: folder, Filename: "model.safetensors", Version: models.ModelVersion{ID: 12}}, "model.safetensors"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := filepath.Join(globalConfig.SavePath, folder, tt.want)
+			if got := dbEntryFilePath(tt.entry); got != want {
+				t.Errorf("dbEntryFilePath() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// TestDbStats seeds entries of different types, base models and statuses and checks
+// the aggregates printed by db stats.
+func TestDbStats(t *testing.T) {
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("opening db: %v", err)
+	}
+	defer db.Close()
+
+	entries := []models.DatabaseEntry{
+		{ModelType: "LORA", Status: models.StatusDownloaded, Version: models.ModelVersion{ID: 1, BaseModel: "SDXL 1.0"}, File: models.File{SizeKB: 1024}},
+		{ModelType: "LORA", Status: models.StatusDownloaded, Version: models.ModelVersion{ID: 2, BaseModel: "SD 1.5"}, File: models.File{SizeKB: 1024}},
+		{ModelType: "Checkpoint", Status: models.StatusPending, Version: models.ModelVersion{ID: 3, BaseModel: "SDXL 1.0"}, File: models.File{SizeKB: 2048}},
+		{ModelType: "Checkpoint", Status: models.StatusError, ErrorCategory: "not_found", Version: models.ModelVersion{ID: 4}},
+	}
+	for _, entry := range entries {
+		entryBytes, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put([]byte(fmt.Sprintf("v_%d", entry.Version.ID)), entryBytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Non-entry keys such as saved cursors are not counted
+	if err := db.SetModelsCursor("query", "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := collectDbEntries(db, nil)
+	if err != nil {
+		t.Fatalf("collectDbEntries() error = %v", err)
+	}
+	var out bytes.Buffer
+	if err := newDbStats(rows).write(&out); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(out.String(), "\n") {
+		lines[strings.Join(strings.Fields(line), " ")] = true
+	}
+	for _, want := range []string{
+		"Downloaded 2", "Pending 1", "Error 1", "Total 4",
+		"LORA 2", "Checkpoint 2",
+		"SDXL 1.0 2", "SD 1.5 1", "unknown 1",
+		"Total files 4", "Total size 4.00MB",
+		"not_found 1 systematic",
+	} {
+		if !lines[want] {
+			t.Errorf("db stats output is missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// TestRepairMetadataSidecar checks --repair-metadata overwrites a corrupted sidecar
+// with valid JSON of the version stored in the database.
+func TestRepairMetadataSidecar(t *testing.T) {
+	dir := t.TempDir()
+	modelPath := filepath.Join(dir, "123_model.safetensors")
+	sidecarPath := filepath.Join(dir, "123_model.json")
+	if err := os.WriteFile(sidecarPath, []byte(`{"id": 123, "name": `), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := models.DatabaseEntry{Version: models.ModelVersion{ID: 123, Name: "v1.0"}}
+	path, err := repairMetadataSidecar(entry, modelPath)
+	if err != nil {
+		t.Fatalf("repairMetadataSidecar() error = %v", err)
+	}
+	if path != sidecarPath {
+		t.Errorf("repairMetadataSidecar() path = %s, want %s", path, sidecarPath)
+	}
+	data, err := os.ReadFile(sidecarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var version models.ModelVersion
+	if err := json.Unmarshal(data, &version); err != nil {
+		t.Fatalf("sidecar is not valid JSON after the repair: %v", err)
+	}
+	if version.ID != 123 || version.Name != "v1.0" {
+		t.Errorf("sidecar version = %d %q, want 123 \"v1.0\"", version.ID, version.Name)
+	}
+
+	missingDir := filepath.Join(dir, "moved")
+	if _, err := repairMetadataSidecar(entry, filepath.Join(missingDir, "123_model.safetensors")); err == nil {
+		t.Error("repairMetadataSidecar() succeeded for a file in a missing directory, want an error")
+	}
+	if _, err := os.Stat(missingDir); !os.IsNotExist(err) {
+		t.Errorf("repairMetadataSidecar() created %s (stat error %v)", missingDir, err)
+	}
+}
+
+// TestFindCorruptImages checks a valid PNG passes while a truncated and an empty one
+// are reported, and that sidecars and a missing directory are ignored.
+func TestFindCorruptImages(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"1-valid.png":     buf.Bytes(),
+		"2-truncated.png": buf.Bytes()[:12],
+		"3-empty.jpeg":    nil,
+		"1-valid.json":    []byte("{}"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	corrupt, err := findCorruptImages(dir)
+	if err != nil {
+		t.Fatalf("findCorruptImages() error = %v", err)
+	}
+	var got []string
+	for _, bad := range corrupt {
+		got = append(got, filepath.Base(bad.Path))
+	}
+	want := []string{"2-truncated.png", "3-empty.jpeg"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findCorruptImages() = %v, want %v", got, want)
+	}
+
+	if corrupt, err := findCorruptImages(filepath.Join(dir, "missing")); err != nil || len(corrupt) != 0 {
+		t.Errorf("findCorruptImages() on a missing directory = %v, %v; want nothing", corrupt, err)
+	}
+}
+
+// TestDbSearchJSON seeds a database and checks the db search --json output is a valid
+// JSON array holding exactly the entries whose model name matches the query.
+func TestDbSearchJSON(t *testing.T) {
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("opening db: %v", err)
+	}
+	defer db.Close()
+
+	for id, name := range map[int]string{1: "Anime Style", 2: "Realistic Vision", 3: "anime lineart"} {
+		entryBytes, err := json.Marshal(models.DatabaseEntry{ModelName: name, Version: models.ModelVersion{ID: id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put([]byte(fmt.Sprintf("v_%d", id)), entryBytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := collectDbEntries(db, func(entry models.DatabaseEntry) bool {
+		return entryNameMatches(entry, "anime")
+	})
+	if err != nil {
+		t.Fatalf("collectDbEntries() error = %v", err)
+	}
+	var out bytes.Buffer
+	if err := writeDbEntriesJSON(&out, rows); err != nil {
+		t.Fatalf("writeDbEntriesJSON() error = %v", err)
+	}
+	var results []struct {
+		VersionID string `json:"versionId"`
+		ModelName string `json:"modelName"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+		t.Fatalf("db search --json output is not a JSON array: %v\n%s", err, out.String())
+	}
+	if len(results) != 2 {
+		t.Fatalf("db search --json returned %d entries, want 2: %+v", len(results), results)
+	}
+	for _, result := range results {
+		if !strings.Contains(strings.ToLower(result.ModelName), "anime") || result.VersionID == "2" {
+			t.Errorf("unexpected match %+v", result)
+		}
+	}
+}
+
+// TestDbSearchFields checks each db search --field mode only matches the query
+// against its own field, and "all" against every field.
+func TestDbSearchFields(t *testing.T) {
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("opening db: %v", err)
+	}
+	defer db.Close()
+
+	entries := []models.DatabaseEntry{
+		{ModelName: "Pony Diffusion", Creator: models.Creator{Username: "astra"}, Version: models.ModelVersion{ID: 1, BaseModel: "Pony"}, Filename: "1_pony_v6.safetensors"},
+		{ModelName: "Detail Tweaker", Creator: models.Creator{Username: "ponylover"}, Version: models.ModelVersion{ID: 2, BaseModel: "SD 1.5"}, Filename: "2_detail.safetensors"},
+		{ModelName: "Film Grain", Creator: models.Creator{Username: "astra"}, Version: models.ModelVersion{ID: 3, BaseModel: "SDXL 1.0"}, Filename: "3_grain_pony.safetensors"},
+		{ModelName: "Flat Colors", Creator: models.Creator{Username: "bob"}, Version: models.ModelVersion{ID: 4, BaseModel: "Pony"}, Filename: "4_flat.safetensors"},
+	}
+	for _, entry := range entries {
+		entryBytes, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put([]byte(fmt.Sprintf("v_%d", entry.Version.ID)), entryBytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		field string
+		query string
+		want  []string // Version IDs
+	}{
+		{"name", "pony", []string{"1"}},
+		{"creator", "pony", []string{"2"}},
+		{"basemodel", "pony", []string{"1", "4"}},
+		{"filename", "pony", []string{"1", "3"}},
+		{"all", "pony", []string{"1", "2", "3", "4"}},
+		{"creator", "ASTRA", []string{"1", "3"}},
+		{"unknown", "pony", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field+"/"+tt.query, func(t *testing.T) {
+			lowerTerm := strings.ToLower(tt.query)
+			rows, err := collectDbEntries(db, func(entry models.DatabaseEntry) bool {
+				return entryFieldMatches(entry, tt.field, lowerTerm)
+			})
+			if err != nil {
+				t.Fatalf("collectDbEntries() error = %v", err)
+			}
+			var got []string
+			for _, row := range rows {
+				got = append(got, row.VersionID)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("--field %s %q matched versions %v, want %v", tt.field, tt.query, got, tt.want)
+			}
+		})
+	}
+}
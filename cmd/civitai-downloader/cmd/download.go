@@ -2,11 +2,13 @@ package cmd
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"go-civitai-download/internal/api"
 	"go-civitai-download/internal/database"
 	"go-civitai-download/internal/downloader"
+	"go-civitai-download/internal/helpers"
 	"go-civitai-download/internal/models"
 	"net"
 	"net/http"
@@ -14,6 +16,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"text/tabwriter"
 	"time"
 
 	"github.com/blevesearch/bleve/v2"
@@ -45,6 +48,8 @@ func init() {
 	downloadCmd.Flags().IntP("concurrency", "c", 0, "Number of concurrent downloads (overrides config)")
 	// Bind the flag to Viper using the struct field name as the key
 	_ = viper.BindPFlag("concurrency", downloadCmd.Flags().Lookup("concurrency"))
+	downloadCmd.Flags().Int("image-concurrency", 0, "Number of concurrent image downloads for --model-images and --version-images (0 uses --concurrency, overrides config)")
+	_ = viper.BindPFlag("imageconcurrency", downloadCmd.Flags().Lookup("image-concurrency"))
 
 	// --- Query Parameter Flags (Mostly mirroring Config struct) ---
 	// Authentication
@@ -54,6 +59,8 @@ func init() {
 	// Filtering & Selection
 	downloadCmd.Flags().StringP("tag", "t", "", "Filter by specific tag name")
 	_ = viper.BindPFlag("tag", downloadCmd.Flags().Lookup("tag"))
+	downloadCmd.Flags().String("tag-filter-mode", "", "With comma-separated --tag values, run one query per tag and keep models matching 'any' or 'all' tags (more API calls)")
+	_ = viper.BindPFlag("tagfiltermode", downloadCmd.Flags().Lookup("tag-filter-mode"))
 	downloadCmd.Flags().StringP("query", "q", "", "Search query term (e.g., model name)")
 	_ = viper.BindPFlag("query", downloadCmd.Flags().Lookup("query"))
 	downloadCmd.Flags().StringSliceP("model-types", "m", []string{}, "Filter by model types (Checkpoint, LORA, etc.)")
@@ -64,46 +71,146 @@ func init() {
 	_ = viper.BindPFlag("username", downloadCmd.Flags().Lookup("username"))
 	downloadCmd.Flags().Bool("nsfw", false, "Include NSFW models (overrides config)")
 	_ = viper.BindPFlag("nsfw", downloadCmd.Flags().Lookup("nsfw"))
-	downloadCmd.Flags().IntP("limit", "l", 0, "Limit the number of models to download per query page (overrides config)")
+	downloadCmd.Flags().IntP("limit", "l", 0, "Maximum total number of models to process across all pages, 0 for no limit (overrides config)")
 	_ = viper.BindPFlag("limit", downloadCmd.Flags().Lookup("limit"))
+	downloadCmd.Flags().Int("page-size", 100, "Number of models to request per API page, 1-100 (overrides config)")
+	_ = viper.BindPFlag("pagesize", downloadCmd.Flags().Lookup("page-size"))
+	downloadCmd.Flags().Int("sample", 0, "Process a random sample of N models from all matching results instead of the first ones (0 disables)")
+	_ = viper.BindPFlag("sample", downloadCmd.Flags().Lookup("sample"))
+	downloadCmd.Flags().Int64("sample-seed", 0, "Random seed for --sample, to repeat a previous sample (0 picks a new seed)")
+	_ = viper.BindPFlag("sampleseed", downloadCmd.Flags().Lookup("sample-seed"))
 	downloadCmd.Flags().IntP("max-pages", "p", 0, "Maximum number of pages to process (0 for unlimited)")
 	_ = viper.BindPFlag("maxpages", downloadCmd.Flags().Lookup("max-pages"))
-	downloadCmd.Flags().String("sort", "", "Sort order (newest, oldest, highest_rated, etc. - overrides config)")
+	downloadCmd.Flags().String("sort", "", "Sort order: newest, most_downloaded or highest_rated, any case (overrides config)")
 	_ = viper.BindPFlag("sort", downloadCmd.Flags().Lookup("sort"))
-	downloadCmd.Flags().String("period", "", "Time period for sort (Day, Week, Month, Year, AllTime - overrides config)")
+	downloadCmd.Flags().String("period", "", "Time period for sort (Day, Week, Month, Year, AllTime, any case - overrides config)")
 	_ = viper.BindPFlag("period", downloadCmd.Flags().Lookup("period"))
+	downloadCmd.Flags().String("commercial-use", "", "Only models allowing this commercial use (Any, None, Image, Rent, Sell - overrides config)")
+	_ = viper.BindPFlag("allowcommercialuse", downloadCmd.Flags().Lookup("commercial-use"))
+	downloadCmd.Flags().Bool("favorites", false, "Only models you have favorited (requires an API key)")
+	_ = viper.BindPFlag("favorites", downloadCmd.Flags().Lookup("favorites"))
+	downloadCmd.Flags().Bool("hidden", false, "Only models you have hidden (requires an API key)")
+	_ = viper.BindPFlag("hidden", downloadCmd.Flags().Lookup("hidden"))
+	downloadCmd.Flags().Int("rating", 0, "Only models with at least this rating (1-5, 0 = any - overrides config)")
+	_ = viper.BindPFlag("rating", downloadCmd.Flags().Lookup("rating"))
 	downloadCmd.Flags().Int("model-id", 0, "Download only a specific model ID")
 	_ = viper.BindPFlag("modelid", downloadCmd.Flags().Lookup("model-id")) // Should match config struct field if exists
 	downloadCmd.Flags().Int("model-version-id", 0, "Download only a specific model version ID")
 	_ = viper.BindPFlag("modelversionid", downloadCmd.Flags().Lookup("model-version-id")) // Should match config struct field if exists
+	downloadCmd.Flags().Int("file-id", 0, "With --model-version-id, download only the file with this ID")
+	_ = viper.BindPFlag("fileid", downloadCmd.Flags().Lookup("file-id"))
+	downloadCmd.Flags().String("file-name", "", "With --model-version-id, download only the file with this name (case-insensitive)")
+	_ = viper.BindPFlag("filename", downloadCmd.Flags().Lookup("file-name"))
+	downloadCmd.Flags().Bool("stdin", false, "Read model IDs and v:<version ID> entries line by line from stdin and download each (implies --yes)")
+	_ = viper.BindPFlag("stdin", downloadCmd.Flags().Lookup("stdin"))
 
 	// File & Version Selection
 	downloadCmd.Flags().Bool("primary-only", false, "Only download the primary file for a version (overrides config)")
 	_ = viper.BindPFlag("primaryonly", downloadCmd.Flags().Lookup("primary-only"))
+	downloadCmd.Flags().Bool("recheck-primary", false, "With --primary-only, also drop files not marked primary from query results the API already filtered with primaryFileOnly")
+	_ = viper.BindPFlag("recheckprimary", downloadCmd.Flags().Lookup("recheck-primary"))
+	downloadCmd.Flags().String("file-select", "all", "Files to keep per version after filtering: all, smallest or largest (by size, overrides config)")
+	_ = viper.BindPFlag("fileselect", downloadCmd.Flags().Lookup("file-select"))
 	downloadCmd.Flags().Bool("pruned", false, "Prefer pruned models (overrides config)")
 	_ = viper.BindPFlag("pruned", downloadCmd.Flags().Lookup("pruned"))
 	downloadCmd.Flags().Bool("fp16", false, "Prefer fp16 models (overrides config)")
 	_ = viper.BindPFlag("fp16", downloadCmd.Flags().Lookup("fp16"))
+	downloadCmd.Flags().String("min-size", "", "Skip files smaller than this size, e.g. 500MB (overrides config)")
+	_ = viper.BindPFlag("minsizebytes", downloadCmd.Flags().Lookup("min-size"))
+	downloadCmd.Flags().String("max-size", "", "Skip files larger than this size, e.g. 2GB (overrides config)")
+	_ = viper.BindPFlag("maxsizebytes", downloadCmd.Flags().Lookup("max-size"))
+	downloadCmd.Flags().Bool("require-safe-scan", false, "Skip files whose virus or pickle scan did not return Success (overrides config)")
+	_ = viper.BindPFlag("requiresafescan", downloadCmd.Flags().Lookup("require-safe-scan"))
 	downloadCmd.Flags().Bool("all-versions", false, "Download all versions of a model, not just the latest (overrides config)")
 	_ = viper.BindPFlag("downloadallversions", downloadCmd.Flags().Lookup("all-versions"))
 	downloadCmd.Flags().StringSlice("ignore-base-models", []string{}, "Base models to ignore (comma-separated or multiple flags, overrides config)")
 	_ = viper.BindPFlag("ignorebasemodels", downloadCmd.Flags().Lookup("ignore-base-models"))
+	downloadCmd.Flags().StringSlice("exclude-tags", []string{}, "Skip models carrying any of these tags (case-insensitive, comma-separated or multiple flags, overrides config)")
+	_ = viper.BindPFlag("excludetags", downloadCmd.Flags().Lookup("exclude-tags"))
 	downloadCmd.Flags().StringSlice("ignore-filename-strings", []string{}, "Substrings in filenames to ignore (comma-separated or multiple flags, overrides config)")
 	_ = viper.BindPFlag("ignorefilenamestrings", downloadCmd.Flags().Lookup("ignore-filename-strings"))
+	downloadCmd.Flags().StringSlice("formats", []string{"SafeTensor"}, "File formats to download, e.g. SafeTensor,PickleTensor (case-insensitive, empty accepts all, overrides config)")
+	_ = viper.BindPFlag("formats", downloadCmd.Flags().Lookup("formats"))
 
 	// Saving & Behavior
 	downloadCmd.Flags().BoolP("yes", "y", false, "Skip confirmation prompt before downloading (overrides config)")
 	_ = viper.BindPFlag("skipconfirmation", downloadCmd.Flags().Lookup("yes"))
+	downloadCmd.Flags().String("report", "", "Write a JSON summary of the run (counts, errors and per-file status by version ID) to this file after the downloads finish")
+	_ = viper.BindPFlag("report", downloadCmd.Flags().Lookup("report"))
+	downloadCmd.Flags().Bool("dry-run", false, "Fetch and filter models as usual, then list the files that would be downloaded and their total size without writing to the database or downloading anything")
+	_ = viper.BindPFlag("dryrun", downloadCmd.Flags().Lookup("dry-run"))
+	downloadCmd.Flags().Bool("resume", false, "Continue paginating from the API cursor saved by a previous interrupted run of the same query")
+	_ = viper.BindPFlag("resume", downloadCmd.Flags().Lookup("resume"))
+	downloadCmd.Flags().Bool("continue", false, "Download the files left Pending in the database by an earlier run without querying the API (query filters, --model-id and --stdin are ignored)")
+	_ = viper.BindPFlag("continue", downloadCmd.Flags().Lookup("continue"))
 	downloadCmd.Flags().Bool("metadata", false, "Save model version metadata to a JSON file (overrides config)")
 	_ = viper.BindPFlag("savemetadata", downloadCmd.Flags().Lookup("metadata"))
+	downloadCmd.Flags().Bool("combined-metadata", false, "Write one .json sidecar per file with both model info (description, tags, license, creator) and version metadata (overrides config)")
+	_ = viper.BindPFlag("combinedmetadata", downloadCmd.Flags().Lookup("combined-metadata"))
+	downloadCmd.Flags().Bool("save-triggers", false, "Write the version's trained words (trigger keywords) comma-separated to a .trigger.txt file next to each downloaded file (overrides config)")
+	_ = viper.BindPFlag("savetriggers", downloadCmd.Flags().Lookup("save-triggers"))
 	downloadCmd.Flags().Bool("model-info", false, "Save model info (description, etc.) to a JSON file (overrides config)") // Renamed flag
 	_ = viper.BindPFlag("savemodelinfo", downloadCmd.Flags().Lookup("model-info"))
+	downloadCmd.Flags().Bool("server-filename", false, "Save files under the file name provided by Civitai as-is instead of a slugified name (the version ID is still prepended)")
+	_ = viper.BindPFlag("serverfilename", downloadCmd.Flags().Lookup("server-filename"))
+	downloadCmd.Flags().String("path-template", defaultPathTemplate, "Go template for each version's directory below the save path, ending in /{{.VersionDir}}; fields: ModelName, ModelType, BaseModel, VersionID, VersionName, VersionDir, Creator, FileName (overrides config)")
+	_ = viper.BindPFlag("pathtemplate", downloadCmd.Flags().Lookup("path-template"))
+	downloadCmd.Flags().String("version-dir-style", versionDirStyleIDSlug, "Naming of each version's directory ({{.VersionDir}}): id-slug, name, date or id (overrides config)")
+	_ = viper.BindPFlag("versiondirstyle", downloadCmd.Flags().Lookup("version-dir-style"))
+	downloadCmd.Flags().Bool("no-metadata-for-skipped", false, "Do not re-check metadata sidecars or rewrite unchanged DB entries for files that are already downloaded (overrides config)")
+	_ = viper.BindPFlag("nometadataforskipped", downloadCmd.Flags().Lookup("no-metadata-for-skipped"))
+	downloadCmd.Flags().Bool("skip-existing-by-hash", false, "Link files whose SHA256 matches an already downloaded file anywhere in the save path instead of downloading them again (hardlink, or symlink across filesystems; overrides config)")
+	_ = viper.BindPFlag("skipexistingbyhash", downloadCmd.Flags().Lookup("skip-existing-by-hash"))
+	downloadCmd.Flags().Bool("copy-config-to-output", false, "Save the effective configuration and query parameters of this run to run-config.json in the save path (overrides config)")
+	_ = viper.BindPFlag("copyconfigtooutput", downloadCmd.Flags().Lookup("copy-config-to-output"))
+	downloadCmd.Flags().Bool("save-version-list", false, "With --model-id, write versions.json to the model directory listing every available version and whether it is downloaded (overrides config)")
+	_ = viper.BindPFlag("saveversionlist", downloadCmd.Flags().Lookup("save-version-list"))
 	downloadCmd.Flags().Bool("version-images", false, "Save version preview images (overrides config)") // Renamed flag
 	_ = viper.BindPFlag("saveversionimages", downloadCmd.Flags().Lookup("version-images"))
 	downloadCmd.Flags().Bool("model-images", false, "Save model gallery images (overrides config)") // Renamed flag
 	_ = viper.BindPFlag("savemodelimages", downloadCmd.Flags().Lookup("model-images"))
+	downloadCmd.Flags().Bool("skip-complete-images", false, "Skip image directories marked complete by a previous run instead of checking each image file (overrides config)")
+	_ = viper.BindPFlag("skipcompleteimages", downloadCmd.Flags().Lookup("skip-complete-images"))
+	downloadCmd.Flags().Bool("force-images", false, "Ignore image completion markers and check every image again")
+	_ = viper.BindPFlag("forceimages", downloadCmd.Flags().Lookup("force-images"))
 	downloadCmd.Flags().Bool("meta-only", false, "Only download/update metadata files, skip model downloads (overrides config)") // Renamed flag
 	_ = viper.BindPFlag("downloadmetaonly", downloadCmd.Flags().Lookup("meta-only"))
+	downloadCmd.Flags().Bool("verbose-skips", false, "List the IDs of models skipped because they have no downloadable versions")
+	_ = viper.BindPFlag("verboseskips", downloadCmd.Flags().Lookup("verbose-skips"))
+	downloadCmd.Flags().Bool("skip-empty-versions", true, "Skip versions that have no files (metadata-only or removed uploads) before selecting versions to download (overrides config)")
+	_ = viper.BindPFlag("skipemptyversions", downloadCmd.Flags().Lookup("skip-empty-versions"))
+	downloadCmd.Flags().String("min-published-age", "", "Skip versions published less than this long ago, e.g. 3d, 2w or 36h (overrides config)")
+	_ = viper.BindPFlag("minpublishedage", downloadCmd.Flags().Lookup("min-published-age"))
+	downloadCmd.Flags().String("since", "", "Skip versions published before this date, e.g. 2024-01-01 or an RFC3339 timestamp (overrides config)")
+	_ = viper.BindPFlag("since", downloadCmd.Flags().Lookup("since"))
+	downloadCmd.Flags().Bool("with-vae", false, "Also download the recommended VAE for each checkpoint into the checkpoint's folder (overrides config)")
+	_ = viper.BindPFlag("withvae", downloadCmd.Flags().Lookup("with-vae"))
+	downloadCmd.Flags().Bool("follow-embeddings", false, "Also download negative embeddings (TextualInversion models) referenced by queued LORAs, best-effort (overrides config)")
+	_ = viper.BindPFlag("followembeddings", downloadCmd.Flags().Lookup("follow-embeddings"))
+	downloadCmd.Flags().Bool("normalize-extensions", false, "After download, rename model files whose extension does not match their detected format (overrides config)")
+	_ = viper.BindPFlag("normalizeextensions", downloadCmd.Flags().Lookup("normalize-extensions"))
+	downloadCmd.Flags().String("convert", "", "After download, convert full-precision .safetensors checkpoints to this precision (only fp16 is supported, overrides config)")
+	_ = viper.BindPFlag("convert", downloadCmd.Flags().Lookup("convert"))
+	downloadCmd.Flags().Bool("keep-original", false, "With --convert, keep the unconverted checkpoint as <name>.original.safetensors (overrides config)")
+	_ = viper.BindPFlag("keeporiginal", downloadCmd.Flags().Lookup("keep-original"))
+	downloadCmd.Flags().String("hash-algo", "", "Compute this hash (sha256 or blake3) after download when the API provides no strong hash, and store it for db verify (overrides config)")
+	_ = viper.BindPFlag("hashalgo", downloadCmd.Flags().Lookup("hash-algo"))
+	downloadCmd.Flags().Bool("hash-sidecar", false, "With --hash-algo, also write the hash to a <file>.sha256 or <file>.blake3 sidecar (overrides config)")
+	_ = viper.BindPFlag("hashsidecar", downloadCmd.Flags().Lookup("hash-sidecar"))
+	downloadCmd.Flags().Duration("ramp-up", 0, "Start download workers gradually, one every interval (e.g. 2s), instead of all at once (overrides config)")
+	_ = viper.BindPFlag("rampup", downloadCmd.Flags().Lookup("ramp-up"))
+	downloadCmd.Flags().Int64("min-free-space", 0, "Stop starting new downloads when free space on the save path would drop below this many MB (0 disables, overrides config)")
+	_ = viper.BindPFlag("minfreespacemb", downloadCmd.Flags().Lookup("min-free-space"))
+	downloadCmd.Flags().Int("retry-failed", 0, "After the downloads finish, retry the ones that failed up to this many times, with backoff (0 disables, overrides config)")
+	_ = viper.BindPFlag("retryfailed", downloadCmd.Flags().Lookup("retry-failed"))
+	downloadCmd.Flags().Duration("retry-failed-delay", 30*time.Second, "Wait before the first --retry-failed attempt, doubled for every further attempt (overrides config)")
+	_ = viper.BindPFlag("retryfaileddelay", downloadCmd.Flags().Lookup("retry-failed-delay"))
+	downloadCmd.Flags().Int("max-errors", 0, "Stop the batch and exit with an error once this many downloads have failed (0 disables, overrides config)")
+	_ = viper.BindPFlag("maxerrors", downloadCmd.Flags().Lookup("max-errors"))
+	downloadCmd.Flags().String("cache-dir", "", "Cache API metadata responses in this directory and revalidate them with ETag/Last-Modified (empty disables, overrides config)")
+	_ = viper.BindPFlag("cachedir", downloadCmd.Flags().Lookup("cache-dir"))
+	downloadCmd.Flags().Duration("cache-ttl", 0, "Serve cached metadata younger than this without contacting the API (0 always revalidates, overrides config)")
+	_ = viper.BindPFlag("cachettl", downloadCmd.Flags().Lookup("cache-ttl"))
 
 	// Debugging flags
 	downloadCmd.Flags().Bool("show-config", false, "Show the effective configuration values and exit")
@@ -127,13 +234,18 @@ func setupDownloadEnvironment(cmd *cobra.Command, cfg *models.Config) (db *datab
 			return
 		}
 	}
-	log.Infof("Opening database at: %s", dbPath)
-	db, err = database.Open(dbPath)
-	if err != nil {
-		err = fmt.Errorf("failed to open database: %w", err)
-		return
+	if _, statErr := os.Stat(dbPath); os.IsNotExist(statErr) && viper.GetBool("dryrun") {
+		// Opening would create it; a dry run treats every file as new instead
+		log.Infof("Dry run: no database at %s, all matching files count as new.", dbPath)
+	} else {
+		log.Infof("Opening database at: %s", dbPath)
+		db, err = database.Open(dbPath)
+		if err != nil {
+			err = fmt.Errorf("failed to open database: %w", err)
+			return
+		}
+		log.Info("Database opened successfully.")
 	}
-	log.Info("Database opened successfully.")
 
 	// --- Concurrency & Downloader Setup ---
 	// Get concurrency level using Viper (respects flag > config > default)
@@ -185,6 +297,32 @@ func setupDownloadEnvironment(cmd *cobra.Command, cfg *models.Config) (db *datab
 	return
 }
 
+// printDryRun lists the files a --dry-run matched, with the path each would be saved
+// to and the total download size. The downloader prefixes file names with the version
+// ID and may still rename a file to the name the server sends.
+func printDryRun(downloadsToQueue []potentialDownload) {
+	if len(downloadsToQueue) == 0 {
+		fmt.Fprintln(humanOutput(), "Dry run: no new files meet the criteria or need downloading.")
+		return
+	}
+	w := tabwriter.NewWriter(humanOutput(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Model\tVersion ID\tSize\tTarget Path")
+	fmt.Fprintln(w, "-----\t----------\t----\t-----------")
+	var totalBytes uint64
+	for _, pd := range downloadsToQueue {
+		sizeBytes := uint64(pd.File.SizeKB * 1024)
+		totalBytes += sizeBytes
+		targetPath := pd.TargetFilepath
+		if pd.ModelVersionID > 0 {
+			targetPath = filepath.Join(filepath.Dir(targetPath), fmt.Sprintf("%d_%s", pd.ModelVersionID, filepath.Base(targetPath)))
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", pd.ModelName, pd.ModelVersionID, helpers.BytesToSize(sizeBytes), targetPath)
+	}
+	w.Flush()
+	fmt.Fprintf(humanOutput(), "\nDry run: %d file(s) would be downloaded, %s in total. Nothing was downloaded and the database was not changed.\n",
+		len(downloadsToQueue), helpers.BytesToSize(totalBytes))
+}
+
 // handleMetadataOnlyMode handles the logic when --download-meta-only is specified.
 // It saves metadata for the queued files and returns true if the program should exit.
 func handleMetadataOnlyMode(downloadsToQueue []potentialDownload, cfg *models.Config) (shouldExit bool) {
@@ -228,6 +366,19 @@ func handleMetadataOnlyMode(downloadsToQueue []potentialDownload, cfg *models.Co
 	return true // Exit after processing
 }
 
+// printStoppedSummary prints the --json summary of a run that ended before downloading:
+// a dry run, nothing to download or a declined confirmation prompt (cancelled). The
+// queued files are reported as not attempted.
+func printStoppedSummary(queued int, cancelled bool) {
+	if !viper.GetBool("json") {
+		return
+	}
+	report := newDownloadReport(queued)
+	report.Cancelled = cancelled
+	report.finish()
+	printSummary(report, func() {})
+}
+
 // confirmDownload displays the download summary and prompts the user for confirmation.
 // Returns true if the user confirms, false otherwise.
 func confirmDownload(downloadsToQueue []potentialDownload) bool {
@@ -269,7 +420,7 @@ func confirmDownload(downloadsToQueue []potentialDownload) bool {
 
 	// Confirmation Prompt
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("Proceed with download? (y/N): ")
+	fmt.Fprint(humanOutput(), "Proceed with download? (y/N): ")
 	input, _ := reader.ReadString('\n')
 	input = strings.ToLower(strings.TrimSpace(input))
 
@@ -280,53 +431,148 @@ func confirmDownload(downloadsToQueue []potentialDownload) bool {
 	return true
 }
 
-// confirmParameters displays the effective configuration and API query parameters,
-// then prompts the user for confirmation before proceeding with API calls.
-// Returns true if the user confirms or if confirmation is skipped, false otherwise.
-func confirmParameters(queryParams models.QueryParameters) bool {
-	// Check if confirmation should be skipped first
-	if viper.GetBool("skipconfirmation") {
-		log.Info("Skipping parameter confirmation due to --yes flag or config setting.")
-		return true
-	}
-
-	log.Info("--- Review Effective Configuration & Parameters ---")
-
-	// Display Global Config (similar to --show-config)
-	effectiveGlobalConfig := map[string]interface{}{
+// effectiveGlobalConfigMap collects the effective global settings shown by --show-config,
+// the parameter confirmation and saved by --copy-config-to-output.
+func effectiveGlobalConfigMap() map[string]interface{} {
+	return map[string]interface{}{
 		// Paths (might still be empty if not set)
 		"SavePath":       viper.GetString("savepath"),
 		"DatabasePath":   viper.GetString("databasepath"),
 		"BleveIndexPath": viper.GetString("bleveindexpath"),
+		"NoIndex":        viper.GetBool("noindex"),
 		// Filtering - Model/Version
 		"DownloadAllVersions": viper.GetBool("downloadallversions"),
+		"SkipEmptyVersions":   viper.GetBool("skipemptyversions"),
+		"MinPublishedAge":     viper.GetString("minpublishedage"),
+		"Since":               viper.GetString("since"),
 		"ModelVersionID":      viper.GetInt("modelversionid"),
-		"ModelID":             viper.GetInt("modelid"), // Added ModelID for completeness
+		"FileID":              viper.GetInt("fileid"),
+		"FileName":            viper.GetString("filename"),
+		"ModelID":             viper.GetInt("modelid"),
 		// Filtering - File Level
 		"PrimaryOnly":           viper.GetBool("primaryonly"),
+		"FileSelect":            viper.GetString("fileselect"),
 		"Pruned":                viper.GetBool("pruned"),
 		"Fp16":                  viper.GetBool("fp16"),
+		"MinSizeBytes":          viper.GetString("minsizebytes"),
+		"MaxSizeBytes":          viper.GetString("maxsizebytes"),
+		"RequireSafeScan":       viper.GetBool("requiresafescan"),
 		"IgnoreBaseModels":      viper.GetStringSlice("ignorebasemodels"),
+		"ExcludeTags":           viper.GetStringSlice("excludetags"),
 		"IgnoreFileNameStrings": viper.GetStringSlice("ignorefilenamestrings"),
+		"Formats":               viper.GetStringSlice("formats"),
 		// Downloader Behavior
-		"Concurrency":         viper.GetInt("concurrency"),
-		"SaveMetadata":        viper.GetBool("savemetadata"),
-		"DownloadMetaOnly":    viper.GetBool("downloadmetaonly"),
-		"SaveModelInfo":       viper.GetBool("savemodelinfo"),
-		"SaveVersionImages":   viper.GetBool("saveversionimages"),
-		"SaveModelImages":     viper.GetBool("savemodelimages"),
-		"SkipConfirmation":    viper.GetBool("skipconfirmation"), // Should be false here
-		"ApiDelayMs":          viper.GetInt("apidelayms"),
-		"ApiClientTimeoutSec": viper.GetInt("apiclienttimeoutsec"),
+		"Concurrency":          viper.GetInt("concurrency"),
+		"ImageConcurrency":     viper.GetInt("imageconcurrency"),
+		"SaveMetadata":         viper.GetBool("savemetadata"),
+		"CombinedMetadata":     viper.GetBool("combinedmetadata"),
+		"SaveTriggers":         viper.GetBool("savetriggers"),
+		"DownloadMetaOnly":     viper.GetBool("downloadmetaonly"),
+		"SaveModelInfo":        viper.GetBool("savemodelinfo"),
+		"SaveVersionList":      viper.GetBool("saveversionlist"),
+		"SaveVersionImages":    viper.GetBool("saveversionimages"),
+		"SaveModelImages":      viper.GetBool("savemodelimages"),
+		"SkipCompleteImages":   viper.GetBool("skipcompleteimages"),
+		"SkipConfirmation":     viper.GetBool("skipconfirmation"),
+		"CopyConfigToOutput":   viper.GetBool("copyconfigtooutput"),
+		"NoMetadataForSkipped": viper.GetBool("nometadataforskipped"),
+		"SkipExistingByHash":   viper.GetBool("skipexistingbyhash"),
+		"ServerFilename":       viper.GetBool("serverfilename"),
+		"PathTemplate":         viper.GetString("pathtemplate"),
+		"VersionDirStyle":      viper.GetString("versiondirstyle"),
+		"Convert":              viper.GetString("convert"),
+		"KeepOriginal":         viper.GetBool("keeporiginal"),
+		"HashAlgo":             viper.GetString("hashalgo"),
+		"HashSidecar":          viper.GetBool("hashsidecar"),
+		"MaxErrors":            viper.GetInt("maxerrors"),
+		"RetryFailed":          viper.GetInt("retryfailed"),
+		"RetryFailedDelay":     viper.GetDuration("retryfaileddelay").String(),
+		"ApiDelayMs":           viper.GetInt("apidelayms"),
+		"ApiClientTimeoutSec":  viper.GetInt("apiclienttimeoutsec"),
+		"ApiBaseUrl":           viper.GetString("apibaseurl"),
+		"MaxRetries":           viper.GetInt("maxretries"),
+		"InitialRetryDelayMs":  viper.GetInt("initialretrydelayms"),
 		// Other
 		"LogApiRequests": viper.GetBool("logapirequests"),
+		// API Query Behavior (the per-request size is sent as "limit" in the API params)
+		"Limit":      viper.GetInt("limit"),
+		"PageSize":   viper.GetInt("pagesize"),
+		"Sample":     viper.GetInt("sample"),
+		"SampleSeed": viper.GetInt64("sampleseed"),
+		// NOTE: Query, Tags, Usernames, ModelTypes, BaseModels, Nsfw, Sort, Period, Rating, MaxPages
+		// are part of API params, not strictly global config shown here.
 	}
+}
+
+// runConfigFile is the name of the file written by --copy-config-to-output.
+const runConfigFile = "run-config.json"
+
+// writeRunConfig saves the effective configuration and API query parameters of this
+// run to run-config.json in the save path, as a record of which settings produced
+// the downloaded files. An existing file from an earlier run is replaced.
+func writeRunConfig(queryParams models.QueryParameters) error {
+	savePath := viper.GetString("savepath")
+	if err := os.MkdirAll(savePath, 0750); err != nil {
+		return fmt.Errorf("failed to create save path %s: %w", savePath, err)
+	}
+	runConfig := struct {
+		Timestamp       string                 `json:"timestamp"`
+		Args            []string               `json:"args"`
+		GlobalConfig    map[string]interface{} `json:"globalConfig"`
+		QueryParameters models.QueryParameters `json:"queryParameters"`
+	}{
+		Timestamp:       time.Now().UTC().Format(time.RFC3339),
+		Args:            redactApiKeyArgs(os.Args[1:]),
+		GlobalConfig:    effectiveGlobalConfigMap(),
+		QueryParameters: queryParams,
+	}
+	data, err := json.MarshalIndent(runConfig, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run configuration: %w", err)
+	}
+	filePath := filepath.Join(savePath, runConfigFile)
+	if err := os.WriteFile(filePath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filePath, err)
+	}
+	log.Infof("Saved run configuration to %s", filePath)
+	return nil
+}
+
+// redactApiKeyArgs returns a copy of args with the value of --api-key masked, so the
+// key is never written to disk alongside the downloads.
+func redactApiKeyArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+	for i, arg := range redacted {
+		if strings.HasPrefix(arg, "--api-key=") {
+			redacted[i] = "--api-key=REDACTED"
+		} else if arg == "--api-key" && i+1 < len(redacted) {
+			redacted[i+1] = "REDACTED"
+		}
+	}
+	return redacted
+}
+
+// confirmParameters displays the effective configuration and API query parameters,
+// then prompts the user for confirmation before proceeding with API calls.
+// Returns true if the user confirms or if confirmation is skipped, false otherwise.
+func confirmParameters(queryParams models.QueryParameters) bool {
+	// Check if confirmation should be skipped first
+	if viper.GetBool("skipconfirmation") {
+		log.Info("Skipping parameter confirmation due to --yes flag or config setting.")
+		return true
+	}
+
+	log.Info("--- Review Effective Configuration & Parameters ---")
+
+	// Display Global Config (same as --show-config)
+	effectiveGlobalConfig := effectiveGlobalConfigMap()
 	globalConfigJSON, err := json.MarshalIndent(effectiveGlobalConfig, "", "  ")
 	if err != nil {
 		log.Errorf("Failed to marshal effectiveGlobalConfig to JSON: %v", err)
 	} else {
-		fmt.Println("\n--- Global Config Settings ---")
-		fmt.Println(string(globalConfigJSON))
+		fmt.Fprintln(humanOutput(), "\n--- Global Config Settings ---")
+		fmt.Fprintln(humanOutput(), string(globalConfigJSON))
 	}
 
 	// Display Query Parameters (using the input struct)
@@ -334,12 +580,12 @@ func confirmParameters(queryParams models.QueryParameters) bool {
 	if err != nil {
 		log.Errorf("Failed to marshal queryParams to JSON: %v", err)
 	}
-	fmt.Println("\n--- Query Parameters for API ---")
-	fmt.Println(string(queryParamsJSON))
+	fmt.Fprintln(humanOutput(), "\n--- Query Parameters for API ---")
+	fmt.Fprintln(humanOutput(), string(queryParamsJSON))
 
 	// Confirmation Prompt
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("\nProceed with these settings? (y/N): ")
+	fmt.Fprint(humanOutput(), "\nProceed with these settings? (y/N): ")
 	input, _ := reader.ReadString('\n')
 	input = strings.ToLower(strings.TrimSpace(input))
 
@@ -352,36 +598,146 @@ func confirmParameters(queryParams models.QueryParameters) bool {
 	return true // User confirmed
 }
 
-// executeDownloads manages the worker pool and queues download jobs.
-func executeDownloads(downloadsToQueue []potentialDownload, db *database.DB, fileDownloader *downloader.Downloader, imageDownloader *downloader.Downloader, concurrencyLevel int, cfg *models.Config, bleveIndex bleve.Index) {
+// executeDownloads manages the worker pool and queues download jobs. Once ctx is
+// cancelled no further jobs are started; files already downloading finish.
+func executeDownloads(ctx context.Context, downloadsToQueue []potentialDownload, db *database.DB, fileDownloader *downloader.Downloader, imageDownloader *downloader.Downloader, concurrencyLevel int, cfg *models.Config, bleveIndex bleve.Index) {
 	log.Info("--- Starting Phase 3: Download Execution --- ")
 
 	// Initialize uilive writer for progress updates
 	writer := uilive.New()
+	writer.Out = humanOutput()
 	writer.Start()
 	defer writer.Stop() // Ensure writer stops even if there are errors
 
+	// SIGUSR1/SIGUSR2 pause and resume starting new files
+	stopPauseSignals := watchPauseSignals(downloadPause)
+	defer stopPauseSignals()
+	// Paused workers must still see the cancellation to exit
+	stopResumeOnCancel := context.AfterFunc(ctx, downloadPause.resume)
+	defer stopResumeOnCancel()
+
+	// Index the files already downloaded by SHA256 so identical ones are linked instead
+	var existingByHash *hashIndex
+	if viper.GetBool("skipexistingbyhash") {
+		var err error
+		if existingByHash, err = buildHashIndex(db); err != nil {
+			log.WithError(err).Warn("Downloading without --skip-existing-by-hash")
+		}
+	}
+
+	result := runDownloadPass(ctx, downloadsToQueue, db, fileDownloader, imageDownloader, concurrencyLevel, writer, bleveIndex, existingByHash)
+
+	// Give the downloads of this run that failed another chance
+	retryFailed := viper.GetInt("retryfailed")
+	for attempt := 1; attempt <= retryFailed; attempt++ {
+		if ctx.Err() != nil || diskSpaceLow.Load() || maxErrorsReached.Load() {
+			break
+		}
+		retries := failedDownloadsToRetry(db, downloadsToQueue, failedDownloadPaths())
+		if len(retries) == 0 {
+			break
+		}
+		delay := viper.GetDuration("retryfaileddelay") << (attempt - 1)
+		log.Infof("Retrying %d failed downloads in %v (attempt %d of %d)...", len(retries), delay, attempt, retryFailed)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		result.add(runDownloadPass(ctx, retries, db, fileDownloader, imageDownloader, concurrencyLevel, writer, bleveIndex, existingByHash))
+	}
+
+	if diskSpaceLow.Load() {
+		log.Warnf("Downloads stopped early because free disk space fell below the --min-free-space buffer (%d further files were not queued). Free up space and run again to continue; skipped files are still pending.", result.skippedLowSpace)
+	}
+	if ctx.Err() != nil {
+		log.Warnf("Downloads stopped early because the run was cancelled (%d further files were not queued). Files in progress were finished; run again to continue, skipped files are still pending.", result.skippedInterrupted)
+	}
+	if maxErrorsReached.Load() {
+		log.Errorf("Downloads stopped early after %d failed downloads (--max-errors %d; %d further files were not queued). Check the errors above (see also 'db stats'); skipped files are still pending.", failedDownloads.Load(), viper.GetInt("maxerrors"), result.skippedMaxErrors)
+	}
+	if summary := failureCategorySummary(); summary != "" {
+		log.Warnf("Failed downloads by category: %s. Run 'db view --error-category <category>' to list them.", summary)
+	}
+	log.Info("--- Finished Phase 3: Download Execution --- ")
+}
+
+// downloadPassResult counts what a download pass queued and why files were not queued.
+type downloadPassResult struct {
+	queued             int
+	failedToQueue      int
+	skippedLowSpace    int
+	skippedMaxErrors   int
+	skippedInterrupted int
+}
+
+func (r *downloadPassResult) add(other downloadPassResult) {
+	r.queued += other.queued
+	r.failedToQueue += other.failedToQueue
+	r.skippedLowSpace += other.skippedLowSpace
+	r.skippedMaxErrors += other.skippedMaxErrors
+	r.skippedInterrupted += other.skippedInterrupted
+}
+
+// runDownloadPass starts the download workers, queues the Pending downloads among
+// downloadsToQueue and waits until the workers have finished them.
+func runDownloadPass(ctx context.Context, downloadsToQueue []potentialDownload, db *database.DB, fileDownloader *downloader.Downloader, imageDownloader *downloader.Downloader, concurrencyLevel int, writer *uilive.Writer, bleveIndex bleve.Index, existingByHash *hashIndex) downloadPassResult {
 	var wg sync.WaitGroup
 	downloadJobs := make(chan downloadJob, concurrencyLevel) // Buffered channel
 
 	// Start download workers
-	log.Infof("Starting %d download workers...", concurrencyLevel)
-	for i := 0; i < concurrencyLevel; i++ {
-		wg.Add(1)
+	rampUp := viper.GetDuration("rampup")
+	if rampUp > 0 && concurrencyLevel > 1 {
+		log.Infof("Starting %d download workers, ramping up one every %v (all running after %v)...", concurrencyLevel, rampUp, rampUp*time.Duration(concurrencyLevel-1))
+	} else {
+		log.Infof("Starting %d download workers...", concurrencyLevel)
+	}
+	// Add all workers up front so wg.Wait() cannot return while the ramp is still starting them
+	wg.Add(concurrencyLevel)
+	startWorker := func(workerID int) {
 		// Pass necessary components to the worker
 		// Pass imageDownloader, writer, concurrencyLevel, and bleveIndex
-		go downloadWorker(i+1, downloadJobs, db, fileDownloader, imageDownloader, &wg, writer, concurrencyLevel, bleveIndex)
+		go downloadWorker(ctx, workerID, downloadJobs, db, fileDownloader, imageDownloader, &wg, writer, concurrencyLevel, bleveIndex, existingByHash)
+	}
+	if rampUp > 0 {
+		startWorker(1)
+		go func() {
+			for i := 2; i <= concurrencyLevel; i++ {
+				time.Sleep(rampUp)
+				log.Debugf("Ramp-up: starting download worker %d of %d", i, concurrencyLevel)
+				startWorker(i)
+			}
+		}()
+	} else {
+		for i := 0; i < concurrencyLevel; i++ {
+			startWorker(i + 1)
+		}
 	}
 
 	// Queue downloads
-	queuedCount := 0
-	failedToQueueCount := 0
-	for _, pd := range downloadsToQueue {
+	var result downloadPassResult
+queue:
+	for i, pd := range downloadsToQueue {
+		// Stop queueing once the run was interrupted, files in progress still finish
+		if ctx.Err() != nil {
+			result.skippedInterrupted = len(downloadsToQueue) - i
+			break
+		}
+		// Stop queueing once a worker reported low disk space
+		if diskSpaceLow.Load() {
+			result.skippedLowSpace = len(downloadsToQueue) - i
+			break
+		}
+		// Or once --max-errors downloads have failed
+		if maxErrorsReached.Load() {
+			result.skippedMaxErrors = len(downloadsToQueue) - i
+			break
+		}
+
 		// --- Calculate DB Key and Check Preconditions ---
 		// Ensure ModelVersion ID exists before calculating key and checking DB
 		if pd.CleanedVersion.ID == 0 {
 			log.Errorf("Cannot process download for %s (Model: %s) - CleanedVersion ID is missing! Skipping queue.", pd.File.Name, pd.ModelName)
-			failedToQueueCount++
+			result.failedToQueue++
 			continue
 		}
 		// Calculate key using version ID with prefix (as it was originally)
@@ -391,19 +747,19 @@ func executeDownloads(downloadsToQueue []potentialDownload, db *database.DB, fil
 		rawValue, errGet := db.Get([]byte(dbKey))
 		if errGet != nil {
 			log.Warnf("Failed to get DB entry %s before queueing download job for %s. Skipping queue.", dbKey, pd.FinalBaseFilename)
-			failedToQueueCount++
+			result.failedToQueue++
 			continue
 		}
 		var entry models.DatabaseEntry
 		if errUnmarshal := json.Unmarshal(rawValue, &entry); errUnmarshal != nil {
 			log.Warnf("Failed to unmarshal DB entry %s before queueing download job for %s. Skipping queue.", dbKey, pd.FinalBaseFilename)
-			failedToQueueCount++
+			result.failedToQueue++
 			continue
 		}
 
 		if entry.Status != models.StatusPending {
 			log.Warnf("DB entry %s for %s is not in Pending state (Status: %s). Skipping queue.", dbKey, pd.FinalBaseFilename, entry.Status)
-			failedToQueueCount++
+			result.failedToQueue++
 			continue
 		}
 
@@ -412,15 +768,20 @@ func executeDownloads(downloadsToQueue []potentialDownload, db *database.DB, fil
 			PotentialDownload: pd,
 			DatabaseKey:       dbKey,
 		}
-		downloadJobs <- job
-		queuedCount++
+		select {
+		case downloadJobs <- job:
+			result.queued++
+		case <-ctx.Done():
+			result.skippedInterrupted = len(downloadsToQueue) - i
+			break queue
+		}
 	}
 
 	close(downloadJobs) // Close channel once all jobs are sent
-	log.Infof("Queued %d download jobs. Waiting for workers to finish... (%d jobs failed to queue)", queuedCount, failedToQueueCount)
+	log.Infof("Queued %d download jobs. Waiting for workers to finish... (%d jobs failed to queue)", result.queued, result.failedToQueue)
 
 	wg.Wait() // Wait for all workers to complete
-	log.Info("--- Finished Phase 3: Download Execution --- ")
+	return result
 }
 
 // runDownload is the main execution function for the download command.
@@ -448,35 +809,7 @@ func runDownload(cmd *cobra.Command, args []string) {
 		queryParams := setupQueryParams(&globalConfig, cmd) // Pass globalConfig only for context if needed by setup
 
 		// Build effective global config using Viper directly
-		effectiveGlobalConfig := map[string]interface{}{
-			// Paths (might still be empty if not set)
-			"SavePath":       viper.GetString("savepath"),
-			"DatabasePath":   viper.GetString("databasepath"),
-			"BleveIndexPath": viper.GetString("bleveindexpath"),
-			// Filtering - Model/Version
-			"DownloadAllVersions": viper.GetBool("downloadallversions"),
-			"ModelVersionID":      viper.GetInt("modelversionid"),
-			// Filtering - File Level
-			"PrimaryOnly":           viper.GetBool("primaryonly"),
-			"Pruned":                viper.GetBool("pruned"),
-			"Fp16":                  viper.GetBool("fp16"),
-			"IgnoreBaseModels":      viper.GetStringSlice("ignorebasemodels"),
-			"IgnoreFileNameStrings": viper.GetStringSlice("ignorefilenamestrings"),
-			// Downloader Behavior
-			"Concurrency":         viper.GetInt("concurrency"),
-			"SaveMetadata":        viper.GetBool("savemetadata"),
-			"DownloadMetaOnly":    viper.GetBool("downloadmetaonly"),
-			"SaveModelInfo":       viper.GetBool("savemodelinfo"),
-			"SaveVersionImages":   viper.GetBool("saveversionimages"),
-			"SaveModelImages":     viper.GetBool("savemodelimages"),
-			"SkipConfirmation":    viper.GetBool("skipconfirmation"),
-			"ApiDelayMs":          viper.GetInt("apidelayms"),
-			"ApiClientTimeoutSec": viper.GetInt("apiclienttimeoutsec"),
-			// Other
-			"LogApiRequests": viper.GetBool("logapirequests"),
-			// NOTE: Query, Tags, Usernames, ModelTypes, BaseModels, Nsfw, Sort, Period, Limit, MaxPages
-			// are part of API params, not strictly global config shown here.
-		}
+		effectiveGlobalConfig := effectiveGlobalConfigMap()
 
 		// Print effectiveGlobalConfig
 		globalConfigJSON, err := json.MarshalIndent(effectiveGlobalConfig, "", "  ")
@@ -503,10 +836,8 @@ func runDownload(cmd *cobra.Command, args []string) {
 	if printUrl, _ := cmd.Flags().GetBool("debug-print-api-url"); printUrl {
 		// Similar to --show-config, we need queryParams
 		queryParams := setupQueryParams(&globalConfig, cmd)
-		// Construct the URL parts using the new helper function
-		apiURL := api.CivitaiApiBaseUrl + "/models" // Use constant from api package
-		params := api.ConvertQueryParamsToURLValues(queryParams)
-		fullURL := fmt.Sprintf("%s?%s", apiURL, params.Encode())
+		// Same encoder as the actual requests (see api.ConvertQueryParamsToURLValues)
+		fullURL := api.ModelsURL(queryParams, "")
 		log.Infof("--- Debug API URL (--debug-print-api-url) ---")
 		fmt.Println(fullURL) // Print only the URL to stdout
 		log.Info("Exiting after printing API URL.")
@@ -518,12 +849,75 @@ func runDownload(cmd *cobra.Command, args []string) {
 	// initLogging()
 	log.Info("Starting Civitai Downloader - Download Command")
 
+	minAge, err := helpers.ParseAge(viper.GetString("minpublishedage"))
+	if err != nil {
+		log.Fatalf("Invalid --min-published-age: %v", err)
+	}
+	minPublishedAge = minAge
+	since, err := parseSinceDate(viper.GetString("since"))
+	if err != nil {
+		log.Fatalf("Invalid --since: %v", err)
+	}
+	publishedSince = since
+	for _, size := range []struct{ key, flag string }{{"minsizebytes", "--min-size"}, {"maxsizebytes", "--max-size"}} {
+		if _, err := helpers.ParseByteSize(viper.GetString(size.key)); err != nil {
+			log.Fatalf("Invalid %s: %v", size.flag, err)
+		}
+	}
+
+	// The API resolves favorites and hidden models from the key's user
+	if (viper.GetBool("favorites") || viper.GetBool("hidden")) && viper.GetString("apikey") == "" {
+		log.Fatal("--favorites and --hidden require an API key: set ApiKey in the config or pass --api-key.")
+	}
+	if !validConvertTarget(viper.GetString("convert")) {
+		log.Fatalf("Invalid --convert %q: only fp16 is supported", viper.GetString("convert"))
+	}
+	if !validHashAlgo(viper.GetString("hashalgo")) {
+		log.Fatalf("Invalid --hash-algo %q: must be sha256 or blake3", viper.GetString("hashalgo"))
+	}
+	if err := validatePathTemplate(viper.GetString("pathtemplate")); err != nil {
+		log.Fatalf("Invalid --path-template: %v", err)
+	}
+	if !validVersionDirStyle(viper.GetString("versiondirstyle")) {
+		log.Fatalf("Invalid --version-dir-style %q: must be id-slug, name, date or id", viper.GetString("versiondirstyle"))
+	}
+
+	// Read all IDs up front; stdin is then used up, so confirmations cannot be answered
+	var stdinIDs []stdinID
+	if viper.GetBool("stdin") {
+		stdinIDs, err = parseStdinIDs(os.Stdin)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if len(stdinIDs) == 0 {
+			log.Fatal("--stdin: no model or version IDs were read from stdin.")
+		}
+		log.Infof("Read %d ID(s) from stdin.", len(stdinIDs))
+		if !viper.GetBool("skipconfirmation") {
+			log.Info("--stdin implies --yes, confirmation prompts are skipped.")
+			viper.Set("skipconfirmation", true)
+		}
+	}
+
+	// A dry run only lists what would be downloaded, so turn off everything in
+	// Phase 1 that writes files next to the models
+	dryRun := viper.GetBool("dryrun")
+	if dryRun {
+		log.Info("Dry run: matching files are listed only, nothing is downloaded and the database is not changed.")
+		for _, key := range []string{"savemodelinfo", "savemodelimages", "saveversionlist", "downloadmetaonly", "copyconfigtooutput"} {
+			viper.Set(key, false)
+		}
+	}
+
 	// --- Initialize Environment ---
 	db, fileDownloader, imageDownloader, concurrencyLevel, err := setupDownloadEnvironment(cmd, &globalConfig)
 	if err != nil {
 		log.Fatalf("Failed to set up download environment: %v", err)
 	}
 	defer func() {
+		if db == nil {
+			return // Dry run without an existing database
+		}
 		log.Info("Closing database.")
 		if err := db.Close(); err != nil {
 			log.Errorf("Error closing database: %v", err)
@@ -540,18 +934,25 @@ func runDownload(cmd *cobra.Command, args []string) {
 		// Optionally, ensure it's an absolute path or resolve relative to working dir/config file?
 		// For now, assume it's a valid path as provided.
 	}
-	log.Infof("Opening/Creating Bleve index at: %s", indexPath)
-	bleveIndex, err := index.OpenOrCreateIndex(indexPath)
-	if err != nil {
-		log.Fatalf("Failed to open or create Bleve index: %v", err)
+	// The index only backs search; downloads continue without it (workers skip
+	// indexing when it is nil) if it is locked by another process or corrupt.
+	var bleveIndex bleve.Index
+	if dryRun {
+		log.Debug("Dry run: not opening the Bleve index.")
+	} else if viper.GetBool("noindex") {
+		log.Info("--no-index set: not opening the Bleve index.")
+	} else if bleveIndex, err = index.OpenOrCreateIndex(indexPath); err != nil {
+		log.WithError(err).Warnf("Failed to open or create Bleve index at %s, continuing without search indexing.", indexPath)
+		bleveIndex = nil
+	} else {
+		defer func() {
+			log.Info("Closing Bleve index.")
+			if err := bleveIndex.Close(); err != nil {
+				log.Errorf("Error closing Bleve index: %v", err)
+			}
+		}()
+		log.Info("Bleve index opened successfully.")
 	}
-	defer func() {
-		log.Info("Closing Bleve index.")
-		if err := bleveIndex.Close(); err != nil {
-			log.Errorf("Error closing Bleve index: %v", err)
-		}
-	}()
-	log.Info("Bleve index opened successfully.")
 	// --- Initialize Bleve Index --- END ---
 
 	// Pass address of globalConfig (needed by legacy parts, but Viper is preferred for new checks)
@@ -559,12 +960,20 @@ func runDownload(cmd *cobra.Command, args []string) {
 	queryParams := setupQueryParams(&globalConfig, cmd) // setupQueryParams already uses Viper
 
 	// --- Confirm Parameters Before API Calls --- START ---
-	if !confirmParameters(queryParams) {
+	continuePending := viper.GetBool("continue")
+	if !continuePending && !confirmParameters(queryParams) {
 		// User cancelled during parameter confirmation
+		printStoppedSummary(0, true)
 		return // Exit runDownload gracefully
 	}
 	// --- Confirm Parameters Before API Calls --- END ---
 
+	if viper.GetBool("copyconfigtooutput") {
+		if err := writeRunConfig(queryParams); err != nil {
+			log.WithError(err).Warn("Failed to save run configuration to the output directory")
+		}
+	}
+
 	// =============================================
 	// Phase 1: Metadata Gathering & Filtering
 	// =============================================
@@ -615,6 +1024,22 @@ func runDownload(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	// Cache metadata responses on disk if enabled. It wraps the logging transport so
+	// only requests that actually reach the API are logged.
+	if cacheDir := viper.GetString("cachedir"); cacheDir != "" {
+		cachingTransport, err := api.NewCachingTransport(finalMetadataTransport, cacheDir, viper.GetDuration("cachettl"))
+		if err != nil {
+			log.WithError(err).Error("Failed to initialize HTTP cache for metadata client, caching disabled.")
+		} else {
+			log.Infof("Caching API metadata responses in %s (TTL %v)", cacheDir, viper.GetDuration("cachettl"))
+			finalMetadataTransport = cachingTransport
+		}
+	}
+
+	// Share the request and bandwidth limits with the download workers
+	finalMetadataTransport = api.NewRateLimitTransport(finalMetadataTransport)
+	finalMetadataTransport = withCommandContext(cmd, finalMetadataTransport)
+
 	// Create the metadata client using the (potentially wrapped) transport
 	metadataClient := &http.Client{
 		Timeout:   metadataTimeout,        // Set client-level timeout
@@ -624,14 +1049,28 @@ func runDownload(cmd *cobra.Command, args []string) {
 
 	modelVersionID := viper.GetInt("modelversionid") // Viper key from init()
 	modelID := viper.GetInt("modelid")               // Viper key from init()
+	if (viper.GetInt("fileid") != 0 || viper.GetString("filename") != "") && (modelVersionID <= 0 || len(stdinIDs) > 0 || continuePending) {
+		log.Warn("--file-id and --file-name only apply together with --model-version-id, ignoring them.")
+	}
 
 	var downloadsToQueue []potentialDownload // Holds downloads confirmed for queueing after DB check
 	var loopErr error                        // Store loop errors
 
-	if modelVersionID > 0 {
+	if continuePending {
+		log.Info("--- Continuing Pending downloads from the database (no API queries) ---")
+		downloadsToQueue, loopErr = pendingDownloadsFromDB(db, &globalConfig)
+		if loopErr != nil {
+			log.Errorf("Failed to load pending downloads: %v", loopErr)
+			return
+		}
+		log.Infof("Found %d pending download(s) in the database.", len(downloadsToQueue))
+	} else if len(stdinIDs) > 0 {
+		log.Infof("--- Processing %d ID(s) from stdin (Model ID, Model Version ID and query filters ignored) ---", len(stdinIDs))
+		downloadsToQueue = handleStdinIDs(stdinIDs, db, metadataClient, imageDownloader, &globalConfig, cmd)
+	} else if modelVersionID > 0 {
 		log.Infof("--- Processing specific Model Version ID: %d (Model ID flag ignored) ---", modelVersionID)
 		// Use the metadataClient initialized above
-		downloadsToQueue, _, loopErr = handleSingleVersionDownload(modelVersionID, db, metadataClient, &globalConfig, cmd)
+		downloadsToQueue, _, loopErr = handleSingleVersionDownload(modelVersionID, viper.GetInt("fileid"), viper.GetString("filename"), db, metadataClient, &globalConfig, cmd)
 
 		if loopErr != nil {
 			log.Errorf("Failed to process single model version %d: %v", modelVersionID, loopErr)
@@ -666,6 +1105,17 @@ func runDownload(cmd *cobra.Command, args []string) {
 		log.Info("--- Finished Phase 1: Metadata Gathering & DB Check ---")
 	}
 
+	reportVersionSkips()
+
+	// Queue negative embeddings referenced by the queued LORAs (best-effort)
+	downloadsToQueue = append(downloadsToQueue, followEmbeddings(downloadsToQueue, db, metadataClient, imageDownloader, &globalConfig, cmd)...)
+
+	if dryRun {
+		printDryRun(downloadsToQueue)
+		printStoppedSummary(len(downloadsToQueue), false)
+		return
+	}
+
 	// =============================================
 	// Phase 1.5: Handle Metadata-Only Mode
 	// =============================================
@@ -681,6 +1131,8 @@ func runDownload(cmd *cobra.Command, args []string) {
 	// =============================================
 	// Confirmation logic moved to confirmDownload function
 	if !confirmDownload(downloadsToQueue) {
+		// Nothing to download is still a run --json consumers expect a summary of
+		printStoppedSummary(len(downloadsToQueue), len(downloadsToQueue) > 0)
 		return // Exit if user cancels
 	}
 
@@ -688,10 +1140,30 @@ func runDownload(cmd *cobra.Command, args []string) {
 	// Phase 3: Download Execution
 	// =============================================
 	// Call the function to execute downloads, passing the index
-	executeDownloads(downloadsToQueue, db, fileDownloader, imageDownloader, concurrencyLevel, &globalConfig, bleveIndex)
+	// The --report file and the --json summary are both built from the run report
+	reportPath := viper.GetString("report")
+	if reportPath != "" || viper.GetBool("json") {
+		runReport = newDownloadReport(len(downloadsToQueue))
+	}
+	// Ctrl-C lets the files in progress finish so the database and partials stay consistent
+	downloadCtx, stopInterrupt := watchInterrupt(cmd.Context())
+	executeDownloads(downloadCtx, downloadsToQueue, db, fileDownloader, imageDownloader, concurrencyLevel, &globalConfig, bleveIndex)
+	stopInterrupt()
+	if reportPath != "" {
+		if err := runReport.write(reportPath); err != nil {
+			log.WithError(err).Error("Failed to write the download report")
+		} else {
+			log.Infof("Wrote download report to %s", reportPath)
+		}
+	}
 
 	// =============================================
 	// Phase 4: Final Summary
 	// =============================================
-	log.Info("Download process complete.")
+	if runReport != nil {
+		runReport.finish()
+	}
+	printSummary(runReport, func() {
+		log.Info("Download process complete.")
+	})
 }
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"go-civitai-download/internal/database"
+	"go-civitai-download/internal/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// modelDirectory is a downloaded model's main directory (savePath/type/modelName with
+// the default PathTemplate), holding all of its downloaded versions.
+type modelDirectory struct {
+	Path      string
+	ModelID   int
+	ModelName string
+	ModelType string // Type of the model (e.g., LORA, Checkpoint)
+	// DownloadURLs are the Civitai download URLs of the downloaded files in the
+	// directory, sorted and without duplicates
+	DownloadURLs []string
+}
+
+// scanModelDirectories derives the model directories from the version entries in
+// the database, optionally limited to modelIDs. Shared by the torrent and pack
+// commands, which both work on one model directory at a time.
+func scanModelDirectories(db *database.DB, savePath string, modelIDs []int) (map[string]modelDirectory, error) {
+	modelDirs := make(map[string]modelDirectory)
+	modelIDSet := make(map[int]struct{})
+	for _, id := range modelIDs {
+		modelIDSet[id] = struct{}{}
+	}
+
+	log.Info("Scanning database to identify model directories...")
+	err := db.Fold(func(key []byte, value []byte) error {
+		keyStr := string(key)
+		// Process only version entries ('v_*') as they contain path info
+		if !strings.HasPrefix(keyStr, "v_") {
+			return nil
+		}
+
+		var entry models.DatabaseEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			log.WithError(err).Warnf("Failed to unmarshal JSON for key %s, skipping", keyStr)
+			return nil
+		}
+
+		// Filter by specific model IDs if provided
+		if len(modelIDs) > 0 {
+			if _, exists := modelIDSet[entry.Version.ModelId]; !exists {
+				return nil // Skip if not in the target model ID list
+			}
+		}
+
+		if entry.Folder == "" {
+			log.WithFields(log.Fields{
+				"modelID":   entry.Version.ModelId,
+				"versionID": entry.Version.ID,
+				"key":       keyStr,
+			}).Warn("Skipping entry due to missing Folder path.")
+			return nil
+		}
+
+		// --- Derive the MODEL directory path ---
+		// Entries record the model directory rendered from PathTemplate. Older entries
+		// only have Folder, laid out as type/modelName/baseModel, so the first two
+		// components are the model directory there.
+		modelTypePart := ""
+		var modelDir string
+		if entry.ModelDir != "" {
+			modelDir = entry.ModelDir
+			if !filepath.IsAbs(modelDir) {
+				modelDir = filepath.Join(savePath, modelDir)
+			}
+		} else {
+			folderParts := strings.Split(entry.Folder, string(filepath.Separator))
+			if len(folderParts) < 2 {
+				log.WithFields(log.Fields{
+					"modelID":   entry.Version.ModelId,
+					"versionID": entry.Version.ID,
+					"folder":    entry.Folder,
+				}).Warn("Could not reliably determine model directory from Folder path (not enough parts), skipping entry.")
+				return nil
+			}
+			modelTypePart = folderParts[0]
+			modelDir = filepath.Join(savePath, modelTypePart, folderParts[1])
+		}
+
+		// Check if this model directory is already marked for processing
+		if dir, exists := modelDirs[modelDir]; exists {
+			dir.DownloadURLs = addDownloadURL(dir.DownloadURLs, entry)
+			modelDirs[modelDir] = dir
+			return nil
+		}
+		log.Debugf("Identified model directory to process: %s (from version %d)", modelDir, entry.Version.ID)
+
+		// Determine Model Type from version info (use first part of folder as fallback)
+		modelType := "unknown_type"
+		if entry.ModelType != "" { // Check DbEntry.ModelType first
+			modelType = entry.ModelType
+		} else if entry.Version.Model.Type != "" { // Then check embedded Model Type
+			modelType = entry.Version.Model.Type
+		} else if modelTypePart != "" {
+			modelType = modelTypePart // Fallback to path component
+			log.Warnf("Could not determine Model Type directly for model ID %d, using path component '%s'.", entry.Version.ModelId, modelType)
+		} else {
+			log.Warnf("Could not determine Model Type for model ID %d, using fallback 'unknown_type'.", entry.Version.ModelId)
+		}
+
+		modelDirs[modelDir] = modelDirectory{
+			Path:         modelDir,
+			ModelID:      entry.Version.ModelId,
+			ModelName:    entry.ModelName,
+			ModelType:    modelType,
+			DownloadURLs: addDownloadURL(nil, entry),
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("Error scanning database")
+		return nil, fmt.Errorf("error scanning database: %w", err)
+	}
+	return modelDirs, nil
+}
+
+// addDownloadURL adds the download URL of a downloaded entry to the sorted urls.
+func addDownloadURL(urls []string, entry models.DatabaseEntry) []string {
+	url := entry.File.DownloadUrl
+	if entry.Status != models.StatusDownloaded || url == "" {
+		return urls
+	}
+	i := sort.SearchStrings(urls, url)
+	if i < len(urls) && urls[i] == url {
+		return urls
+	}
+	return slices.Insert(urls, i, url)
+}
@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	index "go-civitai-download/index"
+	"go-civitai-download/internal/database"
+
+	"github.com/blevesearch/bleve/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var packModelIDs []int
+
+var packCmd = &cobra.Command{
+	Use:   "pack",
+	Short: "Package each downloaded model directory into a single .zip",
+	Long: `Creates one .zip archive per downloaded model directory, containing all of its
+versions' files, metadata and images, for archival or transfer. Model weights and
+images are stored without re-compression, so packing runs at disk speed; only text
+files such as metadata are compressed. The archive path is recorded in the search index.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		savePath := viper.GetString("savepath")
+		if savePath == "" {
+			return errors.New("save path is not configured (--save-path or config file)")
+		}
+		outputDir := viper.GetString("pack.outputdir")
+		overwrite := viper.GetBool("pack.overwrite")
+
+		dbPath := viper.GetString("databasepath")
+		db, err := database.Open(dbPath)
+		if err != nil {
+			log.WithError(err).Errorf("Error opening database at %s", dbPath)
+			return fmt.Errorf("error opening database: %w", err)
+		}
+		defer db.Close()
+
+		modelDirs, err := scanModelDirectories(db, savePath, packModelIDs)
+		if err != nil {
+			return err
+		}
+		if len(modelDirs) == 0 {
+			if len(packModelIDs) > 0 {
+				log.Warnf("No downloaded models found matching specified IDs: %v", packModelIDs)
+			} else {
+				log.Info("No processable model download entries found in the database.")
+			}
+			return nil
+		}
+
+		indexPath := viper.GetString("bleveindexpath")
+		if indexPath == "" {
+			indexPath = filepath.Join(savePath, "civitai.bleve")
+		}
+		var bleveIndex bleve.Index
+		if viper.GetBool("noindex") {
+			log.Info("--no-index set: archive paths will not be recorded in the Bleve index.")
+		} else if bleveIndex, err = index.OpenOrCreateIndex(indexPath); err != nil {
+			log.WithError(err).Warnf("Could not open Bleve index at %s, archive paths will not be recorded.", indexPath)
+			bleveIndex = nil
+		} else {
+			defer func() {
+				if err := bleveIndex.Close(); err != nil {
+					log.WithError(err).Error("Error closing Bleve index")
+				}
+			}()
+		}
+
+		paths := make([]string, 0, len(modelDirs))
+		for path := range modelDirs {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		log.Infof("Packing %d model directories...", len(paths))
+		var packed, skipped, failed int
+		for _, path := range paths {
+			dir := modelDirs[path]
+			zipPath := packOutputPath(path, outputDir)
+			if _, statErr := os.Stat(path); statErr != nil {
+				log.Warnf("Model directory %s not found, skipping.", path)
+				skipped++
+				continue
+			}
+			if _, statErr := os.Stat(zipPath); statErr == nil && !overwrite {
+				log.WithField("path", zipPath).Info("Skipping existing archive (use --overwrite to replace)")
+				skipped++
+				continue
+			}
+
+			files, packErr := packModelDirectory(path, zipPath)
+			if packErr != nil {
+				log.WithError(packErr).Errorf("Failed to pack %s", path)
+				failed++
+				continue
+			}
+			log.Infof("Packed %s (%d files) into %s", dir.ModelName, files, zipPath)
+			packed++
+
+			if bleveIndex != nil {
+				if indexErr := updateModelZipIndex(bleveIndex, dir, zipPath); indexErr != nil {
+					log.WithError(indexErr).Errorf("Failed to record archive of model %d in the index", dir.ModelID)
+				}
+			}
+		}
+
+		log.Infof("Packing complete. Packed: %d, Skipped: %d, Failed: %d", packed, skipped, failed)
+		if failed > 0 {
+			return fmt.Errorf("%d model directories failed to pack", failed)
+		}
+		return nil
+	},
+}
+
+// packOutputPath returns where the archive for a model directory is written: inside
+// outputDir if set, otherwise next to the model directory.
+func packOutputPath(modelDir string, outputDir string) string {
+	zipFileName := filepath.Base(modelDir) + ".zip"
+	if outputDir != "" {
+		return filepath.Join(outputDir, zipFileName)
+	}
+	return filepath.Join(filepath.Dir(modelDir), zipFileName)
+}
+
+// packCompressedExts are extensions stored without compression: model weights do not
+// compress meaningfully and images/videos already are compressed.
+var packCompressedExts = map[string]bool{
+	".safetensors": true, ".ckpt": true, ".pt": true, ".pth": true, ".bin": true, ".gguf": true, ".zip": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".webp": true, ".gif": true, ".mp4": true, ".webm": true,
+}
+
+// packModelDirectory writes all files below modelDir into a zip at zipPath, with
+// paths relative to modelDir's parent so the archive unpacks into a single folder.
+// The archive is written to a temporary file and renamed when complete. Returns the
+// number of files added.
+func packModelDirectory(modelDir string, zipPath string) (int, error) {
+	if err := os.MkdirAll(filepath.Dir(zipPath), 0750); err != nil {
+		return 0, fmt.Errorf("error creating output directory %s: %w", filepath.Dir(zipPath), err)
+	}
+	tmpPath := zipPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, fmt.Errorf("error creating %s: %w", tmpPath, err)
+	}
+
+	zw := zip.NewWriter(out)
+	root := filepath.Dir(modelDir)
+	count := 0
+	walkErr := filepath.Walk(modelDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !info.Mode().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		header.Method = zip.Deflate
+		if packCompressedExts[strings.ToLower(filepath.Ext(path))] {
+			header.Method = zip.Store
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error adding %s: %w", path, err)
+		}
+		count++
+		return nil
+	})
+	if walkErr == nil {
+		walkErr = zw.Close()
+	}
+	if closeErr := out.Close(); walkErr == nil {
+		walkErr = closeErr
+	}
+	if walkErr != nil {
+		os.Remove(tmpPath)
+		return 0, walkErr
+	}
+	if err := os.Rename(tmpPath, zipPath); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("error moving archive into place: %w", err)
+	}
+	return count, nil
+}
+
+// updateModelZipIndex records the archive path on the model's index item (m_<id>),
+// creating the item if needed.
+func updateModelZipIndex(bleveIndex bleve.Index, dir modelDirectory, zipPath string) error {
+	modelItemID := fmt.Sprintf("m_%d", dir.ModelID)
+	item, found, err := index.GetItem(bleveIndex, modelItemID)
+	if err != nil {
+		return fmt.Errorf("error searching index for %s: %w", modelItemID, err)
+	}
+	if !found {
+		item = index.Item{
+			ID:            modelItemID,
+			Type:          "model",
+			ModelName:     dir.ModelName,
+			DirectoryPath: dir.Path,
+		}
+	}
+	item.ZipPath = zipPath
+	return index.IndexItem(bleveIndex, item)
+}
+
+func init() {
+	rootCmd.AddCommand(packCmd)
+
+	packCmd.Flags().IntSliceVar(&packModelIDs, "model-id", []int{}, "Specific model ID(s) to pack (comma-separated or repeated). Default: all downloaded models.")
+	packCmd.Flags().StringP("output-dir", "o", "", "Directory to save the .zip files (default: next to each model directory)")
+	packCmd.Flags().BoolP("overwrite", "f", false, "Overwrite existing .zip files")
+	_ = viper.BindPFlag("pack.outputdir", packCmd.Flags().Lookup("output-dir"))
+	_ = viper.BindPFlag("pack.overwrite", packCmd.Flags().Lookup("overwrite"))
+}
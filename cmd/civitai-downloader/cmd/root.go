@@ -1,17 +1,24 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus" // Import logrus for config loading message
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
 	"go-civitai-download/internal/api"
+	"go-civitai-download/internal/helpers"
 	"go-civitai-download/internal/models"
 )
 
@@ -40,6 +47,13 @@ var globalConfig models.Config
 // globalHttpTransport holds the globally configured HTTP transport (base or logging-wrapped)
 var globalHttpTransport http.RoundTripper
 
+// deadlineExceeded is set once the --deadline for the command has passed
+var deadlineExceeded atomic.Bool
+
+// deadlineGracePeriod is how long in-flight work gets to wind down after the
+// deadline before the process exits regardless.
+const deadlineGracePeriod = 30 * time.Second
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "civitai-downloader",
@@ -61,11 +75,47 @@ func Execute() {
 		fmt.Fprintf(os.Stderr, "Error executing command: %v\n", err)
 		os.Exit(1)
 	}
+	if maxErrorsReached.Load() {
+		fmt.Fprintf(os.Stderr, "Error executing command: stopped after %d failed downloads (--max-errors %d)\n", failedDownloads.Load(), viper.GetInt("maxerrors"))
+		api.CloseAllLoggingTransports()
+		os.Exit(1)
+	}
+	if deadlineExceeded.Load() {
+		fmt.Fprintf(os.Stderr, "Error executing command: deadline of %v exceeded\n", viper.GetDuration("deadline"))
+		api.CloseAllLoggingTransports()
+		os.Exit(1)
+	}
+}
+
+// printSummary prints a command's final summary. With --json the summary is written to
+// stdout as a single JSON object, so it can be piped into other tools; otherwise human
+// prints the usual prose summary.
+func printSummary(summary interface{}, human func()) {
+	if !viper.GetBool("json") {
+		human()
+		return
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		log.WithError(err).Error("Failed to marshal summary as JSON")
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// humanOutput is where live progress, listings and prompts go: stderr with --json, so
+// stdout only carries the summary, and stdout otherwise.
+func humanOutput() io.Writer {
+	if viper.GetBool("json") {
+		return os.Stderr
+	}
+	return os.Stdout
 }
 
 func init() {
 	// Add persistent flags that apply to all commands
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "config.toml", "Configuration file path")
+	rootCmd.PersistentFlags().String("profile", "", "Name of a [profiles.<name>] table in the config file whose settings are merged over the base config (flags still override them)")
 
 	// Add persistent flags for logging
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Logging level (trace, debug, info, warn, error, fatal, panic)")
@@ -94,6 +144,34 @@ func init() {
 	rootCmd.PersistentFlags().IntVar(&apiTimeoutFlag, "api-timeout", -1, "Timeout for API HTTP client in seconds (overrides config, -1 uses config default)")
 	viper.BindPFlag("apiclienttimeoutsec", rootCmd.PersistentFlags().Lookup("api-timeout"))
 
+	// Add persistent flag for the API base URL
+	rootCmd.PersistentFlags().String("api-base-url", api.CivitaiApiBaseUrl, "Base URL of the Civitai API, e.g. a caching proxy (overrides config)")
+	_ = viper.BindPFlag("apibaseurl", rootCmd.PersistentFlags().Lookup("api-base-url"))
+
+	// Add persistent flags for the per-host circuit breaker
+	rootCmd.PersistentFlags().Int("breaker-threshold", 0, "Consecutive failed requests to a host, each within the cooldown of the last, before pausing all requests to it (0 disables, overrides config)")
+	rootCmd.PersistentFlags().Duration("breaker-cooldown", 2*time.Minute, "How long requests to a host are paused once the circuit breaker opens (overrides config)")
+	_ = viper.BindPFlag("breakerthreshold", rootCmd.PersistentFlags().Lookup("breaker-threshold"))
+	_ = viper.BindPFlag("breakercooldown", rootCmd.PersistentFlags().Lookup("breaker-cooldown"))
+
+	// Add persistent flag bounding the run time of the whole command
+	rootCmd.PersistentFlags().Duration("deadline", 0, "Cancel the command and exit with an error once it has run this long, e.g. 2h (0 disables, overrides config)")
+	_ = viper.BindPFlag("deadline", rootCmd.PersistentFlags().Lookup("deadline"))
+
+	// Add persistent flags for the request and bandwidth limits shared by all clients
+	rootCmd.PersistentFlags().Float64("rate-limit", 0, "Maximum HTTP requests per second across all workers and API calls, e.g. 2 or 0.5 (0 disables, overrides config)")
+	rootCmd.PersistentFlags().String("bandwidth-limit", "", "Maximum total download speed in bytes per second, e.g. 10M or 512k (empty disables, overrides config)")
+	_ = viper.BindPFlag("ratelimit", rootCmd.PersistentFlags().Lookup("rate-limit"))
+	_ = viper.BindPFlag("bandwidthlimit", rootCmd.PersistentFlags().Lookup("bandwidth-limit"))
+
+	// Add persistent flag for machine-readable final summaries
+	rootCmd.PersistentFlags().Bool("json", false, "Print the final summary of download, images and db verify, or the entries of db view and db search, as JSON on stdout (logs, progress and prompts go to stderr)")
+	_ = viper.BindPFlag("json", rootCmd.PersistentFlags().Lookup("json"))
+
+	// Add persistent flag to run without the Bleve search index
+	rootCmd.PersistentFlags().Bool("no-index", false, "Do not open or update the Bleve search index (overrides config)")
+	_ = viper.BindPFlag("noindex", rootCmd.PersistentFlags().Lookup("no-index"))
+
 	// Set Viper defaults (these are applied only if not set in config file or by flag)
 	viper.SetDefault("apidelayms", 200)         // Default polite delay
 	viper.SetDefault("apiclienttimeoutsec", 60) // Default timeout
@@ -134,7 +212,7 @@ func loadGlobalConfig(cmd *cobra.Command, args []string) error {
 
 	// Normalize keys (e.g., from config like BaseModels to BASMODELS)
 	// Might help resolve precedence issues with bound flags
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.SetEnvKeyReplacer(envKeyReplacer)
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
@@ -162,6 +240,15 @@ func loadGlobalConfig(cmd *cobra.Command, args []string) error {
 	}
 	// --- End Viper config file reading ---
 
+	// Merge the selected profile over the base config before flags take effect
+	profile, _ := cmd.Flags().GetString("profile")
+	if err := applyConfigProfile(viper.GetViper(), profile); err != nil {
+		return err
+	}
+
+	// Relative paths in the config file are relative to the file, not the working directory
+	applyConfigRelativePaths(viper.GetViper(), cmd)
+
 	// --- Unmarshal directly from the global viper instance AFTER ReadInConfig/Merge ---
 	if err := viper.Unmarshal(&globalConfig); err != nil {
 		log.WithError(err).Warnf("Error unmarshalling config into globalConfig struct: %v", err)
@@ -172,6 +259,21 @@ func loadGlobalConfig(cmd *cobra.Command, args []string) error {
 
 	log.Debug("Config loaded (or attempted). Viper will manage value precedence.")
 
+	// Configure the circuit breakers shared by API requests and downloads
+	api.ConfigureBreakers(viper.GetInt("breakerthreshold"), viper.GetDuration("breakercooldown"))
+
+	// Configure the request and bandwidth limits shared by all HTTP clients
+	bandwidthLimit, err := helpers.ParseByteSize(viper.GetString("bandwidthlimit"))
+	if err != nil {
+		return fmt.Errorf("invalid --bandwidth-limit: %w", err)
+	}
+	api.ConfigureRateLimits(viper.GetFloat64("ratelimit"), bandwidthLimit)
+
+	// Point API requests at a proxy or mirror if one is configured
+	if err := api.ConfigureBaseURL(viper.GetString("apibaseurl")); err != nil {
+		return fmt.Errorf("invalid --api-base-url: %w", err)
+	}
+
 	baseTransport := http.DefaultTransport
 
 	// Check if API logging is enabled using Viper
@@ -204,9 +306,126 @@ func loadGlobalConfig(cmd *cobra.Command, args []string) error {
 			globalHttpTransport = loggingTransport // Use the wrapped transport
 		}
 	}
+	globalHttpTransport = api.NewRateLimitTransport(globalHttpTransport)
 	// --- End Setup Global HTTP Transport ---
 
+	// Bound the whole command by --deadline. HTTP transports are bound to the
+	// command context so in-flight requests are cancelled when it expires.
+	if deadline := viper.GetDuration("deadline"); deadline > 0 {
+		ctx, cancel := context.WithTimeout(cmd.Context(), deadline)
+		cmd.SetContext(ctx)
+		globalHttpTransport = api.NewContextTransport(globalHttpTransport, ctx)
+		go watchDeadline(ctx, cancel, deadline)
+		log.Debugf("Command deadline set to %v", deadline)
+	}
+
 	// If successful or partially successful, globalConfig is populated for use by commands.
 	// BUT: Rely on viper.Get*() for values potentially overridden by flags.
 	return nil
 }
+
+// applyConfigProfile merges the [profiles.<name>] table of v's config file over the
+// base config. The profile goes into the config layer instead of being v.Set, so
+// flags and environment variables still take precedence over it. An empty name
+// does nothing.
+func applyConfigProfile(v *viper.Viper, name string) error {
+	if name == "" {
+		return nil
+	}
+	key := "profiles." + strings.ToLower(name)
+	if !v.InConfig(key) {
+		return fmt.Errorf("profile %q not found in config file %s", name, v.ConfigFileUsed())
+	}
+	if err := v.MergeConfigMap(v.GetStringMap(key)); err != nil {
+		return fmt.Errorf("failed to apply profile %q: %w", name, err)
+	}
+	log.Infof("Using config profile %q", name)
+	return nil
+}
+
+// envKeyReplacer maps viper keys to the names of the environment variables
+// AutomaticEnv reads them from (after upper-casing).
+var envKeyReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// configPathKeys maps the path settings resolved against the config file's directory
+// to the flag that overrides each of them ("" if there is none).
+var configPathKeys = map[string]string{
+	"savepath":       "save-path",
+	"databasepath":   "",
+	"bleveindexpath": "bleve-index-path",
+}
+
+// resolveConfigRelativePath returns path joined to the directory of configFile if it
+// is relative. Empty and absolute paths, or an empty configFile, leave it unchanged.
+func resolveConfigRelativePath(path, configFile string) string {
+	if path == "" || configFile == "" || filepath.IsAbs(path) {
+		return path
+	}
+	configDir, err := filepath.Abs(filepath.Dir(configFile))
+	if err != nil {
+		log.WithError(err).Warnf("Cannot resolve the directory of config file %s, leaving %q relative to the working directory.", configFile, path)
+		return path
+	}
+	return filepath.Join(configDir, path)
+}
+
+// applyConfigRelativePaths resolves the path settings that come from v's config file
+// against its directory. Values given by a flag of cmd or an environment variable
+// stay relative to the working directory.
+func applyConfigRelativePaths(v *viper.Viper, cmd *cobra.Command) {
+	configFile := v.ConfigFileUsed()
+	if configFile == "" {
+		return
+	}
+	for key, flagName := range configPathKeys {
+		if !v.InConfig(key) {
+			continue
+		}
+		if flagName != "" && cmd.Flags().Changed(flagName) {
+			continue
+		}
+		if os.Getenv(strings.ToUpper(envKeyReplacer.Replace(key))) != "" {
+			continue
+		}
+		if path := v.GetString(key); path != "" && !filepath.IsAbs(path) {
+			resolved := resolveConfigRelativePath(path, configFile)
+			log.Debugf("Resolved %s %q relative to the config file: %s", key, path, resolved)
+			v.Set(key, resolved)
+		}
+	}
+}
+
+// watchDeadline waits for the command context to end. If the deadline passed, it
+// reports it and, if the command has not wound down within deadlineGracePeriod,
+// exits the process with a non-zero status.
+func watchDeadline(ctx context.Context, cancel context.CancelFunc, deadline time.Duration) {
+	defer cancel()
+	<-ctx.Done()
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return
+	}
+	deadlineExceeded.Store(true)
+	log.Errorf("Deadline of %v exceeded, cancelling in-flight requests.", deadline)
+	time.Sleep(deadlineGracePeriod)
+	log.Errorf("Command did not stop within %v after the deadline, exiting.", deadlineGracePeriod)
+	api.CloseAllLoggingTransports()
+	os.Exit(1)
+}
+
+// commandContext returns the context of cmd, or context.Background() for a command
+// that was not started through Execute (e.g. in tests).
+func commandContext(cmd *cobra.Command) context.Context {
+	if ctx := cmd.Context(); ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+// withCommandContext binds transport to the command context when a --deadline is
+// set, so requests made through it are cancelled once the deadline passes.
+func withCommandContext(cmd *cobra.Command, transport http.RoundTripper) http.RoundTripper {
+	if viper.GetDuration("deadline") <= 0 {
+		return transport
+	}
+	return api.NewContextTransport(transport, cmd.Context())
+}
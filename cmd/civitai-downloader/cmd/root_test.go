@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// TestApplyConfigRelativePaths loads a config with relative paths from a temp dir and
+// checks they are resolved against that dir, while a --save-path flag or a SAVEPATH
+// environment variable is left alone.
+func TestApplyConfigRelativePaths(t *testing.T) {
+	configDir := t.TempDir()
+	configFile := filepath.Join(configDir, "config.toml")
+	content := "SavePath = \"downloads\"\nDatabasePath = \"data/civitai.db\"\nBleveIndexPath = \"/abs/index\"\n"
+	if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func(flagArgs ...string) *viper.Viper {
+		t.Helper()
+		v := viper.New()
+		v.SetConfigFile(configFile)
+		v.AutomaticEnv()
+		v.SetEnvKeyReplacer(envKeyReplacer)
+		if err := v.ReadInConfig(); err != nil {
+			t.Fatalf("ReadInConfig() error = %v", err)
+		}
+		cmd := &cobra.Command{}
+		cmd.Flags().String("save-path", "", "")
+		cmd.Flags().String("bleve-index-path", "", "")
+		if err := cmd.Flags().Parse(flagArgs); err != nil {
+			t.Fatal(err)
+		}
+		_ = v.BindPFlag("savepath", cmd.Flags().Lookup("save-path"))
+		applyConfigRelativePaths(v, cmd)
+		return v
+	}
+
+	v := load()
+	if got, want := v.GetString("savepath"), filepath.Join(configDir, "downloads"); got != want {
+		t.Errorf("SavePath = %q, want %q", got, want)
+	}
+	if got, want := v.GetString("databasepath"), filepath.Join(configDir, "data", "civitai.db"); got != want {
+		t.Errorf("DatabasePath = %q, want %q", got, want)
+	}
+	if got := v.GetString("bleveindexpath"); got != "/abs/index" {
+		t.Errorf("absolute BleveIndexPath = %q, want it unchanged", got)
+	}
+
+	if got := load("--save-path", "elsewhere").GetString("savepath"); got != "elsewhere" {
+		t.Errorf("SavePath from --save-path = %q, want it left relative to the working directory", got)
+	}
+
+	t.Setenv("SAVEPATH", "from-env")
+	if got := load().GetString("savepath"); got != "from-env" {
+		t.Errorf("SavePath from SAVEPATH = %q, want it left relative to the working directory", got)
+	}
+}
+
+// TestApplyConfigProfile checks a profile's ModelTypes override the base config while
+// the other base settings and a --sort flag are kept.
+func TestApplyConfigProfile(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	content := `ModelTypes = ["Checkpoint"]
+Sort = "Most Downloaded"
+BaseModels = ["SD 1.5"]
+
+[profiles.sdxl-loras]
+ModelTypes = ["LORA"]
+BaseModels = ["SDXL 1.0"]
+Sort = "Newest"
+`
+	if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatalf("ReadInConfig() error = %v", err)
+	}
+	cmd := &cobra.Command{}
+	cmd.Flags().String("sort", "", "")
+	if err := cmd.Flags().Parse([]string{"--sort", "Highest Rated"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = v.BindPFlag("sort", cmd.Flags().Lookup("sort"))
+
+	if err := applyConfigProfile(v, "sdxl-loras"); err != nil {
+		t.Fatalf("applyConfigProfile() error = %v", err)
+	}
+	if got := v.GetStringSlice("modeltypes"); len(got) != 1 || got[0] != "LORA" {
+		t.Errorf("ModelTypes = %v, want the profile's [LORA]", got)
+	}
+	if got := v.GetStringSlice("basemodels"); len(got) != 1 || got[0] != "SDXL 1.0" {
+		t.Errorf("BaseModels = %v, want the profile's [SDXL 1.0]", got)
+	}
+	if got := v.GetString("sort"); got != "Highest Rated" {
+		t.Errorf("Sort = %q, want the --sort flag to override the profile", got)
+	}
+
+	if err := applyConfigProfile(v, "missing"); err == nil {
+		t.Error("applyConfigProfile() accepted a profile that is not in the config")
+	}
+}
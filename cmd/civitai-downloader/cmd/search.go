@@ -1,22 +1,30 @@
 package cmd
 
 import (
-
-	// Import bleve package directly
-
 	"github.com/spf13/cobra"
 )
 
 // Variable shared by subcommands
 var searchQuery string
 
-// searchCmd represents the base search command when called without subcommands.
+// searchCmd represents the base search command. Given a query it searches the models
+// index like 'search models -q'.
 var searchCmd = &cobra.Command{
-	Use:   "search",
+	Use:   "search [QUERY]",
 	Short: "Search the Bleve index for downloaded models or images",
-	Long: `Provides subcommands to search the Bleve index created during downloads.
-Use 'search models' or 'search images'.`,
-	// No Run function, this is a parent command
+	Long: `Searches the Bleve index created during downloads. 'search <QUERY>' searches the
+models index, the same as 'search models -q <QUERY>'; use 'search images' for the
+images index. Each hit shows its directory and, if the torrent command has run for
+it, the torrent path and magnet link.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			_ = cmd.Help()
+			return
+		}
+		searchQuery = args[0]
+		runSearchModels(cmd, args)
+	},
 }
 
 func init() {
@@ -24,6 +32,3 @@ func init() {
 
 	// No flags defined here, they belong to subcommands (models, images)
 }
-
-// runSearch has been moved to search_logic.go as runSearchLogic
-// func runSearch(cmd *cobra.Command, args []string) { ... }
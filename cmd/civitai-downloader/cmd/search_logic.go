@@ -2,10 +2,14 @@ package cmd
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"sort"
 
 	index "go-civitai-download/index"
 
 	"github.com/blevesearch/bleve/v2" // Import bleve package directly
+	"github.com/blevesearch/bleve/v2/search"
 	log "github.com/sirupsen/logrus"
 	// Note: No cobra import needed here as flags are handled by subcommands
 )
@@ -60,16 +64,38 @@ func runSearchLogic(indexPath string, query string) {
 		searchResults.Took)
 
 	if searchResults.Total > 0 {
-		fmt.Println("--- Search Results ---")
-		for i, hit := range searchResults.Hits {
-			fmt.Printf("[%d] ID: %s (Score: %.2f)\n", i+1, hit.ID, hit.Score)
-			// Print requested fields (all fields are requested by SearchIndex)
-			for field, value := range hit.Fields {
-				fmt.Printf("  %s: %v\n", field, value)
-			}
-			fmt.Println("---")
-		}
+		writeSearchHits(os.Stdout, searchResults.Hits)
 	} else {
 		fmt.Println("No results found matching your query.")
 	}
 }
+
+// searchHitKeyFields are printed first for every hit, in this order, when present:
+// what the item is and where to find it. The remaining fields follow sorted by name.
+var searchHitKeyFields = []string{"modelName", "versionName", "name", "directoryPath", "torrentPath", "magnetLink"}
+
+// writeSearchHits prints the hits of a search with their stored fields.
+func writeSearchHits(w io.Writer, hits search.DocumentMatchCollection) {
+	fmt.Fprintln(w, "--- Search Results ---")
+	for i, hit := range hits {
+		fmt.Fprintf(w, "[%d] ID: %s (Score: %.2f)\n", i+1, hit.ID, hit.Score)
+		printed := make(map[string]bool, len(searchHitKeyFields))
+		for _, field := range searchHitKeyFields {
+			if value, ok := hit.Fields[field]; ok {
+				fmt.Fprintf(w, "  %s: %v\n", field, value)
+				printed[field] = true
+			}
+		}
+		rest := make([]string, 0, len(hit.Fields))
+		for field := range hit.Fields {
+			if !printed[field] {
+				rest = append(rest, field)
+			}
+		}
+		sort.Strings(rest)
+		for _, field := range rest {
+			fmt.Fprintf(w, "  %s: %v\n", field, hit.Fields[field])
+		}
+		fmt.Fprintln(w, "---")
+	}
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	index "go-civitai-download/index"
+)
+
+// TestSearchIndexHits indexes two model files and checks a query returns only the
+// matching one, printed with its directory, torrent path and magnet link.
+func TestSearchIndexHits(t *testing.T) {
+	bleveIndex, err := index.OpenOrCreateIndex(filepath.Join(t.TempDir(), "civitai.bleve"))
+	if err != nil {
+		t.Fatalf("OpenOrCreateIndex() error = %v", err)
+	}
+	defer bleveIndex.Close()
+
+	items := []index.Item{
+		{ID: "v_1", Type: "model_file", Name: "lineart.safetensors", ModelName: "Anime Lineart", DirectoryPath: "/models/lora/anime_lineart/1-lineart",
+			TorrentPath: "/torrents/anime_lineart.torrent", MagnetLink: "magnet:?xt=urn:btih:abc"},
+		{ID: "v_2", Type: "model_file", Name: "vision.safetensors", ModelName: "Realistic Vision", DirectoryPath: "/models/checkpoint/realistic_vision/2-vision"},
+	}
+	for _, item := range items {
+		if err := index.IndexItem(bleveIndex, item); err != nil {
+			t.Fatalf("IndexItem(%s) error = %v", item.ID, err)
+		}
+	}
+
+	results, err := index.SearchIndex(bleveIndex, "lineart")
+	if err != nil {
+		t.Fatalf("SearchIndex() error = %v", err)
+	}
+	if len(results.Hits) != 1 || results.Hits[0].ID != "v_1" {
+		t.Fatalf("SearchIndex(\"lineart\") hits = %v, want only v_1", results.Hits)
+	}
+
+	var out bytes.Buffer
+	writeSearchHits(&out, results.Hits)
+	for _, want := range []string{"ID: v_1", "directoryPath: /models/lora/anime_lineart/1-lineart", "torrentPath: /torrents/anime_lineart.torrent", "magnetLink: magnet:?xt=urn:btih:abc"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("search output is missing %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Index(out.String(), "modelName:") > strings.Index(out.String(), "directoryPath:") {
+		t.Errorf("search output does not list the model name before the paths:\n%s", out.String())
+	}
+}
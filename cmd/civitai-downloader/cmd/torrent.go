@@ -1,28 +1,30 @@
 package cmd
 
 import (
-	"encoding/json"
+	"encoding/csv"
 	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"text/tabwriter"
 	"time"
 
 	"github.com/anacrolix/torrent/bencode"
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/blevesearch/bleve/v2"
-	"github.com/blevesearch/bleve/v2/search/query" // Import for query types
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
 	index "go-civitai-download/index"
 	"go-civitai-download/internal/database"
-	"go-civitai-download/internal/models"
+	"go-civitai-download/internal/helpers"
 )
 
 // Struct to hold job parameters for torrent workers
@@ -32,74 +34,50 @@ type torrentJob struct {
 	OutputDir      string
 	Overwrite      bool
 	GenerateMagnet bool
-	LogFields      log.Fields // For context in worker logs
-	ModelID        int        // ID of the parent model
-	ModelName      string     // Name of the model
-	ModelType      string     // Type of the model (e.g., LORA, Checkpoint) - Keep for potential use if Item struct changes
+	Magnet         magnetOutput // Format and optional aggregate file for magnet links
+	HashWorkers    int          // Goroutines hashing pieces of this directory (1 uses the library's sequential hashing)
+	PieceLength    int64        // Piece length in bytes, 0 to pick one from the directory size
+	Private        bool         // Set the private flag so clients only use the listed trackers
+	WebSeeds       []string     // HTTP web seeds (BEP 19 url-list), empty unless --web-seeds is set
+	LogFields      log.Fields   // For context in worker logs
+	ModelID        int          // ID of the parent model
+	ModelName      string       // Name of the model
+	ModelType      string       // Type of the model (e.g., LORA, Checkpoint) - Keep for potential use if Item struct changes
 	BleveIndex     bleve.Index
 }
 
 // Helper to update or create the index item for a model torrent
 func updateModelTorrentIndex(job torrentJob, torrentPath, magnetURI string) error {
 	modelItemID := fmt.Sprintf("m_%d", job.ModelID) // Index key for the model
-	var itemToUpdate index.Item
 
-	// --- Search for existing model item ---
-	termQuery := query.NewTermQuery(modelItemID)
-	termQuery.SetField("_id") // Search by document ID
-	searchRequest := bleve.NewSearchRequest(termQuery)
-	searchRequest.Size = 1 // We only need to know if it exists
-
-	searchResult, err := job.BleveIndex.Search(searchRequest)
+	// --- Fetch the existing model item so fields set by other commands are kept ---
+	itemToUpdate, found, err := index.GetItem(job.BleveIndex, modelItemID)
 	if err != nil {
 		log.WithFields(job.LogFields).WithError(err).Errorf("Error searching for existing index item %s", modelItemID)
 		return fmt.Errorf("error searching index for %s: %w", modelItemID, err)
 	}
 
-	if searchResult.Total > 0 {
-		// --- Item exists, fetch it to update ---
-		docID := searchResult.Hits[0].ID
-		_, err := job.BleveIndex.Document(docID) // Use blank identifier as doc is not used
-		if err != nil {
-			log.WithFields(job.LogFields).WithError(err).Warnf("Found index item %s in search but failed to retrieve document. Will attempt to create new.", modelItemID)
-			// Proceed to create new item below
-		} else {
-			// Found existing document, try to unmarshal its fields
-			// This assumes the document fields match the index.Item struct
-			// A more robust way involves iterating fields: doc.VisitFields(...)
-			// But let's try direct unmarshal for simplicity first.
-			// We need the raw bytes stored under a known field or use Bleve's internal storage retrieval if possible.
-			// Simplification: Let's recreate the item with known fields + new torrent info.
-			// This might lose some fields if the original index item had more, but ensures core info is present.
-			log.WithFields(job.LogFields).Debugf("Found existing index item %s, preparing update.", modelItemID)
-			itemToUpdate = index.Item{
-				ID:            modelItemID,
-				Type:          "model",
-				ModelName:     job.ModelName,
-				DirectoryPath: job.SourcePath,
-				// Preserve other fields if fetched, otherwise they get defaults
-			}
-			// If we successfully fetched and unmarshalled the *full* existing item,
-			// we would just modify itemToUpdate.TorrentPath and itemToUpdate.MagnetLink here.
-		}
-	}
-
-	// --- If item didn't exist or fetch failed, create a new one ---
-	if itemToUpdate.ID == "" { // Check if we need to create
-		log.WithFields(job.LogFields).Debugf("Index item %s not found or fetch failed, creating new item.", modelItemID)
+	// --- If item didn't exist, create a new one ---
+	if !found {
+		log.WithFields(job.LogFields).Debugf("Index item %s not found, creating new item.", modelItemID)
 		itemToUpdate = index.Item{
-			ID:        modelItemID,
-			Type:      "model", // Indicate this is a model-level item
-			ModelName: job.ModelName,
-			// ModelType:     job.ModelType, // REMOVED - Field does not exist in index.Item
+			ID:            modelItemID,
+			Type:          "model", // Indicate this is a model-level item
+			ModelName:     job.ModelName,
 			DirectoryPath: job.SourcePath, // Path to the main model directory
-			// Add other essential fields if needed/available, otherwise leave blank
 		}
+	} else {
+		log.WithFields(job.LogFields).Debugf("Found existing index item %s, preparing update.", modelItemID)
 	}
 
-	// Add/Update torrent info
-	itemToUpdate.TorrentPath = torrentPath
-	itemToUpdate.MagnetLink = magnetURI // Store the actual magnet URI
+	// Add/Update torrent info. Empty values (no .torrent written with --magnet-only,
+	// or an existing torrent skipped) keep what the item already has.
+	if torrentPath != "" {
+		itemToUpdate.TorrentPath = torrentPath
+	}
+	if magnetURI != "" {
+		itemToUpdate.MagnetLink = magnetURI // Store the actual magnet URI
+	}
 
 	// Update the index
 	if err := index.IndexItem(job.BleveIndex, itemToUpdate); err != nil { // Pass by value ok here
@@ -119,7 +97,7 @@ func torrentWorker(id int, jobs <-chan torrentJob, wg *sync.WaitGroup, successCo
 		log.WithFields(job.LogFields).Infof("Worker %d: Processing torrent job for model directory %s", id, job.SourcePath)
 		// Generate torrent for the entire model directory
 		// Capture magnetPath (_), as we don't need it for indexing anymore, but need the magnetURI
-		torrentPath, _, magnetURI, err := generateTorrentFile(job.SourcePath, job.Trackers, job.OutputDir, job.Overwrite, job.GenerateMagnet)
+		torrentPath, _, magnetURI, err := generateTorrentFile(job.SourcePath, job.Trackers, job.OutputDir, job.Overwrite, job.GenerateMagnet, job.Magnet, job.HashWorkers, job.PieceLength, job.Private, job.WebSeeds)
 		if err != nil {
 			log.WithFields(job.LogFields).WithError(err).Errorf("Worker %d: Failed to generate torrent for %s", id, job.SourcePath)
 			failureCounter.Add(1)
@@ -156,8 +134,10 @@ var torrentCmd = &cobra.Command{
 encompassing all its downloaded versions and files. Requires access to the download history database
 and the downloaded files themselves. You must specify tracker announce URLs.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if len(announceURLs) == 0 {
-			return errors.New("at least one --announce URL is required")
+		dryRun := viper.GetBool("torrent.dryrun")
+		trackers := mergeTrackers(announceURLs, viper.GetStringSlice("torrent.trackers"))
+		if len(trackers) == 0 && !dryRun {
+			return errors.New("at least one --announce URL (or Trackers entry in the [torrent] config section) is required")
 		}
 
 		// Retrieve settings using Viper
@@ -181,133 +161,92 @@ and the downloaded files themselves. You must specify tracker announce URLs.`,
 		}
 		defer db.Close()
 
-		indexPath := viper.GetString("bleveindexpath") // Use viper
-		if indexPath == "" {
-			indexPath = filepath.Join(savePath, "civitai.bleve")
-			log.Warnf("BleveIndexPath not set in config, defaulting to: %s", indexPath)
-		}
-		log.Infof("Opening/Creating Bleve index at: %s", indexPath)
-		bleveIndex, err := index.OpenOrCreateIndex(indexPath)
-		if err != nil {
-			log.WithError(err).Error("Failed to open or create Bleve index")
-			// Attempt to close index even if opening failed (might be partially open)
-			if bleveIndex != nil {
-				_ = bleveIndex.Close() // Ignore error on close attempt here
+		// The index is left untouched in dry-run mode and with --no-index; jobs then carry a nil index.
+		var bleveIndex bleve.Index
+		if !dryRun && !viper.GetBool("noindex") {
+			indexPath := viper.GetString("bleveindexpath") // Use viper
+			if indexPath == "" {
+				indexPath = filepath.Join(savePath, "civitai.bleve")
+				log.Warnf("BleveIndexPath not set in config, defaulting to: %s", indexPath)
 			}
-			return fmt.Errorf("failed to open or create Bleve index: %w", err)
-		}
-		defer func() {
-			log.Info("Closing Bleve index")
-			if err := bleveIndex.Close(); err != nil {
-				log.WithError(err).Error("Error closing Bleve index")
+			log.Infof("Opening/Creating Bleve index at: %s", indexPath)
+			bleveIndex, err = index.OpenOrCreateIndex(indexPath)
+			if err != nil {
+				log.WithError(err).Error("Failed to open or create Bleve index")
+				// Attempt to close index even if opening failed (might be partially open)
+				if bleveIndex != nil {
+					_ = bleveIndex.Close() // Ignore error on close attempt here
+				}
+				return fmt.Errorf("failed to open or create Bleve index: %w", err)
 			}
-		}()
+			defer func() {
+				log.Info("Closing Bleve index")
+				if err := bleveIndex.Close(); err != nil {
+					log.WithError(err).Error("Error closing Bleve index")
+				}
+			}()
+		}
 
 		// Retrieve bound flag values using Viper
 		torrentOutputDirEffective := viper.GetString("torrent.outputdir")
 		overwriteTorrentsEffective := viper.GetBool("torrent.overwrite")
-		generateMagnetLinksEffective := viper.GetBool("torrent.magnetlinks")
-
-		// Map to store model directory paths and associated info (to avoid duplicate jobs)
-		modelDirsToProcess := make(map[string]torrentJob)
-		modelIDSet := make(map[int]struct{})
-		if len(torrentModelIDs) > 0 {
-			for _, id := range torrentModelIDs {
-				modelIDSet[id] = struct{}{}
-			}
+		magnetOnly := viper.GetBool("torrent.magnetonly")
+		generateMagnetLinksEffective := viper.GetBool("torrent.magnetlinks") || magnetOnly
+		magnetFormat := strings.ToLower(viper.GetString("torrent.magnetformat"))
+		if !isValidMagnetFormat(magnetFormat) {
+			return fmt.Errorf("invalid --magnet-format %q: must be one of raw, labeled, csv", magnetFormat)
 		}
-
-		log.Info("Scanning database to identify model directories...")
-		errFold := db.Fold(func(key []byte, value []byte) error {
-			keyStr := string(key)
-			// Process only version entries ('v_*') as they contain path info
-			if !strings.HasPrefix(keyStr, "v_") {
-				return nil
-			}
-
-			var entry models.DatabaseEntry
-			if err := json.Unmarshal(value, &entry); err != nil {
-				log.WithError(err).Warnf("Failed to unmarshal JSON for key %s, skipping", keyStr)
-				return nil
-			}
-
-			// Filter by specific model IDs if provided
-			if len(torrentModelIDs) > 0 {
-				if _, exists := modelIDSet[entry.Version.ModelId]; !exists {
-					return nil // Skip if not in the target model ID list
-				}
-			}
-
-			if entry.Folder == "" {
-				log.WithFields(log.Fields{
-					"modelID":   entry.Version.ModelId,
-					"versionID": entry.Version.ID,
-					"key":       keyStr,
-				}).Warn("Skipping entry due to missing Folder path.")
-				return nil
+		magnetCollectPath := viper.GetString("torrent.magnetcollect")
+		hashWorkers := viper.GetInt("torrent.hashworkers")
+		if hashWorkers < 1 {
+			return fmt.Errorf("invalid --hash-workers %d: must be at least 1", hashWorkers)
+		}
+		pieceLength, err := parsePieceLength(viper.GetString("torrent.piecelength"))
+		if err != nil {
+			return fmt.Errorf("invalid --piece-length: %w", err)
+		}
+		// The deprecated --piece-length-kib (PieceLengthKiB) still applies unless --piece-length is given
+		if kib := viper.GetInt("torrent.piecelengthkib"); kib != 0 && !cmd.Flags().Changed("piece-length") {
+			pieceLength = int64(kib) * 1024
+			if err := validatePieceLength(pieceLength); err != nil {
+				return fmt.Errorf("invalid --piece-length-kib %d: %w", kib, err)
 			}
+		}
+		privateTorrents := viper.GetBool("torrent.private")
+		webSeeds := viper.GetBool("torrent.webseeds")
 
-			// --- Derive the MODEL directory path ---
-			// Assumes Folder structure is like: type/modelName/baseModel/versionSlug
-			// We want: savePath/type/modelName
-			// versionDir := filepath.Join(savePath, entry.Folder) // Removed unused variable
-			// Need to handle potential variations in depth, e.g. if Base Model isn't used as a dir level
-			// Let's assume the first component of entry.Folder is the type, and the second is the model name slug.
-			folderParts := strings.Split(entry.Folder, string(filepath.Separator))
-			if len(folderParts) < 2 {
-				log.WithFields(log.Fields{
-					"modelID":   entry.Version.ModelId,
-					"versionID": entry.Version.ID,
-					"folder":    entry.Folder,
-				}).Warn("Could not reliably determine model directory from Folder path (not enough parts), skipping entry.")
-				return nil
+		modelDirs, err := scanModelDirectories(db, savePath, torrentModelIDs)
+		if err != nil {
+			return err
+		}
+		// One job per model directory
+		modelDirsToProcess := make(map[string]torrentJob, len(modelDirs))
+		for modelDir, dir := range modelDirs {
+			var seeds []string
+			if webSeeds {
+				seeds = dir.DownloadURLs
 			}
-			modelTypePart := folderParts[0]
-			modelNamePart := folderParts[1]
-			modelDir := filepath.Join(savePath, modelTypePart, modelNamePart)
-
-			// Check if this model directory is already marked for processing
-			if _, exists := modelDirsToProcess[modelDir]; !exists {
-				log.Debugf("Identified model directory to process: %s (from version %d)", modelDir, entry.Version.ID)
-
-				// Determine Model Type from version info (use first part of folder as fallback)
-				modelType := "unknown_type"
-				if entry.ModelType != "" { // Check DbEntry.ModelType first
-					modelType = entry.ModelType
-				} else if entry.Version.Model.Type != "" { // Then check embedded Model Type
-					modelType = entry.Version.Model.Type
-				} else if modelTypePart != "" {
-					modelType = modelTypePart // Fallback to path component
-					log.Warnf("Could not determine Model Type directly for model ID %d, using path component '%s'.", entry.Version.ModelId, modelType)
-				} else {
-					log.Warnf("Could not determine Model Type for model ID %d, using fallback 'unknown_type'.", entry.Version.ModelId)
-				}
-
-				job := torrentJob{
-					SourcePath:     modelDir, // Target the model directory
-					Trackers:       announceURLs,
-					OutputDir:      torrentOutputDirEffective,    // Use viper value
-					Overwrite:      overwriteTorrentsEffective,   // Use viper value
-					GenerateMagnet: generateMagnetLinksEffective, // Use viper value
-					LogFields: log.Fields{ // Context for the model directory
-						"modelID":   entry.Version.ModelId,
-						"modelName": entry.ModelName, // Use ModelName from entry
-						"directory": modelDir,
-					},
-					ModelID:    entry.Version.ModelId,
-					ModelName:  entry.ModelName,
-					ModelType:  modelType, // Store the determined model type
-					BleveIndex: bleveIndex,
-				}
-				modelDirsToProcess[modelDir] = job
+			modelDirsToProcess[modelDir] = torrentJob{
+				SourcePath:     modelDir, // Target the model directory
+				Trackers:       trackers,
+				OutputDir:      torrentOutputDirEffective,    // Use viper value
+				Overwrite:      overwriteTorrentsEffective,   // Use viper value
+				GenerateMagnet: generateMagnetLinksEffective, // Use viper value
+				Magnet:         magnetOutput{Format: magnetFormat, Name: dir.ModelName, Only: magnetOnly},
+				HashWorkers:    hashWorkers,
+				PieceLength:    pieceLength,
+				Private:        privateTorrents,
+				WebSeeds:       seeds,
+				LogFields: log.Fields{ // Context for the model directory
+					"modelID":   dir.ModelID,
+					"modelName": dir.ModelName,
+					"directory": modelDir,
+				},
+				ModelID:    dir.ModelID,
+				ModelName:  dir.ModelName,
+				ModelType:  dir.ModelType,
+				BleveIndex: bleveIndex,
 			}
-
-			return nil
-		})
-
-		if errFold != nil {
-			log.WithError(errFold).Error("Error scanning database")
-			return fmt.Errorf("error scanning database: %w", errFold)
 		}
 
 		if len(modelDirsToProcess) == 0 {
@@ -319,6 +258,29 @@ and the downloaded files themselves. You must specify tracker announce URLs.`,
 			return nil
 		}
 
+		if dryRun {
+			printTorrentDryRun(modelDirsToProcess)
+			return nil
+		}
+
+		// All magnets go to one shared file instead of per-directory files when collecting
+		if magnetCollectPath != "" {
+			collector, err := newMagnetCollector(magnetCollectPath, magnetFormat)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := collector.Close(); err != nil {
+					log.WithError(err).Errorf("Error closing magnet collect file %s", magnetCollectPath)
+				}
+			}()
+			for dir, job := range modelDirsToProcess {
+				job.Magnet.Collector = collector
+				modelDirsToProcess[dir] = job
+			}
+			log.Infof("Appending magnet links (%s format) to %s", magnetFormat, magnetCollectPath)
+		}
+
 		log.Infof("Generating torrents for %d unique model directories using %d workers...", len(modelDirsToProcess), concurrency)
 
 		// --- Worker Pool Setup ---
@@ -359,11 +321,140 @@ and the downloaded files themselves. You must specify tracker announce URLs.`,
 	},
 }
 
+// printTorrentDryRun lists the model directories that would be processed, where each
+// .torrent would be written and whether it already exists. Nothing is created.
+func printTorrentDryRun(modelDirsToProcess map[string]torrentJob) {
+	dirs := make([]string, 0, len(modelDirsToProcess))
+	for dir := range modelDirsToProcess {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Model ID\tModel Name\tModel Directory\tTorrent Path\tStatus")
+	fmt.Fprintln(w, "--------\t----------\t---------------\t------------\t------")
+	var existing, toCreate, missingDirs int
+	for _, dir := range dirs {
+		job := modelDirsToProcess[dir]
+		outPath := torrentOutputPath(job.SourcePath, job.OutputDir)
+		if job.Magnet.Only {
+			outPath = magnetOutputPath(outPath)
+		}
+
+		status := "would create"
+		if _, err := os.Stat(job.SourcePath); err != nil {
+			status = "model directory missing"
+			missingDirs++
+		} else if _, err := os.Stat(outPath); err == nil {
+			if job.Overwrite {
+				status = "exists (would overwrite)"
+				toCreate++
+			} else {
+				status = "exists (would skip)"
+				existing++
+			}
+		} else {
+			toCreate++
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", job.ModelID, job.ModelName, job.SourcePath, outPath, status)
+	}
+	w.Flush()
+
+	fmt.Printf("\nDry run: %d model directories, %d file(s) to write, %d already existing, %d missing directories. No files were created.\n",
+		len(dirs), toCreate, existing, missingDirs)
+}
+
+// torrentOutputPath returns where the .torrent for a model directory is written:
+// inside outputDir if set, otherwise inside the model directory itself.
+func torrentOutputPath(sourcePath string, outputDir string) string {
+	torrentFileName := fmt.Sprintf("%s.torrent", filepath.Base(sourcePath))
+	if outputDir != "" {
+		return filepath.Join(outputDir, torrentFileName)
+	}
+	return filepath.Join(sourcePath, torrentFileName)
+}
+
+// magnetOutputPath returns the per-directory magnet link file for a .torrent path.
+func magnetOutputPath(torrentPath string) string {
+	magnetFileName := fmt.Sprintf("%s-magnet.txt", strings.TrimSuffix(filepath.Base(torrentPath), filepath.Ext(torrentPath)))
+	return filepath.Join(filepath.Dir(torrentPath), magnetFileName)
+}
+
+// Piece length limits, and the number of pieces --piece-length auto aims for.
+const (
+	minPieceLength        = 16 * 1024
+	maxPieceLength        = 16 * 1024 * 1024
+	autoPieceLengthTarget = 1500
+)
+
+// parsePieceLength parses a --piece-length value: a byte count with an optional k/M
+// suffix (binary units, e.g. "256k", "1M") or "auto", which returns 0.
+func parsePieceLength(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if strings.EqualFold(value, "auto") {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "k"), strings.HasSuffix(value, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(value, "m"), strings.HasSuffix(value, "M"):
+		multiplier = 1024 * 1024
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a size like 256k or 1M, or auto", value)
+	}
+	length := n * multiplier
+	if err := validatePieceLength(length); err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// validatePieceLength checks that length is a power of two between 16 KiB and 16 MiB.
+func validatePieceLength(length int64) error {
+	if length < minPieceLength || length > maxPieceLength || length&(length-1) != 0 {
+		return fmt.Errorf("piece length %d must be a power of two between 16k and 16M", length)
+	}
+	return nil
+}
+
+// autoPieceLength returns the smallest allowed power-of-two piece length that splits
+// totalSize into at most about autoPieceLengthTarget pieces.
+func autoPieceLength(totalSize int64) int64 {
+	length := int64(minPieceLength)
+	for length < maxPieceLength && totalSize/length > autoPieceLengthTarget {
+		length *= 2
+	}
+	return length
+}
+
+// directorySize returns the total size of the regular files below root.
+func directorySize(root string) (int64, error) {
+	var total int64
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			total += fi.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
 // generateTorrentFile creates a .torrent file for the given sourcePath (directory).
-// It can optionally also create a text file containing the magnet link.
-// It returns the path to the generated .torrent file, the magnet link file (if created),
-// the magnet URI string itself, or an error.
-func generateTorrentFile(sourcePath string, trackers []string, outputDir string, overwrite bool, generateMagnetLinks bool) (torrentFilePath string, magnetFilePath string, magnetURI string, err error) {
+// It can optionally also create a text file containing the magnet link, or append the
+// link to a shared collect file when magnet.Collector is set. With magnet.Only the
+// content is still hashed but no .torrent is written, only the magnet link.
+// It returns the path to the generated .torrent file (empty with magnet.Only), the
+// magnet link file (if created), the magnet URI string itself, or an error.
+func generateTorrentFile(sourcePath string, trackers []string, outputDir string, overwrite bool, generateMagnetLinks bool, magnet magnetOutput, hashWorkers int, pieceLength int64, private bool, webSeeds []string) (torrentFilePath string, magnetFilePath string, magnetURI string, err error) {
 	stat, err := os.Stat(sourcePath)
 	if os.IsNotExist(err) {
 		log.WithField("path", sourcePath).Error("Source path not found for torrent generation")
@@ -377,28 +468,48 @@ func generateTorrentFile(sourcePath string, trackers []string, outputDir string,
 	}
 
 	// Use the directory name (which should be the model name slug) for the torrent file
-	torrentFileName := fmt.Sprintf("%s.torrent", filepath.Base(sourcePath))
-	var outPath string
 	if outputDir != "" {
 		// Ensure output directory exists
 		if err := os.MkdirAll(outputDir, 0750); err != nil {
 			log.WithError(err).WithField("dir", outputDir).Error("Error creating output directory")
 			return "", "", "", fmt.Errorf("error creating output directory %s: %w", outputDir, err)
 		}
-		outPath = filepath.Join(outputDir, torrentFileName)
-	} else {
-		// Place the torrent file *inside* the source (model) directory
-		outPath = filepath.Join(sourcePath, torrentFileName)
 	}
+	outPath := torrentOutputPath(sourcePath, outputDir)
 	torrentFilePath = outPath // Assign to return variable
-
-	if !overwrite {
+	if magnet.Only {
+		torrentFilePath = ""
+		// Without a .torrent to check, an existing magnet file is what gets skipped
+		if magnetOutPath := magnetOutputPath(outPath); !overwrite && magnet.Collector == nil {
+			if _, statErr := os.Stat(magnetOutPath); statErr == nil {
+				log.WithField("path", magnetOutPath).Info("Skipping existing magnet link file (use --overwrite to replace)")
+				return "", magnetOutPath, "", nil
+			}
+		}
+	} else if !overwrite {
 		if _, err := os.Stat(outPath); err == nil {
 			log.WithField("path", outPath).Info("Skipping existing torrent file (use --overwrite to replace)")
+			// Existing torrents are still added to the collect file
+			if magnet.Collector != nil {
+				existing, loadErr := metainfo.LoadFromFile(outPath)
+				if loadErr != nil {
+					log.WithError(loadErr).WithField("path", outPath).Warn("Could not read existing torrent file to collect its magnet link")
+					return torrentFilePath, "", "", nil
+				}
+				existingInfo, infoErr := existing.UnmarshalInfo()
+				if infoErr != nil {
+					log.WithError(infoErr).WithField("path", outPath).Warn("Could not decode existing torrent info to collect its magnet link")
+					return torrentFilePath, "", "", nil
+				}
+				magnetURI = buildMagnetURI(existing, existingInfo.Name)
+				if collectErr := magnet.Collector.Add(magnet.Name, existing.HashInfoBytes().HexString(), magnetURI); collectErr != nil {
+					log.WithError(collectErr).Error("Failed to append magnet link to collect file")
+				}
+				return torrentFilePath, "", magnetURI, nil
+			}
 			// If magnet generation is enabled, check if it also exists
 			if generateMagnetLinks {
-				magnetFileName := fmt.Sprintf("%s-magnet.txt", strings.TrimSuffix(filepath.Base(outPath), filepath.Ext(outPath)))
-				magnetOutPath := filepath.Join(filepath.Dir(outPath), magnetFileName)
+				magnetOutPath := magnetOutputPath(outPath)
 				if _, magnetErr := os.Stat(magnetOutPath); magnetErr == nil {
 					magnetFilePath = magnetOutPath // Existing magnet file found
 					log.WithField("path", magnetOutPath).Info("Found existing magnet link file.")
@@ -441,19 +552,36 @@ func generateTorrentFile(sourcePath string, trackers []string, outputDir string,
 		// return "", "", "", errors.New("no valid tracker URLs provided or parsed")
 	}
 
+	// Web seeds live outside the info dictionary, so they do not change the infohash
+	mi.UrlList = webSeeds
+
 	mi.CreatedBy = "go-civitai-download"
 	mi.CreationDate = time.Now().Unix() // Add creation date
 
-	// Use a reasonable piece length (adjust if needed for very large/small files)
-	const pieceLength = 512 * 1024 // 512 KiB
+	if pieceLength == 0 {
+		totalSize, sizeErr := directorySize(sourcePath)
+		if sizeErr != nil {
+			return "", "", "", fmt.Errorf("error measuring %s for the piece length: %w", sourcePath, sizeErr)
+		}
+		pieceLength = autoPieceLength(totalSize)
+		log.WithField("directory", sourcePath).Debugf("Using piece length %s for %s of content", helpers.BytesToSize(uint64(pieceLength)), helpers.BytesToSize(uint64(totalSize)))
+	}
+
 	info := metainfo.Info{
 		PieceLength: pieceLength,
 		Name:        filepath.Base(sourcePath), // Set the base name in the info dict
 	}
+	if private {
+		info.Private = &private
+	}
 
-	log.WithField("directory", sourcePath).Debug("Building torrent info...")
-	// BuildFromFilePath expects the path to the root of the torrent content
-	err = info.BuildFromFilePath(sourcePath)
+	log.WithField("directory", sourcePath).Debugf("Building torrent info with %d hash worker(s)...", max(hashWorkers, 1))
+	if hashWorkers > 1 {
+		err = buildInfoConcurrently(&info, sourcePath, hashWorkers)
+	} else {
+		// BuildFromFilePath expects the path to the root of the torrent content
+		err = info.BuildFromFilePath(sourcePath)
+	}
 	if err != nil {
 		log.WithError(err).WithField("path", sourcePath).Error("Error building torrent info from path")
 		return "", "", "", fmt.Errorf("error building torrent info from path %s: %w", sourcePath, err)
@@ -489,70 +617,32 @@ func generateTorrentFile(sourcePath string, trackers []string, outputDir string,
 	}
 
 	// --- Write Torrent File ---
-	f, err := os.Create(outPath)
-	if err != nil {
-		log.WithError(err).WithField("path", outPath).Error("Error creating torrent file")
-		return "", "", "", fmt.Errorf("error creating torrent file %s: %w", outPath, err)
-	}
-	// Use defer with a closure to check close error
-	defer func() {
-		closeErr := f.Close()
-		if err == nil && closeErr != nil { // Only assign closeErr if no previous error occurred
-			err = fmt.Errorf("error closing torrent file %s: %w", outPath, closeErr)
-			// Attempt cleanup if close fails after successful write
-			if removeErr := os.Remove(outPath); removeErr != nil && !os.IsNotExist(removeErr) {
-				log.WithError(removeErr).Errorf("Failed to clean up partially written torrent file %s after close error", outPath)
-			}
-		}
-	}()
-
-	err = mi.Write(f)
-	if err != nil {
-		log.WithError(err).WithField("path", outPath).Error("Error writing torrent file")
-		// Attempt to remove partially written file on error
-		if removeErr := os.Remove(outPath); removeErr != nil && !os.IsNotExist(removeErr) {
-			log.WithError(removeErr).Warnf("Failed to remove partially written torrent file %s after write error", outPath)
+	if !magnet.Only {
+		if err := writeTorrentFile(&mi, outPath); err != nil {
+			log.WithError(err).WithField("path", outPath).Error("Error writing torrent file")
+			return torrentFilePath, "", "", err
 		}
-		// Return paths but with error, magnet URI is not generated yet or irrelevant due to error
-		return torrentFilePath, magnetFilePath, "", fmt.Errorf("error writing torrent file %s: %w", outPath, err)
+		log.WithField("path", outPath).Info("Successfully generated torrent file")
 	}
 
-	log.WithField("path", outPath).Info("Successfully generated torrent file")
-
 	// --- Generate Magnet Link String (always generated for return value) ---
-	infoHash := mi.HashInfoBytes()
-	magnetParts := []string{
-		fmt.Sprintf("magnet:?xt=urn:btih:%s", infoHash.HexString()),
-		// Use the Name field from the info dict for dn (more reliable than stat.Name())
-		fmt.Sprintf("dn=%s", url.QueryEscape(info.Name)),
-	}
-	// Add trackers from the AnnounceList (which should contain only valid ones)
-	uniqueTrackers := make(map[string]struct{})
-	if mi.Announce != "" { // Add primary announce first if it exists
-		magnetParts = append(magnetParts, fmt.Sprintf("tr=%s", url.QueryEscape(mi.Announce)))
-		uniqueTrackers[mi.Announce] = struct{}{}
-	}
-	for _, tier := range mi.AnnounceList {
-		for _, tracker := range tier {
-			if _, exists := uniqueTrackers[tracker]; !exists {
-				// Double check validity just in case
-				parsedURL, urlErr := url.Parse(tracker)
-				if urlErr == nil && (parsedURL.Scheme == "http" || parsedURL.Scheme == "https" || parsedURL.Scheme == "udp") {
-					magnetParts = append(magnetParts, fmt.Sprintf("tr=%s", url.QueryEscape(tracker)))
-					uniqueTrackers[tracker] = struct{}{}
-				}
-			}
+	// Use the Name field from the info dict for dn (more reliable than stat.Name())
+	magnetURI = buildMagnetURI(&mi, info.Name)
+	infoHash := mi.HashInfoBytes().HexString()
+
+	// --- Append to the shared collect file instead of writing a per-directory file ---
+	if magnet.Collector != nil {
+		if collectErr := magnet.Collector.Add(magnet.Name, infoHash, magnetURI); collectErr != nil {
+			// Like the per-directory file, don't fail torrent generation for this
+			log.WithError(collectErr).Error("Failed to append magnet link to collect file")
 		}
+		return torrentFilePath, "", magnetURI, nil
 	}
-	// Assign the generated magnet URI to the return variable
-	magnetURI = strings.Join(magnetParts, "&")
 
 	// --- Write Magnet Link File (if requested) ---
 	if generateMagnetLinks {
-		// Create magnet file name based on torrent file name
-		magnetFileName := fmt.Sprintf("%s-magnet.txt", strings.TrimSuffix(filepath.Base(outPath), filepath.Ext(outPath)))
 		// Place magnet file next to the torrent file
-		magnetOutPath := filepath.Join(filepath.Dir(outPath), magnetFileName)
+		magnetOutPath := magnetOutputPath(outPath)
 
 		// Handle overwrite for magnet file similar to torrent file
 		writeMagnet := true
@@ -571,7 +661,7 @@ func generateTorrentFile(sourcePath string, trackers []string, outputDir string,
 		}
 
 		if writeMagnet {
-			writeErr := writeMagnetFile(magnetOutPath, magnetURI)
+			writeErr := writeMagnetFile(magnetOutPath, formatMagnetEntry(magnet.Format, magnet.Name, infoHash, magnetURI, true))
 			if writeErr != nil {
 				// Log error but don't fail the whole torrent generation just for the magnet link
 				log.WithError(writeErr).WithField("path", magnetOutPath).Error("Failed to write magnet link file")
@@ -583,12 +673,146 @@ func generateTorrentFile(sourcePath string, trackers []string, outputDir string,
 		}
 	} // End if generateMagnetLinks
 
-	// If we reached here without err being set by defer, it's success
-	return torrentFilePath, magnetFilePath, magnetURI, err // err will be nil on success, or the potential f.Close() error
+	return torrentFilePath, magnetFilePath, magnetURI, nil
+}
+
+// writeTorrentFile writes mi to path, removing the file again if writing fails.
+func writeTorrentFile(mi *metainfo.MetaInfo, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating torrent file %s: %w", path, err)
+	}
+	err = mi.Write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if removeErr := os.Remove(path); removeErr != nil && !os.IsNotExist(removeErr) {
+			log.WithError(removeErr).Warnf("Failed to remove partially written torrent file %s", path)
+		}
+		return fmt.Errorf("error writing torrent file %s: %w", path, err)
+	}
+	return nil
+}
+
+// buildMagnetURI returns the magnet link for a torrent, including its valid trackers.
+func buildMagnetURI(mi *metainfo.MetaInfo, name string) string {
+	magnetParts := []string{
+		fmt.Sprintf("magnet:?xt=urn:btih:%s", mi.HashInfoBytes().HexString()),
+		fmt.Sprintf("dn=%s", url.QueryEscape(name)),
+	}
+	// Add trackers from the AnnounceList (which should contain only valid ones)
+	uniqueTrackers := make(map[string]struct{})
+	if mi.Announce != "" { // Add primary announce first if it exists
+		magnetParts = append(magnetParts, fmt.Sprintf("tr=%s", url.QueryEscape(mi.Announce)))
+		uniqueTrackers[mi.Announce] = struct{}{}
+	}
+	for _, tier := range mi.AnnounceList {
+		for _, tracker := range tier {
+			if _, exists := uniqueTrackers[tracker]; !exists {
+				// Double check validity just in case
+				parsedURL, urlErr := url.Parse(tracker)
+				if urlErr == nil && (parsedURL.Scheme == "http" || parsedURL.Scheme == "https" || parsedURL.Scheme == "udp") {
+					magnetParts = append(magnetParts, fmt.Sprintf("tr=%s", url.QueryEscape(tracker)))
+					uniqueTrackers[tracker] = struct{}{}
+				}
+			}
+		}
+	}
+	return strings.Join(magnetParts, "&")
+}
+
+// magnetOutput controls how magnet links are written for a torrent job.
+type magnetOutput struct {
+	Format    string           // raw, labeled or csv
+	Name      string           // Model name written by the labeled and csv formats
+	Collector *magnetCollector // When set, links are appended here instead of per-directory files
+	Only      bool             // Skip writing the .torrent file (--magnet-only)
+}
+
+// isValidMagnetFormat reports whether format is a supported --magnet-format value.
+func isValidMagnetFormat(format string) bool {
+	switch format {
+	case "raw", "labeled", "csv":
+		return true
+	}
+	return false
+}
+
+// magnetCSVHeader is the header row of csv magnet files.
+var magnetCSVHeader = []string{"name", "infohash", "magnet"}
+
+// formatMagnetEntry renders a magnet link in the given format, without a trailing
+// newline. withHeader adds the csv header row for standalone csv files.
+func formatMagnetEntry(format, name, infoHash, magnetURI string, withHeader bool) string {
+	switch format {
+	case "labeled":
+		return fmt.Sprintf("Name: %s\nInfoHash: %s\nMagnet: %s", name, infoHash, magnetURI)
+	case "csv":
+		var sb strings.Builder
+		w := csv.NewWriter(&sb)
+		if withHeader {
+			_ = w.Write(magnetCSVHeader)
+		}
+		_ = w.Write([]string{name, infoHash, magnetURI})
+		w.Flush()
+		return strings.TrimSuffix(sb.String(), "\n")
+	default:
+		return magnetURI
+	}
 }
 
-// writeMagnetFile writes the magnet URI string to the specified file path.
-func writeMagnetFile(filePath string, magnetURI string) error {
+// magnetCollector appends magnet links from all torrent workers to a single file.
+type magnetCollector struct {
+	mu     sync.Mutex
+	file   *os.File
+	format string
+}
+
+// newMagnetCollector opens path for appending, writing the csv header if the file is new.
+func newMagnetCollector(path string, format string) (*magnetCollector, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return nil, fmt.Errorf("error creating directory for magnet collect file %s: %w", path, err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening magnet collect file %s: %w", path, err)
+	}
+	if format == "csv" {
+		stat, statErr := f.Stat()
+		if statErr == nil && stat.Size() == 0 {
+			if _, err := f.WriteString(strings.Join(magnetCSVHeader, ",") + "\n"); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("error writing header to magnet collect file %s: %w", path, err)
+			}
+		}
+	}
+	return &magnetCollector{file: f, format: format}, nil
+}
+
+// Add appends one magnet entry. Labeled entries are separated by a blank line.
+func (c *magnetCollector) Add(name, infoHash, magnetURI string) error {
+	entry := formatMagnetEntry(c.format, name, infoHash, magnetURI, false) + "\n"
+	if c.format == "labeled" {
+		entry += "\n"
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.file.WriteString(entry); err != nil {
+		return fmt.Errorf("error appending to magnet collect file %s: %w", c.file.Name(), err)
+	}
+	return nil
+}
+
+// Close closes the collect file.
+func (c *magnetCollector) Close() error {
+	return c.file.Close()
+}
+
+// writeMagnetFile writes the magnet link content to the specified file path.
+func writeMagnetFile(filePath string, content string) error {
 	f, err := os.Create(filePath)
 	if err != nil {
 		return fmt.Errorf("error creating magnet file %s: %w", filePath, err)
@@ -605,7 +829,7 @@ func writeMagnetFile(filePath string, magnetURI string) error {
 		}
 	}()
 
-	_, err = f.WriteString(magnetURI)
+	_, err = f.WriteString(content)
 	if err != nil {
 		// Attempt to remove partially written file on error
 		if removeErr := os.Remove(filePath); removeErr != nil && !os.IsNotExist(removeErr) {
@@ -616,6 +840,25 @@ func writeMagnetFile(filePath string, magnetURI string) error {
 	return err // err will be nil on success, or the potential f.Close() error
 }
 
+// mergeTrackers returns the --announce URLs followed by the trackers from the
+// [torrent] config section, trimmed and without duplicates.
+func mergeTrackers(flagTrackers, configTrackers []string) []string {
+	seen := make(map[string]struct{})
+	var merged []string
+	for _, tracker := range append(append([]string{}, flagTrackers...), configTrackers...) {
+		tracker = strings.TrimSpace(tracker)
+		if tracker == "" {
+			continue
+		}
+		if _, dup := seen[tracker]; dup {
+			continue
+		}
+		seen[tracker] = struct{}{}
+		merged = append(merged, tracker)
+	}
+	return merged
+}
+
 func init() {
 	rootCmd.AddCommand(torrentCmd)
 
@@ -625,12 +868,31 @@ func init() {
 	torrentCmd.Flags().StringVarP(&torrentOutputDir, "output-dir", "o", "", "Directory to save generated .torrent files (default: place inside each model's directory)")
 	torrentCmd.Flags().BoolVarP(&overwriteTorrents, "overwrite", "f", false, "Overwrite existing .torrent files")
 	torrentCmd.Flags().BoolVar(&generateMagnetLinks, "magnet-links", false, "Generate a .txt file containing the magnet link alongside each .torrent file")
+	torrentCmd.Flags().String("magnet-format", "raw", "Content of magnet link files: raw (just the link), labeled (name, infohash and link) or csv")
+	torrentCmd.Flags().Bool("magnet-only", false, "Only write magnet links (implies --magnet-links); the content is hashed but no .torrent files are written")
+	torrentCmd.Flags().String("magnet-collect", "", "Append all magnet links to this single file instead of writing one file per model directory")
+	torrentCmd.Flags().Int("hash-workers", 1, "Goroutines hashing the pieces of each model directory; raise it to use several cores for directories with large files")
+	torrentCmd.Flags().String("piece-length", "512k", "Torrent piece length, a power of two between 16k and 16M (e.g. 256k, 1M), or auto to scale it to each directory's size")
+	torrentCmd.Flags().Int("piece-length-kib", 0, "Torrent piece length in KiB")
+	_ = torrentCmd.Flags().MarkDeprecated("piece-length-kib", "use --piece-length instead")
+	torrentCmd.Flags().Bool("private", false, "Mark torrents as private so clients only use the given trackers (no DHT or peer exchange)")
+	torrentCmd.Flags().Bool("web-seeds", false, "Add the Civitai download URLs of each directory's files as HTTP web seeds (url-list)")
+	torrentCmd.Flags().Bool("dry-run", false, "List the model directories and torrent output paths that would be processed, without creating files or updating the index")
 
 	// Bind flags to Viper keys if they correspond to config file options
 	// viper.BindPFlag("announce", torrentCmd.Flags().Lookup("announce")) // Example if needed
 	_ = viper.BindPFlag("torrent.outputdir", torrentCmd.Flags().Lookup("output-dir"))
 	_ = viper.BindPFlag("torrent.overwrite", torrentCmd.Flags().Lookup("overwrite"))
 	_ = viper.BindPFlag("torrent.magnetlinks", torrentCmd.Flags().Lookup("magnet-links"))
+	_ = viper.BindPFlag("torrent.magnetformat", torrentCmd.Flags().Lookup("magnet-format"))
+	_ = viper.BindPFlag("torrent.magnetonly", torrentCmd.Flags().Lookup("magnet-only"))
+	_ = viper.BindPFlag("torrent.magnetcollect", torrentCmd.Flags().Lookup("magnet-collect"))
+	_ = viper.BindPFlag("torrent.dryrun", torrentCmd.Flags().Lookup("dry-run"))
+	_ = viper.BindPFlag("torrent.hashworkers", torrentCmd.Flags().Lookup("hash-workers"))
+	_ = viper.BindPFlag("torrent.piecelength", torrentCmd.Flags().Lookup("piece-length"))
+	_ = viper.BindPFlag("torrent.piecelengthkib", torrentCmd.Flags().Lookup("piece-length-kib"))
+	_ = viper.BindPFlag("torrent.private", torrentCmd.Flags().Lookup("private"))
+	_ = viper.BindPFlag("torrent.webseeds", torrentCmd.Flags().Lookup("web-seeds"))
 
 	// Concurrency is often a command-line only setting, but could be bound too
 	torrentCmd.Flags().IntP("concurrency", "c", 4, "Number of concurrent torrent generation workers")
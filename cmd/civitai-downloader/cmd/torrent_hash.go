@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// hashPiecesPerTask is the number of consecutive pieces a hash worker takes at a
+// time, so each worker reads a contiguous region instead of seeking piece by piece.
+const hashPiecesPerTask = 64
+
+// buildInfoConcurrently fills info.Files and info.Pieces for the directory root like
+// metainfo.Info.BuildFromFilePath, but hashes pieces on up to workers goroutines so
+// a directory holding one large checkpoint is not limited to a single core. The
+// result is identical to BuildFromFilePath, so existing torrents keep their infohash.
+// info.PieceLength must be set.
+func buildInfoConcurrently(info *metainfo.Info, root string, workers int) error {
+	if info.PieceLength <= 0 {
+		return fmt.Errorf("piece length must be positive")
+	}
+
+	// Collect files the same way BuildFromFilePath does
+	info.Files = nil
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return fmt.Errorf("error getting relative path: %w", err)
+		}
+		info.Files = append(info.Files, metainfo.FileInfo{
+			Path:   strings.Split(relPath, string(filepath.Separator)),
+			Length: fi.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(info.Files, func(i, j int) bool {
+		return strings.Join(info.Files[i].BestPath(), "/") < strings.Join(info.Files[j].BestPath(), "/")
+	})
+
+	// Open every file once; ReadAt is safe for concurrent use
+	files := make([]*os.File, len(info.Files))
+	offsets := make([]int64, len(info.Files)) // Start of each file in the torrent's byte stream
+	var total int64
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	for i, fi := range info.Files {
+		f, err := os.Open(filepath.Join(root, filepath.Join(fi.BestPath()...)))
+		if err != nil {
+			return fmt.Errorf("error opening %s: %w", strings.Join(fi.BestPath(), "/"), err)
+		}
+		files[i] = f
+		offsets[i] = total
+		total += fi.Length
+	}
+
+	numPieces := int((total + info.PieceLength - 1) / info.PieceLength)
+	pieces := make([]byte, numPieces*sha1.Size)
+	if workers < 1 {
+		workers = 1
+	}
+
+	tasks := make(chan int)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	var failed atomic.Bool
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, info.PieceLength)
+			for start := range tasks {
+				if failed.Load() {
+					continue // Drain remaining tasks so the producer does not block
+				}
+				end := min(start+hashPiecesPerTask, numPieces)
+				for p := start; p < end; p++ {
+					pieceStart := int64(p) * info.PieceLength
+					n := minInt64(info.PieceLength, total-pieceStart)
+					if err := readTorrentRange(files, offsets, info.Files, pieceStart, buf[:n]); err != nil {
+						errOnce.Do(func() { firstErr = err })
+						failed.Store(true)
+						break
+					}
+					sum := sha1.Sum(buf[:n])
+					copy(pieces[p*sha1.Size:], sum[:])
+				}
+			}
+		}()
+	}
+	for start := 0; start < numPieces; start += hashPiecesPerTask {
+		tasks <- start
+	}
+	close(tasks)
+	wg.Wait()
+	if firstErr != nil {
+		return fmt.Errorf("error generating pieces: %w", firstErr)
+	}
+
+	info.Pieces = pieces
+	return nil
+}
+
+// readTorrentRange fills buf with the bytes at offset in the concatenation of files.
+func readTorrentRange(files []*os.File, offsets []int64, infos []metainfo.FileInfo, offset int64, buf []byte) error {
+	// First file whose end lies beyond offset
+	i := sort.Search(len(files), func(i int) bool { return offsets[i]+infos[i].Length > offset })
+	for len(buf) > 0 {
+		if i >= len(files) {
+			return io.ErrUnexpectedEOF
+		}
+		fileOffset := offset - offsets[i]
+		chunk := minInt64(int64(len(buf)), infos[i].Length-fileOffset)
+		if _, err := files[i].ReadAt(buf[:chunk], fileOffset); err != nil {
+			return fmt.Errorf("error reading %s: %w", files[i].Name(), err)
+		}
+		buf = buf[chunk:]
+		offset += chunk
+		i++
+	}
+	return nil
+}
+
+// minInt64 returns the smaller of a and b (the package's min helper is int only).
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"go-civitai-download/internal/database"
+	"go-civitai-download/internal/models"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/spf13/viper"
+)
+
+// TestGenerateTorrentPieceLength generates torrents for the same directory at two
+// piece lengths and with auto, and checks the resulting piece counts.
+func TestGenerateTorrentPieceLength(t *testing.T) {
+	modelDir := filepath.Join(t.TempDir(), "my-model")
+	if err := os.MkdirAll(modelDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	const size = 1024 * 1024
+	if err := os.WriteFile(filepath.Join(modelDir, "model.safetensors"), make([]byte, size), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pieceLength string
+		wantPieces  int
+	}{
+		{"256k", 4},
+		{"16k", 64},
+		{"auto", 64}, // 1 MiB is below 1500 pieces of the 16k minimum
+	}
+	for _, tt := range tests {
+		t.Run(tt.pieceLength, func(t *testing.T) {
+			pieceLength, err := parsePieceLength(tt.pieceLength)
+			if err != nil {
+				t.Fatalf("parsePieceLength(%q) error = %v", tt.pieceLength, err)
+			}
+			torrentPath, _, _, err := generateTorrentFile(modelDir, []string{"udp://tracker.example:1337/announce"}, t.TempDir(), true, false, magnetOutput{}, 1, pieceLength, false, nil)
+			if err != nil {
+				t.Fatalf("generateTorrentFile() error = %v", err)
+			}
+			mi, err := metainfo.LoadFromFile(torrentPath)
+			if err != nil {
+				t.Fatalf("loading torrent: %v", err)
+			}
+			info, err := mi.UnmarshalInfo()
+			if err != nil {
+				t.Fatalf("decoding torrent info: %v", err)
+			}
+			if got := info.NumPieces(); got != tt.wantPieces {
+				t.Errorf("torrent has %d pieces, want %d", got, tt.wantPieces)
+			}
+		})
+	}
+}
+
+func TestParsePieceLength(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"256k", 256 * 1024, false},
+		{"1M", 1024 * 1024, false},
+		{"auto", 0, false},
+		{"524288", 512 * 1024, false},
+		{"300k", 0, true}, // Not a power of two
+		{"8k", 0, true},   // Below the minimum
+		{"32M", 0, true},  // Above the maximum
+		{"big", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePieceLength(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parsePieceLength(%q) = %d, %v; want %d, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if got := autoPieceLength(8 * 1024 * 1024 * 1024); got != 8*1024*1024 {
+		t.Errorf("autoPieceLength(8 GiB) = %d, want 8 MiB", got)
+	}
+}
+
+// TestScanModelDirectories checks that entries recording their model directory are
+// grouped by it, whatever the layout, and older entries by their folder.
+func TestScanModelDirectories(t *testing.T) {
+	savePath := t.TempDir()
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("opening db: %v", err)
+	}
+	defer db.Close()
+
+	sdDir := filepath.Join("models", "Stable-diffusion", "my_model")
+	entries := []models.DatabaseEntry{
+		{Folder: filepath.Join(sdDir, "sdxl_1.0"), ModelDir: sdDir, Version: models.ModelVersion{ID: 1, ModelId: 1}},
+		{Folder: filepath.Join(sdDir, "pony"), ModelDir: sdDir, Version: models.ModelVersion{ID: 2, ModelId: 1}},
+		{Folder: filepath.Join("lora", "old_model", "sd_1.5"), Version: models.ModelVersion{ID: 3, ModelId: 2}},
+	}
+	for _, entry := range entries {
+		entryBytes, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put([]byte(fmt.Sprintf("v_%d", entry.Version.ID)), entryBytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modelDirs, err := scanModelDirectories(db, savePath, nil)
+	if err != nil {
+		t.Fatalf("scanModelDirectories() error = %v", err)
+	}
+	var got []string
+	for path := range modelDirs {
+		got = append(got, path)
+	}
+	sort.Strings(got)
+	want := []string{filepath.Join(savePath, "lora", "old_model"), filepath.Join(savePath, sdDir)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("model directories = %v, want %v", got, want)
+	}
+}
+
+// TestGenerateTorrentWebSeeds collects the download URLs of a model directory from the
+// database and checks they end up as the url-list of the generated torrent.
+func TestGenerateTorrentWebSeeds(t *testing.T) {
+	savePath := t.TempDir()
+	db, err := database.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("opening db: %v", err)
+	}
+	defer db.Close()
+
+	entries := []models.DatabaseEntry{
+		{Folder: filepath.Join("lora", "my-model", "sdxl-1.0"), Filename: "a.safetensors", Status: models.StatusDownloaded,
+			Version: models.ModelVersion{ID: 2, ModelId: 1}, File: models.File{DownloadUrl: "https://civitai.com/api/download/models/2"}},
+		{Folder: filepath.Join("lora", "my-model", "sdxl-1.0"), Filename: "b.safetensors", Status: models.StatusDownloaded,
+			Version: models.ModelVersion{ID: 1, ModelId: 1}, File: models.File{DownloadUrl: "https://civitai.com/api/download/models/1"}},
+		{Folder: filepath.Join("lora", "my-model", "sdxl-1.0"), Filename: "c.safetensors", Status: models.StatusError,
+			Version: models.ModelVersion{ID: 3, ModelId: 1}, File: models.File{DownloadUrl: "https://civitai.com/api/download/models/3"}},
+	}
+	for _, entry := range entries {
+		path := filepath.Join(savePath, entry.Folder, entry.Filename)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(entry.Filename), 0600); err != nil {
+			t.Fatal(err)
+		}
+		entryBytes, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put([]byte(fmt.Sprintf("v_%d", entry.Version.ID)), entryBytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modelDirs, err := scanModelDirectories(db, savePath, nil)
+	if err != nil {
+		t.Fatalf("scanModelDirectories() error = %v", err)
+	}
+	dir, ok := modelDirs[filepath.Join(savePath, "lora", "my-model")]
+	if !ok {
+		t.Fatalf("model directory not found in %v", modelDirs)
+	}
+	want := []string{"https://civitai.com/api/download/models/1", "https://civitai.com/api/download/models/2"}
+
+	torrentPath, _, _, err := generateTorrentFile(dir.Path, []string{"udp://tracker.example:1337/announce"}, t.TempDir(), true, false, magnetOutput{}, 1, 16*1024, false, dir.DownloadURLs)
+	if err != nil {
+		t.Fatalf("generateTorrentFile() error = %v", err)
+	}
+	mi, err := metainfo.LoadFromFile(torrentPath)
+	if err != nil {
+		t.Fatalf("loading torrent: %v", err)
+	}
+	if !reflect.DeepEqual([]string(mi.UrlList), want) {
+		t.Errorf("UrlList = %v, want %v", mi.UrlList, want)
+	}
+}
+
+// TestGenerateTorrentMagnetOnly checks that --magnet-only writes the magnet link file
+// but no .torrent.
+func TestGenerateTorrentMagnetOnly(t *testing.T) {
+	modelDir := filepath.Join(t.TempDir(), "my-model")
+	if err := os.MkdirAll(modelDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(modelDir, "model.safetensors"), make([]byte, 64*1024), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	torrentPath, magnetPath, magnetURI, err := generateTorrentFile(modelDir, []string{"udp://tracker.example:1337/announce"}, "", false, true, magnetOutput{Format: "raw", Only: true}, 1, 16*1024, false, nil)
+	if err != nil {
+		t.Fatalf("generateTorrentFile() error = %v", err)
+	}
+	if torrentPath != "" {
+		t.Errorf("torrent path = %q, want none", torrentPath)
+	}
+	if _, err := os.Stat(torrentOutputPath(modelDir, "")); !os.IsNotExist(err) {
+		t.Errorf("a .torrent file was written with magnet-only (stat err %v)", err)
+	}
+	content, err := os.ReadFile(magnetPath)
+	if err != nil {
+		t.Fatalf("reading magnet file: %v", err)
+	}
+	if magnetURI == "" || !strings.Contains(string(content), magnetURI) {
+		t.Errorf("magnet file %q does not contain the magnet URI %q", content, magnetURI)
+	}
+}
+
+// TestTorrentNoIndex runs the torrent command on an empty database and checks
+// --no-index keeps it from creating the Bleve index in the save path.
+func TestTorrentNoIndex(t *testing.T) {
+	savePath := t.TempDir()
+	for key, value := range map[string]interface{}{
+		"savepath":         savePath,
+		"databasepath":     filepath.Join(savePath, "civitai.db"),
+		"torrent.trackers": []string{"udp://tracker.example.com:80"},
+	} {
+		old := viper.Get(key)
+		viper.Set(key, value)
+		defer viper.Set(key, old)
+	}
+	defer viper.Set("noindex", false)
+	indexPath := filepath.Join(savePath, "civitai.bleve")
+
+	viper.Set("noindex", true)
+	if err := torrentCmd.RunE(torrentCmd, nil); err != nil {
+		t.Fatalf("torrent --no-index error = %v", err)
+	}
+	if _, err := os.Stat(indexPath); !os.IsNotExist(err) {
+		t.Errorf("torrent --no-index created the index at %s (stat error %v)", indexPath, err)
+	}
+
+	viper.Set("noindex", false)
+	if err := torrentCmd.RunE(torrentCmd, nil); err != nil {
+		t.Fatalf("torrent error = %v", err)
+	}
+	if _, err := os.Stat(indexPath); err != nil {
+		t.Errorf("torrent without --no-index did not create the index: %v", err)
+	}
+}
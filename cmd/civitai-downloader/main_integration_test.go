@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"os/exec"
@@ -10,6 +12,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -837,11 +840,39 @@ func TestQueryParam_Nsfw(t *testing.T) {
 	})
 }
 
-/*
+// TestQueryParam_CommercialUse tests the 'AllowCommercialUse' parameter
+func TestQueryParam_CommercialUse(t *testing.T) {
+	// JSON key = "allowCommercialUse", URL key = "allowCommercialUse"
+	t.Run("FlagOnly", func(t *testing.T) {
+		compareConfigAndURL(t, "allowCommercialUse", "allowCommercialUse", "Sell", []string{"--commercial-use", "Sell"}, "")
+	})
+	t.Run("ConfigOnly", func(t *testing.T) {
+		compareConfigAndURL(t, "allowCommercialUse", "allowCommercialUse", "Image", []string{}, `AllowCommercialUse = "Image"`)
+	})
+	t.Run("FlagOverridesConfig", func(t *testing.T) {
+		compareConfigAndURL(t, "allowCommercialUse", "allowCommercialUse", "Sell", []string{"--commercial-use", "Sell"}, `AllowCommercialUse = "Image"`)
+	})
+	t.Run("Default", func(t *testing.T) {
+		// Any is the API default and not sent
+		compareConfigAndURL(t, "allowCommercialUse", "allowCommercialUse", "<OMIT>", []string{}, "")
+	})
+	t.Run("CaseInsensitive", func(t *testing.T) {
+		compareConfigAndURL(t, "allowCommercialUse", "allowCommercialUse", "Sell", []string{"--commercial-use", "sell"}, "")
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		tempCfgPath := createTempConfig(t, "")
+		_, stderr, err := runCommand(t, "--config", tempCfgPath, "download", "--commercial-use", "Lease", "--debug-print-api-url")
+		require.Error(t, err, "an unknown commercial use should be rejected")
+		assert.Contains(t, stderr, "unknown commercial use")
+	})
+}
+
 // TestQueryParam_Favorites tests the 'Favorites' parameter (boolean)
 func TestQueryParam_Favorites(t *testing.T) {
 	// JSON key = "favorites", URL key = "favorites"
-	// NOTE: No --favorites flag exists for download command
+	t.Run("FlagOnly", func(t *testing.T) {
+		compareConfigAndURL(t, "favorites", "favorites", "true", []string{"--favorites"}, "")
+	})
 	t.Run("ConfigTrue", func(t *testing.T) {
 		compareConfigAndURL(t, "favorites", "favorites", "true", []string{}, `Favorites = true`)
 	})
@@ -853,7 +884,9 @@ func TestQueryParam_Favorites(t *testing.T) {
 // TestQueryParam_Hidden tests the 'Hidden' parameter (boolean)
 func TestQueryParam_Hidden(t *testing.T) {
 	// JSON key = "hidden", URL key = "hidden"
-	// NOTE: No --hidden flag exists for download command
+	t.Run("FlagOnly", func(t *testing.T) {
+		compareConfigAndURL(t, "hidden", "hidden", "true", []string{"--hidden"}, "")
+	})
 	t.Run("ConfigTrue", func(t *testing.T) {
 		compareConfigAndURL(t, "hidden", "hidden", "true", []string{}, `Hidden = true`)
 	})
@@ -862,18 +895,162 @@ func TestQueryParam_Hidden(t *testing.T) {
 	})
 }
 
+// TestDownload_FavoritesRequiresAPIKey checks --favorites and --hidden fail with a
+// clear error when no API key is configured.
+func TestDownload_FavoritesRequiresAPIKey(t *testing.T) {
+	tempCfgPath := createTempConfig(t, fmt.Sprintf("SavePath = %q\n", t.TempDir()))
+	for _, flag := range []string{"--favorites", "--hidden"} {
+		t.Run(flag, func(t *testing.T) {
+			_, stderr, err := runCommand(t, "--config", tempCfgPath, "download", flag, "--yes")
+			require.Error(t, err, "%s without an API key should fail", flag)
+			assert.Contains(t, stderr, "require an API key")
+		})
+	}
+}
+
 // TestQueryParam_Rating tests the 'Rating' parameter (integer)
 func TestQueryParam_Rating(t *testing.T) {
 	// JSON key = "rating", URL key = "rating"
-	// NOTE: No --rating flag exists for download command
+	t.Run("FlagOnly", func(t *testing.T) {
+		compareConfigAndURL(t, "rating", "rating", "4", []string{"--rating", "4"}, "")
+	})
 	t.Run("ConfigOnly", func(t *testing.T) {
 		compareConfigAndURL(t, "rating", "rating", "5", []string{}, `Rating = 5`)
 	})
+	t.Run("FlagOverridesConfig", func(t *testing.T) {
+		compareConfigAndURL(t, "rating", "rating", "3", []string{"--rating", "3"}, `Rating = 5`)
+	})
 	t.Run("Default (0)", func(t *testing.T) {
 		// Rating = 0 should be omitted based on API docs/behavior
 		compareConfigAndURL(t, "rating", "rating", "<OMIT>", []string{}, "")
 	})
+	t.Run("OutOfRange", func(t *testing.T) {
+		compareConfigAndURL(t, "rating", "rating", "<OMIT>", []string{"--rating", "6"}, "")
+	})
 }
-*/
 
 // TODO: Add more test cases covering other flags and config options.
+
+// TestDownload_DryRunCreatesNoDatabase runs a dry run against an unreachable API (via a
+// dead proxy) and checks that neither the database nor the search index is created.
+func TestDownload_DryRunCreatesNoDatabase(t *testing.T) {
+	saveDir := t.TempDir()
+	dbPath := filepath.Join(saveDir, "civitai_download_db")
+	tempCfgPath := createTempConfig(t, fmt.Sprintf("SavePath = %q\nDatabasePath = %q\nMaxRetries = 0\n", saveDir, dbPath))
+	t.Setenv("HTTPS_PROXY", "http://127.0.0.1:1")
+	t.Setenv("HTTP_PROXY", "http://127.0.0.1:1")
+
+	_, stderr, _ := runCommand(t, "--config", tempCfgPath, "download", "--dry-run", "--yes", "--model-id", "12345")
+
+	assert.Contains(t, stderr, "Dry run", "Dry run should be announced")
+	_, statErr := os.Stat(dbPath)
+	assert.True(t, os.IsNotExist(statErr), "Dry run must not create the database at %s", dbPath)
+	_, statErr = os.Stat(filepath.Join(saveDir, "civitai.bleve"))
+	assert.True(t, os.IsNotExist(statErr), "Dry run must not create the Bleve index")
+}
+
+// TestDownload_APIBaseURLOverride points --api-base-url at a local server and checks
+// the model lookup is sent there instead of civitai.com.
+func TestDownload_APIBaseURLOverride(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	saveDir := t.TempDir()
+	tempCfgPath := createTempConfig(t, fmt.Sprintf("SavePath = %q\nMaxRetries = 0\n", saveDir))
+	_, _, _ = runCommand(t, "--config", tempCfgPath, "--api-base-url", server.URL+"/proxy/api/v1/", "download", "--dry-run", "--yes", "--model-id", "12345")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, paths, "/proxy/api/v1/models/12345", "Model lookup should go to the overridden API base URL")
+}
+
+// TestDownload_JSONStdoutIsSummary checks that with --json stdout holds nothing but one
+// JSON object, both when the parameter prompt is declined (no input) and for a dry run
+// whose listing goes to stderr.
+func TestDownload_JSONStdoutIsSummary(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/models/12345") {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"id": 12345, "name": "Test Model", "type": "LORA", "creator": {"username": "tester"},
+			"modelVersions": [{"id": 99, "modelId": 12345, "name": "v1", "baseModel": "SDXL 1.0", "publishedAt": "2024-05-01T12:00:00.000Z",
+				"files": [{"id": 1, "name": "test.safetensors", "sizeKB": 10, "primary": true, "type": "Model", "hashes": {"CRC32": "0A1B2C3D"},
+					"downloadUrl": "http://127.0.0.1:1/download/99", "metadata": {"format": "SafeTensor"}}]}]}`)
+	}))
+	defer server.Close()
+
+	saveDir := t.TempDir()
+	tempCfgPath := createTempConfig(t, fmt.Sprintf("SavePath = %q\nMaxRetries = 0\n", saveDir))
+	tests := []struct {
+		name string
+		args []string
+		want map[string]interface{}
+	}{
+		{"declined prompt", nil, map[string]interface{}{"queued": float64(0), "cancelled": true}},
+		{"dry run", []string{"--yes", "--dry-run"}, map[string]interface{}{"queued": float64(1), "notAttempted": float64(1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"--config", tempCfgPath, "--api-base-url", server.URL, "--json", "download", "--model-id", "12345"}, tt.args...)
+			stdout, _, err := runCommand(t, args...)
+			require.NoError(t, err)
+
+			var summary map[string]interface{}
+			decoder := json.NewDecoder(strings.NewReader(stdout))
+			require.NoError(t, decoder.Decode(&summary), "stdout should be a JSON object, got %q", stdout)
+			assert.False(t, decoder.More(), "stdout should hold a single JSON object, got %q", stdout)
+			for key, value := range tt.want {
+				assert.Equal(t, value, summary[key], "summary field %s", key)
+			}
+		})
+	}
+}
+
+// TestImages_ResumeSavesCursorPerPage checks that a page's images are downloaded
+// before the next page is requested and that --resume continues from that page.
+func TestImages_ResumeSavesCursorPerPage(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path+"?cursor="+r.URL.Query().Get("cursor"))
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/img/1.png":
+			w.Write([]byte("png"))
+		case strings.HasSuffix(r.URL.Path, "/images") && r.URL.Query().Get("cursor") == "":
+			fmt.Fprintf(w, `{"items": [{"id": 1, "url": %q, "username": "tester"}], "metadata": {"nextCursor": "c2"}}`, server.URL+"/img/1.png")
+		default:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	saveDir := t.TempDir()
+	tempCfgPath := createTempConfig(t, fmt.Sprintf("SavePath = %q\nMaxRetries = 0\nSkipConfirmation = true\n", saveDir))
+	args := []string{"--config", tempCfgPath, "--api-base-url", server.URL, "--no-index", "images", "--model-id", "123", "--concurrency", "1"}
+
+	_, _, err := runCommand(t, args...)
+	require.NoError(t, err)
+	mu.Lock()
+	assert.Equal(t, []string{"/images?cursor=", "/img/1.png?cursor=", "/images?cursor=c2"}, requests)
+	requests = nil
+	mu.Unlock()
+
+	// The resumed page still fails, so this run exits without having fetched any images.
+	_, _, err = runCommand(t, append(args, "--resume")...)
+	require.Error(t, err)
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, requests)
+	assert.Equal(t, "/images?cursor=c2", requests[0], "--resume should start at the page after the downloaded one")
+}
@@ -1,6 +1,8 @@
 package index
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"time"
@@ -10,6 +12,10 @@ import (
 
 const defaultIndexPath = "civitai.bleve"
 
+// openTimeout bounds how long opening waits for the index lock, so a second process
+// using the same index gets an error instead of blocking forever.
+const openTimeout = "5s"
+
 // Item represents a generic item to be indexed.
 // We might need more specific structs later for models, images, etc.
 // By default, all fields defined here are indexed and searchable using their
@@ -44,6 +50,9 @@ type Item struct {
 	// Torrent Information (populated by the 'torrent' command)
 	TorrentPath string `json:"torrentPath,omitempty"` // Path to the downloaded .torrent file
 	MagnetLink  string `json:"magnetLink,omitempty"`  // Magnet link for the torrent
+
+	// Archive Information (populated by the 'pack' command)
+	ZipPath string `json:"zipPath,omitempty"` // Path to the .zip of the model directory
 }
 
 // OpenOrCreateIndex opens an existing Bleve index or creates a new one if it doesn't exist.
@@ -52,7 +61,7 @@ func OpenOrCreateIndex(indexPath string) (bleve.Index, error) {
 		indexPath = defaultIndexPath
 	}
 
-	index, err := bleve.Open(indexPath)
+	index, err := bleve.OpenUsing(indexPath, map[string]interface{}{"bolt_timeout": openTimeout})
 	if err == bleve.ErrorIndexPathDoesNotExist {
 		log.Printf("Creating new index at: %s", indexPath)
 		mapping := bleve.NewIndexMapping()
@@ -86,6 +95,77 @@ func SearchIndex(index bleve.Index, query string) (*bleve.SearchResult, error) {
 	return searchResults, nil
 }
 
+// GetItem returns the item stored under id, rebuilt from its stored fields, and
+// whether it exists.
+func GetItem(index bleve.Index, id string) (Item, bool, error) {
+	searchRequest := bleve.NewSearchRequest(bleve.NewDocIDQuery([]string{id}))
+	searchRequest.Fields = []string{"*"}
+	searchResults, err := index.Search(searchRequest)
+	if err != nil {
+		return Item{}, false, err
+	}
+	if len(searchResults.Hits) == 0 {
+		return Item{}, false, nil
+	}
+	item, err := itemFromFields(id, searchResults.Hits[0].Fields)
+	if err != nil {
+		return Item{}, false, fmt.Errorf("error reading stored fields of %s: %w", id, err)
+	}
+	return item, true, nil
+}
+
+// AllItems returns every item in the index, rebuilt from its stored fields.
+func AllItems(index bleve.Index) ([]Item, error) {
+	const pageSize = 500
+	var items []Item
+	for from := 0; ; from += pageSize {
+		searchRequest := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), pageSize, from, false)
+		searchRequest.Fields = []string{"*"}
+		searchRequest.SortBy([]string{"_id"}) // Stable order across pages
+		searchResults, err := index.Search(searchRequest)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range searchResults.Hits {
+			item, err := itemFromFields(hit.ID, hit.Fields)
+			if err != nil {
+				return nil, fmt.Errorf("error reading stored fields of %s: %w", hit.ID, err)
+			}
+			items = append(items, item)
+		}
+		if len(searchResults.Hits) < pageSize {
+			return items, nil
+		}
+	}
+}
+
+// itemFromFields converts the stored fields of a search hit back into an Item.
+func itemFromFields(id string, fields map[string]interface{}) (Item, error) {
+	var item Item
+	// A single tag is stored as a plain string rather than an array
+	if tag, ok := fields["tags"].(string); ok {
+		fields["tags"] = []string{tag}
+	}
+	// Dates come back as strings that may not be RFC 3339; parse them separately
+	publishedAt, _ := fields["publishedAt"].(string)
+	delete(fields, "publishedAt")
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return item, err
+	}
+	if err := json.Unmarshal(data, &item); err != nil {
+		return item, err
+	}
+	item.ID = id
+	if publishedAt != "" {
+		if t, err := time.Parse(time.RFC3339, publishedAt); err == nil {
+			item.PublishedAt = t
+		}
+	}
+	return item, nil
+}
+
 // DeleteIndex removes the index directory. Use with caution!
 func DeleteIndex(indexPath string) error {
 	if indexPath == "" {
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrCircuitOpen is returned instead of sending a request while the circuit
+// breaker for its host is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitBreaker stops requests to a host after a number of consecutive failures
+// within the cooldown of each other. Once open, requests fail fast until the cooldown has passed; the next request is
+// then let through as a trial, closing the circuit on success or reopening it on failure.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	host      string
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	lastFail  time.Time // Time of the last counted failure; older failures no longer count
+	openUntil time.Time
+	halfOpen  bool // Cooldown passed, waiting for the trial request result
+}
+
+// Package-level breakers shared by every worker, keyed by host.
+var (
+	breakersMu       sync.Mutex
+	breakers         = map[string]*CircuitBreaker{}
+	breakerThreshold int // 0 disables the breakers
+	breakerCooldown  = time.Minute
+)
+
+// ConfigureBreakers sets the failure threshold and cooldown used by all host
+// breakers and resets their state. A threshold of 0 or less disables them.
+func ConfigureBreakers(threshold int, cooldown time.Duration) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	breakerThreshold = threshold
+	if cooldown > 0 {
+		breakerCooldown = cooldown
+	}
+	breakers = map[string]*CircuitBreaker{}
+	if threshold > 0 {
+		log.Debugf("Circuit breaker enabled: opens after %d consecutive failures less than %v apart and pauses requests for as long", threshold, breakerCooldown)
+	}
+}
+
+// BreakerForHost returns the shared breaker for host, or nil if breakers are disabled.
+// All CircuitBreaker methods are safe to call on a nil breaker.
+func BreakerForHost(host string) *CircuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	if breakerThreshold <= 0 {
+		return nil
+	}
+	b, ok := breakers[host]
+	if !ok {
+		b = &CircuitBreaker{host: host, threshold: breakerThreshold, cooldown: breakerCooldown}
+		breakers[host] = b
+	}
+	return b
+}
+
+// Allow returns ErrCircuitOpen (wrapped with the remaining cooldown) if requests
+// to the host should not be sent right now.
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if remaining := time.Until(b.openUntil); remaining > 0 {
+		return fmt.Errorf("%w for %s (retrying in %v)", ErrCircuitOpen, b.host, remaining.Round(time.Second))
+	}
+	if b.halfOpen {
+		// A trial request is already in flight
+		return fmt.Errorf("%w for %s (waiting for trial request)", ErrCircuitOpen, b.host)
+	}
+	b.halfOpen = true
+	log.Infof("Circuit breaker for %s: cooldown over, sending a trial request.", b.host)
+	return nil
+}
+
+// RecordSuccess closes the circuit and resets the failure count.
+func (b *CircuitBreaker) RecordSuccess() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.openUntil.IsZero() {
+		log.Infof("Circuit breaker for %s closed, requests resumed.", b.host)
+	}
+	b.failures = 0
+	b.lastFail = time.Time{}
+	b.openUntil = time.Time{}
+	b.halfOpen = false
+}
+
+// ReleaseTrial ends a trial request that finished without a result, e.g. because
+// it was cancelled, so the next request after the cooldown becomes the trial
+// instead of the circuit staying half-open for good.
+func (b *CircuitBreaker) ReleaseTrial() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.halfOpen = false
+}
+
+// RecordFailure counts a failed request (network error, 5xx or 429) and opens
+// the circuit once the threshold is reached or a trial request fails. Failures
+// more than the cooldown apart start the count over, so occasional errors spread
+// over a long batch never open the circuit.
+func (b *CircuitBreaker) RecordFailure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if !b.lastFail.IsZero() && now.Sub(b.lastFail) > b.cooldown {
+		b.failures = 0
+	}
+	b.lastFail = now
+	b.failures++
+	if b.halfOpen || (b.openUntil.IsZero() && b.failures >= b.threshold) {
+		b.openUntil = now.Add(b.cooldown)
+		b.halfOpen = false
+		log.Errorf("Circuit breaker for %s opened after %d consecutive failures, pausing requests for %v.", b.host, b.failures, b.cooldown)
+	}
+}
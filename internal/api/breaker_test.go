@@ -0,0 +1,44 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestCircuitBreakerWindow checks the breaker only opens on failures close enough
+// together, and that failures older than the cooldown start the count over.
+func TestCircuitBreakerWindow(t *testing.T) {
+	b := &CircuitBreaker{host: "civitai.example", threshold: 2, cooldown: time.Hour}
+
+	b.RecordFailure()
+	b.lastFail = time.Now().Add(-2 * time.Hour) // Pretend the failure is old
+	b.RecordFailure()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() = %v after failures more than the cooldown apart, want nil", err)
+	}
+
+	b.RecordFailure()
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Allow() = %v after consecutive failures within the cooldown, want ErrCircuitOpen", err)
+	}
+}
+
+// TestCircuitBreakerReleaseTrial checks a trial request that ends without a result
+// lets the next request through as the trial instead of blocking requests for good.
+func TestCircuitBreakerReleaseTrial(t *testing.T) {
+	b := &CircuitBreaker{host: "civitai.example", threshold: 1, cooldown: time.Hour}
+	b.RecordFailure()
+	b.openUntil = time.Now().Add(-time.Second) // Cooldown over
+
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() = %v after the cooldown, want the trial request to go through", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() = %v while the trial is in flight, want ErrCircuitOpen", err)
+	}
+	b.ReleaseTrial()
+	if err := b.Allow(); err != nil {
+		t.Errorf("Allow() = %v after the trial was released, want a new trial request", err)
+	}
+}
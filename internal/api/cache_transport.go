@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cacheEntry is a cached GET response stored as JSON in the cache directory.
+type cacheEntry struct {
+	URL          string      `json:"url"`
+	StatusCode   int         `json:"statusCode"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"lastModified,omitempty"`
+	StoredAt     time.Time   `json:"storedAt"`
+}
+
+// CachingTransport wraps an http.RoundTripper with an on-disk cache for GET requests.
+// Responses younger than TTL are served without contacting the server; older ones are
+// revalidated with If-None-Match/If-Modified-Since, and a 304 is answered from the cache.
+// Only 200 responses are stored.
+type CachingTransport struct {
+	Transport http.RoundTripper
+	Dir       string
+	TTL       time.Duration
+}
+
+// NewCachingTransport creates a CachingTransport storing entries in dir, creating it if needed.
+func NewCachingTransport(transport http.RoundTripper, dir string, ttl time.Duration) (*CachingTransport, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create HTTP cache directory %s: %w", dir, err)
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &CachingTransport{Transport: transport, Dir: dir, TTL: ttl}, nil
+}
+
+// RoundTrip serves the request from the cache when possible.
+func (t *CachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return t.Transport.RoundTrip(req)
+	}
+
+	path := t.entryPath(req)
+	entry := t.load(path)
+	if entry != nil && t.TTL > 0 && time.Since(entry.StoredAt) < t.TTL {
+		log.Debugf("[Cache] Fresh hit for %s", req.URL.String())
+		return entry.response(req), nil
+	}
+
+	outReq := req
+	if entry != nil && (entry.ETag != "" || entry.LastModified != "") {
+		outReq = req.Clone(req.Context())
+		if entry.ETag != "" {
+			outReq.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			outReq.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := t.Transport.RoundTrip(outReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		log.Debugf("[Cache] Not modified, serving cached response for %s", req.URL.String())
+		entry.StoredAt = time.Now()
+		t.save(path, entry)
+		return entry.response(req), nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	body, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if readErr != nil {
+		return nil, readErr
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.save(path, &cacheEntry{
+		URL:          req.URL.String(),
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Body:         body,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		StoredAt:     time.Now(),
+	})
+	return resp, nil
+}
+
+// entryPath returns the cache file for a request. The Authorization header is part of
+// the key because results can differ per API key (e.g. NSFW visibility).
+func (t *CachingTransport) entryPath(req *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(req.URL.String()))
+	h.Write([]byte{0})
+	h.Write([]byte(req.Header.Get("Authorization")))
+	return filepath.Join(t.Dir, hex.EncodeToString(h.Sum(nil))+".json")
+}
+
+// load reads a cache entry, returning nil if it is missing or unreadable.
+func (t *CachingTransport) load(path string) *cacheEntry {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		log.WithError(err).Debugf("[Cache] Ignoring unreadable cache entry %s", path)
+		return nil
+	}
+	return &entry
+}
+
+// save writes a cache entry. Failures only disable caching for that request.
+func (t *CachingTransport) save(path string, entry *cacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.WithError(err).Debugf("[Cache] Failed to encode cache entry for %s", entry.URL)
+		return
+	}
+	// Write via a temp name and rename so concurrent readers never see a partial file
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		log.WithError(err).Debugf("[Cache] Failed to write cache entry for %s", entry.URL)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.WithError(err).Debugf("[Cache] Failed to store cache entry for %s", entry.URL)
+	}
+}
+
+// response builds an http.Response for req from the cached entry.
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	header := e.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("X-Cache", "HIT")
+	return &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +9,9 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"go-civitai-download/internal/models"
@@ -25,8 +27,44 @@ var (
 	ErrServerError  = errors.New("API server error")
 )
 
+// CivitaiApiBaseUrl is the default base of all API request URLs.
 const CivitaiApiBaseUrl = "https://civitai.com/api/v1"
 
+var (
+	baseURLMu sync.RWMutex
+	baseURL   = CivitaiApiBaseUrl
+)
+
+// ConfigureBaseURL sets the base that API request URLs are built from, e.g. a
+// caching proxy in front of Civitai. Empty restores CivitaiApiBaseUrl.
+func ConfigureBaseURL(base string) error {
+	base = strings.TrimRight(strings.TrimSpace(base), "/")
+	if base == "" {
+		base = CivitaiApiBaseUrl
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return fmt.Errorf("invalid API base URL %q: %w", base, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid API base URL %q: must be an absolute http or https URL", base)
+	}
+	baseURLMu.Lock()
+	defer baseURLMu.Unlock()
+	baseURL = base
+	if base != CivitaiApiBaseUrl {
+		log.Infof("Using API base URL %s", base)
+	}
+	return nil
+}
+
+// BaseURL returns the base of API request URLs, without a trailing slash.
+func BaseURL() string {
+	baseURLMu.RLock()
+	defer baseURLMu.RUnlock()
+	return baseURL
+}
+
 // apiLogger is a dedicated logger for api.log
 var apiLogger = log.New()
 var apiLogFile *os.File
@@ -83,6 +121,7 @@ type Client struct {
 	ApiKey         string
 	HttpClient     *http.Client // Use a shared client
 	logApiRequests bool         // Store the config setting
+	retry          RetryOptions // From MaxRetries and InitialRetryDelayMs
 }
 
 // NewClient creates a new API client
@@ -100,24 +139,18 @@ func NewClient(apiKey string, httpClient *http.Client, cfg models.Config) *Clien
 		ApiKey:         apiKey,
 		HttpClient:     httpClient,
 		logApiRequests: cfg.LogApiRequests, // Store flag for use in methods
+		retry: RetryOptions{
+			MaxRetries:   cfg.MaxRetries,
+			InitialDelay: time.Duration(cfg.InitialRetryDelayMs) * time.Millisecond,
+			LogPrefix:    "API models",
+		},
 	}
 }
 
 // GetModels fetches models based on query parameters, using cursor pagination.
 // Accepts the cursor for the next page. Returns the next cursor and the response.
 func (c *Client) GetModels(cursor string, queryParams models.QueryParameters) (string, models.ApiResponse, error) {
-	// Use the helper function to build base query parameters
-	values := ConvertQueryParamsToURLValues(queryParams)
-
-	// Add cursor *only if* it's provided (not empty)
-	if cursor != "" {
-		values.Add("cursor", cursor)
-	} else {
-		// For the first request (empty cursor), do not add 'page' either.
-		// The API defaults to the first page/results without page/cursor.
-	}
-
-	reqURL := fmt.Sprintf("%s/models?%s", CivitaiApiBaseUrl, values.Encode())
+	reqURL := ModelsURL(queryParams, cursor)
 	// No change to main logger here
 	// log.Debugf("Requesting URL: %s", reqURL)
 
@@ -144,108 +177,37 @@ func (c *Client) GetModels(cursor string, queryParams models.QueryParameters) (s
 	}
 	// --- End Log API Request ---
 
-	var resp *http.Response
-	var lastErr error
-	maxRetries := 3
-
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		resp, err = c.HttpClient.Do(req)
-
-		// --- Log API Response (Attempt) ---
-		if c.logApiRequests && resp != nil { // Log even on non-200 responses
-			// Read body first for logging, then replace it for potential retries/final processing
-			bodyBytes, readErr := io.ReadAll(resp.Body)
-			if closeErr := resp.Body.Close(); closeErr != nil { // Close original body and check error
-				apiLogger.WithError(closeErr).Warn("Error closing response body after reading for logging")
-			}
-			if readErr != nil {
-				apiLogger.WithError(readErr).Errorf("Attempt %d: Failed to read response body for logging", attempt+1)
-			} else {
-				// Create a new io.ReadCloser from the read bytes
-				resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
-
-				// Try to dump response (might fail on huge bodies)
-				respDump, dumpErr := httputil.DumpResponse(resp, false) // false = don't dump body here
-				if dumpErr != nil {
-					apiLogger.WithError(dumpErr).Errorf("Attempt %d: Failed to dump API response headers", attempt+1)
-				} else {
-					apiLogger.Debugf("\n--- API Response (Attempt %d) ---\n%s\n--- Body (%d bytes) ---\n%s\n----------------------------- \n",
-						attempt+1, string(respDump), len(bodyBytes), string(bodyBytes))
-				}
-			}
-		} else if c.logApiRequests && err != nil {
-			// Log if the Do() call itself failed
-			apiLogger.WithError(err).Errorf("Attempt %d: HTTP Client Do() failed", attempt+1)
-		}
-		// --- End Log API Response (Attempt) ---
-
-		if err != nil {
-			lastErr = fmt.Errorf("http request failed (attempt %d/%d): %w", attempt+1, maxRetries, err)
-			if attempt < maxRetries-1 { // Only log retry warning if not the last attempt
-				log.WithError(err).Warnf("Retrying (%d/%d)...", attempt+1, maxRetries)
-				time.Sleep(time.Duration(attempt+1) * 2 * time.Second) // Exponential backoff
-				continue
-			}
-			break // Max retries reached on HTTP error
-		}
-
-		switch resp.StatusCode {
-		case http.StatusOK:
-			lastErr = nil        // Success
-			goto ProcessResponse // Use goto to break out of switch and loop
-		case http.StatusTooManyRequests:
-			lastErr = ErrRateLimited
-		case http.StatusUnauthorized, http.StatusForbidden:
-			lastErr = ErrUnauthorized
-			goto RequestFailed // Non-retryable auth error
-		case http.StatusNotFound:
-			lastErr = ErrNotFound
-			goto RequestFailed // Non-retryable not found error
-		case http.StatusServiceUnavailable:
-			lastErr = fmt.Errorf("%w (status code 503)", ErrServerError)
-		default:
-			if resp.StatusCode >= 500 {
-				lastErr = fmt.Errorf("%w (status code %d)", ErrServerError, resp.StatusCode)
-			} else {
-				// Other client-side errors (4xx) are likely not retryable
-				lastErr = fmt.Errorf("API request failed with status %d", resp.StatusCode)
-				goto RequestFailed
-			}
-		}
+	resp, body, err := DoRequestWithRetry(c.HttpClient, req, c.retry)
 
-		// If we are here, it's a retryable error (Rate Limit or 5xx)
-		// resp.Body was already closed and replaced during logging
-		if attempt < maxRetries-1 {
-			var sleepDuration time.Duration
-			if resp.StatusCode == http.StatusTooManyRequests {
-				// Longer backoff for rate limits
-				sleepDuration = time.Duration(attempt+1) * 5 * time.Second
-				log.WithError(lastErr).Warnf("Rate limited. Retrying (%d/%d) after %s...", attempt+1, maxRetries, sleepDuration)
-			} else { // Server errors (5xx)
-				sleepDuration = time.Duration(attempt+1) * 3 * time.Second
-				log.WithError(lastErr).Warnf("Server error. Retrying (%d/%d) after %s...", attempt+1, maxRetries, sleepDuration)
-			}
-			time.Sleep(sleepDuration)
+	// --- Log API Response ---
+	if c.logApiRequests && resp != nil { // Log even on non-200 responses
+		respDump, dumpErr := httputil.DumpResponse(resp, false) // Body was already read by the retry helper
+		if dumpErr != nil {
+			apiLogger.WithError(dumpErr).Error("Failed to dump API response headers")
 		} else {
-			log.WithError(lastErr).Errorf("Request failed after %d attempts with status %d", maxRetries, resp.StatusCode)
+			apiLogger.Debugf("\n--- API Response ---\n%s\n--- Body (%d bytes) ---\n%s\n----------------------------- \n",
+				string(respDump), len(body), string(body))
 		}
+	} else if c.logApiRequests && err != nil {
+		apiLogger.WithError(err).Error("HTTP request failed")
 	}
+	// --- End Log API Response ---
 
-RequestFailed:
-	if lastErr != nil {
-		// Don't close body here, it should have been closed during logging or by defer on success path
-		// if resp != nil { resp.Body.Close() }
-		return "", models.ApiResponse{}, lastErr
-	}
-
-ProcessResponse:
-	// Body should already be replaced with a readable version from logging step
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body) // Read the replaced body
 	if err != nil {
-		log.WithError(err).Error("Error reading final response body")
-		return "", models.ApiResponse{}, fmt.Errorf("error reading response body: %w", err)
+		if resp == nil {
+			return "", models.ApiResponse{}, fmt.Errorf("http request failed: %w", err)
+		}
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			return "", models.ApiResponse{}, fmt.Errorf("%w: %v", ErrRateLimited, err)
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return "", models.ApiResponse{}, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+		case resp.StatusCode == http.StatusNotFound:
+			return "", models.ApiResponse{}, fmt.Errorf("%w: %v", ErrNotFound, err)
+		case resp.StatusCode >= 500:
+			return "", models.ApiResponse{}, fmt.Errorf("%w (status code %d): %v", ErrServerError, resp.StatusCode, err)
+		}
+		return "", models.ApiResponse{}, fmt.Errorf("API request failed with status %d: %w", resp.StatusCode, err)
 	}
 
 	var response models.ApiResponse
@@ -262,14 +224,23 @@ ProcessResponse:
 }
 
 // ConvertQueryParamsToURLValues converts the QueryParameters struct into url.Values for API requests.
-// This is used for constructing the request URL.
+// It is the single encoder for /models queries: the download pagination, the API client and
+// --debug-print-api-url all use it, so what is shown and what is requested cannot drift apart.
+// Multi-value filters (types, baseModels) are sent as repeated keys.
 func ConvertQueryParamsToURLValues(queryParams models.QueryParameters) url.Values {
 	values := url.Values{}
-	values.Add("sort", queryParams.Sort)
-	values.Add("period", queryParams.Period)
+	// Limit is the per-request page size; leave it to the API default if unset
+	if queryParams.Limit > 0 {
+		values.Set("limit", strconv.Itoa(queryParams.Limit))
+	}
+	if queryParams.Sort != "" {
+		values.Set("sort", queryParams.Sort)
+	}
+	if queryParams.Period != "" {
+		values.Set("period", queryParams.Period)
+	}
 	// Always include the nsfw parameter, converting the boolean to string "true" or "false"
-	values.Add("nsfw", fmt.Sprintf("%t", queryParams.Nsfw))
-	values.Add("limit", fmt.Sprintf("%d", queryParams.Limit))
+	values.Set("nsfw", strconv.FormatBool(queryParams.Nsfw))
 	for _, t := range queryParams.Types {
 		values.Add("types", t)
 	}
@@ -277,20 +248,54 @@ func ConvertQueryParamsToURLValues(queryParams models.QueryParameters) url.Value
 		values.Add("baseModels", t)
 	}
 	if queryParams.PrimaryFileOnly {
-		values.Add("primaryFileOnly", fmt.Sprintf("%t", queryParams.PrimaryFileOnly))
+		values.Set("primaryFileOnly", "true")
 	}
 	if queryParams.Query != "" {
-		values.Add("query", queryParams.Query)
+		values.Set("query", queryParams.Query)
 	}
 	if queryParams.Tag != "" {
-		values.Add("tag", queryParams.Tag)
+		values.Set("tag", queryParams.Tag)
 	}
 	if queryParams.Username != "" {
-		values.Add("username", queryParams.Username)
+		values.Set("username", queryParams.Username)
+	}
+	// License filters default to allowed; only restrictions are sent
+	if !queryParams.AllowNoCredit {
+		values.Set("allowNoCredit", "false")
+	}
+	if !queryParams.AllowDerivatives {
+		values.Set("allowDerivatives", "false")
+	}
+	if !queryParams.AllowDifferentLicenses {
+		values.Set(models.AllowDifferentLicensesParam, "false")
+	}
+	if queryParams.AllowCommercialUse != "" && queryParams.AllowCommercialUse != "Any" {
+		values.Set("allowCommercialUse", queryParams.AllowCommercialUse)
+	}
+	// Filters on the authenticated user's own lists, only sent when set
+	if queryParams.Favorites {
+		values.Set("favorites", "true")
+	}
+	if queryParams.Hidden {
+		values.Set("hidden", "true")
+	}
+	if queryParams.Rating > 0 {
+		values.Set("rating", strconv.Itoa(queryParams.Rating))
 	}
 
 	// Note: Cursor/Page parameters are typically added separately based on pagination logic.
 	return values
 }
 
+// ModelsURL builds the /models request URL for queryParams, continuing from cursor if set.
+func ModelsURL(queryParams models.QueryParameters, cursor string) string {
+	values := ConvertQueryParamsToURLValues(queryParams)
+	// For the first request (empty cursor) neither cursor nor page is sent;
+	// the API defaults to the first page of results.
+	if cursor != "" {
+		values.Set("cursor", cursor)
+	}
+	return BaseURL() + "/models?" + values.Encode()
+}
+
 // TODO: Add methods for other API endpoints (e.g., GetModelByID, GetModelVersionByID)
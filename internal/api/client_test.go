@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"go-civitai-download/internal/models"
+)
+
+func TestModelsURLAllowDifferentLicenses(t *testing.T) {
+	if err := ConfigureBaseURL("http://mirror.example/api/v1"); err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureBaseURL("")
+
+	tests := []struct {
+		name      string
+		allow     bool
+		wantParam bool
+	}{
+		{"Allowed (API default) omits the parameter", true, false},
+		{"Disallowed sends the plural parameter", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiURL := ModelsURL(models.QueryParameters{AllowDifferentLicenses: tt.allow, AllowNoCredit: true, AllowDerivatives: true}, "")
+			if !strings.HasPrefix(apiURL, "http://mirror.example/api/v1/models?") {
+				t.Errorf("ModelsURL() = %s, want it under the configured base URL", apiURL)
+			}
+			parsed, err := url.Parse(apiURL)
+			if err != nil {
+				t.Fatalf("ModelsURL() returned unparsable URL %q: %v", apiURL, err)
+			}
+			query := parsed.Query()
+
+			if got := query.Get("allowDifferentLicenses"); tt.wantParam && got != "false" {
+				t.Errorf("allowDifferentLicenses = %q, want \"false\" (URL: %s)", got, apiURL)
+			} else if !tt.wantParam && query.Has("allowDifferentLicenses") {
+				t.Errorf("allowDifferentLicenses should be omitted (URL: %s)", apiURL)
+			}
+			// The singular spelling is silently ignored by the API
+			if query.Has("allowDifferentLicense") {
+				t.Errorf("URL uses the singular allowDifferentLicense parameter: %s", apiURL)
+			}
+		})
+	}
+}
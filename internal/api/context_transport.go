@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// ContextTransport wraps an http.RoundTripper so that every request is also
+// cancelled when Ctx is done. It lets a command-wide deadline abort in-flight
+// requests made by clients that don't otherwise carry a context.
+type ContextTransport struct {
+	Transport http.RoundTripper
+	Ctx       context.Context
+}
+
+// NewContextTransport creates a ContextTransport bound to ctx.
+func NewContextTransport(transport http.RoundTripper, ctx context.Context) *ContextTransport {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &ContextTransport{Transport: transport, Ctx: ctx}
+}
+
+// RoundTrip sends the request with a context that is cancelled by either the
+// request's own context or Ctx.
+func (t *ContextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.Ctx.Err(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	stop := context.AfterFunc(t.Ctx, cancel)
+	resp, err := t.Transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		stop()
+		cancel()
+		return nil, err
+	}
+	// Keep the context alive until the body has been read and closed
+	resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: func() { stop(); cancel() }}
+	return resp, nil
+}
+
+// cancelOnCloseBody releases the per-request context when the body is closed.
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// Package-level limiters shared by every client wrapped in a RateLimitTransport, so
+// all download workers and the metadata client draw from the same budget.
+var (
+	rateLimitMu      sync.Mutex
+	requestLimiter   *rate.Limiter // nil disables the request rate limit
+	bandwidthLimiter *rate.Limiter // nil disables the bandwidth limit
+)
+
+// ConfigureRateLimits sets the shared request rate (requests per second) and
+// bandwidth (bytes per second of response bodies). A value of 0 or less disables
+// the respective limit.
+func ConfigureRateLimits(requestsPerSecond float64, bytesPerSecond int64) {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+	requestLimiter = nil
+	if requestsPerSecond > 0 {
+		requestLimiter = rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
+		log.Debugf("Request rate limit: %g requests/s", requestsPerSecond)
+	}
+	bandwidthLimiter = nil
+	if bytesPerSecond > 0 {
+		// Allow at most one second's worth of data in a single burst
+		bandwidthLimiter = rate.NewLimiter(rate.Limit(bytesPerSecond), int(min(bytesPerSecond, 1<<30)))
+		log.Debugf("Bandwidth limit: %d bytes/s", bytesPerSecond)
+	}
+}
+
+func currentRateLimiters() (*rate.Limiter, *rate.Limiter) {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+	return requestLimiter, bandwidthLimiter
+}
+
+// RateLimitTransport wraps an http.RoundTripper so that every request waits for the
+// shared request limiter and every response body is read no faster than the shared
+// bandwidth limiter allows. It is a no-op while ConfigureRateLimits has not enabled
+// either limit.
+type RateLimitTransport struct {
+	Transport http.RoundTripper
+}
+
+// NewRateLimitTransport creates a RateLimitTransport around transport.
+func NewRateLimitTransport(transport http.RoundTripper) *RateLimitTransport {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &RateLimitTransport{Transport: transport}
+}
+
+// RoundTrip waits for a request token, then sends the request.
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requests, bandwidth := currentRateLimiters()
+	if requests != nil {
+		if err := requests.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil || bandwidth == nil {
+		return resp, err
+	}
+	resp.Body = &rateLimitedBody{ReadCloser: resp.Body, limiter: bandwidth, ctx: req.Context()}
+	return resp, nil
+}
+
+// rateLimitedBody throttles reads of a response body to the bandwidth limiter.
+type rateLimitedBody struct {
+	io.ReadCloser
+	limiter *rate.Limiter
+	ctx     context.Context
+}
+
+func (b *rateLimitedBody) Read(p []byte) (int, error) {
+	if burst := b.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := b.limiter.WaitN(b.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryOptions configures DoRequestWithRetry.
+type RetryOptions struct {
+	MaxRetries   int           // Retries after the first attempt
+	InitialDelay time.Duration // Delay before the first retry, doubled after each further one
+	// RetryableStatusCodes are the non-200 statuses that are retried. Nil retries
+	// 408, 429 and every 5xx status.
+	RetryableStatusCodes []int
+	LogPrefix            string // Prefixed to log and error messages
+}
+
+// retryable reports whether a response with the given status should be retried.
+func (o RetryOptions) retryable(statusCode int) bool {
+	if o.RetryableStatusCodes == nil {
+		return statusCode >= 500 || // Server errors, including 504
+			statusCode == http.StatusRequestTimeout || // 408
+			statusCode == http.StatusTooManyRequests // 429
+	}
+	for _, code := range o.RetryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// DoRequestWithRetry performs an HTTP request with exponential backoff retries on
+// network errors and retryable status codes, and returns the response together with
+// its body, which has already been read and closed. Requests to a host whose circuit
+// breaker is open fail fast. For a non-200 response the response and body are
+// returned along with the error, so callers can inspect the status.
+func DoRequestWithRetry(client *http.Client, req *http.Request, opts RetryOptions) (*http.Response, []byte, error) {
+	logPrefix := opts.LogPrefix
+	maxRetries := opts.MaxRetries
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	breaker := BreakerForHost(req.URL.Host)
+
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			// Calculate backoff: initial * 2^(attempt-1)
+			backoff := opts.InitialDelay * time.Duration(1<<(attempt-1))
+			log.Infof("[%s] Retrying request for %s in %v (Attempt %d/%d)...", logPrefix, req.URL.String(), backoff, attempt+1, maxRetries+1)
+			time.Sleep(backoff)
+		}
+
+		// Fail fast instead of retrying while the host is known to be down
+		if breakerErr := breaker.Allow(); breakerErr != nil {
+			return nil, nil, fmt.Errorf("[%s] not sending request to %s: %w", logPrefix, req.URL.String(), breakerErr)
+		}
+
+		// Clone the request for the attempt, especially important if the body is consumed.
+		clonedReq := req.Clone(req.Context())
+		if req.Body != nil && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				breaker.ReleaseTrial()
+				return nil, nil, fmt.Errorf("[%s] failed to get request body for retry clone (attempt %d): %w", logPrefix, attempt+1, err)
+			}
+			clonedReq.Body = body
+		} else if req.Body != nil {
+			// If it were POST/PUT, we'd need GetBody to be set for safe retries.
+			log.Warnf("[%s] Cannot guarantee safe retry for request with non-nil body without GetBody defined (URL: %s)", logPrefix, req.URL.String())
+		}
+
+		log.Debugf("[%s] Attempt %d/%d: Sending request to %s", logPrefix, attempt+1, maxRetries+1, clonedReq.URL.String())
+		resp, err := client.Do(clonedReq)
+		if err != nil {
+			// Network-level error
+			log.WithError(err).Warnf("[%s] Attempt %d/%d failed for %s: %v", logPrefix, attempt+1, maxRetries+1, clonedReq.URL.String(), err)
+			// A cancelled command (e.g. --deadline) won't recover by retrying
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				breaker.ReleaseTrial()
+				return nil, nil, fmt.Errorf("[%s] request to %s cancelled: %w", logPrefix, clonedReq.URL.String(), err)
+			}
+			breaker.RecordFailure()
+			if attempt == maxRetries {
+				return nil, nil, fmt.Errorf("[%s] network error failed after %d attempts for %s: %w", logPrefix, maxRetries+1, clonedReq.URL.String(), err)
+			}
+			continue // Retry
+		}
+
+		// Read the body regardless of status code
+		bodyBytes, readErr := io.ReadAll(resp.Body)
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			// Log error, but continue processing the body we potentially read successfully
+			log.WithError(closeErr).Warnf("[%s] Failed to close response body after reading for %s", logPrefix, clonedReq.URL.String())
+		}
+		if readErr != nil {
+			log.WithError(readErr).Warnf("[%s] Attempt %d/%d failed to read response body for %s: %v", logPrefix, attempt+1, maxRetries+1, clonedReq.URL.String(), readErr)
+			breaker.RecordFailure()
+			if attempt == maxRetries {
+				return nil, nil, fmt.Errorf("[%s] failed to read body after %d attempts for %s: %w", logPrefix, maxRetries+1, clonedReq.URL.String(), readErr)
+			}
+			continue // Retry
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			breaker.RecordSuccess()
+			log.Debugf("[%s] Attempt %d/%d successful for %s", logPrefix, attempt+1, maxRetries+1, clonedReq.URL.String())
+			return resp, bodyBytes, nil
+		}
+
+		// Non-OK status code
+		bodySample := string(bodyBytes)
+		if len(bodySample) > 200 { // Limit logged body size
+			bodySample = bodySample[:200] + "..."
+		}
+		log.Warnf("[%s] Attempt %d/%d for %s failed with status %s. Body: %s", logPrefix, attempt+1, maxRetries+1, clonedReq.URL.String(), resp.Status, bodySample)
+
+		isRetryableStatus := opts.retryable(resp.StatusCode)
+		// Only server-side trouble counts towards the breaker; a 404 still means the host is up
+		if isRetryableStatus {
+			breaker.RecordFailure()
+		} else {
+			breaker.RecordSuccess()
+		}
+
+		if isRetryableStatus && attempt < maxRetries {
+			log.Warnf("[%s] Status %s is retryable.", logPrefix, resp.Status)
+			continue // Backoff delay is handled at the start of the next iteration
+		}
+		if !isRetryableStatus {
+			return resp, bodyBytes, fmt.Errorf("[%s] request failed with non-retryable status %s on attempt %d. Body: %s", logPrefix, resp.Status, attempt+1, bodySample)
+		}
+		return resp, bodyBytes, fmt.Errorf("[%s] request failed with status %s after %d attempts. Body: %s", logPrefix, resp.Status, attempt+1, bodySample)
+	}
+
+	// Should not be reachable
+	return nil, nil, fmt.Errorf("[%s] retry loop completed without success or error return for %s", logPrefix, req.URL.String())
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoRequestWithRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int // Responses answered with failStatus before a 200
+		failStatus   int
+		closeConn    bool // Drop the connection instead of answering
+		maxRetries   int
+		wantRequests int32
+		wantErr      bool
+		wantStatus   int
+	}{
+		{name: "429 then success", failures: 2, failStatus: http.StatusTooManyRequests, maxRetries: 3, wantRequests: 3, wantStatus: http.StatusOK},
+		{name: "429 exhausts retries", failures: 5, failStatus: http.StatusTooManyRequests, maxRetries: 2, wantRequests: 3, wantErr: true, wantStatus: http.StatusTooManyRequests},
+		{name: "404 is not retried", failures: 5, failStatus: http.StatusNotFound, maxRetries: 3, wantRequests: 1, wantErr: true, wantStatus: http.StatusNotFound},
+		{name: "network error then success", failures: 1, closeConn: true, maxRetries: 2, wantRequests: 2, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if n := atomic.AddInt32(&requests, 1); int(n) <= tt.failures {
+					if tt.closeConn {
+						conn, _, err := w.(http.Hijacker).Hijack()
+						if err == nil {
+							conn.Close()
+						}
+						return
+					}
+					w.WriteHeader(tt.failStatus)
+					return
+				}
+				_, _ = w.Write([]byte("ok"))
+			}))
+			defer server.Close()
+
+			req, err := http.NewRequest("GET", server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := RetryOptions{MaxRetries: tt.maxRetries, InitialDelay: time.Millisecond, LogPrefix: tt.name}
+			resp, body, err := DoRequestWithRetry(server.Client(), req, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DoRequestWithRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.wantRequests {
+				t.Errorf("server saw %d requests, want %d", got, tt.wantRequests)
+			}
+			if resp == nil || resp.StatusCode != tt.wantStatus {
+				t.Errorf("DoRequestWithRetry() response = %v, want status %d", resp, tt.wantStatus)
+			}
+			if !tt.wantErr && string(body) != "ok" {
+				t.Errorf("DoRequestWithRetry() body = %q, want %q", body, "ok")
+			}
+		})
+	}
+}
+
+func TestRetryOptionsRetryableStatusCodes(t *testing.T) {
+	opts := RetryOptions{RetryableStatusCodes: []int{http.StatusConflict}}
+	if !opts.retryable(http.StatusConflict) || opts.retryable(http.StatusServiceUnavailable) {
+		t.Error("custom RetryableStatusCodes were not used as the exact retryable set")
+	}
+	var defaults RetryOptions
+	for _, code := range []int{http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusGatewayTimeout} {
+		if !defaults.retryable(code) {
+			t.Errorf("status %d is not retried by default", code)
+		}
+	}
+	if defaults.retryable(http.StatusNotFound) {
+		t.Error("404 is retried by default")
+	}
+}
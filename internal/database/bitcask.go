@@ -325,4 +325,76 @@ func (d *DB) DeletePageState(queryHash string) error {
 	return nil // Treat KeyNotFound as success
 }
 
+// GetImageCursor retrieves the saved images API cursor for a given query hash.
+// Returns an empty string if no cursor has been saved.
+func (d *DB) GetImageCursor(queryHash string) (string, error) {
+	key := []byte("images_cursor_" + queryHash)
+	cursorBytes, err := d.Get(key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return "", nil // Nothing saved, start from the first page
+		}
+		return "", fmt.Errorf("error reading image cursor for %s: %w", queryHash, err)
+	}
+	log.WithField("queryHash", queryHash).Debugf("Retrieved image cursor: %s", string(cursorBytes))
+	return string(cursorBytes), nil
+}
+
+// SetImageCursor saves the images API cursor to resume from for a given query hash.
+func (d *DB) SetImageCursor(queryHash string, cursor string) error {
+	key := []byte("images_cursor_" + queryHash)
+	if err := d.Put(key, []byte(cursor)); err != nil {
+		return err // Put already wraps error
+	}
+	log.WithField("queryHash", queryHash).Debugf("Set image cursor to: %s", cursor)
+	return nil
+}
+
+// DeleteImageCursor removes the saved images API cursor for a given query hash.
+func (d *DB) DeleteImageCursor(queryHash string) error {
+	key := []byte("images_cursor_" + queryHash)
+	err := d.Delete(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("error deleting image cursor for %s: %w", queryHash, err)
+	}
+	log.WithField("queryHash", queryHash).Debug("Deleted image cursor")
+	return nil // Treat KeyNotFound as success
+}
+
+// GetModelsCursor retrieves the saved models API cursor for a given query hash.
+// Returns an empty string if no cursor has been saved.
+func (d *DB) GetModelsCursor(queryHash string) (string, error) {
+	key := []byte("cursor_" + queryHash)
+	cursorBytes, err := d.Get(key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return "", nil // Nothing saved, start from the first page
+		}
+		return "", fmt.Errorf("error reading models cursor for %s: %w", queryHash, err)
+	}
+	log.WithField("queryHash", queryHash).Debugf("Retrieved models cursor: %s", string(cursorBytes))
+	return string(cursorBytes), nil
+}
+
+// SetModelsCursor saves the models API cursor to resume from for a given query hash.
+func (d *DB) SetModelsCursor(queryHash string, cursor string) error {
+	key := []byte("cursor_" + queryHash)
+	if err := d.Put(key, []byte(cursor)); err != nil {
+		return err // Put already wraps error
+	}
+	log.WithField("queryHash", queryHash).Debugf("Set models cursor to: %s", cursor)
+	return nil
+}
+
+// DeleteModelsCursor removes the saved models API cursor for a given query hash.
+func (d *DB) DeleteModelsCursor(queryHash string) error {
+	key := []byte("cursor_" + queryHash)
+	err := d.Delete(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("error deleting models cursor for %s: %w", queryHash, err)
+	}
+	log.WithField("queryHash", queryHash).Debug("Deleted models cursor")
+	return nil // Treat KeyNotFound as success
+}
+
 // TODO: Add functions for CLI features like ListModels, GetModelInfo, etc.
@@ -1,8 +1,12 @@
 package downloader
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"mime"
 	"net/http"
@@ -12,6 +16,7 @@ import (
 	"strings"
 	"time"
 
+	"go-civitai-download/internal/api"
 	"go-civitai-download/internal/helpers"
 	"go-civitai-download/internal/models"
 
@@ -26,7 +31,8 @@ var (
 	ErrHttpRequest  = errors.New("HTTP request creation/execution error")
 )
 
-// Downloader handles downloading files with progress and hash checks.
+// Downloader handles downloading files with progress and hash checks. Request and
+// bandwidth limits are applied by the client's transport (see api.RateLimitTransport).
 type Downloader struct {
 	client *http.Client
 	apiKey string // Add field to store API key
@@ -98,12 +104,44 @@ func findExistingFileWithMatchingBaseAndHash(dirPath string, baseNameWithoutExt
 	return "", false, nil // No matching file found
 }
 
+// tempDownloadPath returns the partial file a download of targetFilepath is written
+// to. It includes the model version ID, so two versions that resolve to the same
+// target never resume each other's partial.
+func tempDownloadPath(targetFilepath string, modelVersionID int) string {
+	if modelVersionID == 0 {
+		return targetFilepath + ".tmp"
+	}
+	return fmt.Sprintf("%s.%d.tmp", targetFilepath, modelVersionID)
+}
+
 // DownloadFile downloads a file from the specified URL to the target filepath.
 // It checks for existing files, verifies hashes, and attempts to use the
 // Content-Disposition header for the filename.
 // It also now accepts a modelVersionID to prepend to the final filename.
 // Returns the final filepath used (or empty string on failure) and an error if one occurred.
-func (d *Downloader) DownloadFile(targetFilepath string, url string, hashes models.Hashes, modelVersionID int) (string, error) {
+// Cancelling ctx aborts the transfer; the error then wraps ctx.Err() and the
+// temporary file is removed.
+func (d *Downloader) DownloadFile(ctx context.Context, targetFilepath string, url string, hashes models.Hashes, modelVersionID int) (string, error) {
+	finalPath, _, err := d.downloadFile(ctx, targetFilepath, url, hashes, modelVersionID, false, nil)
+	return finalPath, err
+}
+
+// ProgressFunc receives the progress of a download: the bytes of the file written so
+// far (including a resumed partial) and its total size, 0 if the server did not send
+// a Content-Length. It is called from the downloading goroutine on every write.
+type ProgressFunc func(written, total uint64)
+
+// DownloadFileSHA256 is DownloadFile that also returns the uppercase hex SHA256 of
+// the downloaded file, computed while it is written. The hash is empty when a valid
+// existing file was found and nothing was downloaded. onProgress, if not nil, is
+// called as data arrives.
+func (d *Downloader) DownloadFileSHA256(ctx context.Context, targetFilepath string, url string, hashes models.Hashes, modelVersionID int, onProgress ProgressFunc) (string, string, error) {
+	return d.downloadFile(ctx, targetFilepath, url, hashes, modelVersionID, true, onProgress)
+}
+
+// downloadFile implements DownloadFile, computing the SHA256 of the downloaded data
+// along the way if computeSHA256 is set and reporting progress to onProgress.
+func (d *Downloader) downloadFile(ctx context.Context, targetFilepath string, url string, hashes models.Hashes, modelVersionID int, computeSHA256 bool, onProgress ProgressFunc) (string, string, error) {
 	initialFinalFilepath := targetFilepath // Store the initially constructed path
 	targetDir := filepath.Dir(initialFinalFilepath)
 	initialBaseName := filepath.Base(initialFinalFilepath)
@@ -115,38 +153,59 @@ func (d *Downloader) DownloadFile(targetFilepath string, url string, hashes mode
 	foundPath, exists, errCheck := findExistingFileWithMatchingBaseAndHash(targetDir, initialBaseNameWithoutExt, initialExt, hashes)
 	if errCheck != nil {
 		log.WithError(errCheck).Errorf("Error during initial check for existing file matching %s%s in %s", initialBaseNameWithoutExt, initialExt, targetDir)
-		return "", fmt.Errorf("%w: initial check for existing file: %v", ErrFileSystem, errCheck)
+		return "", "", fmt.Errorf("%w: initial check for existing file: %v", ErrFileSystem, errCheck)
 	}
 	if exists {
 		log.Infof("Found valid existing file matching base name '%s' and extension '%s': %s. Skipping download.", initialBaseNameWithoutExt, initialExt, foundPath)
-		return foundPath, nil // Success, return the path of the valid existing file
+		return foundPath, "", nil // Success, return the path of the valid existing file
 	}
 	log.Infof("No valid file matching base name '%s' and extension '%s' found initially. Proceeding with download process.", initialBaseNameWithoutExt, initialExt)
 	// --- End Initial Check ---
 
 	// Ensure target directory exists before creating temp file
 	if !helpers.CheckAndMakeDir(targetDir) {
-		return "", fmt.Errorf("%w: failed to create target directory %s", ErrFileSystem, targetDir)
+		return "", "", fmt.Errorf("%w: failed to create target directory %s", ErrFileSystem, targetDir)
 	}
 
-	// Create a temporary file in the target directory
-	baseName := filepath.Base(targetFilepath)
-	tempFile, err := os.CreateTemp(targetDir, baseName+".*.tmp") // Use targetDir here
+	// Use a stable temporary file name in the target directory so an interrupted
+	// download can be picked up again by a later run (see progress.go).
+	tempPath := tempDownloadPath(targetFilepath, modelVersionID)
+	resumeOffset, existingProgress := resumableOffset(tempPath, url)
+
+	var tempFile *os.File
+	var err error
+	if resumeOffset > 0 {
+		tempFile, err = os.OpenFile(tempPath, os.O_WRONLY, 0644)
+		if err == nil {
+			// Drop anything written after the last recorded offset; it was never confirmed.
+			if err = tempFile.Truncate(resumeOffset); err == nil {
+				_, err = tempFile.Seek(resumeOffset, io.SeekStart)
+			}
+		}
+	} else {
+		removeProgress(tempPath) // Stale or invalid sidecar, start over
+		tempFile, err = os.Create(tempPath)
+	}
 	if err != nil {
-		return "", fmt.Errorf("%w: creating temporary file %s: %w", ErrFileSystem, targetFilepath, err)
+		return "", "", fmt.Errorf("%w: creating temporary file %s: %w", ErrFileSystem, targetFilepath, err)
 	}
-	// Use a flag to track if we should remove the temp file on error exit
+	// Use a flag to track if we should remove the temp file on error exit.
+	// keepPartial is set when the temp file holds resumable data, in which case
+	// it (and its progress sidecar) are left in place for the next attempt.
 	shouldCleanupTemp := true
+	keepPartial := resumeOffset > 0
 	defer func() {
 		if shouldCleanupTemp {
-			// If tempFile wasn't closed explicitly due to an early error *before* the explicit close,
-			// or if it was closed but we still need to cleanup (e.g., hash mismatch),
-			// we might need to close it here, but the explicit close should handle most cases.
-			// The main goal here is the os.Remove.
+			_ = tempFile.Close() // May already be closed, error ignored
+			if keepPartial {
+				log.Infof("Keeping partial download %s for a later resume.", tempFile.Name())
+				return
+			}
 			log.Debugf("Cleaning up temporary file via defer: %s", tempFile.Name())
 			if removeErr := os.Remove(tempFile.Name()); removeErr != nil {
 				log.WithError(removeErr).Warnf("Failed to remove temporary file %s during defer cleanup", tempFile.Name())
 			}
+			removeProgress(tempFile.Name())
 		}
 	}()
 
@@ -155,9 +214,13 @@ func (d *Downloader) DownloadFile(targetFilepath string, url string, hashes mode
 	log.Infof("Attempting to download from URL: %s", url)
 
 	// Create request
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return "", fmt.Errorf("%w: creating download request for %s: %w", ErrHttpRequest, url, err)
+		return "", "", fmt.Errorf("%w: creating download request for %s: %w", ErrHttpRequest, url, err)
+	}
+	if resumeOffset > 0 {
+		log.Infof("Resuming partial download %s from byte %d", tempPath, resumeOffset)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeOffset))
 	}
 
 	// Add authentication header if API key is present
@@ -169,16 +232,57 @@ func (d *Downloader) DownloadFile(targetFilepath string, url string, hashes mode
 		log.Debug("No API Key found, skipping Authorization header for download.") // Added Debug Log
 	}
 
+	// Fail fast while the host's circuit breaker is open
+	breaker := api.BreakerForHost(req.URL.Host)
+	if breakerErr := breaker.Allow(); breakerErr != nil {
+		return "", "", fmt.Errorf("%w: %w", ErrHttpRequest, breakerErr)
+	}
+
 	resp, err := d.client.Do(req)
 	if err != nil {
+		// A cancelled request says nothing about the host's health
+		if ctx.Err() == nil {
+			breaker.RecordFailure()
+		} else {
+			breaker.ReleaseTrial()
+		}
 		log.WithError(err).Errorf("Error performing download request from %s", url)
-		return "", fmt.Errorf("%w: performing request for %s: %v", ErrHttpRequest, url, err)
+		return "", "", fmt.Errorf("%w: performing request for %s: %w", ErrHttpRequest, url, err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		breaker.RecordFailure()
+	} else {
+		breaker.RecordSuccess()
+	}
 
-	if resp.StatusCode != http.StatusOK {
+	if resumeOffset > 0 && resp.StatusCode == http.StatusOK {
+		// Server ignored the Range header and is sending the whole file; start over.
+		log.Warnf("Server did not honour range request for %s, restarting download from zero.", url)
+		if err := tempFile.Truncate(0); err != nil {
+			return "", "", fmt.Errorf("%w: truncating temporary file %s: %w", ErrFileSystem, tempPath, err)
+		}
+		if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+			return "", "", fmt.Errorf("%w: seeking temporary file %s: %w", ErrFileSystem, tempPath, err)
+		}
+		resumeOffset = 0
+		existingProgress = nil
+		keepPartial = false
+		removeProgress(tempPath)
+	} else if resumeOffset > 0 && resp.StatusCode != http.StatusPartialContent {
+		log.Errorf("Error resuming download: Received status code %d from %s", resp.StatusCode, url)
+		return "", "", &HttpStatusError{StatusCode: resp.StatusCode, URL: url}
+	} else if resumeOffset > 0 {
+		// Make sure the server continues exactly where the partial ends
+		start, _, total, rangeErr := parseContentRange(resp.Header.Get("Content-Range"))
+		if rangeErr != nil || start != resumeOffset || (total >= 0 && total < resumeOffset) {
+			log.WithError(rangeErr).Errorf("Server sent an unexpected Content-Range %q for %s (expected start %d), discarding partial download.", resp.Header.Get("Content-Range"), url, resumeOffset)
+			keepPartial = false
+			return "", "", fmt.Errorf("%w: unexpected Content-Range %q resuming %s from byte %d", ErrHttpStatus, resp.Header.Get("Content-Range"), url, resumeOffset)
+		}
+	} else if resumeOffset == 0 && resp.StatusCode != http.StatusOK {
 		log.Errorf("Error downloading file: Received status code %d from %s", resp.StatusCode, url)
-		return "", fmt.Errorf("%w: received status %d from %s", ErrHttpStatus, resp.StatusCode, url)
+		return "", "", &HttpStatusError{StatusCode: resp.StatusCode, URL: url}
 	}
 
 	// --- Filename Handling from Content-Disposition ---
@@ -188,7 +292,8 @@ func (d *Downloader) DownloadFile(targetFilepath string, url string, hashes mode
 	if contentDisposition != "" {
 		_, params, err := mime.ParseMediaType(contentDisposition)
 		if err == nil && params["filename"] != "" {
-			potentialApiFilename = params["filename"]
+			// Only the base name is used so a header cannot point outside the target directory
+			potentialApiFilename = filepath.Base(params["filename"])
 			log.Infof("Received filename from Content-Disposition: %s", potentialApiFilename)
 		} else {
 			// If the disposition is 'inline' and has no filename, it's expected, log as debug.
@@ -233,31 +338,63 @@ func (d *Downloader) DownloadFile(targetFilepath string, url string, hashes mode
 	foundPathFinal, existsFinal, errCheckFinal := findExistingFileWithMatchingBaseAndHash(finalTargetDir, finalBaseNameWithoutExt, finalExt, hashes)
 	if errCheckFinal != nil {
 		log.WithError(errCheckFinal).Errorf("Error during final check for existing file matching %s%s in %s", finalBaseNameWithoutExt, finalExt, finalTargetDir)
-		return "", fmt.Errorf("%w: final check for existing file: %v", ErrFileSystem, errCheckFinal)
+		return "", "", fmt.Errorf("%w: final check for existing file: %v", ErrFileSystem, errCheckFinal)
 	}
 	if existsFinal {
 		log.Infof("Found valid existing file matching final base name '%s' and extension '%s': %s. Download not needed.", finalBaseNameWithoutExt, finalExt, foundPathFinal)
-		shouldCleanupTemp = true   // Ensure any temp file created before this check is removed
-		return foundPathFinal, nil // Success, return the path of the valid existing file
+		shouldCleanupTemp = true       // Ensure any temp file created before this check is removed
+		keepPartial = false            // The partial is no longer needed either
+		return foundPathFinal, "", nil // Success, return the path of the valid existing file
 	}
 	log.Debugf("Final target file base name '%s' with extension '%s' does not exist with valid hash. Proceeding with network download to temp file.", finalBaseNameWithoutExt, finalExt)
 	// --- End Final Path Check ---
 
-	// Get the size of the file
+	// Get the size of the file (a 206 response only carries the remaining bytes)
 	size, _ := strconv.ParseUint(resp.Header.Get("Content-Length"), 10, 64)
+	if resumeOffset > 0 && size > 0 {
+		size += uint64(resumeOffset)
+	}
 
-	// Create a CounterWriter
+	// Create a CounterWriter, recording progress to the sidecar as bytes arrive
+	progress := newProgressWriter(tempFile, url, resumeOffset, existingProgress)
 	counter := &helpers.CounterWriter{
-		Writer: tempFile,
+		Writer: progress,
 		Total:  0,
 	}
+	if onProgress != nil {
+		counter.OnWrite = func(total uint64) {
+			onProgress(uint64(resumeOffset)+total, size)
+		}
+	}
+
+	// Hash the file while it is written; a resumed download first hashes the partial
+	var dst io.Writer = counter
+	var sha hash.Hash
+	if computeSHA256 {
+		sha = sha256.New()
+		if resumeOffset > 0 {
+			if err := copyFilePrefix(sha, tempPath, resumeOffset); err != nil {
+				return "", "", fmt.Errorf("%w: hashing partial download %s: %w", ErrFileSystem, tempPath, err)
+			}
+		}
+		dst = io.MultiWriter(counter, sha)
+	}
 
 	// Write the body to temporary file, showing progress
 	log.Infof("Downloading to %s (Target: %s, Size: %s)...", tempFile.Name(), finalFilepath, helpers.BytesToSize(size))
-	_, err = io.Copy(counter, resp.Body)
+	_, err = io.Copy(dst, &contextReader{ctx: ctx, r: resp.Body})
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		log.Warnf("Download of %s cancelled, removing %s", url, tempFile.Name())
+		keepPartial = false
+		return "", "", fmt.Errorf("downloading %s: %w", url, ctxErr)
+	}
 	if err != nil {
 		log.WithError(err).Errorf("Error writing temporary file %s", tempFile.Name())
-		return "", fmt.Errorf("%w: writing temporary file %s: %v", ErrFileSystem, tempFile.Name(), err)
+		if progress.progress.Offset > 0 {
+			progress.flush() // Record how far we got so the next run can resume
+			keepPartial = true
+		}
+		return "", "", fmt.Errorf("%w: writing temporary file %s: %w", ErrFileSystem, tempFile.Name(), err)
 	}
 	log.Infof("Finished writing %s.", tempFile.Name())
 
@@ -266,7 +403,7 @@ func (d *Downloader) DownloadFile(targetFilepath string, url string, hashes mode
 		// Log the error, but try to continue with hash check/rename if closing failed?
 		// Or maybe return error here? Returning error seems safer.
 		log.WithError(err).Errorf("Failed to explicitly close temp file %s before hash/rename", tempFile.Name())
-		return "", fmt.Errorf("%w: closing temp file %s: %w", ErrFileSystem, tempFile.Name(), err)
+		return "", "", fmt.Errorf("%w: closing temp file %s: %w", ErrFileSystem, tempFile.Name(), err)
 	}
 
 	// Verify the hash of the downloaded temporary file ONLY if hashes were provided
@@ -275,7 +412,8 @@ func (d *Downloader) DownloadFile(targetFilepath string, url string, hashes mode
 		log.Debugf("Verifying hash for temp file: %s", tempFile.Name())
 		if !helpers.CheckHash(tempFile.Name(), hashes) {
 			log.Errorf("Hash mismatch for downloaded file: %s", tempFile.Name())
-			return "", ErrHashMismatch
+			keepPartial = false // Corrupt data, never resume from it
+			return "", "", ErrHashMismatch
 		}
 		log.Infof("Hash verified for %s.", tempFile.Name())
 	} else {
@@ -286,12 +424,73 @@ func (d *Downloader) DownloadFile(targetFilepath string, url string, hashes mode
 	log.Debugf("Renaming temp file %s to %s", tempFile.Name(), finalFilepath)
 	if err = os.Rename(tempFile.Name(), finalFilepath); err != nil {
 		log.WithError(err).Errorf("Error renaming temporary file %s to %s", tempFile.Name(), finalFilepath)
-		return "", fmt.Errorf("%w: renaming temporary file %s to %s: %v", ErrFileSystem, tempFile.Name(), finalFilepath, err)
+		return "", "", fmt.Errorf("%w: renaming temporary file %s to %s: %v", ErrFileSystem, tempFile.Name(), finalFilepath, err)
+	}
+
+	sum := ""
+	if sha != nil {
+		sum = strings.ToUpper(hex.EncodeToString(sha.Sum(nil)))
 	}
 
 	// If rename was successful, we don't want the defer to remove the temp file (which is now the final file)
 	shouldCleanupTemp = false
+	removeProgress(tempPath)
 	log.Infof("Successfully downloaded and verified %s", finalFilepath)
 
-	return finalFilepath, nil
+	return finalFilepath, sum, nil
+}
+
+// copyFilePrefix writes the first n bytes of the file at path to w.
+func copyFilePrefix(w io.Writer, path string, n int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(w, f, n)
+	return err
+}
+
+// contextReader stops a read loop such as io.Copy once ctx is done, even if the
+// underlying body would keep delivering data.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// parseContentRange parses a "bytes start-end/total" Content-Range header. total is
+// -1 when the server reports it as unknown ("*").
+func parseContentRange(header string) (start, end, total int64, err error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes ")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("unsupported Content-Range %q", header)
+	}
+	rangePart, totalPart, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", header)
+	}
+	startStr, endStr, ok := strings.Cut(rangePart, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", header)
+	}
+	if start, err = strconv.ParseInt(startStr, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range start %q: %w", header, err)
+	}
+	if end, err = strconv.ParseInt(endStr, 10, 64); err != nil || end < start {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range end %q", header)
+	}
+	total = -1
+	if totalPart != "*" {
+		if total, err = strconv.ParseInt(totalPart, 10, 64); err != nil || total <= end {
+			return 0, 0, 0, fmt.Errorf("malformed Content-Range total %q", header)
+		}
+	}
+	return start, end, total, nil
 }
@@ -0,0 +1,362 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"go-civitai-download/internal/api"
+	"go-civitai-download/internal/models"
+)
+
+// testPayload returns deterministic file content and its hashes.
+func testPayload(size int) ([]byte, models.Hashes) {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	sum := sha256.Sum256(data)
+	return data, models.Hashes{SHA256: strings.ToUpper(hex.EncodeToString(sum[:]))}
+}
+
+// TestDownloadFileResume simulates a connection dropped halfway through a download,
+// followed by a second attempt that continues from the partial with a Range request.
+func TestDownloadFileResume(t *testing.T) {
+	data, hashes := testPayload(3 * 1024 * 1024)
+	cut := len(data) / 2
+
+	tests := []struct {
+		name         string
+		ignoreRange  bool   // Server answers the resume with 200 and the whole file
+		contentRange string // Overrides the Content-Range of the 206 response
+		wantRange    string
+		wantErr      bool
+	}{
+		{name: "resumed with 206", wantRange: fmt.Sprintf("bytes=%d-", cut)},
+		{name: "server ignores range", ignoreRange: true, wantRange: fmt.Sprintf("bytes=%d-", cut)},
+		{name: "wrong Content-Range", contentRange: "bytes 0-99/100", wantRange: fmt.Sprintf("bytes=%d-", cut), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			var gotRange string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests == 1 {
+					// Announce the full size but drop the connection halfway through
+					w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+					w.WriteHeader(http.StatusOK)
+					_, _ = w.Write(data[:cut])
+					return
+				}
+				gotRange = r.Header.Get("Range")
+				if tt.ignoreRange || gotRange == "" {
+					w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+					_, _ = w.Write(data)
+					return
+				}
+				contentRange := tt.contentRange
+				if contentRange == "" {
+					contentRange = fmt.Sprintf("bytes %d-%d/%d", cut, len(data)-1, len(data))
+				}
+				w.Header().Set("Content-Range", contentRange)
+				w.Header().Set("Content-Length", strconv.Itoa(len(data)-cut))
+				w.WriteHeader(http.StatusPartialContent)
+				_, _ = w.Write(data[cut:])
+			}))
+			defer server.Close()
+
+			target := filepath.Join(t.TempDir(), "model.safetensors")
+			d := NewDownloader(server.Client(), "")
+
+			if _, err := d.DownloadFile(context.Background(), target, server.URL, hashes, 0); err == nil {
+				t.Fatal("first attempt succeeded, expected the truncated body to fail")
+			}
+			if info, err := os.Stat(target + ".tmp"); err != nil || info.Size() != int64(cut) {
+				t.Fatalf("expected a %d byte partial after the first attempt, got %v (err %v)", cut, info, err)
+			}
+
+			// The SHA256 computed while writing must cover the resumed partial too
+			var lastWritten, lastTotal uint64
+			onProgress := func(written, total uint64) { lastWritten, lastTotal = written, total }
+			finalPath, sum, err := d.DownloadFileSHA256(context.Background(), target, server.URL, hashes, 0, onProgress)
+			if gotRange != tt.wantRange {
+				t.Errorf("resume request Range = %q, want %q", gotRange, tt.wantRange)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("resume succeeded despite a mismatched Content-Range")
+				}
+				if _, statErr := os.Stat(target + ".tmp"); !os.IsNotExist(statErr) {
+					t.Errorf("partial was kept after a mismatched Content-Range")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resume failed: %v", err)
+			}
+			got, err := os.ReadFile(finalPath)
+			if err != nil {
+				t.Fatalf("reading downloaded file: %v", err)
+			}
+			if len(got) != len(data) || string(got) != string(data) {
+				t.Errorf("downloaded file differs from the served content (%d bytes, want %d)", len(got), len(data))
+			}
+			if sum != hashes.SHA256 {
+				t.Errorf("DownloadFileSHA256() sum = %s, want %s", sum, hashes.SHA256)
+			}
+			if lastWritten != uint64(len(data)) || lastTotal != uint64(len(data)) {
+				t.Errorf("last progress = %d of %d bytes, want %d of %d including the partial", lastWritten, lastTotal, len(data), len(data))
+			}
+			if _, statErr := os.Stat(progressPath(target + ".tmp")); !os.IsNotExist(statErr) {
+				t.Errorf("progress sidecar was not removed after a successful download")
+			}
+		})
+	}
+}
+
+// TestDownloadFileResumePerVersion checks that two versions resolving to the same
+// target keep separate partials, so neither resumes from the other's bytes.
+func TestDownloadFileResumePerVersion(t *testing.T) {
+	data, hashes := testPayload(1024 * 1024)
+	cut := len(data) / 2
+
+	var mu sync.Mutex
+	truncate := true
+	ranges := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges[r.URL.Path] = r.Header.Get("Range")
+		drop := truncate
+		truncate = false
+		mu.Unlock()
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		if drop {
+			_, _ = w.Write(data[:cut])
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	target := filepath.Join(t.TempDir(), "model.safetensors")
+	d := NewDownloader(server.Client(), "")
+
+	if _, err := d.DownloadFile(context.Background(), target, server.URL+"/v1", hashes, 1); err == nil {
+		t.Fatal("first attempt succeeded, expected the truncated body to fail")
+	}
+	partial := tempDownloadPath(target, 1)
+	if info, err := os.Stat(partial); err != nil || info.Size() != int64(cut) {
+		t.Fatalf("expected a %d byte partial for version 1, got %v (err %v)", cut, info, err)
+	}
+
+	if _, err := d.DownloadFile(context.Background(), target, server.URL+"/v2", hashes, 2); err != nil {
+		t.Fatalf("version 2 download failed: %v", err)
+	}
+	mu.Lock()
+	gotRange := ranges["/v2"]
+	mu.Unlock()
+	if gotRange != "" {
+		t.Errorf("version 2 sent Range %q, want a fresh download", gotRange)
+	}
+	if info, err := os.Stat(partial); err != nil || info.Size() != int64(cut) {
+		t.Errorf("version 1 partial was touched by the version 2 download: %v (err %v)", info, err)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header                string
+		start, end, wantTotal int64
+		wantErr               bool
+	}{
+		{"bytes 100-199/200", 100, 199, 200, false},
+		{"bytes 100-199/*", 100, 199, -1, false},
+		{"bytes 100-199/150", 0, 0, 0, true},
+		{"bytes 200-100/300", 0, 0, 0, true},
+		{"items 0-1/2", 0, 0, 0, true},
+		{"", 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		start, end, total, err := parseContentRange(tt.header)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseContentRange(%q) error = %v, wantErr %v", tt.header, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (start != tt.start || end != tt.end || total != tt.wantTotal) {
+			t.Errorf("parseContentRange(%q) = %d, %d, %d, want %d, %d, %d", tt.header, start, end, total, tt.start, tt.end, tt.wantTotal)
+		}
+	}
+}
+
+// TestDownloadFileBLAKE3Only checks that a file for which the API only provides a
+// BLAKE3 hash is verified against it rather than accepted unchecked.
+func TestDownloadFileBLAKE3Only(t *testing.T) {
+	// BLAKE3 test vector for "abc"
+	const abcBLAKE3 = "6437B3AC38465133FFB63B75273A8DB548C558465D79DB03FD359C6CD5BD9D85"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("abc"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		blake3  string
+		wantErr error
+	}{
+		{name: "matching digest", blake3: abcBLAKE3},
+		{name: "lowercase digest", blake3: strings.ToLower(abcBLAKE3)},
+		{name: "mismatched digest", blake3: strings.Repeat("0", 64), wantErr: ErrHashMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "model.safetensors")
+			d := NewDownloader(server.Client(), "")
+			finalPath, err := d.DownloadFile(context.Background(), target, server.URL, models.Hashes{BLAKE3: tt.blake3}, 0)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("DownloadFile() error = %v, want %v", err, tt.wantErr)
+				}
+				if _, statErr := os.Stat(target); !os.IsNotExist(statErr) {
+					t.Errorf("file with a mismatched hash was moved into place")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DownloadFile() error = %v", err)
+			}
+			if _, statErr := os.Stat(finalPath); statErr != nil {
+				t.Errorf("downloaded file missing: %v", statErr)
+			}
+		})
+	}
+}
+
+// TestDownloadFileRateLimit starts several downloads at once through a client limited
+// to one request per second and checks the server saw them one second apart.
+func TestDownloadFileRateLimit(t *testing.T) {
+	api.ConfigureRateLimits(1, 0)
+	defer api.ConfigureRateLimits(0, 0)
+
+	var mu sync.Mutex
+	var requestTimes []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requestTimes = append(requestTimes, time.Now())
+		mu.Unlock()
+		_, _ = w.Write([]byte("abc"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: api.NewRateLimitTransport(server.Client().Transport)}
+	dir := t.TempDir()
+	const downloads = 3
+	var wg sync.WaitGroup
+	for i := 0; i < downloads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d := NewDownloader(client, "")
+			if _, err := d.DownloadFile(context.Background(), filepath.Join(dir, fmt.Sprintf("file%d.bin", i)), server.URL, models.Hashes{}, 0); err != nil {
+				t.Errorf("download %d failed: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(requestTimes) != downloads {
+		t.Fatalf("server saw %d requests, want %d", len(requestTimes), downloads)
+	}
+	sort.Slice(requestTimes, func(i, j int) bool { return requestTimes[i].Before(requestTimes[j]) })
+	for i := 1; i < len(requestTimes); i++ {
+		// Allow some scheduling slack below the nominal one second
+		if gap := requestTimes[i].Sub(requestTimes[i-1]); gap < 900*time.Millisecond {
+			t.Errorf("requests %d and %d were %v apart, want about 1s", i-1, i, gap)
+		}
+	}
+}
+
+// TestDownloadFileCancel cancels the context while a slow transfer is in progress and
+// checks the download stops with context.Canceled and leaves no temporary file.
+func TestDownloadFileCancel(t *testing.T) {
+	data, hashes := testPayload(64 * 1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		for i := 0; i < len(data); i += 1024 {
+			if _, err := w.Write(data[i : i+1024]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	target := filepath.Join(t.TempDir(), "model.safetensors")
+	d := NewDownloader(server.Client(), "")
+
+	start := time.Now()
+	_, err := d.DownloadFile(ctx, target, server.URL, hashes, 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("DownloadFile() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("DownloadFile() took %v to return after the cancel", elapsed)
+	}
+	if ErrorCategory(err) != CategoryCanceled {
+		t.Errorf("ErrorCategory() = %q, want %q", ErrorCategory(err), CategoryCanceled)
+	}
+	for _, leftover := range []string{target, target + ".tmp", progressPath(target + ".tmp")} {
+		if _, statErr := os.Stat(leftover); !os.IsNotExist(statErr) {
+			t.Errorf("%s was left behind after the cancelled download", leftover)
+		}
+	}
+}
+
+// TestErrorCategory wraps each sentinel error the way DownloadFile returns it and
+// checks the category stored in the database and shown in the run summary.
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{fmt.Errorf("%w: expected ABC, got DEF", ErrHashMismatch), CategoryHashMismatch},
+		{&HttpStatusError{StatusCode: http.StatusForbidden}, CategoryAuth},
+		{&HttpStatusError{StatusCode: http.StatusNotFound}, CategoryNotFound},
+		{&HttpStatusError{StatusCode: http.StatusTooManyRequests}, CategoryRateLimited},
+		{&HttpStatusError{StatusCode: http.StatusBadGateway}, CategoryServerError},
+		{fmt.Errorf("%w: 418", ErrHttpStatus), CategoryHttpStatus},
+		{fmt.Errorf("%w: connection refused", ErrHttpRequest), CategoryNetwork},
+		{fmt.Errorf("%w: writing file: %w", ErrFileSystem, syscall.ENOSPC), CategoryDiskFull},
+		{fmt.Errorf("%w: rename failed", ErrFileSystem), CategoryFileSystem},
+		{fmt.Errorf("download: %w", context.Canceled), CategoryCanceled},
+		{fmt.Errorf("host paused: %w", api.ErrCircuitOpen), CategoryCircuitOpen},
+		{errors.New("something else"), CategoryUnknown},
+	}
+	for _, tt := range tests {
+		if got := ErrorCategory(tt.err); got != tt.want {
+			t.Errorf("ErrorCategory(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
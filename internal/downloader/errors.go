@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"go-civitai-download/internal/api"
+)
+
+// HttpStatusError is returned when the server answers a download with an unexpected
+// status code. It matches ErrHttpStatus with errors.Is.
+type HttpStatusError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *HttpStatusError) Error() string {
+	return fmt.Sprintf("%v: received status %d from %s", ErrHttpStatus, e.StatusCode, e.URL)
+}
+
+func (e *HttpStatusError) Unwrap() error {
+	return ErrHttpStatus
+}
+
+// Error categories stored in DatabaseEntry.ErrorCategory.
+const (
+	CategoryHashMismatch = "hash_mismatch"
+	CategoryAuth         = "auth"         // 401/403, usually an expired download URL or missing API key
+	CategoryNotFound     = "not_found"    // 404/410, the file was removed upstream
+	CategoryRateLimited  = "rate_limited" // 429
+	CategoryServerError  = "server_error" // 5xx
+	CategoryHttpStatus   = "http_status"  // Any other unexpected status
+	CategoryCircuitOpen  = "circuit_open"
+	CategoryNetwork      = "network"
+	CategoryDiskFull     = "disk_full"
+	CategoryFileSystem   = "filesystem"
+	CategoryCanceled     = "canceled"
+	CategoryUnknown      = "unknown"
+)
+
+// ErrorCategory maps an error returned by DownloadFile to one of the Category
+// constants, or "" for a nil error.
+func ErrorCategory(err error) string {
+	if err == nil {
+		return ""
+	}
+	var statusErr *HttpStatusError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return CategoryCanceled
+	case errors.Is(err, ErrHashMismatch):
+		return CategoryHashMismatch
+	case errors.As(err, &statusErr):
+		return statusCategory(statusErr.StatusCode)
+	case errors.Is(err, ErrHttpStatus):
+		return CategoryHttpStatus
+	case errors.Is(err, api.ErrCircuitOpen):
+		return CategoryCircuitOpen
+	case errors.Is(err, syscall.ENOSPC):
+		return CategoryDiskFull
+	// Checked before ErrFileSystem: a body read failing mid-copy is reported as a write error
+	case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, ErrHttpRequest):
+		return CategoryNetwork
+	case errors.Is(err, ErrFileSystem):
+		return CategoryFileSystem
+	default:
+		return CategoryUnknown
+	}
+}
+
+// statusCategory maps an HTTP status code to an error category.
+func statusCategory(code int) string {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return CategoryAuth
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return CategoryNotFound
+	case code == http.StatusTooManyRequests:
+		return CategoryRateLimited
+	case code >= 500:
+		return CategoryServerError
+	default:
+		return CategoryHttpStatus
+	}
+}
+
+// IsTransientCategory reports whether failures in category are likely to go away by
+// simply retrying later, as opposed to systematic ones that need attention (expired
+// URLs, removed files, hash mismatches, a full disk).
+func IsTransientCategory(category string) bool {
+	switch category {
+	case CategoryRateLimited, CategoryServerError, CategoryCircuitOpen, CategoryNetwork, CategoryCanceled:
+		return true
+	}
+	return false
+}
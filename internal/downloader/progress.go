@@ -0,0 +1,202 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// progressSuffix is appended to the temp file path to name its progress sidecar.
+const progressSuffix = ".progress"
+
+// progressPrefixLen is the number of leading bytes hashed to validate a partial
+// file before resuming it.
+const progressPrefixLen = 1024 * 1024
+
+// progressFlushInterval controls how often (in bytes written) the sidecar is updated.
+const progressFlushInterval = 8 * 1024 * 1024
+
+// downloadProgress is the content of a .progress sidecar file. It records how much
+// of a temp file is known to be written for a given URL, so a later run can
+// continue from there instead of starting over.
+type downloadProgress struct {
+	URL        string    `json:"url"`
+	Offset     int64     `json:"offset"`
+	PrefixLen  int64     `json:"prefixLen"`
+	PrefixHash string    `json:"prefixSha256"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// progressPath returns the sidecar path for a temp file.
+func progressPath(tempPath string) string {
+	return tempPath + progressSuffix
+}
+
+// readProgress loads the sidecar for tempPath. Returns nil if no sidecar exists.
+func readProgress(tempPath string) (*downloadProgress, error) {
+	data, err := os.ReadFile(progressPath(tempPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading progress file for %s: %w", tempPath, err)
+	}
+	var p downloadProgress
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parsing progress file for %s: %w", tempPath, err)
+	}
+	return &p, nil
+}
+
+// writeProgress saves the sidecar for tempPath, replacing any previous one.
+func writeProgress(tempPath string, p *downloadProgress) error {
+	p.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshalling progress for %s: %w", tempPath, err)
+	}
+	// Write via a temp name and rename so a crash never leaves a half-written sidecar.
+	sidecar := progressPath(tempPath)
+	if err := os.WriteFile(sidecar+".new", data, 0600); err != nil {
+		return fmt.Errorf("writing progress file %s: %w", sidecar, err)
+	}
+	if err := os.Rename(sidecar+".new", sidecar); err != nil {
+		return fmt.Errorf("renaming progress file %s: %w", sidecar, err)
+	}
+	return nil
+}
+
+// removeProgress deletes the sidecar for tempPath, ignoring a missing file.
+func removeProgress(tempPath string) {
+	if err := os.Remove(progressPath(tempPath)); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Warnf("Failed to remove progress file for %s", tempPath)
+	}
+}
+
+// hashFilePrefix returns the hex SHA256 of the first n bytes of the file.
+func hashFilePrefix(path string, n int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	copied, err := io.CopyN(h, f, n)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if copied != n {
+		return "", fmt.Errorf("file %s is shorter than expected prefix (%d < %d bytes)", path, copied, n)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// resumableOffset checks the sidecar and partial temp file for url and returns the
+// byte offset the download can continue from, along with the validated sidecar.
+// Any mismatch (different URL, short file, prefix hash mismatch) yields 0 so the
+// caller starts from scratch.
+func resumableOffset(tempPath string, url string) (int64, *downloadProgress) {
+	p, err := readProgress(tempPath)
+	if err != nil {
+		log.WithError(err).Warnf("Ignoring unreadable progress file for %s", tempPath)
+		return 0, nil
+	}
+	if p == nil || p.Offset <= 0 {
+		return 0, nil
+	}
+	if p.URL != url {
+		log.Debugf("Progress file for %s is for a different URL, not resuming.", tempPath)
+		return 0, nil
+	}
+	info, err := os.Stat(tempPath)
+	if err != nil {
+		log.Debugf("Progress file exists but partial %s is missing, not resuming.", tempPath)
+		return 0, nil
+	}
+	if info.Size() < p.Offset {
+		log.Warnf("Partial %s is smaller (%d bytes) than recorded progress (%d bytes), not resuming.", tempPath, info.Size(), p.Offset)
+		return 0, nil
+	}
+	prefixHash, err := hashFilePrefix(tempPath, p.PrefixLen)
+	if err != nil || prefixHash != p.PrefixHash {
+		log.WithError(err).Warnf("Partial %s failed prefix validation, not resuming.", tempPath)
+		return 0, nil
+	}
+	return p.Offset, p
+}
+
+// progressWriter wraps the temp file and periodically records how many bytes
+// have been written, along with the prefix hash once it is available.
+type progressWriter struct {
+	file        *os.File
+	progress    *downloadProgress
+	prefix      hash.Hash // Hash of the first progressPrefixLen bytes (nil once complete)
+	lastFlushed int64
+}
+
+// newProgressWriter creates a progressWriter for a download starting at offset.
+// When resuming, the prefix hash is taken from the validated existing sidecar.
+func newProgressWriter(file *os.File, url string, offset int64, existing *downloadProgress) *progressWriter {
+	pw := &progressWriter{
+		file:        file,
+		progress:    &downloadProgress{URL: url, Offset: offset},
+		lastFlushed: offset,
+	}
+	if existing != nil && offset > 0 {
+		pw.progress.PrefixLen = existing.PrefixLen
+		pw.progress.PrefixHash = existing.PrefixHash
+	}
+	if pw.progress.PrefixLen < progressPrefixLen && offset == 0 {
+		pw.prefix = sha256.New()
+	}
+	return pw
+}
+
+// Write implements io.Writer.
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.file.Write(b)
+	if n > 0 {
+		if pw.prefix != nil {
+			remaining := progressPrefixLen - pw.progress.Offset
+			if remaining > int64(n) {
+				remaining = int64(n)
+			}
+			if remaining > 0 {
+				_, _ = pw.prefix.Write(b[:remaining])
+				pw.progress.PrefixLen = pw.progress.Offset + remaining
+				pw.progress.PrefixHash = hex.EncodeToString(pw.prefix.Sum(nil))
+			}
+			if pw.progress.PrefixLen >= progressPrefixLen {
+				pw.prefix = nil // Prefix complete, stop hashing
+			}
+		}
+		pw.progress.Offset += int64(n)
+		if pw.progress.Offset-pw.lastFlushed >= progressFlushInterval {
+			pw.flush()
+		}
+	}
+	return n, err
+}
+
+// flush syncs the temp file and records the current offset in the sidecar.
+func (pw *progressWriter) flush() {
+	// Sync first so the sidecar never claims more bytes than are on disk.
+	if err := pw.file.Sync(); err != nil {
+		log.WithError(err).Debugf("Failed to sync %s before recording progress", pw.file.Name())
+		return
+	}
+	if err := writeProgress(pw.file.Name(), pw.progress); err != nil {
+		log.WithError(err).Debugf("Failed to record download progress for %s", pw.file.Name())
+		return
+	}
+	pw.lastFlushed = pw.progress.Offset
+}
@@ -0,0 +1,115 @@
+package helpers
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// blurhashChars is the base83 alphabet used by the blurhash format.
+const blurhashChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// decodeBase83 decodes a base83 string as used by blurhash.
+func decodeBase83(s string) (int, error) {
+	value := 0
+	for _, c := range s {
+		digit := strings.IndexRune(blurhashChars, c)
+		if digit < 0 {
+			return 0, fmt.Errorf("invalid blurhash character %q", c)
+		}
+		value = value*83 + digit
+	}
+	return value, nil
+}
+
+// DecodeBlurhash renders a blurhash (https://blurha.sh) as a width x height image.
+// Useful for tiny gallery placeholders; the result is intentionally blurry.
+func DecodeBlurhash(hash string, width, height int) (*image.NRGBA, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid blurhash image size %dx%d", width, height)
+	}
+	if len(hash) < 6 {
+		return nil, fmt.Errorf("blurhash %q is too short", hash)
+	}
+
+	sizeFlag, err := decodeBase83(hash[0:1])
+	if err != nil {
+		return nil, err
+	}
+	numX := sizeFlag%9 + 1
+	numY := sizeFlag/9 + 1
+	if expected := 4 + 2*numX*numY; len(hash) != expected {
+		return nil, fmt.Errorf("blurhash %q has length %d, expected %d", hash, len(hash), expected)
+	}
+
+	quantisedMax, err := decodeBase83(hash[1:2])
+	if err != nil {
+		return nil, err
+	}
+	maxValue := float64(quantisedMax+1) / 166
+
+	colors := make([][3]float64, numX*numY)
+	for i := range colors {
+		if i == 0 {
+			value, err := decodeBase83(hash[2:6])
+			if err != nil {
+				return nil, err
+			}
+			colors[i] = [3]float64{
+				srgbToLinear(value >> 16),
+				srgbToLinear((value >> 8) & 255),
+				srgbToLinear(value & 255),
+			}
+			continue
+		}
+		value, err := decodeBase83(hash[4+i*2 : 6+i*2])
+		if err != nil {
+			return nil, err
+		}
+		colors[i] = [3]float64{
+			signPow((float64(value/(19*19))-9)/9, 2) * maxValue,
+			signPow((float64((value/19)%19)-9)/9, 2) * maxValue,
+			signPow((float64(value%19)-9)/9, 2) * maxValue,
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var r, g, b float64
+			for j := 0; j < numY; j++ {
+				for i := 0; i < numX; i++ {
+					basis := math.Cos(math.Pi*float64(x*i)/float64(width)) * math.Cos(math.Pi*float64(y*j)/float64(height))
+					c := colors[i+j*numX]
+					r += c[0] * basis
+					g += c[1] * basis
+					b += c[2] * basis
+				}
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: linearToSrgb(r), G: linearToSrgb(g), B: linearToSrgb(b), A: 255})
+		}
+	}
+	return img, nil
+}
+
+func srgbToLinear(value int) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(value float64) uint8 {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return uint8(v*12.92*255 + 0.5)
+	}
+	return uint8((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
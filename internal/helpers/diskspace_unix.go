@@ -0,0 +1,18 @@
+//go:build !windows
+
+package helpers
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// FreeDiskSpace returns the number of bytes available to the current user on the
+// filesystem containing path.
+func FreeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("checking free space for %s: %w", path, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
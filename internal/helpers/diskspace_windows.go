@@ -0,0 +1,23 @@
+//go:build windows
+
+package helpers
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// FreeDiskSpace returns the number of bytes available to the current user on the
+// volume containing path.
+func FreeDiskSpace(path string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, fmt.Errorf("checking free space for %s: %w", path, err)
+	}
+	var freeBytesAvailable uint64
+	if err := windows.GetDiskFreeSpaceEx(pathPtr, &freeBytesAvailable, nil, nil); err != nil {
+		return 0, fmt.Errorf("checking free space for %s: %w", path, err)
+	}
+	return freeBytesAvailable, nil
+}
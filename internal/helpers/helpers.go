@@ -2,6 +2,7 @@ package helpers
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"hash"
@@ -11,7 +12,9 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"go-civitai-download/internal/models" // Import the models package
 
@@ -100,6 +103,8 @@ func CheckHash(filePath string, hashes models.Hashes) bool {
 type CounterWriter struct {
 	Total  uint64
 	Writer io.Writer
+	// OnWrite, if set, is called with the new Total after each successful write
+	OnWrite func(total uint64)
 }
 
 // Write implements the io.Writer interface for CounterWriter.
@@ -108,6 +113,9 @@ func (cw *CounterWriter) Write(p []byte) (n int, err error) {
 	// Only add to total if write was successful and n is positive
 	if err == nil && n > 0 {
 		cw.Total += uint64(n)
+		if cw.OnWrite != nil {
+			cw.OnWrite(cw.Total)
+		}
 	}
 	// Progress reporting might be handled differently in CLI context
 	// fmt.Printf("\rDownloaded %s", BytesToSize(cw.Total))
@@ -232,8 +240,154 @@ func CorrectPathBasedOnImageType(tempFilePath, finalFilePath string) (string, er
 	return correctedFinalPath, nil
 }
 
+// DetectModelFormatExtension sniffs the header of a model file and returns the
+// extension matching its real format: ".safetensors", ".gguf" or ".ckpt" (pickle or
+// PyTorch zip archive). Returns "" if the format is not recognised.
+func DetectModelFormatExtension(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("opening file %s for format detection: %w", filePath, err)
+	}
+	defer f.Close()
+
+	header := make([]byte, 9)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("reading header of %s: %w", filePath, err)
+	}
+	header = header[:n]
+
+	switch {
+	case n >= 4 && string(header[:4]) == "GGUF":
+		return ".gguf", nil
+	case n >= 4 && string(header[:4]) == "PK\x03\x04":
+		return ".ckpt", nil // torch.save zip archive
+	case n >= 2 && header[0] == 0x80 && header[1] >= 2 && header[1] <= 5:
+		return ".ckpt", nil // Legacy pickle (protocol 2-5)
+	case n == 9 && header[8] == '{':
+		// Safetensors: little-endian uint64 JSON header length followed by the JSON header
+		headerLen := binary.LittleEndian.Uint64(header[:8])
+		if headerLen >= 2 && headerLen <= 100*1024*1024 {
+			return ".safetensors", nil
+		}
+	}
+	return "", nil
+}
+
+// CorrectPathBasedOnModelFormat checks the real format of a downloaded model file and
+// returns the path it should have. Only safetensors/pickle/gguf mismatches are
+// corrected; pickle files already named .pt, .pth, .bin or .ckpt are left alone.
+func CorrectPathBasedOnModelFormat(filePath string) (string, error) {
+	detectedExt, err := DetectModelFormatExtension(filePath)
+	if err != nil {
+		return filePath, err
+	}
+	if detectedExt == "" {
+		log.Debugf("Could not detect model format of %s, keeping extension.", filePath)
+		return filePath, nil
+	}
+
+	originalExt := filepath.Ext(filePath)
+	lowerExt := strings.ToLower(originalExt)
+	if lowerExt == detectedExt {
+		return filePath, nil
+	}
+	if detectedExt == ".ckpt" {
+		switch lowerExt {
+		case ".pt", ".pth", ".bin":
+			return filePath, nil // Valid pickle extensions, loaders handle these
+		}
+	}
+
+	correctedPath := strings.TrimSuffix(filePath, originalExt) + detectedExt
+	log.Warnf("Extension '%s' of %s does not match its detected format '%s'. Correcting to: %s", originalExt, filePath, detectedExt, correctedPath)
+	return correctedPath, nil
+}
+
+// ParseAge parses an age such as "3d", "2w" or any time.ParseDuration value ("36h").
+// Days and weeks are whole numbers of 24h days. An empty string is a zero age.
+func ParseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q: expected e.g. 3d, 2w or 36h", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q: expected e.g. 3d, 2w or 36h", s)
+	}
+	return d, nil
+}
+
+// ParseByteSize parses a size such as "512k", "10M", "1.5GB" or a plain byte count.
+// Suffixes are binary units, case-insensitive and may end in "B" ("500MB", "2GiB").
+// An empty string is zero.
+func ParseByteSize(s string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	if value == "" {
+		return 0, nil
+	}
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "b"), "i")
+	multiplier := float64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		case 't':
+			multiplier = 1 << 40
+		}
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid size %q: expected e.g. 512k, 500MB, 2GB or a number of bytes", s)
+	}
+	if n*multiplier >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return int64(n * multiplier), nil
+}
+
 // TODO: Move loadConfig function to internal/config/config.go
 
+// FileHash computes the "sha256" or "blake3" hash of a file, as uppercase hex like
+// the hashes returned by the Civitai API.
+func FileHash(filePath string, algo string) (string, error) {
+	var hasher hash.Hash
+	switch strings.ToLower(algo) {
+	case "sha256":
+		hasher = sha256.New()
+	case "blake3":
+		hasher = blake3.New()
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm %q", algo)
+	}
+	sum, err := calculateHash(filePath, hasher)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(sum), nil
+}
+
 // -- Hashing Helper --
 func calculateHash(filePath string, hashAlgo hash.Hash) (string, error) {
 	file, err := os.Open(filePath)
@@ -1,10 +1,13 @@
 package helpers
 
 import (
+	"encoding/binary"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"go-civitai-download/internal/models" // For models.Hashes
 )
@@ -235,3 +238,185 @@ func TestCheckAndMakeDir(t *testing.T) {
 }
 
 // TODO: Add tests for CheckAndMakeDir (might need filesystem mocking or cleanup)
+
+func TestCorrectPathBasedOnModelFormat(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Minimal safetensors header: 8-byte length followed by the JSON header
+	safetensorsContent := append([]byte{2, 0, 0, 0, 0, 0, 0, 0}, []byte("{}")...)
+	pickleContent := []byte{0x80, 0x02, '}', 'q', 0x00}
+	zipContent := []byte("PK\x03\x04rest-of-archive")
+
+	tests := []struct {
+		name     string
+		fileName string
+		content  []byte
+		wantName string
+	}{
+		{"Safetensors labelled ckpt", "model.ckpt", safetensorsContent, "model.safetensors"},
+		{"Safetensors correctly labelled", "model.safetensors", safetensorsContent, "model.safetensors"},
+		{"Pickle labelled safetensors", "model.safetensors", pickleContent, "model.ckpt"},
+		{"Zip archive labelled safetensors", "model.safetensors", zipContent, "model.ckpt"},
+		{"Pickle with pt extension", "model.pt", pickleContent, "model.pt"},
+		{"GGUF labelled safetensors", "model.safetensors", []byte("GGUF\x03\x00\x00\x00"), "model.gguf"},
+		{"Unknown content", "model.safetensors", []byte("not a model"), "model.safetensors"},
+		{"Short file", "model.ckpt", []byte{0x01}, "model.ckpt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(tempDir, strings.ReplaceAll(tt.name, " ", "_")+"_"+tt.fileName)
+			if err := os.WriteFile(filePath, tt.content, 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+			got, err := CorrectPathBasedOnModelFormat(filePath)
+			if err != nil {
+				t.Fatalf("CorrectPathBasedOnModelFormat() error = %v", err)
+			}
+			wantPath := filepath.Join(tempDir, strings.ReplaceAll(tt.name, " ", "_")+"_"+tt.wantName)
+			if got != wantPath {
+				t.Errorf("CorrectPathBasedOnModelFormat() = %q, want %q", got, wantPath)
+			}
+		})
+	}
+}
+
+func TestDecodeBlurhash(t *testing.T) {
+	img, err := DecodeBlurhash("LEHV6nWB2yk8pyo0adR*.7kCMdnj", 32, 24)
+	if err != nil {
+		t.Fatalf("DecodeBlurhash() returned error: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 32 || b.Dy() != 24 {
+		t.Errorf("DecodeBlurhash() image size = %dx%d, want 32x24", b.Dx(), b.Dy())
+	}
+	if a := img.NRGBAAt(0, 0).A; a != 255 {
+		t.Errorf("DecodeBlurhash() pixel alpha = %d, want 255", a)
+	}
+
+	for _, hash := range []string{"", "LEHV6nWB2yk8pyo0adR*.7kCMdn", "LEHV6nWB2yk8pyo0adR*.7kCMdn\""} {
+		if _, err := DecodeBlurhash(hash, 32, 32); err == nil {
+			t.Errorf("DecodeBlurhash(%q) expected an error, got nil", hash)
+		}
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"3d", 72 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"36h", 36 * time.Hour, false},
+		{"d", 0, true},
+		{"-1d", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAge(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseAge(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAge(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"512k", 512 << 10, false},
+		{"1.5GB", 3 << 29, false},
+		{"2048", 2048, false},
+		{"-1MB", 0, true},
+		{"NaN", 0, true},
+		{"infGB", 0, true},
+		{"1e30", 0, true},
+		{"lots", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseByteSize(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseByteSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseByteSize(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestConvertSafetensorsToFP16(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// One F32 tensor [1, -2.5, 65504, 1e-8] followed by one I64 tensor [7]
+	header := `{"__metadata__":{"format":"pt"},"w":{"dtype":"F32","shape":[4],"data_offsets":[0,16]},"n":{"dtype":"I64","shape":[1],"data_offsets":[16,24]}}`
+	content := binary.LittleEndian.AppendUint64(nil, uint64(len(header)))
+	content = append(content, header...)
+	for _, v := range []float32{1, -2.5, 65504, 1e-8} {
+		content = binary.LittleEndian.AppendUint32(content, math.Float32bits(v))
+	}
+	content = binary.LittleEndian.AppendUint64(content, 7)
+	src := filepath.Join(tempDir, "model.safetensors")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := ValidateSafetensors(src); err != nil {
+		t.Fatalf("ValidateSafetensors(src) error = %v", err)
+	}
+
+	dst := filepath.Join(tempDir, "model.fp16.safetensors")
+	converted, hashes, err := ConvertSafetensorsToFP16(src, dst)
+	if err != nil {
+		t.Fatalf("ConvertSafetensorsToFP16() error = %v", err)
+	}
+	if converted != 1 {
+		t.Errorf("ConvertSafetensorsToFP16() converted %d tensors, want 1", converted)
+	}
+	if err := ValidateSafetensors(dst); err != nil {
+		t.Fatalf("ValidateSafetensors(dst) error = %v", err)
+	}
+	if !CheckHash(dst, models.Hashes{SHA256: hashes.SHA256}) || !CheckHash(dst, models.Hashes{BLAKE3: hashes.BLAKE3}) {
+		t.Errorf("ConvertSafetensorsToFP16() returned hashes that do not match the written file")
+	}
+
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("Failed to read converted file: %v", err)
+	}
+	dataStart := 8 + int(binary.LittleEndian.Uint64(data))
+	if dataStart%8 != 0 {
+		t.Errorf("Data section starts at %d, want 8-byte alignment", dataStart)
+	}
+	var halves []uint16
+	for i := 0; i < 4; i++ {
+		halves = append(halves, binary.LittleEndian.Uint16(data[dataStart+2*i:]))
+	}
+	// 1e-8 is below half the smallest fp16 subnormal and rounds to zero
+	want := []uint16{0x3c00, 0xc100, 0x7bff, 0x0000}
+	for i := range want {
+		if halves[i] != want[i] {
+			t.Errorf("Converted value %d = %#04x, want %#04x", i, halves[i], want[i])
+		}
+	}
+	if n := binary.LittleEndian.Uint64(data[dataStart+8:]); n != 7 {
+		t.Errorf("Copied I64 tensor = %d, want 7", n)
+	}
+
+	// Converting again finds nothing to do
+	again := filepath.Join(tempDir, "model.again.safetensors")
+	if converted, _, err := ConvertSafetensorsToFP16(dst, again); err != nil || converted != 0 {
+		t.Errorf("ConvertSafetensorsToFP16() on fp16 file = %d, %v; want 0, nil", converted, err)
+	}
+	if _, err := os.Stat(again); !os.IsNotExist(err) {
+		t.Errorf("ConvertSafetensorsToFP16() wrote %s although nothing was converted", again)
+	}
+}
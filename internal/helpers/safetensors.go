@@ -0,0 +1,291 @@
+package helpers
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"go-civitai-download/internal/models"
+
+	"github.com/zeebo/blake3"
+)
+
+// maxSafetensorsHeader caps the JSON header size we are willing to parse.
+const maxSafetensorsHeader = 100 * 1024 * 1024
+
+// safetensorsDtypeSizes holds the element size in bytes of each safetensors dtype.
+var safetensorsDtypeSizes = map[string]int64{
+	"BOOL": 1, "U8": 1, "I8": 1, "F8_E4M3": 1, "F8_E5M2": 1,
+	"U16": 2, "I16": 2, "F16": 2, "BF16": 2,
+	"U32": 4, "I32": 4, "F32": 4,
+	"U64": 8, "I64": 8, "F64": 8,
+}
+
+// safetensorsTensor is one tensor entry of a safetensors header.
+type safetensorsTensor struct {
+	Dtype       string   `json:"dtype"`
+	Shape       []int64  `json:"shape"`
+	DataOffsets [2]int64 `json:"data_offsets"`
+}
+
+// safetensorsHeader is a parsed safetensors header. Metadata is the raw
+// "__metadata__" entry, kept as-is.
+type safetensorsHeader struct {
+	Tensors   map[string]safetensorsTensor
+	Metadata  json.RawMessage
+	DataStart int64 // Offset of the data section in the file
+}
+
+// readSafetensorsHeader parses the header of the safetensors file f.
+func readSafetensorsHeader(f *os.File) (*safetensorsHeader, error) {
+	var lenBuf [8]byte
+	if _, err := io.ReadFull(f, lenBuf[:]); err != nil {
+		return nil, fmt.Errorf("reading header length: %w", err)
+	}
+	headerLen := binary.LittleEndian.Uint64(lenBuf[:])
+	if headerLen < 2 || headerLen > maxSafetensorsHeader {
+		return nil, fmt.Errorf("invalid header length %d", headerLen)
+	}
+	headerBytes := make([]byte, headerLen)
+	if _, err := io.ReadFull(f, headerBytes); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(headerBytes, &raw); err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+	header := &safetensorsHeader{
+		Tensors:   make(map[string]safetensorsTensor, len(raw)),
+		DataStart: 8 + int64(headerLen),
+	}
+	for name, value := range raw {
+		if name == "__metadata__" {
+			header.Metadata = value
+			continue
+		}
+		var tensor safetensorsTensor
+		if err := json.Unmarshal(value, &tensor); err != nil {
+			return nil, fmt.Errorf("decoding tensor %s: %w", name, err)
+		}
+		header.Tensors[name] = tensor
+	}
+	return header, nil
+}
+
+// sortedTensorNames returns the tensor names ordered by their position in the data section.
+func (h *safetensorsHeader) sortedTensorNames() []string {
+	names := make([]string, 0, len(h.Tensors))
+	for name := range h.Tensors {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return h.Tensors[names[i]].DataOffsets[0] < h.Tensors[names[j]].DataOffsets[0]
+	})
+	return names
+}
+
+// ValidateSafetensors checks that filePath is a well-formed safetensors file: the
+// header parses, every tensor's byte range matches its dtype and shape, and the data
+// section holds exactly the tensors without gaps or overlaps.
+func ValidateSafetensors(filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", filePath, err)
+	}
+	defer f.Close()
+
+	header, err := readSafetensorsHeader(f)
+	if err != nil {
+		return fmt.Errorf("invalid safetensors file %s: %w", filePath, err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stating %s: %w", filePath, err)
+	}
+
+	var expectedOffset int64
+	for _, name := range header.sortedTensorNames() {
+		tensor := header.Tensors[name]
+		elemSize, ok := safetensorsDtypeSizes[tensor.Dtype]
+		if !ok {
+			return fmt.Errorf("invalid safetensors file %s: tensor %s has unknown dtype %q", filePath, name, tensor.Dtype)
+		}
+		elements := int64(1)
+		for _, dim := range tensor.Shape {
+			elements *= dim
+		}
+		if tensor.DataOffsets[0] != expectedOffset || tensor.DataOffsets[1]-tensor.DataOffsets[0] != elements*elemSize {
+			return fmt.Errorf("invalid safetensors file %s: tensor %s has inconsistent data offsets %v", filePath, name, tensor.DataOffsets)
+		}
+		expectedOffset = tensor.DataOffsets[1]
+	}
+	if header.DataStart+expectedOffset != stat.Size() {
+		return fmt.Errorf("invalid safetensors file %s: data section is %d bytes, tensors need %d", filePath, stat.Size()-header.DataStart, expectedOffset)
+	}
+	return nil
+}
+
+// ConvertSafetensorsToFP16 writes a copy of the safetensors file src to dst with all
+// F32 tensors converted to F16 (round to nearest even); other tensors and the
+// metadata are copied unchanged. It returns the number of converted tensors and the
+// hashes of dst. If src has no F32 tensors nothing is written and 0 is returned.
+func ConvertSafetensorsToFP16(src, dst string) (int, models.Hashes, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, models.Hashes{}, fmt.Errorf("opening %s: %w", src, err)
+	}
+	defer in.Close()
+
+	header, err := readSafetensorsHeader(in)
+	if err != nil {
+		return 0, models.Hashes{}, fmt.Errorf("invalid safetensors file %s: %w", src, err)
+	}
+
+	// Lay out the new data section
+	names := header.sortedTensorNames()
+	outHeader := make(map[string]interface{}, len(names)+1)
+	if len(header.Metadata) > 0 {
+		outHeader["__metadata__"] = header.Metadata
+	}
+	converted := 0
+	var offset int64
+	for _, name := range names {
+		tensor := header.Tensors[name]
+		size := tensor.DataOffsets[1] - tensor.DataOffsets[0]
+		if tensor.Dtype == "F32" {
+			tensor.Dtype = "F16"
+			size /= 2
+			converted++
+		}
+		tensor.DataOffsets = [2]int64{offset, offset + size}
+		offset += size
+		outHeader[name] = tensor
+	}
+	if converted == 0 {
+		return 0, models.Hashes{}, nil
+	}
+
+	headerBytes, err := json.Marshal(outHeader)
+	if err != nil {
+		return 0, models.Hashes{}, fmt.Errorf("encoding header: %w", err)
+	}
+	// Pad with spaces so the data section stays 8-byte aligned
+	if pad := (8 - len(headerBytes)%8) % 8; pad > 0 {
+		headerBytes = append(headerBytes, []byte(strings.Repeat(" ", pad))...)
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, models.Hashes{}, fmt.Errorf("creating %s: %w", dst, err)
+	}
+	defer out.Close()
+
+	sha := sha256.New()
+	b3 := blake3.New()
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	w := bufio.NewWriterSize(io.MultiWriter(out, sha, b3, crc), 1024*1024)
+
+	var lenBuf [8]byte
+	binary.LittleEndian.PutUint64(lenBuf[:], uint64(len(headerBytes)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return 0, models.Hashes{}, fmt.Errorf("writing %s: %w", dst, err)
+	}
+	if _, err := w.Write(headerBytes); err != nil {
+		return 0, models.Hashes{}, fmt.Errorf("writing %s: %w", dst, err)
+	}
+
+	buf := make([]byte, 1024*1024) // Multiple of 4, so F32 values never straddle reads
+	for _, name := range names {
+		tensor := header.Tensors[name]
+		section := io.NewSectionReader(in, header.DataStart+tensor.DataOffsets[0], tensor.DataOffsets[1]-tensor.DataOffsets[0])
+		if tensor.Dtype != "F32" {
+			if _, err := io.CopyBuffer(w, section, buf); err != nil {
+				return 0, models.Hashes{}, fmt.Errorf("copying tensor %s: %w", name, err)
+			}
+			continue
+		}
+		for {
+			n, readErr := io.ReadFull(section, buf)
+			if n%4 != 0 {
+				return 0, models.Hashes{}, fmt.Errorf("tensor %s is truncated", name)
+			}
+			half := make([]byte, n/2)
+			for i := 0; i < n; i += 4 {
+				binary.LittleEndian.PutUint16(half[i/2:], float32ToFloat16(binary.LittleEndian.Uint32(buf[i:])))
+			}
+			if _, err := w.Write(half); err != nil {
+				return 0, models.Hashes{}, fmt.Errorf("writing %s: %w", dst, err)
+			}
+			if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+				break
+			}
+			if readErr != nil {
+				return 0, models.Hashes{}, fmt.Errorf("reading tensor %s: %w", name, readErr)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return 0, models.Hashes{}, fmt.Errorf("writing %s: %w", dst, err)
+	}
+	if err := out.Close(); err != nil {
+		return 0, models.Hashes{}, fmt.Errorf("closing %s: %w", dst, err)
+	}
+
+	sha256Hex := strings.ToUpper(hex.EncodeToString(sha.Sum(nil)))
+	hashes := models.Hashes{
+		AutoV2: sha256Hex[:10],
+		SHA256: sha256Hex,
+		CRC32:  strings.ToUpper(hex.EncodeToString(crc.Sum(nil))),
+		BLAKE3: strings.ToUpper(hex.EncodeToString(b3.Sum(nil))),
+	}
+	return converted, hashes, nil
+}
+
+// float32ToFloat16 converts the bits of an IEEE 754 single to a half, rounding to
+// nearest even. Values too large for a half become infinity.
+func float32ToFloat16(f uint32) uint16 {
+	sign := uint16(f>>16) & 0x8000
+	exp := int32(f>>23) & 0xff
+	mant := f & 0x7fffff
+
+	if exp == 0xff { // Inf or NaN
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	}
+	e := exp - 127 + 15
+	if e >= 0x1f {
+		return sign | 0x7c00
+	}
+	if e <= 0 {
+		// Subnormal half (or zero)
+		if e < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint32(14 - e)
+		half := mant >> shift
+		rem := mant & (1<<shift - 1)
+		halfway := uint32(1) << (shift - 1)
+		if rem > halfway || (rem == halfway && half&1 == 1) {
+			half++
+		}
+		return sign | uint16(half)
+	}
+	half := uint32(e)<<10 | mant>>13
+	rem := mant & 0x1fff
+	if rem > 0x1000 || (rem == 0x1000 && half&1 == 1) {
+		half++ // A carry into the exponent correctly rounds up to the next power (or Inf)
+	}
+	return sign | uint16(half)
+}
@@ -1,8 +1,8 @@
 package models
 
 import (
-	"net/url"
-	"strconv"
+	"encoding/json"
+	"time"
 )
 
 type (
@@ -14,48 +14,124 @@ type (
 		SavePath       string `toml:"SavePath"`
 		DatabasePath   string `toml:"DatabasePath"`
 		BleveIndexPath string `toml:"BleveIndexPath"` // New field for Bleve index path
+		NoIndex        bool   `toml:"NoIndex"`        // Skip opening and updating the Bleve index
 
 		// Filtering - Model/Version Level
 		Query               string   `toml:"Query"`
 		Tag                 string   `toml:"Tag"`
+		TagFilterMode       string   `toml:"TagFilterMode"` // "any"/"all": one query per comma-separated tag, merged locally
 		Username            string   `toml:"Username"`
 		ModelTypes          []string `toml:"ModelTypes"` // Renamed from Types
 		BaseModels          []string `toml:"BaseModels"`
 		IgnoreBaseModels    []string `toml:"IgnoreBaseModels"`
+		ExcludeTags         []string `toml:"ExcludeTags"`         // Skip models carrying any of these tags (case-insensitive)
 		Nsfw                bool     `toml:"Nsfw"`                // Renamed from GetNsfw
 		ModelVersionID      int      `toml:"ModelVersionID"`      // New
 		DownloadAllVersions bool     `toml:"DownloadAllVersions"` // New
 
 		// Filtering - File Level
-		PrimaryOnly           bool     `toml:"PrimaryOnly"` // Renamed from GetOnlyPrimaryModel
-		Pruned                bool     `toml:"Pruned"`      // Renamed from GetPruned
-		Fp16                  bool     `toml:"Fp16"`        // Renamed from GetFp16
+		PrimaryOnly           bool     `toml:"PrimaryOnly"`     // Renamed from GetOnlyPrimaryModel
+		FileSelect            string   `toml:"FileSelect"`      // all, smallest or largest file per version
+		Pruned                bool     `toml:"Pruned"`          // Renamed from GetPruned
+		Fp16                  bool     `toml:"Fp16"`            // Renamed from GetFp16
+		MinSizeBytes          string   `toml:"MinSizeBytes"`    // Skip smaller files, e.g. "500MB" ("" = no limit)
+		MaxSizeBytes          string   `toml:"MaxSizeBytes"`    // Skip larger files, e.g. "2GB" ("" = no limit)
+		RequireSafeScan       bool     `toml:"RequireSafeScan"` // Skip files whose virus or pickle scan is not Success
 		IgnoreFileNameStrings []string `toml:"IgnoreFileNameStrings"`
+		Formats               []string `toml:"Formats"` // Accepted file formats (e.g. SafeTensor, PickleTensor), empty = all
 
 		// API Query Behavior
-		Sort     string `toml:"Sort"`
-		Period   string `toml:"Period"`
-		Limit    int    `toml:"Limit"`
-		MaxPages int    `toml:"MaxPages"` // New
+		Sort               string `toml:"Sort"`
+		Period             string `toml:"Period"`
+		AllowCommercialUse string `toml:"AllowCommercialUse"` // Any, None, Image, Rent or Sell
+		Favorites          bool   `toml:"Favorites"`          // Only models favorited by the API key's user
+		Hidden             bool   `toml:"Hidden"`             // Only models hidden by the API key's user
+		Rating             int    `toml:"Rating"`             // Minimum rating 1-5 (0 = any)
+		Limit              int    `toml:"Limit"`              // Total models across all pages (0 = no limit)
+		PageSize           int    `toml:"PageSize"`           // Models requested per API page (1-100)
+		MaxPages           int    `toml:"MaxPages"`           // New
+		Sample             int    `toml:"Sample"`             // Process a random sample of N matching models (0 disables)
+		SampleSeed         int64  `toml:"SampleSeed"`         // Seed for Sample (0 picks a new one each run)
 
 		// Downloader Behavior
-		Concurrency         int  `toml:"Concurrency"` // Renamed from DefaultConcurrency
-		SaveMetadata        bool `toml:"SaveMetadata"`
-		DownloadMetaOnly    bool `toml:"DownloadMetaOnly"`  // New
-		SaveModelInfo       bool `toml:"SaveModelInfo"`     // New
-		SaveVersionImages   bool `toml:"SaveVersionImages"` // New
-		SaveModelImages     bool `toml:"SaveModelImages"`   // New
-		SkipConfirmation    bool `toml:"SkipConfirmation"`  // New (for --yes flag)
-		ApiDelayMs          int  `toml:"ApiDelayMs"`
-		ApiClientTimeoutSec int  `toml:"ApiClientTimeoutSec"`
+		Concurrency          int           `toml:"Concurrency"`      // Renamed from DefaultConcurrency
+		ImageConcurrency     int           `toml:"ImageConcurrency"` // Concurrent image downloads (0 = Concurrency)
+		SaveMetadata         bool          `toml:"SaveMetadata"`
+		CombinedMetadata     bool          `toml:"CombinedMetadata"`     // Write model+version info into one sidecar
+		SaveTriggers         bool          `toml:"SaveTriggers"`         // Write trained words to <file>.trigger.txt
+		DownloadMetaOnly     bool          `toml:"DownloadMetaOnly"`     // New
+		SaveModelInfo        bool          `toml:"SaveModelInfo"`        // New
+		SaveVersionList      bool          `toml:"SaveVersionList"`      // Write versions.json listing all versions of a --model-id model
+		SaveVersionImages    bool          `toml:"SaveVersionImages"`    // New
+		SaveModelImages      bool          `toml:"SaveModelImages"`      // New
+		SkipCompleteImages   bool          `toml:"SkipCompleteImages"`   // Skip image directories holding a completion marker
+		NoMetadataForSkipped bool          `toml:"NoMetadataForSkipped"` // Leave sidecars and unchanged DB entries of already downloaded files alone
+		SkipExistingByHash   bool          `toml:"SkipExistingByHash"`   // Link identical files already downloaded elsewhere instead of downloading
+		CopyConfigToOutput   bool          `toml:"CopyConfigToOutput"`   // Save run-config.json with the effective settings to SavePath
+		SkipConfirmation     bool          `toml:"SkipConfirmation"`     // New (for --yes flag)
+		ApiDelayMs           int           `toml:"ApiDelayMs"`
+		ApiClientTimeoutSec  int           `toml:"ApiClientTimeoutSec"`
+		ApiBaseUrl           string        `toml:"ApiBaseUrl"`          // Base of API request URLs, e.g. a caching proxy
+		MaxRetries           int           `toml:"MaxRetries"`          // Retries of failed API metadata requests
+		InitialRetryDelayMs  int           `toml:"InitialRetryDelayMs"` // Delay before the first retry, doubled after each
+		WithVae              bool          `toml:"WithVae"`             // Also download each checkpoint's recommended VAE
+		FollowEmbeddings     bool          `toml:"FollowEmbeddings"`    // Also download negative embeddings referenced by LORAs
+		ServerFilename       bool          `toml:"ServerFilename"`      // Keep Civitai's file name as-is instead of the slugified one
+		PathTemplate         string        `toml:"PathTemplate"`        // text/template for each version's directory below SavePath
+		VersionDirStyle      string        `toml:"VersionDirStyle"`     // id-slug, name, date or id; fills {{.VersionDir}}
+		NormalizeExtensions  bool          `toml:"NormalizeExtensions"` // Rename files whose extension does not match their format
+		Convert              string        `toml:"Convert"`             // Convert full-precision safetensors checkpoints after download ("" or "fp16")
+		KeepOriginal         bool          `toml:"KeepOriginal"`        // Keep the unconverted checkpoint next to the converted one
+		HashAlgo             string        `toml:"HashAlgo"`            // Strong hash to compute when the API has none ("", "sha256" or "blake3")
+		HashSidecar          bool          `toml:"HashSidecar"`         // Also write the HashAlgo hash to a <file>.<algo> sidecar
+		RampUp               time.Duration `toml:"RampUp"`              // Interval between starting download workers (0 starts all at once)
+		BreakerThreshold     int           `toml:"BreakerThreshold"`    // Consecutive failures to a host before failing fast (0 disables)
+		BreakerCooldown      time.Duration `toml:"BreakerCooldown"`     // Pause after the breaker opens
+		Deadline             time.Duration `toml:"Deadline"`            // Upper bound for the run time of a command (0 disables)
+		RateLimit            float64       `toml:"RateLimit"`           // Requests per second shared by all clients (0 disables)
+		BandwidthLimit       string        `toml:"BandwidthLimit"`      // Total download speed, e.g. "10M" bytes/s (empty disables)
+		MinFreeSpaceMB       int64         `toml:"MinFreeSpaceMB"`      // Free space (MB) to keep on SavePath; 0 disables the check
+		MaxErrors            int           `toml:"MaxErrors"`           // Failed downloads after which the batch is stopped (0 disables)
+		RetryFailed          int           `toml:"RetryFailed"`         // Retries of the downloads that failed, after the main pass
+		RetryFailedDelay     time.Duration `toml:"RetryFailedDelay"`    // Wait before the first of those retries, doubled for each further one
+		SkipEmptyVersions    bool          `toml:"SkipEmptyVersions"`   // Ignore versions with no files during version selection
+		MinPublishedAge      string        `toml:"MinPublishedAge"`     // Ignore versions published more recently than this (e.g. "3d")
+		Since                string        `toml:"Since"`               // Ignore versions published before this date (e.g. "2024-01-01")
+		CacheDir             string        `toml:"CacheDir"`            // On-disk cache for API metadata responses (empty disables)
+		CacheTTL             time.Duration `toml:"CacheTTL"`            // Age below which cached metadata is used without revalidation
+
+		// VaeMap maps a base model (e.g. "SDXL 1.0") to the model version ID of the VAE to use for it
+		VaeMap map[string]int `toml:"VaeMap"`
+
+		// TypeFolderMap maps a model type (e.g. "Checkpoint") to the folder its files are saved in
+		TypeFolderMap map[string]string `toml:"TypeFolderMap"`
+
+		// Torrent holds the defaults for the torrent command ([torrent] section)
+		Torrent TorrentConfig `toml:"torrent"`
 
 		// Other
 		LogApiRequests bool `toml:"LogApiRequests"`
 	}
 
+	// TorrentConfig is the [torrent] config section. Trackers are added after any
+	// --announce URLs; the other keys are defaults for the flags of the same name.
+	TorrentConfig struct {
+		Trackers       []string `toml:"Trackers"`
+		OutputDir      string   `toml:"OutputDir"`
+		Overwrite      bool     `toml:"Overwrite"`
+		MagnetLinks    bool     `toml:"MagnetLinks"`
+		MagnetFormat   string   `toml:"MagnetFormat"`
+		MagnetOnly     bool     `toml:"MagnetOnly"`
+		HashWorkers    int      `toml:"HashWorkers"`
+		PieceLength    string   `toml:"PieceLength"`    // Power of two like "512k" or "1M", or "auto"
+		PieceLengthKiB int      `toml:"PieceLengthKiB"` // Deprecated, use PieceLength
+		Private        bool     `toml:"Private"`        // Set the private flag on generated torrents
+		WebSeeds       bool     `toml:"WebSeeds"`       // Add Civitai download URLs as web seeds
+	}
+
 	// Api Calls and Responses
 	QueryParameters struct {
-		Limit                  int      `json:"limit"`
+		Limit                  int      `json:"limit"` // Models per API request, not the total limit
 		Page                   int      `json:"page,omitempty"`
 		Query                  string   `json:"query,omitempty"`
 		Tag                    string   `json:"tag,omitempty"`
@@ -70,6 +146,9 @@ type (
 		AllowCommercialUse     string   `json:"allowCommercialUse,omitempty"`
 		Nsfw                   bool     `json:"nsfw"`
 		BaseModels             []string `json:"baseModels,omitempty"`
+		Favorites              bool     `json:"favorites,omitempty"` // Requires an API key
+		Hidden                 bool     `json:"hidden,omitempty"`    // Requires an API key
+		Rating                 int      `json:"rating"`              // Minimum rating 1-5, 0 = any (not sent)
 		Cursor                 string   `json:"cursor,omitempty"`
 	}
 
@@ -200,16 +279,19 @@ type (
 
 	// Internal file db entry for each model
 	DatabaseEntry struct {
-		ModelName    string       `json:"modelName"`
-		ModelType    string       `json:"modelType"`
-		Version      ModelVersion `json:"version"`
-		File         File         `json:"file"`
-		Timestamp    int64        `json:"timestamp"`
-		Creator      Creator      `json:"creator"`
-		Filename     string       `json:"filename"`
-		Folder       string       `json:"folder"`
-		Status       string       `json:"status"`
-		ErrorDetails string       `json:"errorDetails,omitempty"`
+		ModelName     string       `json:"modelName"`
+		ModelType     string       `json:"modelType"`
+		Version       ModelVersion `json:"version"`
+		File          File         `json:"file"`
+		Timestamp     int64        `json:"timestamp"`
+		Creator       Creator      `json:"creator"`
+		Filename      string       `json:"filename"`
+		Folder        string       `json:"folder"`
+		VersionDir    string       `json:"versionDir,omitempty"` // Directory below Folder holding the file; empty for entries from older versions
+		ModelDir      string       `json:"modelDir,omitempty"`   // Model directory relative to SavePath, packaged by torrent and pack; empty for entries from older versions
+		Status        string       `json:"status"`
+		ErrorDetails  string       `json:"errorDetails,omitempty"`
+		ErrorCategory string       `json:"errorCategory,omitempty"` // e.g. auth, not_found, network; see downloader.ErrorCategory
 	}
 
 	// --- Start: /api/v1/images Endpoint Structures ---
@@ -256,79 +338,25 @@ const (
 	StatusError      = "Error"
 )
 
-// ConstructApiUrl builds the Civitai API URL from query parameters.
-func ConstructApiUrl(params QueryParameters) string {
-	base := "https://civitai.com/api/v1/models"
-	values := url.Values{}
-
-	// Add parameters if they have non-default values
-	if params.Limit > 0 && params.Limit <= 100 { // Use API default if not set or invalid
-		values.Set("limit", strconv.Itoa(params.Limit))
-	} else {
-		// Let the API use its default limit (usually 100)
-	}
-
-	if params.Page > 0 { // Page is only relevant for non-cursor pagination (less common now)
-		// values.Set("page", strconv.Itoa(params.Page))
-		// Generally, Cursor should be preferred over Page.
-	}
-
-	if params.Query != "" {
-		values.Set("query", params.Query)
-	}
-
-	if params.Tag != "" {
-		values.Set("tag", params.Tag)
-	}
-
-	if params.Username != "" {
-		values.Set("username", params.Username)
-	}
-
-	for _, t := range params.Types {
-		values.Add("types", t)
-	}
-
-	if params.Sort != "" {
-		values.Set("sort", params.Sort)
-	}
-
-	if params.Period != "" {
-		values.Set("period", params.Period)
-	}
-
-	if !params.AllowNoCredit { // Default is true, so only add if false
-		values.Set("allowNoCredit", "false")
-	}
-
-	if !params.AllowDerivatives { // Default is true
-		values.Set("allowDerivatives", "false")
-	}
-
-	if !params.AllowDifferentLicenses { // Default is true
-		values.Set("allowDifferentLicense", "false") // API uses singular 'License'
-	}
-
-	if params.AllowCommercialUse != "Any" && params.AllowCommercialUse != "" { // Default is Any
-		values.Set("allowCommercialUse", params.AllowCommercialUse)
-	}
-
-	// Only add nsfw param if true
-	if params.Nsfw {
-		values.Set("nsfw", "true")
-	}
-
-	for _, bm := range params.BaseModels {
-		values.Add("baseModels", bm) // API uses camelCase
-	}
-
-	if params.Cursor != "" {
-		values.Set("cursor", params.Cursor)
-	}
-
-	queryString := values.Encode()
-	if queryString != "" {
-		return base + "?" + queryString
-	}
-	return base
+// AllowDifferentLicensesParam is the /models query parameter for license filtering.
+// Note the plural: the singular allowDifferentLicense is only the response field name.
+const AllowDifferentLicensesParam = "allowDifferentLicenses"
+
+// ImageMeta returns the generation metadata of an image (ImageApiItem.Meta or
+// ModelImage.Meta) as a map. The API sends an object for most images but null, an
+// array or occasionally a JSON-encoded string for others; anything that is not an
+// object yields an empty (non-nil) map, so callers can index it safely.
+func ImageMeta(meta interface{}) map[string]interface{} {
+	switch m := meta.(type) {
+	case map[string]interface{}:
+		if m != nil {
+			return m
+		}
+	case string:
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(m), &decoded); err == nil && decoded != nil {
+			return decoded
+		}
+	}
+	return map[string]interface{}{}
 }
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestImageMeta(t *testing.T) {
+	tests := []struct {
+		name       string
+		json       string
+		wantPrompt string
+		wantLen    int
+	}{
+		{"Object", `{"meta":{"prompt":"a cat","steps":20}}`, "a cat", 2},
+		{"Null", `{"meta":null}`, "", 0},
+		{"Missing", `{}`, "", 0},
+		{"Empty array", `{"meta":[]}`, "", 0},
+		{"Array of objects", `{"meta":[{"prompt":"a cat"}]}`, "", 0},
+		{"JSON string", `{"meta":"{\"prompt\":\"a cat\"}"}`, "a cat", 1},
+		{"Plain string", `{"meta":"none"}`, "", 0},
+		{"Number", `{"meta":5}`, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var image ImageApiItem
+			if err := json.Unmarshal([]byte(tt.json), &image); err != nil {
+				t.Fatalf("Unmarshal(%s) error = %v", tt.json, err)
+			}
+			meta := ImageMeta(image.Meta)
+			if meta == nil {
+				t.Fatalf("ImageMeta() returned nil map")
+			}
+			if len(meta) != tt.wantLen {
+				t.Errorf("ImageMeta() has %d entries, want %d", len(meta), tt.wantLen)
+			}
+			if prompt, _ := meta["prompt"].(string); prompt != tt.wantPrompt {
+				t.Errorf("ImageMeta()[\"prompt\"] = %q, want %q", prompt, tt.wantPrompt)
+			}
+
+			// ModelImage uses the same representation
+			var modelImage ModelImage
+			if err := json.Unmarshal([]byte(tt.json), &modelImage); err != nil {
+				t.Fatalf("Unmarshal(%s) into ModelImage error = %v", tt.json, err)
+			}
+			if got := len(ImageMeta(modelImage.Meta)); got != tt.wantLen {
+				t.Errorf("ImageMeta(ModelImage) has %d entries, want %d", got, tt.wantLen)
+			}
+		})
+	}
+}